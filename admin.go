@@ -0,0 +1,433 @@
+package tabloid
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// adminPerPage is the number of items listed on each page of the admin area.
+const adminPerPage = 50
+
+// HandleAdmin handles requests to the root of the admin area, redirecting to the list of users.
+func (s *Server) HandleAdmin() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminUsers handles requests to list users, most recently logged in first, optionally filtered by
+// the "q" query parameter.
+func (s *Server) HandleAdminUsers() HandleE {
+	tmpl := s.parseAdminTemplate("admin_users.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		query, page := adminListParams(req)
+		users, err := s.store.ListUsers(query, page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "users", len(users), map[string]interface{}{
+			"Users": users,
+			"Roles": AllRoles,
+			"Now":   NowFunc(),
+		})
+	}
+}
+
+// HandleAdminUpdateUserRoleAction handles requests to change the role of a user.
+func (s *Server) HandleAdminUpdateUserRoleAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := req.ParseForm()
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		role, err := ParseRole(req.FormValue("role"))
+		if err != nil {
+			return UnprocessableEntityWithError(err, "role")
+		}
+
+		if s.refuseSelf(res, req, params.ByName("id"), "/admin/users") {
+			return nil
+		}
+
+		err = s.store.UpdateUserRole(params.ByName("id"), role)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		entry := newModerationLogEntry(req, ModerationChangeRole).OnUser(params.ByName("id"))
+		entry.Reason = "now " + string(role)
+		err = s.LogModeration(entry)
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Role updated.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminBanUserAction handles requests to ban a user, who is signed out everywhere.
+func (s *Server) HandleAdminBanUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		userID := params.ByName("id")
+		if s.refuseSelf(res, req, userID, "/admin/users") {
+			return nil
+		}
+
+		err := s.store.BanUser(userID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.sessionStore.DeleteUserSessions(userID)
+		if err != nil {
+			return err
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationBanUser).OnUser(userID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "User banned.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminUnbanUserAction handles requests to lift the ban of a user.
+func (s *Server) HandleAdminUnbanUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := s.store.UnbanUser(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationUnbanUser).OnUser(params.ByName("id")))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "User unbanned.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminSuspendUserAction handles requests to suspend a user for the number of days given in the "days"
+// form field, the "reason" field being shown to them.
+func (s *Server) HandleAdminSuspendUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := req.ParseForm()
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		days, err := strconv.Atoi(req.FormValue("days"))
+		if err != nil || days < 1 {
+			return UnprocessableEntityWithError(err, "days")
+		}
+
+		reason := strings.TrimSpace(req.FormValue("reason"))
+		if reason == "" {
+			return UnprocessableEntity("reason")
+		}
+
+		userID := params.ByName("id")
+		if s.refuseSelf(res, req, userID, "/admin/users") {
+			return nil
+		}
+
+		until := NowFunc().Add(time.Duration(days) * 24 * time.Hour)
+		err = s.store.SuspendUser(userID, until, reason)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationSuspendUser).OnUser(userID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "User suspended.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminUnsuspendUserAction handles requests to lift the suspension of a user.
+func (s *Server) HandleAdminUnsuspendUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := s.store.UnsuspendUser(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationUnsuspendUser).OnUser(params.ByName("id")))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Suspension lifted.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminShadowBanUserAction handles requests to shadow-ban a user, whose content is then only visible
+// to themselves.
+func (s *Server) HandleAdminShadowBanUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		userID := params.ByName("id")
+		if s.refuseSelf(res, req, userID, "/admin/users") {
+			return nil
+		}
+
+		err := s.store.ShadowBanUser(userID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationShadowBanUser).OnUser(userID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "User shadow-banned.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminUnshadowBanUserAction handles requests to lift the shadow-ban of a user.
+func (s *Server) HandleAdminUnshadowBanUserAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := s.store.UnshadowBanUser(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationUnshadowBan).OnUser(params.ByName("id")))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Shadow-ban lifted.")
+		http.Redirect(res, req, "/admin/users", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminStories handles requests to list stories, most recent first and including the removed ones,
+// optionally filtered by the "q" query parameter.
+func (s *Server) HandleAdminStories() HandleE {
+	tmpl := s.parseAdminTemplate("admin_stories.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		query, page := adminListParams(req)
+		stories, err := s.store.ListRecentStories(query, page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "stories", len(stories), map[string]interface{}{
+			"Stories": stories,
+			"Now":     NowFunc(),
+		})
+	}
+}
+
+// HandleAdminRemoveStoryAction handles requests to remove a story.
+func (s *Server) HandleAdminRemoveStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		err := s.store.RemoveStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationRemoveStory).OnStory(params.ByName("id")))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Story removed.")
+		http.Redirect(res, req, "/admin/stories", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminPinStoryAction handles requests to pin a story for the number of days given in the "days" form
+// field, zero meaning unpinning it.
+func (s *Server) HandleAdminPinStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		return s.pinStory(res, req, params.ByName("id"), "/admin/stories")
+	}
+}
+
+// HandleAdminComments handles requests to list comments, most recent first and including the removed ones,
+// optionally filtered by the "q" query parameter.
+func (s *Server) HandleAdminComments() HandleE {
+	tmpl := s.parseAdminTemplate("admin_comments.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		query, page := adminListParams(req)
+		comments, err := s.store.ListRecentComments(query, page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "comments", len(comments), map[string]interface{}{
+			"Comments": comments,
+		})
+	}
+}
+
+// HandleAdminRemoveCommentAction handles requests to remove a comment. Its replies are kept.
+func (s *Server) HandleAdminRemoveCommentAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		comment, err := s.store.FindComment(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.store.RemoveComment(comment.ID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationRemoveComment).OnComment(comment.StoryID, comment.ID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Comment removed.")
+		http.Redirect(res, req, "/admin/comments", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminSettings handles requests to get the site settings form.
+func (s *Server) HandleAdminSettings() HandleE {
+	tmpl := s.parseAdminTemplate("admin_settings.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		settings, err := s.store.FindSiteSettings()
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "settings", 0, map[string]interface{}{
+			"Settings": settings,
+		})
+	}
+}
+
+// HandleAdminUpdateSettingsAction handles requests to update the site settings.
+func (s *Server) HandleAdminUpdateSettingsAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		err := req.ParseForm()
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		settings := &SiteSettings{
+			Name:          strings.TrimSpace(req.FormValue("name")),
+			Description:   strings.TrimSpace(req.FormValue("description")),
+			Announcement:  strings.TrimSpace(req.FormValue("announcement")),
+			BannedDomains: ParseBannedDomains(req.FormValue("banned_domains")),
+		}
+
+		if settings.Name == "" {
+			return UnprocessableEntity("name")
+		}
+
+		settings.DomainPenalties, err = ParseDomainPenalties(req.FormValue("domain_penalties"))
+		if err != nil {
+			return UnprocessableEntityWithError(err, "domain_penalties")
+		}
+
+		err = s.store.UpdateSiteSettings(settings)
+		if err != nil {
+			return err
+		}
+
+		err = s.LogModeration(NewModerationLogEntry(ctxUser(req.Context()).ID, ModerationUpdateSettings, ""))
+		if err != nil {
+			return err
+		}
+
+		// no need to wait for the cache to expire on this instance
+		s.siteSettingsMu.Lock()
+		s.siteSettings = settings
+		s.siteSettingsLoadedAt = time.Now()
+		s.siteSettingsMu.Unlock()
+
+		SetFlash(res, "success", "Settings saved.")
+		http.Redirect(res, req, "/admin/settings", http.StatusFound)
+		return nil
+	}
+}
+
+// refuseSelf returns true if the given user is the one making the request, redirecting to the given path,
+// as admins can't ban, suspend or shadow-ban themselves, nor change their own role.
+func (s *Server) refuseSelf(res http.ResponseWriter, req *http.Request, userID string, redirectPath string) bool {
+	if ctxUser(req.Context()).ID != userID {
+		return false
+	}
+
+	SetFlash(res, "warning", "You can't do that to yourself.")
+	http.Redirect(res, req, redirectPath, http.StatusFound)
+	return true
+}
+
+// adminListParams returns the search query and the page requested on a list of the admin area.
+func adminListParams(req *http.Request) (string, int) {
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	if page < 0 {
+		page = 0
+	}
+
+	return query, page
+}
+
+func (s *Server) parseAdminTemplate(name string) *template.Template {
+	tmpl, err := template.New(name).Funcs(s.helpers()).ParseFiles(
+		"assets/templates/"+name,
+		"assets/templates/_admin_nav.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
+	return tmpl
+}
+
+// renderAdmin renders a page of the admin area, section being the current tab. The pagination links are
+// computed from the number of listed items.
+func (s *Server) renderAdmin(res http.ResponseWriter, req *http.Request, tmpl *template.Template, section string, count int, vars map[string]interface{}) error {
+	res.Header().Set("Content-Type", "text/html")
+
+	query, page := adminListParams(req)
+	vars["Session"] = ctxSession(req.Context())
+	vars["Section"] = section
+	vars["Query"] = query
+	vars["PrevPage"] = page - 1
+	vars["NextPage"] = -1
+	if count == adminPerPage {
+		vars["NextPage"] = page + 1
+	}
+
+	return tmpl.Execute(res, vars)
+}
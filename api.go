@@ -0,0 +1,612 @@
+package tabloid
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiPrefix is where the current version of the JSON API is mounted.
+const apiPrefix = "/api/v1"
+
+// maxAPIBodyBytes bounds the size of the JSON bodies the API accepts.
+const maxAPIBodyBytes = 1 << 20
+
+// apiSpecPath is where the OpenAPI specification of the API is found, to be kept in sync with its routes.
+const apiSpecPath = "assets/api/openapi.json"
+
+// apiStory is how a story is represented in the API.
+type apiStory struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	URL           string    `json:"url,omitempty"`
+	Domain        string    `json:"domain,omitempty"`
+	Body          string    `json:"body,omitempty"`
+	BodyHTML      string    `json:"body_html,omitempty"`
+	Score         int64     `json:"score"`
+	Author        string    `json:"author"`
+	CommentsCount int64     `json:"comments_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	Pinned        bool      `json:"pinned"`
+	Locked        bool      `json:"locked"`
+	Held          bool      `json:"held"`
+	Vote          string    `json:"vote,omitempty"`
+}
+
+// apiStoryWithComments is a story along its tree of comments, when fetching a single story.
+type apiStoryWithComments struct {
+	*apiStory
+	Comments []*apiComment `json:"comments"`
+}
+
+// apiComment is how a comment is represented in the API, along its replies.
+type apiComment struct {
+	ID        string        `json:"id"`
+	StoryID   string        `json:"story_id"`
+	BodyHTML  string        `json:"body_html"`
+	Score     int64         `json:"score"`
+	Author    string        `json:"author,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	Removed   bool          `json:"removed"`
+	Hidden    bool          `json:"hidden"`
+	Held      bool          `json:"held"`
+	CanEdit   bool          `json:"can_edit"`
+	Vote      string        `json:"vote,omitempty"`
+	Replies   []*apiComment `json:"replies"`
+}
+
+// apiUser is how a user is represented in the API, without anything private.
+type apiUser struct {
+	Name      string    `json:"name"`
+	Karma     int       `json:"karma"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiVote returns how the vote of a user is represented, empty if they didn't vote.
+func apiVote(up sql.NullBool) string {
+	if !up.Valid {
+		return ""
+	}
+	if up.Bool {
+		return "up"
+	}
+	return "down"
+}
+
+func newAPIStory(story *Story, withBody bool) *apiStory {
+	st := &apiStory{
+		ID:            story.ID,
+		Title:         story.Title,
+		URL:           story.URL,
+		Domain:        story.Domain,
+		Score:         story.Score,
+		Author:        story.Author,
+		CommentsCount: story.CommentsCount,
+		CreatedAt:     story.CreatedAt,
+		Pinned:        story.IsPinned(NowFunc()),
+		Locked:        story.Locked,
+		Held:          story.IsHeld(),
+	}
+
+	if withBody {
+		st.Body = story.Body
+		st.BodyHTML = string(renderBody(story.Body))
+	}
+
+	return st
+}
+
+func newAPIUser(userRecord *User) *apiUser {
+	return &apiUser{
+		Name:      userRecord.Name,
+		Karma:     userRecord.Karma,
+		Role:      string(userRecord.Role),
+		CreatedAt: userRecord.CreatedAt,
+	}
+}
+
+// newAPIComments turns a tree of comments into its API representation, replies being ranked like they are
+// on story pages.
+func newAPIComments(tree []*CommentPresenter) []*apiComment {
+	sorted := make([]*CommentPresenter, len(tree))
+	copy(sorted, tree)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) > rank(sorted[j])
+	})
+
+	comments := []*apiComment{}
+	for _, c := range sorted {
+		comment := &apiComment{
+			ID:        c.ID,
+			StoryID:   c.StoryID,
+			BodyHTML:  string(c.Body),
+			Score:     c.Score,
+			Author:    c.Author,
+			CreatedAt: c.CreatedAt,
+			Removed:   c.Removed,
+			Hidden:    c.Hidden,
+			Held:      c.Held,
+			CanEdit:   c.CanEdit,
+			Replies:   newAPIComments(c.Children),
+		}
+
+		if c.Upvoted {
+			comment.Vote = "up"
+		} else if c.Downvoted {
+			comment.Vote = "down"
+		}
+
+		comments = append(comments, comment)
+	}
+
+	return comments
+}
+
+// writeJSON responds with the given value encoded in JSON.
+func writeJSON(res http.ResponseWriter, code int, v interface{}) error {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(code)
+	return json.NewEncoder(res).Encode(v)
+}
+
+// wantsJSON returns true if the client asked for JSON rather than HTML in its Accept header, letting the HTML
+// routes answer with the data they render instead. The type with the highest quality wins, the first listed
+// one on a tie, and a zero quality refuses a type.
+func wantsJSON(req *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	jsonFirst := false
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		params := strings.Split(part, ";")
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "application/json":
+			if jsonQ < 0 {
+				jsonQ = acceptQuality(params[1:])
+				jsonFirst = htmlQ < 0
+			}
+		case "text/html":
+			if htmlQ < 0 {
+				htmlQ = acceptQuality(params[1:])
+			}
+		}
+	}
+
+	return jsonQ > 0 && (jsonQ > htmlQ || (jsonQ == htmlQ && jsonFirst))
+}
+
+// acceptQuality returns the quality given by the q parameter among the parameters of a media range in an
+// Accept header, 1 if there is none and 0 if it's invalid.
+func acceptQuality(params []string) float64 {
+	for _, param := range params {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+
+	return 1
+}
+
+// decodeJSON reads the JSON body of a request into v, responding with a bad request error if it's invalid.
+func decodeJSON(req *http.Request, v interface{}) error {
+	err := json.NewDecoder(io.LimitReader(req.Body, maxAPIBodyBytes)).Decode(v)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	return nil
+}
+
+// requestUser returns the user making the request, either through a session or a personal access token, nil
+// if anonymous.
+func (s *Server) requestUser(req *http.Request) (*User, error) {
+	if userRecord := ctxUser(req.Context()); userRecord != nil {
+		return userRecord, nil
+	}
+
+	session := ctxSession(req.Context())
+	if session == nil {
+		return nil, nil
+	}
+
+	return s.findSessionUser(session)
+}
+
+// HandleAPISpec serves the OpenAPI specification of the API.
+func (s *Server) HandleAPISpec() HandleE {
+	spec, err := ioutil.ReadFile(apiSpecPath)
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to load the API specification")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		res.Header().Set("Content-Type", "application/json")
+		_, err := res.Write(spec)
+		return err
+	}
+}
+
+// HandleAPIStories lists the ranked stories of the front page, paginated with the "page" query parameter.
+func (s *Server) HandleAPIStories() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		viewer, err := s.requestUser(req)
+		if err != nil {
+			return err
+		}
+
+		now := NowFunc()
+		settings := s.currentSiteSettings()
+		// one more story tells if there is a next page
+		perPage := s.config.StoriesPerPage
+		stories := []*apiStory{}
+		if viewer != nil {
+			seen, err := s.store.ListStoriesWithVotes(viewer.ID, page, perPage+1)
+			if err != nil {
+				return err
+			}
+
+			more := len(seen) > perPage
+			if more {
+				seen = seen[:perPage]
+			}
+
+			sort.Slice(seen, func(i, j int) bool {
+				return rankPinnedFirst(&seen[i].Story, &seen[j].Story, now, settings)
+			})
+
+			for _, st := range seen {
+				story := newAPIStory(&st.Story, false)
+				story.Vote = apiVote(st.Up)
+				stories = append(stories, story)
+			}
+
+			return writeJSON(res, http.StatusOK, map[string]interface{}{"stories": stories, "next_page": nextAPIPage(page, more)})
+		}
+
+		list, err := s.store.ListStories(page, perPage+1)
+		if err != nil {
+			return err
+		}
+
+		more := len(list) > perPage
+		if more {
+			list = list[:perPage]
+		}
+
+		sort.Slice(list, func(i, j int) bool {
+			return rankPinnedFirst(list[i], list[j], now, settings)
+		})
+
+		for _, st := range list {
+			stories = append(stories, newAPIStory(st, false))
+		}
+
+		return writeJSON(res, http.StatusOK, map[string]interface{}{"stories": stories, "next_page": nextAPIPage(page, more)})
+	}
+}
+
+// nextAPIPage returns the number of the next page, nil if there is none.
+func nextAPIPage(page int, more bool) interface{} {
+	if !more {
+		return nil
+	}
+
+	return page + 1
+}
+
+// HandleAPIStory returns a story along its tree of comments.
+func (s *Server) HandleAPIStory() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		viewer, err := s.requestUser(req)
+		if err != nil {
+			return err
+		}
+
+		id := params.ByName("id")
+		var story *apiStory
+		var cc []CommentAccessor
+		if viewer != nil {
+			seen, err := s.store.FindStoryWithVote(id, viewer.ID)
+			if err != nil {
+				return Maybe404(err)
+			}
+
+			visible, err := s.canSeeStory(&seen.Story, viewer)
+			if err != nil {
+				return err
+			}
+
+			if !visible {
+				return NotFound(req.URL.Path)
+			}
+
+			story = newAPIStory(&seen.Story, true)
+			story.Vote = apiVote(seen.Up)
+
+			comments, err := s.store.ListCommentsWithVotes(id, viewer.ID)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range comments {
+				cc = append(cc, c)
+			}
+		} else {
+			found, err := s.store.FindStory(id)
+			if err != nil {
+				return Maybe404(err)
+			}
+
+			visible, err := s.canSeeStory(found, nil)
+			if err != nil {
+				return err
+			}
+
+			if !visible {
+				return NotFound(req.URL.Path)
+			}
+
+			story = newAPIStory(found, true)
+
+			comments, err := s.store.ListComments(id)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range comments {
+				cc = append(cc, c)
+			}
+		}
+
+		tree := NewCommentPresentersTree(cc)
+		if viewer != nil {
+			tree.SetCanEdits(viewer, time.Duration(s.config.EditWindowInMinutes)*time.Minute, NowFunc())
+		}
+		return writeJSON(res, http.StatusOK, &apiStoryWithComments{apiStory: story, Comments: newAPIComments(tree)})
+	}
+}
+
+// HandleAPIUser returns the public profile of a user.
+func (s *Server) HandleAPIUser() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		userRecord, err := s.store.FindUserByLogin(params.ByName("name"))
+		if err != nil {
+			return err
+		}
+
+		if userRecord == nil || userRecord.IsBanned() {
+			return NotFound(req.URL.Path)
+		}
+
+		return writeJSON(res, http.StatusOK, newAPIUser(userRecord))
+	}
+}
+
+// HandleAPISubmitStory submits a story, from a JSON body with its "title" and either its "url" or its "body".
+// Links which were already submitted are refused with a conflict error, unless "repost" is true and the
+// repost window is over.
+func (s *Server) HandleAPISubmitStory() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		var input struct {
+			Title  string `json:"title"`
+			URL    string `json:"url"`
+			Body   string `json:"body"`
+			Repost bool   `json:"repost"`
+		}
+		err := decodeJSON(req, &input)
+		if err != nil {
+			return err
+		}
+
+		title := strings.TrimSpace(input.Title)
+		url_ := strings.TrimSpace(input.URL)
+		body := strings.TrimSpace(input.Body)
+		err = validateStory(title, url_, body)
+		if err != nil {
+			return err
+		}
+
+		userRecord := ctxUser(req.Context())
+		story := NewStory(title, body, userRecord.ID, url_)
+
+		if story.CanonicalURL != "" {
+			existing, err := s.store.FindStoryByCanonicalURL(story.CanonicalURL)
+			if err != nil {
+				return err
+			}
+
+			if existing != nil {
+				window := time.Duration(s.config.RepostWindowInDays) * 24 * time.Hour
+				if !input.Repost || (window > 0 && NowFunc().Sub(existing.CreatedAt) < window) {
+					return DuplicateStory(existing)
+				}
+			}
+		}
+
+		err = s.createStory(userRecord, story)
+		if err != nil {
+			return err
+		}
+
+		res.Header().Set("Location", apiPrefix+"/stories/"+story.ID)
+		return writeJSON(res, http.StatusCreated, newAPIStory(story, true))
+	}
+}
+
+// HandleAPISubmitComment posts a comment on a story, from a JSON body with its "body" and optionally the
+// "parent_id" of the comment it replies to.
+func (s *Server) HandleAPISubmitComment() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		visible, err := s.canSeeStory(story, ctxUser(req.Context()))
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		if story.Locked {
+			return StoryLocked(req.URL.Path)
+		}
+
+		var input struct {
+			Body     string `json:"body"`
+			ParentID string `json:"parent_id"`
+		}
+		err = decodeJSON(req, &input)
+		if err != nil {
+			return err
+		}
+
+		body := strings.TrimSpace(input.Body)
+		if body == "" {
+			return UnprocessableEntity("body")
+		}
+
+		parentID := sql.NullString{String: input.ParentID, Valid: input.ParentID != ""}
+		if parentID.Valid {
+			parent, err := s.store.FindComment(parentID.String)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					return UnprocessableEntity("parent_id")
+				}
+				return err
+			}
+
+			if parent.StoryID != story.ID {
+				return UnprocessableEntity("parent_id")
+			}
+		}
+
+		userRecord := ctxUser(req.Context())
+		comment := NewComment(story.ID, parentID, body, userRecord.ID)
+		err = s.createComment(userRecord, story, comment)
+		if err != nil {
+			return err
+		}
+
+		presenter := NewCommentPresenter(&CommentNode{Comment: comment})
+		return writeJSON(res, http.StatusCreated, newAPIComments([]*CommentPresenter{presenter})[0])
+	}
+}
+
+// HandleAPIUpdateComment replaces the body of a comment, from a JSON body with its new "body". Only its
+// author can do it, within the edit window.
+func (s *Server) HandleAPIUpdateComment() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		comment, err := s.store.FindComment(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		if comment.IsRemoved() {
+			return NotFound(req.URL.Path)
+		}
+
+		userRecord := ctxUser(req.Context())
+		editWindow := time.Duration(s.config.EditWindowInMinutes) * time.Minute
+		if !CanEditComment(userRecord, comment, editWindow, NowFunc()) {
+			return Forbidden(req.URL.Path)
+		}
+
+		story, err := s.store.FindStory(comment.StoryID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		var input struct {
+			Body string `json:"body"`
+		}
+		err = decodeJSON(req, &input)
+		if err != nil {
+			return err
+		}
+
+		body := strings.TrimSpace(input.Body)
+		if body == "" {
+			return UnprocessableEntity("body")
+		}
+
+		err = s.updateComment(story, comment, body)
+		if err != nil {
+			return err
+		}
+
+		presenter := NewCommentPresenter(&CommentNode{Comment: comment})
+		return writeJSON(res, http.StatusOK, newAPIComments([]*CommentPresenter{presenter})[0])
+	}
+}
+
+// HandleAPIVote votes on a story, or on a comment when the route has a comment id, from a JSON body with
+// "up" telling if it's an upvote or a downvote.
+func (s *Server) HandleAPIVote() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		storyID, commentID := params.ByName("id"), ""
+		if params.ByName("comment_id") != "" {
+			comment, err := s.store.FindComment(params.ByName("comment_id"))
+			if err != nil {
+				return Maybe404(err)
+			}
+			storyID, commentID = comment.StoryID, comment.ID
+		}
+
+		story, err := s.store.FindStory(storyID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		visible, err := s.canSeeStory(story, ctxUser(req.Context()))
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		var input struct {
+			Up *bool `json:"up"`
+		}
+		err = decodeJSON(req, &input)
+		if err != nil {
+			return err
+		}
+
+		if input.Up == nil {
+			return UnprocessableEntity("up")
+		}
+
+		err = s.vote(ctxUser(req.Context()), storyID, commentID, *input.Up)
+		if err != nil {
+			return err
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
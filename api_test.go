@@ -0,0 +1,134 @@
+package tabloid
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/rs/zerolog"
+)
+
+func TestWriteError(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("JSON for the API", func(c *qt.C) {
+		w := httptest.NewRecorder()
+		UnprocessableEntity("title").RespondError(w, httptest.NewRequest("POST", "/api/v1/stories", nil))
+
+		c.Assert(w.Code, qt.Equals, 422)
+		c.Assert(w.Header().Get("Content-Type"), qt.Equals, "application/json")
+
+		var body apiError
+		c.Assert(json.NewDecoder(w.Body).Decode(&body), qt.IsNil)
+		c.Assert(body.Error.Status, qt.Equals, 422)
+		c.Assert(body.Error.Fields, qt.DeepEquals, []string{"title"})
+	})
+
+	c.Run("plain text elsewhere", func(c *qt.C) {
+		w := httptest.NewRecorder()
+		UnprocessableEntity("title").RespondError(w, httptest.NewRequest("POST", "/submit", nil))
+
+		c.Assert(w.Code, qt.Equals, 422)
+		c.Assert(w.Header().Get("Content-Type"), qt.Equals, "text/plain; charset=utf-8")
+	})
+}
+
+func TestWantsJSON(t *testing.T) {
+	c := qt.New(t)
+
+	for accept, expected := range map[string]bool{
+		"":                                        false,
+		"application/json":                        true,
+		"application/json; charset=utf-8":         true,
+		"text/html,application/json;q=0.9":        false,
+		"application/json, text/plain, */*":       true,
+		"*/*":                                     false,
+		"application/json;q=0":                    false,
+		"application/json;q=0, */*":               false,
+		"text/html;q=0.5, application/json":       true,
+		"text/html;q=0.8, application/json;q=0.9": true,
+		"application/json;q=0.5, text/html;q=0.5": true,
+		"text/html;q=0.5, application/json;q=0.5": false,
+		"application/json;q=abc":                  false,
+		"application/json; Q = 0.1":               true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		c.Assert(wantsJSON(req), qt.Equals, expected, qt.Commentf(accept))
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/submit", nil)
+	req.Header.Set("Accept", "application/json")
+	UnprocessableEntity("title").RespondError(w, req)
+	c.Assert(w.Header().Get("Content-Type"), qt.Equals, "application/json")
+}
+
+func TestAPIVote(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(apiVote(sql.NullBool{}), qt.Equals, "")
+	c.Assert(apiVote(sql.NullBool{Bool: true, Valid: true}), qt.Equals, "up")
+	c.Assert(apiVote(sql.NullBool{Bool: false, Valid: true}), qt.Equals, "down")
+}
+
+func TestNewAPIComments(t *testing.T) {
+	c := qt.New(t)
+	now := time.Now()
+
+	comments := []CommentAccessor{
+		&Comment{ID: "1", StoryID: "s", Score: 1, CreatedAt: now},
+		&Comment{ID: "2", StoryID: "s", Score: 10, CreatedAt: now},
+		&Comment{ID: "3", StoryID: "s", ParentCommentID: sql.NullString{String: "1", Valid: true}, Score: 1, CreatedAt: now},
+	}
+
+	result := newAPIComments(NewCommentPresentersTree(comments))
+	c.Assert(result, qt.HasLen, 2)
+	c.Assert(result[0].ID, qt.Equals, "2")
+	c.Assert(result[0].Replies, qt.HasLen, 0)
+	c.Assert(result[1].ID, qt.Equals, "1")
+	c.Assert(result[1].Replies, qt.HasLen, 1)
+	c.Assert(result[1].Replies[0].ID, qt.Equals, "3")
+}
+
+func TestAPISpecMatchesRoutes(t *testing.T) {
+	c := qt.New(t)
+
+	data, err := ioutil.ReadFile(apiSpecPath)
+	c.Assert(err, qt.IsNil)
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	c.Assert(json.Unmarshal(data, &spec), qt.IsNil)
+
+	// the specification names parameters {like_this}, the router :like_this
+	param := regexp.MustCompile(`\{([a-z_]+)\}`)
+	documented := []string{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+param.ReplaceAllString(path, ":$1"))
+		}
+	}
+
+	s := NewServer(&ServerConfig{}, zerolog.Nop(), nil)
+	s.registerRoutes()
+
+	registered := []string{}
+	for _, r := range s.routes {
+		if strings.HasPrefix(r.Path, apiPrefix+"/") {
+			registered = append(registered, r.Method+" "+r.Path)
+		}
+	}
+
+	sort.Strings(documented)
+	sort.Strings(registered)
+	c.Assert(documented, qt.DeepEquals, registered)
+}
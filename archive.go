@@ -0,0 +1,376 @@
+package tabloid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/html"
+)
+
+// defaultArchiveMaxBytes is how much of a page is read when archiving it, unless configured otherwise.
+const defaultArchiveMaxBytes = 1024 * 1024
+
+// An Archive is a snapshot of the readable text of a linked page, so it can still be read once the page is
+// gone or behind a paywall.
+type Archive struct {
+	// CanonicalURL identifies the page, see CanonicalURL.
+	CanonicalURL string    `db:"canonical_url"`
+	URL          string    `db:"url"`
+	Title        string    `db:"title"`
+	Text         string    `db:"text"`
+	Truncated    bool      `db:"truncated"`
+	FetchedAt    time.Time `db:"fetched_at"`
+	// Error is why the page couldn't be archived, if it couldn't. Failures are kept too, as pages are only
+	// fetched once.
+	Error string `db:"error"`
+}
+
+// Paragraphs returns the text of the archive, split in paragraphs.
+func (a *Archive) Paragraphs() []string {
+	return strings.Split(a.Text, "\n\n")
+}
+
+// An ArchiveStore is responsible of persisting archives, one per canonical URL.
+//
+// Lookups return nil without an error when no archive is found.
+type ArchiveStore interface {
+	FindArchive(canonicalURL string) (*Archive, error)
+	InsertArchive(archive *Archive) error
+}
+
+// MemoryArchiveStore is an ArchiveStore keeping archives in a map, by canonical URL.
+type MemoryArchiveStore struct {
+	mu       sync.Mutex
+	archives map[string]*Archive
+}
+
+// NewMemoryArchiveStore returns an empty MemoryArchiveStore.
+func NewMemoryArchiveStore() *MemoryArchiveStore {
+	return &MemoryArchiveStore{
+		archives: map[string]*Archive{},
+	}
+}
+
+func (m *MemoryArchiveStore) FindArchive(canonicalURL string) (*Archive, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	archive, ok := m.archives[canonicalURL]
+	if !ok {
+		return nil, nil
+	}
+
+	found := *archive
+	return &found, nil
+}
+
+func (m *MemoryArchiveStore) InsertArchive(archive *Archive) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// pages are archived once, the first snapshot is kept
+	if _, ok := m.archives[archive.CanonicalURL]; ok {
+		return nil
+	}
+
+	kept := *archive
+	m.archives[archive.CanonicalURL] = &kept
+	return nil
+}
+
+// FetchArchive retrieves the readable text of the page the given link points to, reading at most maxBytes
+// of it, with the same restrictions as Fetch.
+func (f *MetadataFetcher) FetchArchive(ctx context.Context, link string, maxBytes int64) (*Archive, error) {
+	resp, cancel, err := f.get(ctx, link, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	// reading one more byte tells if the page is larger than allowed
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := int64(len(page)) > maxBytes
+	if truncated {
+		page = page[:maxBytes]
+	}
+
+	archive := parseArchive(bytes.NewReader(page))
+	archive.URL = link
+	archive.CanonicalURL = CanonicalURL(link)
+	archive.Truncated = truncated
+	archive.FetchedAt = NowFunc()
+
+	return archive, nil
+}
+
+// skippedElements are the elements whose content isn't part of the readable text of a page.
+var skippedElements = map[string]bool{
+	"aside":    true,
+	"button":   true,
+	"footer":   true,
+	"form":     true,
+	"header":   true,
+	"iframe":   true,
+	"nav":      true,
+	"noscript": true,
+	"script":   true,
+	"select":   true,
+	"style":    true,
+	"svg":      true,
+	"template": true,
+}
+
+// blockElements are the elements delimiting paragraphs.
+var blockElements = map[string]bool{
+	"article":    true,
+	"blockquote": true,
+	"br":         true,
+	"div":        true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"li":         true,
+	"main":       true,
+	"p":          true,
+	"pre":        true,
+	"section":    true,
+	"td":         true,
+	"tr":         true,
+}
+
+// parseArchive extracts the title and the paragraphs of text of a page, dropping all markup so what's left
+// is safe to show. If the page has an article or main element, only the text inside of it is kept.
+func parseArchive(r io.Reader) *Archive {
+	archive := &Archive{}
+	var all, main, block []string
+	var skipped, inMain int
+	var inTitle bool
+
+	flush := func() {
+		text := strings.Join(strings.Fields(strings.Join(block, " ")), " ")
+		block = block[:0]
+		if text == "" {
+			return
+		}
+
+		all = append(all, text)
+		if inMain > 0 {
+			main = append(main, text)
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = tt == html.StartTagToken
+			}
+			if skippedElements[tag] {
+				if tt == html.StartTagToken {
+					skipped++
+				}
+				continue
+			}
+			if blockElements[tag] {
+				flush()
+			}
+			if (tag == "article" || tag == "main") && tt == html.StartTagToken {
+				inMain++
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = false
+			}
+			if skippedElements[tag] {
+				if skipped > 0 {
+					skipped--
+				}
+				continue
+			}
+			if blockElements[tag] {
+				flush()
+			}
+			if (tag == "article" || tag == "main") && inMain > 0 {
+				inMain--
+			}
+		case html.TextToken:
+			if inTitle {
+				if archive.Title == "" {
+					archive.Title = strings.TrimSpace(string(z.Text()))
+				}
+				continue
+			}
+			if skipped == 0 {
+				block = append(block, string(z.Text()))
+			}
+		}
+	}
+	flush()
+
+	if len(main) > 0 {
+		all = main
+	}
+	archive.Text = strings.Join(all, "\n\n")
+
+	return archive
+}
+
+// isArchivable returns true if links to the given host can be archived, their domain not having opted out.
+func (s *Server) isArchivable(host string) bool {
+	for _, domain := range s.config.ArchiveExcludedDomains {
+		if onDomain(host, domain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// archiveLink takes a snapshot of the page the given link points to, unless it already was.
+func (s *Server) archiveLink(ctx context.Context, link string) error {
+	if !s.config.ArchiveLinks || s.metadataFetcher == nil || !s.isArchivable(Domain(link)) {
+		return nil
+	}
+
+	archive, err := s.archiveStore.FindArchive(CanonicalURL(link))
+	if err != nil {
+		return err
+	}
+
+	if archive != nil {
+		return nil
+	}
+
+	maxBytes := int64(s.config.ArchiveMaxSizeInKB) * 1024
+	if maxBytes <= 0 {
+		maxBytes = defaultArchiveMaxBytes
+	}
+
+	archive, err = s.metadataFetcher.FetchArchive(ctx, link, maxBytes)
+	if err != nil {
+		archive = &Archive{CanonicalURL: CanonicalURL(link), URL: link, FetchedAt: NowFunc(), Error: err.Error()}
+	}
+
+	return s.archiveStore.InsertArchive(archive)
+}
+
+// findArchive returns the archive of the page the given story links to, nil if there is none to show.
+func (s *Server) findArchive(story *Story) (*Archive, error) {
+	if !s.config.ArchiveLinks || story.URL == "" || !s.isArchivable(Domain(story.URL)) {
+		return nil, nil
+	}
+
+	canonicalURL := story.CanonicalURL
+	if canonicalURL == "" {
+		canonicalURL = CanonicalURL(story.URL)
+	}
+
+	archive, err := s.archiveStore.FindArchive(canonicalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if archive == nil || archive.Error != "" {
+		return nil, nil
+	}
+
+	return archive, nil
+}
+
+// archivePath returns where the archive of the page the given story links to can be read, empty if there
+// is none.
+func (s *Server) archivePath(story *Story) (string, error) {
+	archive, err := s.findArchive(story)
+	if err != nil || archive == nil {
+		return "", err
+	}
+
+	return "/stories/" + story.ID + "/archive", nil
+}
+
+// HandleArchive shows the archived text of the page a story links to.
+func (s *Server) HandleArchive() HandleE {
+	tmpl, err := template.New("archive.html").Funcs(s.helpers()).ParseFiles("assets/templates/archive.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to load templates")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		session := ctxSession(req.Context())
+		var viewer *User
+		if session != nil {
+			viewer, err = s.findSessionUser(session)
+			if err != nil {
+				return err
+			}
+		}
+
+		visible, err := s.canSeeStory(story, viewer)
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		archive, err := s.findArchive(story)
+		if err != nil {
+			return err
+		}
+
+		if archive == nil {
+			return NotFound(req.URL.Path)
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		// snapshots are for the members' discussions, not for search engines
+		res.Header().Set("X-Robots-Tag", "noindex")
+
+		return tmpl.Execute(res, map[string]interface{}{
+			"Story":   newStoryPresenterWithPos(story, 0),
+			"Archive": archive,
+			"Session": session,
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package tabloid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseArchive(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("keeps the paragraphs of text", func(c *qt.C) {
+		archive := parseArchive(strings.NewReader(`<html><head><title> Foobar </title><style>p { color: red }</style></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<h1>Foo</h1>
+<p>Some <em>bar</em>
+   and baz.</p>
+<script>alert("nope")</script>
+<ul><li>One</li><li>Two</li></ul>
+<footer>Copyright</footer>
+</body></html>`))
+		c.Assert(archive.Title, qt.Equals, "Foobar")
+		c.Assert(archive.Paragraphs(), qt.DeepEquals, []string{"Foo", "Some bar and baz.", "One", "Two"})
+	})
+
+	c.Run("keeps only the article when there is one", func(c *qt.C) {
+		archive := parseArchive(strings.NewReader(`<body><div>Subscribe!</div><article><p>The story.</p></article><div>Related</div></body>`))
+		c.Assert(archive.Paragraphs(), qt.DeepEquals, []string{"The story."})
+	})
+
+	c.Run("drops markup", func(c *qt.C) {
+		archive := parseArchive(strings.NewReader(`<p>&lt;script&gt; <img src="x" onerror="alert(1)"> tags</p>`))
+		c.Assert(archive.Text, qt.Equals, "<script> tags")
+	})
+}
+
+func TestFetchArchive(t *testing.T) {
+	c := qt.New(t)
+
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Foobar</title></head><body><p>" + strings.Repeat("foo ", 100) + "</p><p>bar</p></body></html>"))
+	}))
+	defer standIn.Close()
+
+	// the stand-in server listens on a loopback address
+	f := NewMetadataFetcher()
+	f.AllowPrivateAddresses = true
+
+	archive, err := f.FetchArchive(context.Background(), standIn.URL+"/a", 1024)
+	c.Assert(err, qt.IsNil)
+	c.Assert(archive.Title, qt.Equals, "Foobar")
+	c.Assert(archive.Paragraphs(), qt.HasLen, 2)
+	c.Assert(archive.Truncated, qt.IsFalse)
+	c.Assert(archive.CanonicalURL, qt.Equals, CanonicalURL(standIn.URL+"/a"))
+
+	archive, err = f.FetchArchive(context.Background(), standIn.URL+"/a", 100)
+	c.Assert(err, qt.IsNil)
+	c.Assert(archive.Paragraphs(), qt.HasLen, 1)
+	c.Assert(archive.Truncated, qt.IsTrue)
+
+	_, err = NewMetadataFetcher().FetchArchive(context.Background(), standIn.URL+"/a", 1024)
+	c.Assert(err, qt.ErrorMatches, `.*refusing to fetch a private address`)
+}
+
+func TestMemoryArchiveStore(t *testing.T) {
+	c := qt.New(t)
+	store := NewMemoryArchiveStore()
+
+	found, err := store.FindArchive("foobar.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.IsNil)
+
+	c.Assert(store.InsertArchive(&Archive{CanonicalURL: "foobar.com", Text: "first"}), qt.IsNil)
+	c.Assert(store.InsertArchive(&Archive{CanonicalURL: "foobar.com", Text: "second"}), qt.IsNil)
+
+	found, err = store.FindArchive("foobar.com")
+	c.Assert(err, qt.IsNil)
+	c.Assert(found.Text, qt.Equals, "first")
+}
@@ -8,24 +8,31 @@ import (
 
 // An OAuthHandler is responsible of providing the callbacks to interact
 // with an OAuth provider.
+//
+// It doesn't keep track of the signed in user, beforeWriteCallback is where the caller
+// opens its own session.
 type OAuthHandler interface {
 	Start(res http.ResponseWriter, req *http.Request) error
 	Callback(res http.ResponseWriter, req *http.Request, beforeWriteCallback func(*User) error) error
-	Destroy(res http.ResponseWriter, req *http.Request) error
 }
 
-// An AuthService wraps OAuth and a access to the current user.
+// An AuthService wraps OAuth and the retrieval of user data from the provider.
+//
+// Its name identifies the provider in the routes (/auth/:provider/start) and in the
+// identities linked to a user, so it must be unique among the providers given to a server.
 type AuthService interface {
 	OAuthHandler
-	CurrentUser(req *http.Request) (*User, error)
+	Name() string
 	LoadUserData(token *oauth2.Token, req *http.Request, res http.ResponseWriter) (*User, error)
 }
 
-// A User is a convenient structure to hold user data coming from Github.
+// A User is a convenient structure to hold user data coming from an OAuth provider.
 type User struct {
 	AvatarURL string
 	Login     string
 	Email     string
+	// Provider is the name of the AuthService the user signed in with.
+	Provider string
 	// No reason to store the token for now
 	// AccessToken *oauth2.Token
 }
@@ -1,7 +1,6 @@
 package fake_auth
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 
@@ -11,9 +10,13 @@ import (
 	"golang.org/x/oauth2"
 )
 
-const sessionKey = "fake_auth_key"
+const (
+	sessionKey  = "fake_auth_key"
+	defaultName = "fake"
+)
 
 type Handler struct {
+	name         string
 	userData     map[string]interface{}
 	user         *authentication.User
 	sessionStore *sessions.CookieStore
@@ -24,6 +27,7 @@ type Handler struct {
 
 func New(sessionStore *sessions.CookieStore, logger zerolog.Logger) *Handler {
 	return &Handler{
+		name:         defaultName,
 		sessionStore: sessionStore,
 		logger:       logger.With().Str("component", "fake_auth").Logger(),
 	}
@@ -33,53 +37,39 @@ func (h *Handler) SetServerURL(url string) {
 	h.serverUrl = url
 }
 
-func (h *Handler) LoadUserData(accessToken *oauth2.Token, req *http.Request, res http.ResponseWriter) (*authentication.User, error) {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return nil, err
-	} // TODO do I need this?
-
-	userSession := &authentication.User{
-		Login:     "fakeLogin" + strconv.Itoa(h.counter),
-		AvatarURL: "https://www.placecage.com/g/200/200",
-	}
-	h.logger.Debug().Str("login", userSession.Login).Msg("authenticated")
-	b, err := json.Marshal(userSession)
-	if err != nil {
-		return nil, err
-	}
-
-	session.Values["user"] = b
-	if err := session.Save(req, res); err != nil {
-		return nil, err
-	}
+// SetName renames the provider, making it possible to run several fake providers side by side.
+// Each of them gets its own session cookie.
+func (h *Handler) SetName(name string) {
+	h.name = name
+}
 
-	return userSession, nil
+func (h *Handler) Name() string {
+	return h.name
 }
 
-func (h *Handler) CurrentUser(req *http.Request) (*authentication.User, error) {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return nil, err
+// sessionName returns the cookie name for the provider session, so different fake providers
+// don't share their sessions.
+func (h *Handler) sessionName() string {
+	if h.name == defaultName {
+		return sessionKey
 	}
 
-	var b []byte
-	b, ok := session.Values["user"].([]byte)
-	if !ok {
-		return nil, nil
-	}
+	return sessionKey + "_" + h.name
+}
 
-	var userSession authentication.User
-	err = json.Unmarshal(b, &userSession)
-	if err != nil {
-		return nil, err
+func (h *Handler) LoadUserData(accessToken *oauth2.Token, req *http.Request, res http.ResponseWriter) (*authentication.User, error) {
+	u := &authentication.User{
+		Login:     "fakeLogin" + strconv.Itoa(h.counter),
+		AvatarURL: "https://www.placecage.com/g/200/200",
+		Provider:  h.name,
 	}
+	h.logger.Debug().Str("login", u.Login).Msg("authenticated")
 
-	return &userSession, nil
+	return u, nil
 }
 
 func (h *Handler) Start(res http.ResponseWriter, req *http.Request) error {
-	session, err := h.sessionStore.Get(req, sessionKey)
+	session, err := h.sessionStore.Get(req, h.sessionName())
 	if err != nil {
 		return err
 	}
@@ -92,7 +82,7 @@ func (h *Handler) Start(res http.ResponseWriter, req *http.Request) error {
 
 	// make subsequent login behave as a new user
 	h.counter++
-	http.Redirect(res, req, h.serverUrl+"/oauth/authorize", 302)
+	http.Redirect(res, req, h.serverUrl+"/auth/"+h.name+"/callback", 302)
 	return nil
 }
 
@@ -110,19 +100,3 @@ func (h *Handler) Callback(res http.ResponseWriter, req *http.Request, beforeWri
 	http.Redirect(res, req, "/", 302)
 	return nil
 }
-
-func (h *Handler) Destroy(res http.ResponseWriter, req *http.Request) error {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return err
-	}
-
-	session.Options.MaxAge = -1
-	err = session.Save(req, res)
-	if err != nil {
-		return err
-	}
-
-	http.Redirect(res, req, "/", 302)
-	return nil
-}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -17,13 +16,13 @@ import (
 )
 
 const (
-	sessionKey = "tabloid-session"
+	sessionKey   = "tabloid-session"
+	providerName = "github"
 )
 
 // TODO wrap in a config struct?
 type Handler struct {
-	// useless atm, but keeping around to dig around whatever I could need
-	// at this stage.
+	// only holds the OAuth state, the signed in user is tracked by the server.
 	sessionStore *sessions.CookieStore
 	logger       zerolog.Logger
 	oauthConfig  *oauth2.Config
@@ -48,15 +47,13 @@ func New(serverSecret string, clientID string, clientSecret string, logger zerol
 	}
 }
 
-// TODO comment that properly
-// side effect: load into session
-// returns what was stored in the session
-func (h *Handler) LoadUserData(accessToken *oauth2.Token, req *http.Request, res http.ResponseWriter) (*authentication.User, error) {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return nil, err
-	}
+// Name returns the name of the provider, as used in the authentication routes.
+func (h *Handler) Name() string {
+	return providerName
+}
 
+// LoadUserData fetches the profile of the user owning the access token.
+func (h *Handler) LoadUserData(accessToken *oauth2.Token, req *http.Request, res http.ResponseWriter) (*authentication.User, error) {
 	client := github.NewClient(h.oauthConfig.Client(context.Background(), accessToken))
 
 	user, _, err := client.Users.Get(context.Background(), "")
@@ -64,49 +61,19 @@ func (h *Handler) LoadUserData(accessToken *oauth2.Token, req *http.Request, res
 		return nil, err
 	}
 
-	userSession := &authentication.User{
+	u := &authentication.User{
 		Login:     *user.Login,
 		AvatarURL: *user.AvatarURL,
+		Provider:  providerName,
 	}
 
 	// email can be defined as private in Github, and if that's the case
 	// this field will be nil.
 	if user.Email != nil {
-		userSession.Email = *user.Email
-	}
-
-	b, err := json.Marshal(userSession)
-	if err != nil {
-		return nil, err
+		u.Email = *user.Email
 	}
 
-	session.Values["user"] = b
-	if err := session.Save(req, res); err != nil {
-		return nil, err
-	}
-
-	return userSession, nil
-}
-
-func (h *Handler) CurrentUser(req *http.Request) (*authentication.User, error) {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	var b []byte
-	b, ok := session.Values["user"].([]byte)
-	if !ok {
-		return nil, nil
-	}
-
-	var userSession authentication.User
-	err = json.Unmarshal(b, &userSession)
-	if err != nil {
-		return nil, err
-	}
-
-	return &userSession, nil
+	return u, nil
 }
 
 func (h *Handler) Start(res http.ResponseWriter, req *http.Request) error {
@@ -162,21 +129,3 @@ func (h *Handler) Callback(res http.ResponseWriter, req *http.Request, beforeWri
 	http.Redirect(res, req, "/", 302)
 	return nil
 }
-
-func (h *Handler) Destroy(res http.ResponseWriter, req *http.Request) error {
-	session, err := h.sessionStore.Get(req, sessionKey)
-	if err != nil {
-		return err
-	}
-
-	// kill the session
-	session.Options.MaxAge = -1
-	session.Values["user"] = nil // TODO max age probably makes this unnecessary
-	err = session.Save(req, res)
-	if err != nil {
-		return err
-	}
-
-	http.Redirect(res, req, "/", 302)
-	return nil
-}
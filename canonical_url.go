@@ -0,0 +1,62 @@
+package tabloid
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// trackingParams are the query parameters that only serve to track where a visitor came from, which don't
+// change what a link points to.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref_src": true,
+}
+
+// CanonicalURL returns a normalized form of the given URL, so links to the same page can be told apart from
+// different ones: the scheme, a "www." prefix, default ports, trailing slashes, fragments and tracking
+// parameters (utm_* and the like) are dropped, the host is lowercased and the remaining parameters are sorted.
+// It's meant to be compared, not to be followed.
+func CanonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if strings.HasPrefix(strings.ToLower(k), "utm_") || trackingParams[strings.ToLower(k)] {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	canonical := host + path
+	if len(params) > 0 {
+		canonical += "?" + strings.Join(params, "&")
+	}
+
+	return canonical
+}
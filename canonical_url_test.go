@@ -0,0 +1,32 @@
+package tabloid
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://foobar.com", "foobar.com"},
+		{"https://www.FooBar.com/", "foobar.com"},
+		{"https://foobar.com:443/a/b/", "foobar.com/a/b"},
+		{"http://foobar.com:8080/a", "foobar.com:8080/a"},
+		{"https://foobar.com/Case?b=2&a=1#section", "foobar.com/Case?a=1&b=2"},
+		{"https://foobar.com/a?utm_source=hn&UTM_Medium=x&fbclid=123&id=42", "foobar.com/a?id=42"},
+		{"  https://foobar.com/a  ", "foobar.com/a"},
+		{"", ""},
+		{"not a url", "not a url"},
+	}
+
+	for _, test := range tests {
+		c.Assert(CanonicalURL(test.url), qt.Equals, test.want, qt.Commentf("url %q", test.url))
+	}
+
+	c.Assert(NewStory("t", "", "1", "https://www.foobar.com/?utm_source=x").CanonicalURL, qt.Equals, "foobar.com")
+}
@@ -0,0 +1,272 @@
+// Package client is a Go client for the JSON API of Tabloid, described in assets/api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Story is a story, as returned by the API. Comments are only set when fetching a single story.
+type Story struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	URL           string     `json:"url"`
+	Domain        string     `json:"domain"`
+	Body          string     `json:"body"`
+	BodyHTML      string     `json:"body_html"`
+	Score         int64      `json:"score"`
+	Author        string     `json:"author"`
+	CommentsCount int64      `json:"comments_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Pinned        bool       `json:"pinned"`
+	Locked        bool       `json:"locked"`
+	Held          bool       `json:"held"`
+	Vote          string     `json:"vote"`
+	Comments      []*Comment `json:"comments"`
+}
+
+// Comment is a comment, along its replies.
+type Comment struct {
+	ID        string     `json:"id"`
+	StoryID   string     `json:"story_id"`
+	BodyHTML  string     `json:"body_html"`
+	Score     int64      `json:"score"`
+	Author    string     `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+	Removed   bool       `json:"removed"`
+	Hidden    bool       `json:"hidden"`
+	Held      bool       `json:"held"`
+	CanEdit   bool       `json:"can_edit"`
+	Vote      string     `json:"vote"`
+	Replies   []*Comment `json:"replies"`
+}
+
+// User is the public profile of a user.
+type User struct {
+	Name      string    `json:"name"`
+	Karma     int       `json:"karma"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StoriesPage is a page of the front page stories. NextPage is nil on the last page.
+type StoriesPage struct {
+	Stories  []*Story `json:"stories"`
+	NextPage *int     `json:"next_page"`
+}
+
+// NewStory is a story to submit, with either an URL or a body. Repost submits an already submitted link
+// again, once the repost window is over.
+type NewStory struct {
+	Title  string `json:"title"`
+	URL    string `json:"url,omitempty"`
+	Body   string `json:"body,omitempty"`
+	Repost bool   `json:"repost,omitempty"`
+}
+
+// Error is an error returned by the API. Fields lists the invalid fields if any, and Location points to
+// the existing story when submitting a link twice.
+type Error struct {
+	Status   int      `json:"status"`
+	Message  string   `json:"message"`
+	Fields   []string `json:"fields"`
+	Location string   `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) > 0 {
+		return fmt.Sprintf("tabloid: %d %s (%s)", e.Status, e.Message, strings.Join(e.Fields, ", "))
+	}
+	return fmt.Sprintf("tabloid: %d %s", e.Status, e.Message)
+}
+
+// IsNotFound returns true if the error is the API not finding what was asked for.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// Client talks to the API of a Tabloid instance.
+type Client struct {
+	// BaseURL is the root of the instance, like https://tabloid.example.com.
+	BaseURL string
+	// Token is a personal access token, required to write. Reading works without it.
+	Token string
+	// HTTPClient is the client requests go through, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the instance at the given base URL, authenticated with the given personal access
+// token if it isn't empty.
+func New(baseURL string, token string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token}
+}
+
+// Stories returns a page of the front page stories, starting at zero.
+func (c *Client) Stories(ctx context.Context, page int) (*StoriesPage, error) {
+	var result StoriesPage
+	err := c.do(ctx, "GET", "/stories?page="+strconv.Itoa(page), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// EachStory calls fn on every story of the front page, going through the pages until the last one.
+// Returning an error from fn stops and returns it.
+func (c *Client) EachStory(ctx context.Context, fn func(*Story) error) error {
+	page := 0
+	for {
+		result, err := c.Stories(ctx, page)
+		if err != nil {
+			return err
+		}
+
+		for _, story := range result.Stories {
+			err := fn(story)
+			if err != nil {
+				return err
+			}
+		}
+
+		if result.NextPage == nil {
+			return nil
+		}
+		page = *result.NextPage
+	}
+}
+
+// Story returns a story along its tree of comments.
+func (c *Client) Story(ctx context.Context, id string) (*Story, error) {
+	var story Story
+	err := c.do(ctx, "GET", "/stories/"+url.PathEscape(id), nil, &story)
+	if err != nil {
+		return nil, err
+	}
+
+	return &story, nil
+}
+
+// User returns the public profile of a user.
+func (c *Client) User(ctx context.Context, name string) (*User, error) {
+	var user User
+	err := c.do(ctx, "GET", "/users/"+url.PathEscape(name), nil, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SubmitStory submits a story.
+func (c *Client) SubmitStory(ctx context.Context, input *NewStory) (*Story, error) {
+	var story Story
+	err := c.do(ctx, "POST", "/stories", input, &story)
+	if err != nil {
+		return nil, err
+	}
+
+	return &story, nil
+}
+
+// SubmitComment comments on a story, replying to the comment with the given parent id if it isn't empty.
+func (c *Client) SubmitComment(ctx context.Context, storyID string, parentID string, body string) (*Comment, error) {
+	input := map[string]string{"body": body}
+	if parentID != "" {
+		input["parent_id"] = parentID
+	}
+
+	var comment Comment
+	err := c.do(ctx, "POST", "/stories/"+url.PathEscape(storyID)+"/comments", input, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// UpdateComment replaces the body of a comment, within the edit window.
+func (c *Client) UpdateComment(ctx context.Context, id string, body string) (*Comment, error) {
+	var comment Comment
+	err := c.do(ctx, "PUT", "/comments/"+url.PathEscape(id), map[string]string{"body": body}, &comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// VoteStory upvotes a story, or downvotes it if up is false.
+func (c *Client) VoteStory(ctx context.Context, id string, up bool) error {
+	return c.do(ctx, "POST", "/stories/"+url.PathEscape(id)+"/votes", map[string]bool{"up": up}, nil)
+}
+
+// VoteComment upvotes a comment, or downvotes it if up is false.
+func (c *Client) VoteComment(ctx context.Context, id string, up bool) error {
+	return c.do(ctx, "POST", "/comments/"+url.PathEscape(id)+"/votes", map[string]bool{"up": up}, nil)
+}
+
+// do sends a request to the given path of the API, encoding the input if any and decoding the response
+// into the output if any. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, path string, input interface{}, output interface{}) error {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/api/v1"+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var result struct {
+			Error *Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&result) != nil || result.Error == nil {
+			// not coming from the API, like a proxy in front of it
+			result.Error = &Error{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		result.Error.Location = resp.Header.Get("Location")
+		return result.Error
+	}
+
+	if output == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(output)
+}
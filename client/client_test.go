@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestClient(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/stories", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "POST" {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":{"status":401,"message":"Unauthorized"}}`))
+				return
+			}
+
+			var input NewStory
+			c.Assert(json.NewDecoder(r.Body).Decode(&input), qt.IsNil)
+			if input.Title == "" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"error":{"status":422,"message":"Unprocessable Entity","fields":["title"]}}`))
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&Story{ID: "3", Title: input.Title})
+			return
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "0":
+			w.Write([]byte(`{"stories":[{"id":"1"},{"id":"2"}],"next_page":1}`))
+		default:
+			w.Write([]byte(`{"stories":[{"id":"3"}],"next_page":null}`))
+		}
+	})
+	mux.HandleFunc("/api/v1/stories/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1","comments":[{"id":"4","replies":[{"id":"5","replies":[]}]}]}`))
+	})
+	mux.HandleFunc("/api/v1/stories/1/votes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	standIn := httptest.NewServer(mux)
+	defer standIn.Close()
+
+	c.Run("walking through the pages", func(c *qt.C) {
+		ids := []string{}
+		err := New(standIn.URL+"/", "").EachStory(ctx, func(story *Story) error {
+			ids = append(ids, story.ID)
+			return nil
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(ids, qt.DeepEquals, []string{"1", "2", "3"})
+	})
+
+	c.Run("fetching a story and its comments", func(c *qt.C) {
+		story, err := New(standIn.URL, "").Story(ctx, "1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(story.Comments, qt.HasLen, 1)
+		c.Assert(story.Comments[0].Replies[0].ID, qt.Equals, "5")
+
+		_, err = New(standIn.URL, "").Story(ctx, "2")
+		c.Assert(IsNotFound(err), qt.IsTrue)
+	})
+
+	c.Run("writing", func(c *qt.C) {
+		client := New(standIn.URL, "secret")
+
+		story, err := client.SubmitStory(ctx, &NewStory{Title: "Example", URL: "https://example.com"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(story.ID, qt.Equals, "3")
+
+		_, err = client.SubmitStory(ctx, &NewStory{URL: "https://example.com"})
+		c.Assert(err, qt.ErrorMatches, `tabloid: 422 Unprocessable Entity \(title\)`)
+		c.Assert(err.(*Error).Fields, qt.DeepEquals, []string{"title"})
+
+		_, err = New(standIn.URL, "").SubmitStory(ctx, &NewStory{Title: "Example"})
+		c.Assert(err.(*Error).Status, qt.Equals, http.StatusUnauthorized)
+
+		c.Assert(client.VoteStory(ctx, "1", true), qt.IsNil)
+	})
+}
@@ -0,0 +1,112 @@
+// Package main provides administration commands that are run against the database directly, like promoting
+// the first admin of an instance, who can then manage roles from the web interface.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jhchabran/tabloid"
+	"github.com/jhchabran/tabloid/cmd"
+	"github.com/jhchabran/tabloid/pgstore"
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `usage: admin <command> [arguments]
+
+commands:
+  promote <user name> [role]   gives a role to a user, admin by default (member, moderator, admin)
+  canonicalize                 fills the canonical url of the stories submitted before it was stored
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := cmd.DefaultConfig()
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Cannot read configuration")
+	}
+	logger := cmd.SetupLogger(cfg)
+
+	// setup database
+	var pg *pgstore.PGStore
+	if cfg.DatabaseURL != "" {
+		pg = pgstore.New(cfg.DatabaseURL)
+	} else {
+		pgcfg := fmt.Sprintf(
+			"user=%v dbname=%v sslmode=disable password=%v host=%v",
+			cfg.DatabaseUser,
+			cfg.DatabaseName,
+			cfg.DatabasePassword,
+			cfg.DatabaseHost,
+		)
+		pg = pgstore.New(pgcfg)
+	}
+
+	err = pg.Connect()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Can't connect to database")
+	}
+
+	switch os.Args[1] {
+	case "promote":
+		err = promote(pg, os.Args[2:])
+	case "canonicalize":
+		err = canonicalize(pg)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Command failed")
+	}
+}
+
+// promote gives a role to the user with the given name.
+func promote(pg *pgstore.PGStore, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("promote takes a user name and an optional role")
+	}
+
+	role := tabloid.RoleAdmin
+	if len(args) == 2 {
+		var err error
+		role, err = tabloid.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	user, err := pg.FindUserByLogin(args[0])
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return fmt.Errorf("no user named %q", args[0])
+	}
+
+	err = pg.UpdateUserRole(user.ID, role)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", user.Name, role)
+	return nil
+}
+
+// canonicalize fills the canonical URL of older stories, so their links are detected when submitted again.
+func canonicalize(pg *pgstore.PGStore) error {
+	count, err := pg.CanonicalizeURLs()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d stories updated\n", count)
+	return nil
+}
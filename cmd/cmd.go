@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -12,38 +13,85 @@ import (
 )
 
 type Config struct {
-	LogLevel                 string  `json:"log_level"`
-	LogFormat                string  `json:"log_format"`
-	DatabaseName             string  `json:"database_name"`
-	DatabaseUser             string  `json:"database_user"`
-	DatabaseHost             string  `json:"database_host"`
-	DatabasePassword         string  `json:"database_password"`
-	DatabaseURL              string  `json:"database_url"`
-	GithubClientID           string  `json:"github_client_id"`
-	GithubClientSecret       string  `json:"github_client_secret"`
-	ServerSecret             string  `json:"server_secret"`
-	StoriesPerPage           int     `json:"stories_per_page"`
-	EditWindowInMinutes      int     `json:"edit_window_in_minutes"`
-	FrontPageTimeBaseInHours int     `json:"front_page_time_base_in_hours"`
-	FrontPageGravity         float64 `json:"front_page_gravity"`
-	Addr                     string  `json:"addr"`
-	RootURL                  string  `json:"root_url"`
+	LogLevel                  string   `json:"log_level"`
+	LogFormat                 string   `json:"log_format"`
+	DatabaseName              string   `json:"database_name"`
+	DatabaseUser              string   `json:"database_user"`
+	DatabaseHost              string   `json:"database_host"`
+	DatabasePassword          string   `json:"database_password"`
+	DatabaseURL               string   `json:"database_url"`
+	GithubClientID            string   `json:"github_client_id"`
+	GithubClientSecret        string   `json:"github_client_secret"`
+	ServerSecret              string   `json:"server_secret"`
+	StoriesPerPage            int      `json:"stories_per_page"`
+	EditWindowInMinutes       int      `json:"edit_window_in_minutes"`
+	FrontPageTimeBaseInHours  int      `json:"front_page_time_base_in_hours"`
+	FrontPageGravity          float64  `json:"front_page_gravity"`
+	SessionIdleTimeoutInHours int      `json:"session_idle_timeout_in_hours"`
+	SessionLifetimeInHours    int      `json:"session_lifetime_in_hours"`
+	FlagThreshold             int      `json:"flag_threshold"`
+	StoriesPerDay             int      `json:"stories_per_day"`
+	NewAccountStoriesPerDay   int      `json:"new_account_stories_per_day"`
+	NewAccountAgeInDays       int      `json:"new_account_age_in_days"`
+	CommentsPerHour           int      `json:"comments_per_hour"`
+	VotesPerHour              int      `json:"votes_per_hour"`
+	TitleFetchesPerHour       int      `json:"title_fetches_per_hour"`
+	MinKarmaToDownvote        int      `json:"min_karma_to_downvote"`
+	MinKarmaToFlag            int      `json:"min_karma_to_flag"`
+	HoldSubmissionsInDays     int      `json:"hold_submissions_in_days"`
+	BannedDomains             []string `json:"banned_domains"`
+	BannedWords               []string `json:"banned_words"`
+	MaxLinksPerPost           int      `json:"max_links_per_post"`
+	DuplicateWindowInHours    int      `json:"duplicate_window_in_hours"`
+	RepostWindowInDays        int      `json:"repost_window_in_days"`
+	FetchLinkMetadata         bool     `json:"fetch_link_metadata"`
+	LinkPreviews              bool     `json:"link_previews"`
+	ArchiveLinks              bool     `json:"archive_links"`
+	ArchiveMaxSizeInKB        int      `json:"archive_max_size_in_kb"`
+	ArchiveExcludedDomains    []string `json:"archive_excluded_domains"`
+	HookWorkers               int      `json:"hook_workers"`
+	HookTimeoutInSeconds      int      `json:"hook_timeout_in_seconds"`
+	HookRetries               int      `json:"hook_retries"`
+	SynchronousHooks          bool     `json:"synchronous_hooks"`
+	Addr                      string   `json:"addr"`
+	RootURL                   string   `json:"root_url"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		LogLevel:                 "info",
-		LogFormat:                "json",
-		DatabaseName:             "tabloid",
-		DatabaseUser:             "postgres",
-		DatabasePassword:         "postgres",
-		DatabaseHost:             "127.0.0.1",
-		StoriesPerPage:           10,
-		EditWindowInMinutes:      60,
-		FrontPageTimeBaseInHours: 3 * 24,
-		FrontPageGravity:         1.8,
-		Addr:                     "localhost:8080",
-		RootURL:                  "http://localhost:8080",
+		LogLevel:                  "info",
+		LogFormat:                 "json",
+		DatabaseName:              "tabloid",
+		DatabaseUser:              "postgres",
+		DatabasePassword:          "postgres",
+		DatabaseHost:              "127.0.0.1",
+		StoriesPerPage:            10,
+		EditWindowInMinutes:       60,
+		FrontPageTimeBaseInHours:  3 * 24,
+		FrontPageGravity:          1.8,
+		SessionIdleTimeoutInHours: 14 * 24,
+		SessionLifetimeInHours:    90 * 24,
+		FlagThreshold:             3,
+		StoriesPerDay:             20,
+		NewAccountStoriesPerDay:   5,
+		NewAccountAgeInDays:       7,
+		CommentsPerHour:           60,
+		VotesPerHour:              300,
+		TitleFetchesPerHour:       60,
+		MinKarmaToDownvote:        10,
+		MinKarmaToFlag:            5,
+		HoldSubmissionsInDays:     2,
+		MaxLinksPerPost:           5,
+		DuplicateWindowInHours:    24,
+		RepostWindowInDays:        30,
+		FetchLinkMetadata:         true,
+		LinkPreviews:              true,
+		ArchiveMaxSizeInKB:        1024,
+		HookWorkers:               4,
+		HookTimeoutInSeconds:      30,
+		HookRetries:               3,
+		Addr:                      "localhost:8080",
+		RootURL:                   "http://localhost:8080",
 	}
 }
 
@@ -150,6 +198,251 @@ func (c *Config) Load() error {
 		c.FrontPageGravity = vf
 	}
 
+	v = os.Getenv("SESSION_IDLE_TIMEOUT_IN_HOURS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.SessionIdleTimeoutInHours = vi
+	}
+
+	v = os.Getenv("SESSION_LIFETIME_IN_HOURS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.SessionLifetimeInHours = vi
+	}
+
+	v = os.Getenv("FLAG_THRESHOLD")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.FlagThreshold = vi
+	}
+
+	v = os.Getenv("STORIES_PER_DAY")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.StoriesPerDay = vi
+	}
+
+	v = os.Getenv("NEW_ACCOUNT_STORIES_PER_DAY")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.NewAccountStoriesPerDay = vi
+	}
+
+	v = os.Getenv("NEW_ACCOUNT_AGE_IN_DAYS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.NewAccountAgeInDays = vi
+	}
+
+	v = os.Getenv("COMMENTS_PER_HOUR")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.CommentsPerHour = vi
+	}
+
+	v = os.Getenv("VOTES_PER_HOUR")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.VotesPerHour = vi
+	}
+
+	v = os.Getenv("TITLE_FETCHES_PER_HOUR")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.TitleFetchesPerHour = vi
+	}
+
+	v = os.Getenv("MIN_KARMA_TO_DOWNVOTE")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.MinKarmaToDownvote = vi
+	}
+
+	v = os.Getenv("MIN_KARMA_TO_FLAG")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.MinKarmaToFlag = vi
+	}
+
+	v = os.Getenv("HOLD_SUBMISSIONS_IN_DAYS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.HoldSubmissionsInDays = vi
+	}
+
+	v = os.Getenv("BANNED_DOMAINS")
+	if v != "" {
+		c.BannedDomains = splitList(v)
+	}
+
+	v = os.Getenv("BANNED_WORDS")
+	if v != "" {
+		c.BannedWords = splitList(v)
+	}
+
+	v = os.Getenv("MAX_LINKS_PER_POST")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.MaxLinksPerPost = vi
+	}
+
+	v = os.Getenv("DUPLICATE_WINDOW_IN_HOURS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.DuplicateWindowInHours = vi
+	}
+
+	v = os.Getenv("REPOST_WINDOW_IN_DAYS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.RepostWindowInDays = vi
+	}
+
+	v = os.Getenv("FETCH_LINK_METADATA")
+	if v != "" {
+		vb, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+
+		c.FetchLinkMetadata = vb
+	}
+
+	v = os.Getenv("LINK_PREVIEWS")
+	if v != "" {
+		vb, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+
+		c.LinkPreviews = vb
+	}
+
+	v = os.Getenv("ARCHIVE_LINKS")
+	if v != "" {
+		vb, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+
+		c.ArchiveLinks = vb
+	}
+
+	v = os.Getenv("ARCHIVE_MAX_SIZE_IN_KB")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.ArchiveMaxSizeInKB = vi
+	}
+
+	v = os.Getenv("ARCHIVE_EXCLUDED_DOMAINS")
+	if v != "" {
+		c.ArchiveExcludedDomains = splitList(v)
+	}
+
+	v = os.Getenv("HOOK_WORKERS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.HookWorkers = vi
+	}
+
+	v = os.Getenv("HOOK_TIMEOUT_IN_SECONDS")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.HookTimeoutInSeconds = vi
+	}
+
+	v = os.Getenv("HOOK_RETRIES")
+	if v != "" {
+		vi, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+
+		c.HookRetries = vi
+	}
+
+	v = os.Getenv("SYNCHRONOUS_HOOKS")
+	if v != "" {
+		vb, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+
+		c.SynchronousHooks = vb
+	}
+
 	v = os.Getenv("ADDR")
 	if v != "" {
 		c.Addr = v
@@ -180,6 +473,19 @@ func (c *Config) Load() error {
 	return nil
 }
 
+// splitList returns the comma separated values of a setting, without blanks.
+func splitList(v string) []string {
+	var values []string
+	for _, value := range strings.Split(v, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
 func SetupLogger(cfg *Config) zerolog.Logger {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
@@ -114,11 +114,53 @@ func main() {
 
 	// create the server
 	s := tabloid.NewServer(&tabloid.ServerConfig{
-		Addr:                cfg.Addr,
-		StoriesPerPage:      cfg.StoriesPerPage,
-		EditWindowInMinutes: cfg.EditWindowInMinutes,
+		Addr:                      cfg.Addr,
+		StoriesPerPage:            cfg.StoriesPerPage,
+		EditWindowInMinutes:       cfg.EditWindowInMinutes,
+		SessionIdleTimeoutInHours: cfg.SessionIdleTimeoutInHours,
+		SessionLifetimeInHours:    cfg.SessionLifetimeInHours,
+		FlagThreshold:             cfg.FlagThreshold,
+		MinKarmaToDownvote:        cfg.MinKarmaToDownvote,
+		MinKarmaToFlag:            cfg.MinKarmaToFlag,
+		NewUserInDays:             cfg.NewAccountAgeInDays,
+		HoldSubmissionsInDays:     cfg.HoldSubmissionsInDays,
+		RepostWindowInDays:        cfg.RepostWindowInDays,
+		FetchLinkMetadata:         cfg.FetchLinkMetadata,
+		LinkPreviews:              cfg.LinkPreviews,
+		ArchiveLinks:              cfg.ArchiveLinks,
+		ArchiveMaxSizeInKB:        cfg.ArchiveMaxSizeInKB,
+		ArchiveExcludedDomains:    cfg.ArchiveExcludedDomains,
+		HookWorkers:               cfg.HookWorkers,
+		HookTimeoutInSeconds:      cfg.HookTimeoutInSeconds,
+		HookRetries:               cfg.HookRetries,
+		SynchronousHooks:          cfg.SynchronousHooks,
+		RateLimits: map[tabloid.RateLimitAction]tabloid.RateLimit{
+			tabloid.RateLimitSubmit: {
+				Count:           cfg.StoriesPerDay,
+				Period:          24 * time.Hour,
+				NewAccountCount: cfg.NewAccountStoriesPerDay,
+				NewAccountAge:   time.Duration(cfg.NewAccountAgeInDays) * 24 * time.Hour,
+			},
+			tabloid.RateLimitComment:    {Count: cfg.CommentsPerHour, Period: time.Hour},
+			tabloid.RateLimitVote:       {Count: cfg.VotesPerHour, Period: time.Hour},
+			tabloid.RateLimitFetchTitle: {Count: cfg.TitleFetchesPerHour, Period: time.Hour},
+		},
 	}, logger, pg, authService)
 
+	// reject or hold spam before it's stored
+	if len(cfg.BannedDomains) > 0 {
+		s.AddFilter(tabloid.BannedDomains(cfg.BannedDomains))
+	}
+	if len(cfg.BannedWords) > 0 {
+		s.AddFilter(tabloid.BannedWords(cfg.BannedWords))
+	}
+	if cfg.MaxLinksPerPost > 0 {
+		s.AddFilter(tabloid.LinkLimit(cfg.MaxLinksPerPost))
+	}
+	if cfg.DuplicateWindowInHours > 0 {
+		s.AddFilter(&tabloid.DuplicateBodies{Store: pg, Window: time.Duration(cfg.DuplicateWindowInHours) * time.Hour})
+	}
+
 	// create the slack client; needed scope channel list, user list, post messages
 	slackToken := os.Getenv("SLACK_TOKEN")
 	api := slack.New(slackToken)
@@ -25,12 +25,33 @@ type Comment struct {
 	AuthorID        string         `db:"author_id"`
 	Author          string         `db:"author"`
 	CreatedAt       time.Time      `db:"created_at"`
+	DeletedAt       sql.NullTime   `db:"deleted_at"`
+	HiddenAt        sql.NullTime   `db:"hidden_at"`
+	// HeldAt is set when a filter held the comment, until a moderator reviews it.
+	HeldAt sql.NullTime `db:"held_at"`
+}
+
+// IsRemoved returns true if the comment has been removed by a moderator.
+func (c *Comment) IsRemoved() bool {
+	return c.DeletedAt.Valid
+}
+
+// IsHidden returns true if the comment has been hidden after being flagged too many times, until
+// a moderator reviews it.
+func (c *Comment) IsHidden() bool {
+	return c.HiddenAt.Valid
+}
+
+// IsHeld returns true if the comment is waiting for a moderator to review it.
+func (c *Comment) IsHeld() bool {
+	return c.HeldAt.Valid
 }
 
 func (c *Comment) GetID() string                      { return c.ID }
 func (c *Comment) GetScore() int64                    { return c.Score }
 func (c *Comment) Age() time.Time                     { return c.CreatedAt }
 func (c *Comment) GetParentCommentID() sql.NullString { return c.ParentCommentID }
+func (c *Comment) GetAuthorID() string                { return c.AuthorID }
 func (c *Comment) Pings() []string {
 	matches := usernameRegexp.FindAllStringSubmatch(c.Body, -1)
 
@@ -62,28 +83,37 @@ type CommentAccessor interface {
 
 // TODO move this in a better place
 type CommentPresenter struct {
-	ID         string
-	StoryID    string
-	Path       string
-	ParentPath string
-	StoryPath  string
-	Body       template.HTML
-	Score      int64
-	Author     string
-	CreatedAt  time.Time
-	Children   []*CommentPresenter
-	Upvoted    bool
-	CanEdit    bool
+	ID         string              `json:"id"`
+	StoryID    string              `json:"story_id"`
+	Path       string              `json:"-"`
+	ParentPath string              `json:"-"`
+	StoryPath  string              `json:"-"`
+	Body       template.HTML       `json:"body_html"`
+	Score      int64               `json:"score"`
+	Author     string              `json:"author,omitempty"`
+	AuthorID   string              `json:"-"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Children   []*CommentPresenter `json:"replies"`
+	Upvoted    bool                `json:"upvoted"`
+	Downvoted  bool                `json:"downvoted"`
+	CanEdit    bool                `json:"can_edit"`
+	// Removed is true if a moderator removed the comment, in which case its body and author are blanked.
+	Removed bool `json:"removed"`
+	// Hidden is true if the comment has been flagged too many times, its body being blanked until reviewed.
+	Hidden bool `json:"hidden"`
+	// Held is true if a filter held the comment, its body being blanked until reviewed.
+	Held bool `json:"held"`
 }
 
-// CanEdit returns true if the given user is the author and if the creation date is still within
-// the given edit window.
-func (c *CommentPresenter) SetCanEdit(userName string, editWindow time.Duration, at time.Time) {
-	c.CanEdit = userName == c.Author && c.CreatedAt.Add(editWindow).After(at)
+// SetCanEdit sets CanEdit according to CanEditComment, so templates can tell if the given user can
+// edit the comment. Removed and hidden comments can't be edited.
+func (c *CommentPresenter) SetCanEdit(user *User, editWindow time.Duration, at time.Time) {
+	c.CanEdit = !c.Removed && !c.Hidden && !c.Held && CanEditComment(user, c, editWindow, at)
 }
 
-func (c *CommentPresenter) GetScore() int64 { return c.Score }
-func (c *CommentPresenter) Age() time.Time  { return c.CreatedAt }
+func (c *CommentPresenter) GetScore() int64     { return c.Score }
+func (c *CommentPresenter) Age() time.Time      { return c.CreatedAt }
+func (c *CommentPresenter) GetAuthorID() string { return c.AuthorID }
 
 // CommentTree is a simple tree of comments ordered by score
 type CommentNode struct {
@@ -93,11 +123,11 @@ type CommentNode struct {
 
 type CommentPresentersTree []*CommentPresenter
 
-func (t CommentPresentersTree) SetCanEdits(userName string, editWindow time.Duration, at time.Time) {
+func (t CommentPresentersTree) SetCanEdits(user *User, editWindow time.Duration, at time.Time) {
 	for children := t; len(children) > 0; {
 		next := []*CommentPresenter{}
 		for _, c := range children {
-			c.SetCanEdit(userName, editWindow, at)
+			c.SetCanEdit(user, editWindow, at)
 			next = append(next, c.Children...)
 		}
 
@@ -196,26 +226,49 @@ func NewCommentPresenter(c *CommentNode) *CommentPresenter {
 	}
 
 	if comment, ok := c.Comment.(*CommentSeenByUser); ok {
-		return &CommentPresenter{
+		return blankRemoved(&CommentPresenter{
 			ID:        comment.ID,
 			StoryID:   comment.StoryID,
 			Body:      renderBody(comment.Body),
 			Score:     comment.Score,
 			Author:    comment.Author,
+			AuthorID:  comment.AuthorID,
 			CreatedAt: comment.CreatedAt,
 			Children:  children,
 			Upvoted:   comment.Up.Bool,
-		}
+			Downvoted: comment.Up.Valid && !comment.Up.Bool,
+			Removed:   comment.IsRemoved(),
+			Hidden:    comment.IsHidden(),
+			Held:      comment.IsHeld(),
+		})
 	} else {
 		comment, _ := c.Comment.(*Comment)
-		return &CommentPresenter{
+		return blankRemoved(&CommentPresenter{
 			ID:        comment.ID,
 			StoryID:   comment.StoryID,
 			Body:      renderBody(comment.Body),
 			Score:     comment.Score,
 			Author:    comment.Author,
+			AuthorID:  comment.AuthorID,
 			CreatedAt: comment.CreatedAt,
 			Children:  children,
-		}
+			Removed:   comment.IsRemoved(),
+			Hidden:    comment.IsHidden(),
+			Held:      comment.IsHeld(),
+		})
+	}
+}
+
+// blankRemoved hides the body and author of a removed comment, and the body of a hidden or held one, their replies
+// being still displayed.
+func blankRemoved(c *CommentPresenter) *CommentPresenter {
+	if c.Removed {
+		c.Body = template.HTML("<p><em>[removed]</em></p>")
+		c.Author = ""
+		c.AuthorID = ""
+	} else if c.Hidden || c.Held {
+		c.Body = template.HTML("<p><em>[hidden pending moderation]</em></p>")
 	}
+
+	return c
 }
@@ -0,0 +1,6 @@
+DROP TABLE site_settings;
+
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE stories DROP COLUMN pinned_until;
+ALTER TABLE stories DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN banned_at;
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN banned_at timestamp;
+ALTER TABLE stories ADD COLUMN deleted_at timestamp;
+ALTER TABLE stories ADD COLUMN pinned_until timestamp;
+ALTER TABLE comments ADD COLUMN deleted_at timestamp;
+
+CREATE TABLE site_settings (
+	id integer PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+	settings jsonb NOT NULL DEFAULT '{}'::jsonb,
+	updated_at timestamp NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE comments DROP COLUMN hidden_at;
+ALTER TABLE stories DROP COLUMN hidden_at;
+DROP TABLE flags;
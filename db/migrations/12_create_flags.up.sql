@@ -0,0 +1,18 @@
+CREATE TABLE flags (
+	id serial PRIMARY KEY,
+	story_id integer NOT NULL,
+	comment_id integer default NULL,
+	user_id integer NOT NULL,
+	reason varchar(32) NOT NULL,
+	created_at timestamp NOT NULL,
+	resolved_at timestamp
+);
+
+CREATE INDEX flags_story_id_idx ON flags (story_id);
+CREATE INDEX flags_comment_id_idx ON flags (comment_id);
+CREATE INDEX flags_open_idx ON flags (created_at) WHERE resolved_at IS NULL;
+CREATE UNIQUE INDEX flags_stories_idx ON flags (user_id, story_id) WHERE comment_id IS NULL;
+CREATE UNIQUE INDEX flags_comments_idx ON flags (user_id, comment_id) WHERE comment_id IS NOT NULL;
+
+ALTER TABLE stories ADD COLUMN hidden_at timestamp;
+ALTER TABLE comments ADD COLUMN hidden_at timestamp;
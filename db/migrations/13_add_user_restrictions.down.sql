@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN shadow_banned_at;
+ALTER TABLE users DROP COLUMN suspension_reason;
+ALTER TABLE users DROP COLUMN suspended_until;
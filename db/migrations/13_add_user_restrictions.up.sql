@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN suspended_until timestamp;
+ALTER TABLE users ADD COLUMN suspension_reason text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN shadow_banned_at timestamp;
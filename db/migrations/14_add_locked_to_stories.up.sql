@@ -0,0 +1 @@
+ALTER TABLE stories ADD COLUMN locked boolean NOT NULL DEFAULT false;
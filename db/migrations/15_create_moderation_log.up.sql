@@ -0,0 +1,16 @@
+CREATE TABLE moderation_log (
+	id serial PRIMARY KEY,
+	moderator_id integer default NULL,
+	action varchar(64) NOT NULL,
+	story_id integer default NULL,
+	comment_id integer default NULL,
+	user_id integer default NULL,
+	reason text NOT NULL DEFAULT '',
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX moderation_log_created_at_idx ON moderation_log (created_at);
+
+-- the log is append-only
+CREATE RULE moderation_log_no_update AS ON UPDATE TO moderation_log DO INSTEAD NOTHING;
+CREATE RULE moderation_log_no_delete AS ON DELETE TO moderation_log DO INSTEAD NOTHING;
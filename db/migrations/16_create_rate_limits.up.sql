@@ -0,0 +1,5 @@
+CREATE TABLE rate_limits (
+	key varchar(128) PRIMARY KEY,
+	tokens double precision NOT NULL,
+	updated_at timestamp NOT NULL
+);
@@ -0,0 +1,20 @@
+ALTER TABLE stories DROP COLUMN held_at;
+
+DROP TRIGGER update_comments_score_on_change ON votes;
+DROP TRIGGER update_stories_score_on_change ON votes;
+
+CREATE OR REPLACE FUNCTION update_stories_score() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE stories SET score = score + (case when NEW.up then 1 else -1 end) WHERE id=NEW.story_id;
+	RETURN NULL; -- after trigger, result is ignored
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_comments_score() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE comments SET score = score + (case when NEW.up then 1 else -1 end) WHERE id=NEW.comment_id;
+	RETURN NULL; -- after trigger, result is ignored
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE users DROP COLUMN karma;
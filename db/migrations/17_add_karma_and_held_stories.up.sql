@@ -0,0 +1,62 @@
+-- karma is the score users received from others on their stories and comments
+ALTER TABLE users ADD COLUMN karma integer NOT NULL DEFAULT 0;
+
+UPDATE users SET karma = received.karma FROM (
+	SELECT COALESCE(comments.author_id, stories.author_id) AS author_id,
+		SUM(CASE WHEN votes.up THEN 1 ELSE -1 END) AS karma
+	FROM votes
+	LEFT JOIN stories ON stories.id = votes.story_id
+	LEFT JOIN comments ON comments.id = votes.comment_id
+	WHERE votes.user_id <> COALESCE(comments.author_id, stories.author_id)
+	GROUP BY 1
+) received WHERE users.id = received.author_id;
+
+-- votes can now be changed, from up to down and the other way around
+CREATE OR REPLACE FUNCTION update_stories_score() RETURNS TRIGGER AS $$
+DECLARE
+	delta integer := (case when NEW.up then 1 else -1 end);
+BEGIN
+	IF TG_OP = 'UPDATE' THEN
+		IF OLD.up IS NOT DISTINCT FROM NEW.up THEN
+			RETURN NULL;
+		END IF;
+		delta := delta * 2;
+	END IF;
+
+	UPDATE stories SET score = score + delta WHERE id=NEW.story_id;
+	UPDATE users SET karma = karma + delta
+		WHERE id = (SELECT author_id FROM stories WHERE id=NEW.story_id) AND id <> NEW.user_id;
+	RETURN NULL; -- after trigger, result is ignored
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_comments_score() RETURNS TRIGGER AS $$
+DECLARE
+	delta integer := (case when NEW.up then 1 else -1 end);
+BEGIN
+	IF TG_OP = 'UPDATE' THEN
+		IF OLD.up IS NOT DISTINCT FROM NEW.up THEN
+			RETURN NULL;
+		END IF;
+		delta := delta * 2;
+	END IF;
+
+	UPDATE comments SET score = score + delta WHERE id=NEW.comment_id;
+	UPDATE users SET karma = karma + delta
+		WHERE id = (SELECT author_id FROM comments WHERE id=NEW.comment_id) AND id <> NEW.user_id;
+	RETURN NULL; -- after trigger, result is ignored
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_stories_score_on_change
+AFTER UPDATE ON votes
+FOR EACH ROW
+	EXECUTE PROCEDURE update_stories_score();
+
+CREATE TRIGGER update_comments_score_on_change
+AFTER UPDATE ON votes
+FOR EACH ROW
+	EXECUTE PROCEDURE update_comments_score();
+
+-- stories submitted by new accounts can be held until a moderator reviews them
+ALTER TABLE stories ADD COLUMN held_at timestamp;
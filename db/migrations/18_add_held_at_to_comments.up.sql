@@ -0,0 +1,2 @@
+-- comments caught by a filter are held until a moderator reviews them
+ALTER TABLE comments ADD COLUMN held_at timestamp;
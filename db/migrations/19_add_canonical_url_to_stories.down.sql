@@ -0,0 +1,2 @@
+DROP INDEX stories_canonical_url_idx;
+ALTER TABLE stories DROP COLUMN canonical_url;
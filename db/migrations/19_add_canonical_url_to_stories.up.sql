@@ -0,0 +1,4 @@
+-- the normalized url, used to find out if a link has already been submitted; existing stories are filled
+-- with `admin canonicalize`
+ALTER TABLE stories ADD COLUMN canonical_url text NOT NULL DEFAULT '';
+CREATE INDEX stories_canonical_url_idx ON stories (canonical_url) WHERE canonical_url <> '';
@@ -0,0 +1,2 @@
+DROP INDEX stories_domain_idx;
+ALTER TABLE stories DROP COLUMN domain;
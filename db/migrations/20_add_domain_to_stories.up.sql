@@ -0,0 +1,5 @@
+-- the host of the story url, without its www. prefix, empty for self posts
+ALTER TABLE stories ADD COLUMN domain text NOT NULL DEFAULT '';
+UPDATE stories SET domain = lower(regexp_replace(substring(url from '^[a-zA-Z][a-zA-Z0-9+.-]*://(?:[^@/]*@)?([^/:?#]+)'), '^www\.', ''))
+	WHERE url <> '' AND url ~ '^[a-zA-Z][a-zA-Z0-9+.-]*://';
+CREATE INDEX stories_domain_idx ON stories (domain) WHERE domain <> '';
@@ -0,0 +1,9 @@
+CREATE TABLE link_metadata (
+	canonical_url text PRIMARY KEY,
+	url text NOT NULL,
+	title text NOT NULL DEFAULT '',
+	description text NOT NULL DEFAULT '',
+	image_url text NOT NULL DEFAULT '',
+	error text NOT NULL DEFAULT '',
+	fetched_at timestamp NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX link_metadata_fetched_at_idx;
+ALTER TABLE link_metadata DROP COLUMN site_name;
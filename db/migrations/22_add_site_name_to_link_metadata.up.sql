@@ -0,0 +1,2 @@
+ALTER TABLE link_metadata ADD COLUMN site_name text NOT NULL DEFAULT '';
+CREATE INDEX link_metadata_fetched_at_idx ON link_metadata (fetched_at);
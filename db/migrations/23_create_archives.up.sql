@@ -0,0 +1,9 @@
+CREATE TABLE archives (
+	canonical_url text PRIMARY KEY,
+	url text NOT NULL,
+	title text NOT NULL DEFAULT '',
+	text text NOT NULL DEFAULT '',
+	truncated boolean NOT NULL DEFAULT false,
+	error text NOT NULL DEFAULT '',
+	fetched_at timestamp NOT NULL
+);
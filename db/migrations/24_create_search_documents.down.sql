@@ -0,0 +1,7 @@
+DROP TRIGGER comments_search_update ON comments;
+DROP FUNCTION comments_search_update();
+DROP TRIGGER stories_search_update ON stories;
+DROP FUNCTION stories_search_update();
+DROP FUNCTION story_search_document(text, text, text);
+DROP TABLE comments_search;
+DROP TABLE stories_search;
@@ -0,0 +1,49 @@
+-- Documents are kept apart from the stories and comments, so selecting those doesn't drag the vectors along.
+CREATE TABLE stories_search (
+	story_id integer PRIMARY KEY,
+	document tsvector NOT NULL
+);
+CREATE INDEX stories_search_document_idx ON stories_search USING GIN (document);
+
+CREATE TABLE comments_search (
+	comment_id integer PRIMARY KEY,
+	document tsvector NOT NULL
+);
+CREATE INDEX comments_search_document_idx ON comments_search USING GIN (document);
+
+-- Titles weigh more than URLs, which weigh more than bodies. URLs are split on punctuation so their
+-- words can be found.
+CREATE FUNCTION story_search_document(title text, url text, body text) RETURNS tsvector AS $$
+	SELECT setweight(to_tsvector('english', coalesce(title, '')), 'A')
+		|| setweight(to_tsvector('simple', regexp_replace(coalesce(url, ''), '[[:punct:]]+', ' ', 'g')), 'B')
+		|| setweight(to_tsvector('english', coalesce(body, '')), 'C')
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE FUNCTION stories_search_update() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO stories_search (story_id, document)
+	VALUES (NEW.id, story_search_document(NEW.title, NEW.url, NEW.body))
+	ON CONFLICT (story_id) DO UPDATE SET document = EXCLUDED.document;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stories_search_update AFTER INSERT OR UPDATE OF title, url, body ON stories
+	FOR EACH ROW EXECUTE PROCEDURE stories_search_update();
+
+CREATE FUNCTION comments_search_update() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO comments_search (comment_id, document)
+	VALUES (NEW.id, to_tsvector('english', coalesce(NEW.body, '')))
+	ON CONFLICT (comment_id) DO UPDATE SET document = EXCLUDED.document;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_search_update AFTER INSERT OR UPDATE OF body ON comments
+	FOR EACH ROW EXECUTE PROCEDURE comments_search_update();
+
+INSERT INTO stories_search (story_id, document)
+	SELECT id, story_search_document(title, url, body) FROM stories;
+INSERT INTO comments_search (comment_id, document)
+	SELECT id, to_tsvector('english', coalesce(body, '')) FROM comments;
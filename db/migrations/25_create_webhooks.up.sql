@@ -0,0 +1,24 @@
+CREATE TABLE webhooks (
+	id serial PRIMARY KEY,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events jsonb NOT NULL DEFAULT '[]'::jsonb,
+	created_at timestamp NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id serial PRIMARY KEY,
+	webhook_id integer NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event text NOT NULL,
+	payload text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamp,
+	delivered_at timestamp,
+	failed_at timestamp,
+	last_status integer NOT NULL DEFAULT 0,
+	last_error text NOT NULL DEFAULT '',
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries (created_at);
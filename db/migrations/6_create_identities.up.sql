@@ -0,0 +1,16 @@
+CREATE TABLE identities (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	provider varchar(255) NOT NULL,
+	login varchar(255) NOT NULL,
+	email varchar(255),
+	created_at timestamp NOT NULL,
+	last_login_at timestamp
+);
+
+CREATE UNIQUE INDEX identities_provider_login_idx ON identities (provider, login);
+CREATE INDEX identities_user_id_idx ON identities (user_id);
+
+-- until now, Github was the only provider and users were keyed by their Github login.
+INSERT INTO identities (user_id, provider, login, email, created_at, last_login_at)
+	SELECT id, 'github', name, email, COALESCE(created_at, now()), last_login_at FROM users;
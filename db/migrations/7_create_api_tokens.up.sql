@@ -0,0 +1,13 @@
+CREATE TABLE api_tokens (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	name varchar(255) NOT NULL,
+	token_hash varchar(64) NOT NULL,
+	scopes jsonb NOT NULL DEFAULT '[]'::jsonb,
+	created_at timestamp NOT NULL,
+	last_used_at timestamp,
+	revoked_at timestamp
+);
+
+CREATE UNIQUE INDEX api_tokens_token_hash_idx ON api_tokens (token_hash);
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
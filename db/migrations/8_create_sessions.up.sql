@@ -0,0 +1,13 @@
+CREATE TABLE sessions (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	token_hash varchar(64) NOT NULL,
+	provider varchar(255) NOT NULL,
+	user_agent text NOT NULL DEFAULT '',
+	ip varchar(255) NOT NULL DEFAULT '',
+	created_at timestamp NOT NULL,
+	last_seen_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX sessions_token_hash_idx ON sessions (token_hash);
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
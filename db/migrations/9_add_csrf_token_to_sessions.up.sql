@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN csrf_token varchar(64) NOT NULL DEFAULT md5(random()::text);
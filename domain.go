@@ -0,0 +1,179 @@
+package tabloid
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Domain returns the host of the given URL, lowercased and without its "www." prefix, or an empty string
+// if it has none.
+func Domain(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// onDomain returns true if host is the given domain or one of its subdomains.
+func onDomain(host string, domain string) bool {
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// penalizedRank returns the rank of a story, multiplied by the penalty of its domain if admins set one.
+func penalizedRank(story *Story, settings *SiteSettings) float64 {
+	r := rank(story)
+	// a penalty on a negative rank would raise it
+	if r > 0 {
+		r *= settings.DomainPenalty(story.Domain)
+	}
+
+	return r
+}
+
+// HandleDomain handles requests to list the stories from a given domain, most recent first.
+func (s *Server) HandleDomain() HandleE {
+	tmpl, err := template.New("domain.html").Funcs(s.helpers()).ParseFiles("assets/templates/domain.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html",
+		"assets/templates/_story.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to load templates")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		res.Header().Set("Content-Type", "text/html")
+
+		domain := strings.ToLower(params.ByName("host"))
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		// one more story tells if there is a next page
+		perPage := s.config.StoriesPerPage
+		storyPresenters := []*storyPresenter{}
+		session := ctxSession(req.Context())
+		if session != nil {
+			stories, err := s.store.ListStoriesByDomainWithVotes(domain, session.UserID, page, perPage+1)
+			if err != nil {
+				return err
+			}
+
+			for i, st := range stories {
+				pr := newStoryPresenterWithPos(&st.Story, 1+i+page*perPage)
+				pr.Upvoted = st.Up.Valid && st.Up.Bool
+				pr.Downvoted = st.Up.Valid && !st.Up.Bool
+				storyPresenters = append(storyPresenters, pr)
+			}
+		} else {
+			stories, err := s.store.ListStoriesByDomain(domain, page, perPage+1)
+			if err != nil {
+				return err
+			}
+
+			for i, st := range stories {
+				storyPresenters = append(storyPresenters, newStoryPresenterWithPos(st, 1+i+page*perPage))
+			}
+		}
+
+		nextPage := -1
+		if len(storyPresenters) > perPage {
+			storyPresenters = storyPresenters[:perPage]
+			nextPage = page + 1
+		}
+
+		return tmpl.Execute(res, map[string]interface{}{
+			"Domain":   domain,
+			"Stories":  storyPresenters,
+			"Session":  session,
+			"CurrPage": page,
+			"PrevPage": page - 1,
+			"NextPage": nextPage,
+		})
+	}
+}
+
+// DomainPenalty returns the factor applied to the rank of the stories from the given domain, 1 if
+// there is none. When both a domain and one of its subdomains have a penalty, the most specific one applies.
+func (ss *SiteSettings) DomainPenalty(domain string) float64 {
+	if ss == nil || domain == "" {
+		return 1
+	}
+
+	matched, penalty := "", 1.0
+	for d, p := range ss.DomainPenalties {
+		if onDomain(domain, d) && len(d) > len(matched) {
+			matched, penalty = d, p
+		}
+	}
+
+	return penalty
+}
+
+// BannedDomainsText returns the banned domains, one per line, for the settings form.
+func (ss *SiteSettings) BannedDomainsText() string {
+	return strings.Join(ss.BannedDomains, "\n")
+}
+
+// DomainPenaltiesText returns the domain penalties, one "domain factor" pair per line, for the settings form.
+func (ss *SiteSettings) DomainPenaltiesText() string {
+	lines := make([]string, 0, len(ss.DomainPenalties))
+	for domain, penalty := range ss.DomainPenalties {
+		lines = append(lines, domain+" "+strconv.FormatFloat(penalty, 'f', -1, 64))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// ParseBannedDomains parses the banned domains from the settings form, one per line.
+func ParseBannedDomains(text string) []string {
+	var domains []string
+	for _, line := range strings.Split(text, "\n") {
+		if domain := strings.ToLower(strings.TrimSpace(line)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains
+}
+
+// ParseDomainPenalties parses the domain penalties from the settings form, one "domain factor" pair per line,
+// the factor being a positive, finite number.
+func ParseDomainPenalties(text string) (map[string]float64, error) {
+	penalties := map[string]float64{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid domain penalty %q, expected a domain and a factor", strings.TrimSpace(line))
+		}
+
+		penalty, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(penalty) || math.IsInf(penalty, 0) || penalty <= 0 {
+			return nil, fmt.Errorf("invalid factor %q for %s", fields[1], fields[0])
+		}
+
+		penalties[strings.ToLower(fields[0])] = penalty
+	}
+
+	if len(penalties) == 0 {
+		return nil, nil
+	}
+
+	return penalties, nil
+}
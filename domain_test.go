@@ -0,0 +1,48 @@
+package tabloid
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDomain(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(Domain("https://www.FooBar.com/a?b=c"), qt.Equals, "foobar.com")
+	c.Assert(Domain("http://blog.foobar.com:8080"), qt.Equals, "blog.foobar.com")
+	c.Assert(Domain(""), qt.Equals, "")
+	c.Assert(NewStory("t", "", "1", "https://www.foobar.com/a").Domain, qt.Equals, "foobar.com")
+
+	c.Run("penalties", func(c *qt.C) {
+		penalties, err := ParseDomainPenalties("foobar.com 0.5\n\n  Spam.com   0.1 \nblog.foobar.com 0.8")
+		c.Assert(err, qt.IsNil)
+		c.Assert(penalties, qt.DeepEquals, map[string]float64{"foobar.com": 0.5, "spam.com": 0.1, "blog.foobar.com": 0.8})
+
+		settings := &SiteSettings{DomainPenalties: penalties}
+		c.Assert(settings.DomainPenalty("www.foobar.com"), qt.Equals, 0.5)
+		c.Assert(settings.DomainPenalty("notfoobar.com"), qt.Equals, 1.0)
+		c.Assert(settings.DomainPenaltiesText(), qt.Equals, "blog.foobar.com 0.8\nfoobar.com 0.5\nspam.com 0.1")
+
+		// the most specific domain applies, whatever the order of the map
+		for i := 0; i < 10; i++ {
+			c.Assert(settings.DomainPenalty("blog.foobar.com"), qt.Equals, 0.8)
+			c.Assert(settings.DomainPenalty("a.blog.foobar.com"), qt.Equals, 0.8)
+		}
+
+		_, err = ParseDomainPenalties("foobar.com")
+		c.Assert(err, qt.ErrorMatches, `invalid domain penalty "foobar.com", expected a domain and a factor`)
+		for _, factor := range []string{"-1", "0", "NaN", "Inf", "-Inf"} {
+			_, err = ParseDomainPenalties("foobar.com " + factor)
+			c.Assert(err, qt.ErrorMatches, `invalid factor "`+factor+`" for foobar.com`)
+		}
+
+		penalties, err = ParseDomainPenalties("")
+		c.Assert(err, qt.IsNil)
+		c.Assert(penalties, qt.IsNil)
+	})
+
+	c.Run("banned domains", func(c *qt.C) {
+		c.Assert(ParseBannedDomains(" Spam.com\n\nads.net "), qt.DeepEquals, []string{"spam.com", "ads.net"})
+	})
+}
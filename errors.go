@@ -2,15 +2,52 @@ package tabloid
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// An ErrorResponder responds to a request with the appropriate error, returning false if it can't, in which
+// case the request is answered with an internal server error. Responses go through writeError, so API
+// clients get them in JSON.
 type ErrorResponder interface {
 	RespondError(w http.ResponseWriter, r *http.Request) bool
 }
 
+// apiError is the body of the errors the API responds with.
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Status  int      `json:"status"`
+	Message string   `json:"message"`
+	Fields  []string `json:"fields,omitempty"`
+}
+
+// isAPIRequest returns true if the request targets the JSON API, or asked for JSON.
+func isAPIRequest(r *http.Request) bool {
+	return r != nil && r.URL != nil && (strings.HasPrefix(r.URL.Path, apiPrefix+"/") || wantsJSON(r))
+}
+
+// writeError responds with the given message and status code, along the invalid fields if any. API requests
+// get a JSON body, the others plain text, like http.Error.
+func writeError(w http.ResponseWriter, r *http.Request, msg string, code int, fields ...string) {
+	if !isAPIRequest(r) {
+		http.Error(w, msg, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(apiError{Error: apiErrorBody{Status: code, Message: msg, Fields: fields}})
+}
+
 // Maybe404Error responds with not found status code, if its supplied error
 // is sql.ErrNoRows.
 type Maybe404Error struct {
@@ -34,7 +71,25 @@ func (e *Maybe404Error) RespondError(w http.ResponseWriter, r *http.Request) boo
 		return false
 	}
 
-	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	writeError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	return true
+}
+
+// NotFoundError responds with not found status code.
+type NotFoundError struct {
+	path string
+}
+
+func NotFound(path string) *NotFoundError {
+	return &NotFoundError{path: path}
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("NotFoundError: %v", e.path)
+}
+
+func (e *NotFoundError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	writeError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	return true
 }
 
@@ -52,7 +107,152 @@ func (e *UnauthorizedError) Error() string {
 }
 
 func (e *UnauthorizedError) RespondError(w http.ResponseWriter, r *http.Request) bool {
-	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	writeError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return true
+}
+
+// ForbiddenError responds with forbidden status code.
+type ForbiddenError struct {
+	path string
+}
+
+func Forbidden(path string) *ForbiddenError {
+	return &ForbiddenError{path: path}
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("ForbiddenError: %v", e.path)
+}
+
+func (e *ForbiddenError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	writeError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return true
+}
+
+// CSRFError responds with forbidden status code when a state changing request doesn't carry
+// the CSRF token of the session.
+type CSRFError struct {
+	path string
+}
+
+func InvalidCSRFToken(path string) *CSRFError {
+	return &CSRFError{path: path}
+}
+
+func (e *CSRFError) Error() string {
+	return fmt.Sprintf("CSRFError: %v", e.path)
+}
+
+func (e *CSRFError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	writeError(w, r, "invalid CSRF token", http.StatusForbidden)
+	return true
+}
+
+// SuspendedError responds with forbidden status code when a suspended user attempts to post, telling them
+// why and until when.
+type SuspendedError struct {
+	until  time.Time
+	reason string
+}
+
+func Suspended(user *User) *SuspendedError {
+	return &SuspendedError{until: user.SuspendedUntil.Time, reason: user.SuspensionReason}
+}
+
+func (e *SuspendedError) Error() string {
+	return fmt.Sprintf("SuspendedError: until %v", e.until)
+}
+
+func (e *SuspendedError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	msg := fmt.Sprintf("Your account is suspended until %s", e.until.Format("2006-01-02 15:04 MST"))
+	if e.reason != "" {
+		msg += ": " + e.reason
+	}
+
+	writeError(w, r, msg, http.StatusForbidden)
+	return true
+}
+
+// StoryLockedError responds with forbidden status code when commenting on a locked story.
+type StoryLockedError struct {
+	path string
+}
+
+func StoryLocked(path string) *StoryLockedError {
+	return &StoryLockedError{path: path}
+}
+
+func (e *StoryLockedError) Error() string {
+	return fmt.Sprintf("StoryLockedError: %v", e.path)
+}
+
+func (e *StoryLockedError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	writeError(w, r, "This story is locked, it can't receive new comments.", http.StatusForbidden)
+	return true
+}
+
+// NotEnoughKarmaError responds with forbidden status code when a user doesn't have enough karma yet for
+// an action.
+type NotEnoughKarmaError struct {
+	required int
+}
+
+func NotEnoughKarma(required int) *NotEnoughKarmaError {
+	return &NotEnoughKarmaError{required: required}
+}
+
+func (e *NotEnoughKarmaError) Error() string {
+	return fmt.Sprintf("NotEnoughKarmaError: %d required", e.required)
+}
+
+func (e *NotEnoughKarmaError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	writeError(w, r, fmt.Sprintf("You need at least %d karma to do that.", e.required), http.StatusForbidden)
+	return true
+}
+
+// TooManyRequestsError responds with too many requests status code when a user exhausted their budget
+// for an action, telling them when to retry.
+type TooManyRequestsError struct {
+	retryAfter time.Duration
+}
+
+func TooManyRequests(retryAfter time.Duration) *TooManyRequestsError {
+	return &TooManyRequestsError{retryAfter: retryAfter}
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("TooManyRequestsError: retry after %v", e.retryAfter)
+}
+
+func (e *TooManyRequestsError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	// Retry-After is in whole seconds, rounded up so retrying right on time succeeds
+	seconds := int((e.retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, fmt.Sprintf("You're doing that too often, try again in %v.", time.Duration(seconds)*time.Second), http.StatusTooManyRequests)
+	return true
+}
+
+// DuplicateStoryError responds with conflict status code when submitting a link which already has been,
+// telling where the existing discussion is.
+type DuplicateStoryError struct {
+	storyID string
+}
+
+func DuplicateStory(existing *Story) *DuplicateStoryError {
+	return &DuplicateStoryError{storyID: existing.ID}
+}
+
+func (e *DuplicateStoryError) Error() string {
+	return fmt.Sprintf("DuplicateStoryError: %v", e.storyID)
+}
+
+func (e *DuplicateStoryError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Location", "/stories/"+e.storyID+"/comments")
+	writeError(w, r, "This link has already been submitted, see /stories/"+e.storyID+"/comments.", http.StatusConflict)
 	return true
 }
 
@@ -70,7 +270,7 @@ func (e *BadRequestError) Error() string {
 }
 
 func (e *BadRequestError) RespondError(w http.ResponseWriter, r *http.Request) bool {
-	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	writeError(w, r, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 	return true
 }
 
@@ -104,7 +304,11 @@ func (e *UnprocessableEntityError) Error() string {
 
 func (e *UnprocessableEntityError) RespondError(w http.ResponseWriter, r *http.Request) bool {
 	msg := fmt.Sprintf("%s: invalid %v", http.StatusText(http.StatusUnprocessableEntity), e.fieldNames)
-	http.Error(w, msg, http.StatusUnprocessableEntity)
+	// unlike other errors, the reason of a rejection is meant to be read by the user
+	if rejection, ok := e.err.(*FilterRejection); ok {
+		msg = fmt.Sprintf("%s: invalid %v, %s", http.StatusText(http.StatusUnprocessableEntity), e.fieldNames, rejection.Reason)
+	}
+	writeError(w, r, msg, http.StatusUnprocessableEntity, e.fieldNames...)
 	return true
 }
 
@@ -126,6 +330,6 @@ func (e *MethodNotAllowedError) Error() string {
 }
 
 func (e *MethodNotAllowedError) RespondError(w http.ResponseWriter, r *http.Request) bool {
-	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	writeError(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 	return true
 }
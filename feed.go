@@ -0,0 +1,479 @@
+package tabloid
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// feedSize is how many stories or comments a feed lists.
+const feedSize = 30
+
+// feedFormat is a format feeds are rendered in, named after the last segment of their paths.
+type feedFormat string
+
+const (
+	feedRSS  feedFormat = "rss"
+	feedAtom feedFormat = "atom"
+	feedJSON feedFormat = "feed.json"
+)
+
+var feedFormats = []feedFormat{feedRSS, feedAtom, feedJSON}
+
+// path returns the path of the feed following the page at the given path, "/rss" for the front page.
+func (f feedFormat) path(page string) string {
+	return strings.TrimSuffix(page, "/") + "/" + string(f)
+}
+
+func (f feedFormat) contentType() string {
+	switch f {
+	case feedAtom:
+		return "application/atom+xml; charset=utf-8"
+	case feedJSON:
+		return "application/feed+json; charset=utf-8"
+	default:
+		return "application/rss+xml; charset=utf-8"
+	}
+}
+
+// A feed lists stories or comments from a page of the site, newest or best first.
+type feed struct {
+	Title string
+	// Path is the page of the site the feed follows.
+	Path  string
+	Items []*feedItem
+	// Modified is when the listing last changed without its items telling, as when votes reorder it.
+	Modified time.Time
+}
+
+// A feedItem is a story or a comment in a feed. Path is its page on the site, which is also its id.
+type feedItem struct {
+	Path      string
+	Title     string
+	URL       string
+	Content   template.HTML
+	Author    string
+	CreatedAt time.Time
+}
+
+// updated returns when the feed last changed, which is when its most recent item was created unless
+// Modified is later, the zero time if it's empty.
+func (f *feed) updated() time.Time {
+	updated := f.Modified
+	for _, item := range f.Items {
+		if item.CreatedAt.After(updated) {
+			updated = item.CreatedAt
+		}
+	}
+
+	return updated
+}
+
+var feedStoryContent = template.Must(template.New("feed").Parse(
+	`{{if .URL}}<p><a href="{{.URL}}">{{.URL}}</a></p>{{end}}{{.Body}}<p><a href="{{.Link}}">{{.CommentsCount}} comments</a></p>`))
+
+// newStoryFeedItem turns a story into a feed item, linking to its comments.
+func newStoryFeedItem(sp *storyPresenter, base string) (*feedItem, error) {
+	path := "/stories/" + sp.ID + "/comments"
+
+	var content bytes.Buffer
+	err := feedStoryContent.Execute(&content, map[string]interface{}{
+		"URL":           sp.URL,
+		"Body":          sp.Body,
+		"Link":          base + path,
+		"CommentsCount": sp.CommentsCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &feedItem{
+		Path:      path,
+		Title:     sp.Title,
+		URL:       sp.URL,
+		Content:   template.HTML(content.String()),
+		Author:    sp.Author,
+		CreatedAt: sp.CreatedAt,
+	}, nil
+}
+
+// newStoriesFeed returns a feed of the given stories.
+func newStoriesFeed(title string, path string, stories []*Story, base string) (*feed, error) {
+	f := &feed{Title: title, Path: path}
+	for _, story := range stories {
+		item, err := newStoryFeedItem(newStoryPresenterWithBody(story), base)
+		if err != nil {
+			return nil, err
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	return f, nil
+}
+
+// storiesFeed returns a feed of the given stories, modified when they were last voted on or commented on.
+func (s *Server) storiesFeed(title string, path string, stories []*Story, req *http.Request) (*feed, error) {
+	f, err := newStoriesFeed(title, path, stories, baseURL(req))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(stories))
+	for i, story := range stories {
+		ids[i] = story.ID
+	}
+	if f.Modified, err = s.store.LastStoriesActivity(ids); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// baseURL returns the scheme and host the request was made to, to build the absolute links feeds need.
+func baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return scheme + "://" + req.Host
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Comments    string  `xml:"comments,omitempty"`
+	Creator     string  `xml:"http://purl.org/dc/elements/1.1/ creator,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// render encodes the feed in the given format, links being absolute to the given base URL. Self is the path
+// the feed is served at.
+func (f *feed) render(format feedFormat, base string, self string) ([]byte, error) {
+	link := base + f.Path
+	self = base + self
+
+	switch format {
+	case feedAtom:
+		updated := f.updated()
+		if updated.IsZero() {
+			updated = NowFunc()
+		}
+
+		out := atomFeed{
+			Title:   f.Title,
+			ID:      self,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: link, Rel: "alternate", Type: "text/html"},
+				{Href: self, Rel: "self", Type: format.contentType()},
+			},
+			Entries: []atomEntry{},
+		}
+		for _, item := range f.Items {
+			entry := atomEntry{
+				Title:     item.Title,
+				ID:        base + item.Path,
+				Updated:   item.CreatedAt.UTC().Format(time.RFC3339),
+				Published: item.CreatedAt.UTC().Format(time.RFC3339),
+				Links:     []atomLink{{Href: base + item.Path, Rel: "alternate", Type: "text/html"}},
+				Author:    atomAuthor{Name: item.Author},
+				Content:   atomContent{Type: "html", Body: string(item.Content)},
+			}
+			if item.URL != "" {
+				entry.Links = append(entry.Links, atomLink{Href: item.URL, Rel: "related"})
+			}
+			out.Entries = append(out.Entries, entry)
+		}
+
+		data, err := xml.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), data...), nil
+
+	case feedJSON:
+		out := jsonFeed{
+			Version:     "https://jsonfeed.org/version/1.1",
+			Title:       f.Title,
+			HomePageURL: link,
+			FeedURL:     self,
+			Items:       []jsonFeedItem{},
+		}
+		for _, item := range f.Items {
+			out.Items = append(out.Items, jsonFeedItem{
+				ID:            base + item.Path,
+				URL:           base + item.Path,
+				ExternalURL:   item.URL,
+				Title:         item.Title,
+				ContentHTML:   string(item.Content),
+				DatePublished: item.CreatedAt.UTC().Format(time.RFC3339),
+				Authors:       []jsonFeedAuthor{{Name: item.Author}},
+			})
+		}
+
+		return json.MarshalIndent(out, "", "  ")
+
+	default:
+		out := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:       f.Title,
+				Link:        link,
+				Description: f.Title,
+			},
+		}
+		if updated := f.updated(); !updated.IsZero() {
+			out.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+		}
+		for _, item := range f.Items {
+			rss := rssItem{
+				Title:       item.Title,
+				Link:        base + item.Path,
+				Description: string(item.Content),
+				Creator:     item.Author,
+				GUID:        rssGUID{IsPermaLink: true, Value: base + item.Path},
+				PubDate:     item.CreatedAt.UTC().Format(time.RFC1123Z),
+			}
+			// feed readers open the link, which is the story URL, comments being a click away
+			if item.URL != "" {
+				rss.Link = item.URL
+				rss.Comments = base + item.Path
+			}
+			out.Channel.Items = append(out.Channel.Items, rss)
+		}
+
+		data, err := xml.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), data...), nil
+	}
+}
+
+// feedLoader returns the feed to serve for a request.
+type feedLoader func(req *http.Request, params httprouter.Params) (*feed, error)
+
+// HandleFeed serves the feed returned by the given loader in the given format. Readers polling it get
+// a not modified response when nothing changed, through its ETag and Last-Modified headers.
+func (s *Server) HandleFeed(format feedFormat, load feedLoader) HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		f, err := load(req, params)
+		if err != nil {
+			return err
+		}
+
+		data, err := f.render(format, baseURL(req), req.URL.Path)
+		if err != nil {
+			return err
+		}
+
+		sum := sha1.Sum(data)
+		res.Header().Set("Content-Type", format.contentType())
+		res.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		http.ServeContent(res, req, "", f.updated(), bytes.NewReader(data))
+		return nil
+	}
+}
+
+// frontPageFeed lists the stories of the front page, ranked like they are on the index.
+func (s *Server) frontPageFeed(req *http.Request, _ httprouter.Params) (*feed, error) {
+	stories, err := s.store.ListStories(0, feedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	now := NowFunc()
+	settings := s.currentSiteSettings()
+	sort.Slice(stories, func(i, j int) bool {
+		return rankPinnedFirst(stories[i], stories[j], now, settings)
+	})
+
+	return s.storiesFeed(settings.Name, "/", stories, req)
+}
+
+// newestFeed lists the most recent stories.
+func (s *Server) newestFeed(req *http.Request, _ httprouter.Params) (*feed, error) {
+	stories, err := s.store.ListStories(0, feedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// pinned stories come first in the listing
+	sort.SliceStable(stories, func(i, j int) bool {
+		return stories[i].CreatedAt.After(stories[j].CreatedAt)
+	})
+
+	return s.storiesFeed(s.currentSiteSettings().Name+": newest stories", "/", stories, req)
+}
+
+// userFeed lists the most recent stories submitted by a user.
+func (s *Server) userFeed(req *http.Request, params httprouter.Params) (*feed, error) {
+	userRecord, err := s.store.FindUserByLogin(params.ByName("name"))
+	if err != nil {
+		return nil, err
+	}
+
+	if userRecord == nil || userRecord.IsBanned() || userRecord.IsShadowBanned() {
+		return nil, NotFound(req.URL.Path)
+	}
+
+	stories, err := s.store.ListStoriesByAuthor(userRecord.ID, 0, feedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// there is no profile page, searching for their stories comes close
+	path := "/search?" + url.Values{"author": {userRecord.Name}, "type": {string(SearchStories)}}.Encode()
+	return s.storiesFeed(s.currentSiteSettings().Name+": stories by "+userRecord.Name, path, stories, req)
+}
+
+// domainFeed lists the most recent stories linking to a domain.
+func (s *Server) domainFeed(req *http.Request, params httprouter.Params) (*feed, error) {
+	domain := strings.ToLower(params.ByName("host"))
+	stories, err := s.store.ListStoriesByDomain(domain, 0, feedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storiesFeed(s.currentSiteSettings().Name+": stories from "+domain, "/domain/"+domain, stories, req)
+}
+
+// storyCommentsFeed lists the most recent comments of a story, leaving out those which can't be read.
+func (s *Server) storyCommentsFeed(req *http.Request, params httprouter.Params) (*feed, error) {
+	story, err := s.store.FindStory(params.ByName("id"))
+	if err != nil {
+		return nil, Maybe404(err)
+	}
+
+	visible, err := s.canSeeStory(story, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !visible || story.IsRemoved() {
+		return nil, NotFound(req.URL.Path)
+	}
+
+	comments, err := s.store.ListComments(story.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.After(comments[j].CreatedAt)
+	})
+
+	path := "/stories/" + story.ID + "/comments"
+	f := &feed{Title: "Comments on " + story.Title, Path: path}
+	for _, comment := range comments {
+		cp := NewCommentPresenter(&CommentNode{Comment: comment})
+		if cp.Removed || cp.Hidden || cp.Held {
+			continue
+		}
+
+		f.Items = append(f.Items, &feedItem{
+			Path:      path + "#" + cp.ID,
+			Title:     cp.Author + " on " + story.Title,
+			Content:   cp.Body,
+			Author:    cp.Author,
+			CreatedAt: cp.CreatedAt,
+		})
+
+		if len(f.Items) == feedSize {
+			break
+		}
+	}
+
+	return f, nil
+}
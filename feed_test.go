@@ -0,0 +1,133 @@
+package tabloid
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestFeedRender(t *testing.T) {
+	c := qt.New(t)
+	createdAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	f, err := newStoriesFeed("Tabloid", "/", []*Story{
+		{ID: "1", Title: "A link", URL: "https://example.com", Author: "alice", CreatedAt: createdAt},
+		{ID: "2", Title: "Ask", Body: "*Hello*", Author: "bob", CreatedAt: createdAt.Add(-time.Hour)},
+	}, "https://tabloid.test")
+	c.Assert(err, qt.IsNil)
+	c.Assert(f.updated(), qt.Equals, createdAt)
+
+	c.Run("RSS", func(c *qt.C) {
+		data, err := f.render(feedRSS, "https://tabloid.test", "/rss")
+		c.Assert(err, qt.IsNil)
+
+		var out rssFeed
+		c.Assert(xml.Unmarshal(data, &out), qt.IsNil)
+		c.Assert(out.Channel.Items, qt.HasLen, 2)
+		c.Assert(out.Channel.Items[0].Link, qt.Equals, "https://example.com")
+		c.Assert(out.Channel.Items[0].Comments, qt.Equals, "https://tabloid.test/stories/1/comments")
+		c.Assert(out.Channel.Items[1].Link, qt.Equals, "https://tabloid.test/stories/2/comments")
+		c.Assert(out.Channel.Items[1].Description, qt.Contains, "<em>Hello</em>")
+		c.Assert(out.Channel.Items[1].Creator, qt.Equals, "bob")
+	})
+
+	c.Run("Atom", func(c *qt.C) {
+		data, err := f.render(feedAtom, "https://tabloid.test", "/atom")
+		c.Assert(err, qt.IsNil)
+
+		var out atomFeed
+		c.Assert(xml.Unmarshal(data, &out), qt.IsNil)
+		c.Assert(out.ID, qt.Equals, "https://tabloid.test/atom")
+		c.Assert(out.Updated, qt.Equals, "2020-05-01T10:00:00Z")
+		c.Assert(out.Entries, qt.HasLen, 2)
+		c.Assert(out.Entries[0].ID, qt.Equals, "https://tabloid.test/stories/1/comments")
+		c.Assert(out.Entries[0].Author.Name, qt.Equals, "alice")
+	})
+
+	c.Run("JSON Feed", func(c *qt.C) {
+		data, err := f.render(feedJSON, "https://tabloid.test", "/feed.json")
+		c.Assert(err, qt.IsNil)
+
+		var out jsonFeed
+		c.Assert(json.Unmarshal(data, &out), qt.IsNil)
+		c.Assert(out.FeedURL, qt.Equals, "https://tabloid.test/feed.json")
+		c.Assert(out.HomePageURL, qt.Equals, "https://tabloid.test/")
+		c.Assert(out.Items, qt.HasLen, 2)
+		c.Assert(out.Items[0].ExternalURL, qt.Equals, "https://example.com")
+	})
+}
+
+func TestHandleFeed(t *testing.T) {
+	c := qt.New(t)
+	createdAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	s := &Server{}
+	items := []*feedItem{
+		{Path: "/stories/1/comments", Title: "A link", CreatedAt: createdAt},
+		{Path: "/stories/2/comments", Title: "Another link", CreatedAt: createdAt.Add(-time.Hour)},
+	}
+	var modified time.Time
+	handle := s.HandleFeed(feedRSS, func(req *http.Request, _ httprouter.Params) (*feed, error) {
+		return &feed{Title: "Tabloid", Path: "/", Items: items, Modified: modified}, nil
+	})
+
+	res := httptest.NewRecorder()
+	c.Assert(handle(res, httptest.NewRequest("GET", "/rss", nil), nil), qt.IsNil)
+	c.Assert(res.Code, qt.Equals, 200)
+	c.Assert(res.Header().Get("Content-Type"), qt.Equals, "application/rss+xml; charset=utf-8")
+	c.Assert(res.Header().Get("Last-Modified"), qt.Equals, "Fri, 01 May 2020 10:00:00 GMT")
+	etag := res.Header().Get("ETag")
+	c.Assert(etag, qt.Not(qt.Equals), "")
+
+	c.Run("same ETag", func(c *qt.C) {
+		req := httptest.NewRequest("GET", "/rss", nil)
+		req.Header.Set("If-None-Match", etag)
+		res := httptest.NewRecorder()
+		c.Assert(handle(res, req, nil), qt.IsNil)
+		c.Assert(res.Code, qt.Equals, http.StatusNotModified)
+	})
+
+	c.Run("not modified since", func(c *qt.C) {
+		req := httptest.NewRequest("GET", "/rss", nil)
+		req.Header.Set("If-Modified-Since", "Fri, 01 May 2020 10:00:00 GMT")
+		res := httptest.NewRecorder()
+		c.Assert(handle(res, req, nil), qt.IsNil)
+		c.Assert(res.Code, qt.Equals, http.StatusNotModified)
+	})
+
+	c.Run("changed", func(c *qt.C) {
+		req := httptest.NewRequest("GET", "/rss", nil)
+		req.Header.Set("If-None-Match", `"outdated"`)
+		res := httptest.NewRecorder()
+		c.Assert(handle(res, req, nil), qt.IsNil)
+		c.Assert(res.Code, qt.Equals, 200)
+	})
+
+	c.Run("reordered without new items", func(c *qt.C) {
+		// as when the older story gets upvoted past the newer one
+		items[0], items[1] = items[1], items[0]
+		modified = createdAt.Add(time.Minute)
+		defer func() {
+			items[0], items[1] = items[1], items[0]
+			modified = time.Time{}
+		}()
+
+		req := httptest.NewRequest("GET", "/rss", nil)
+		req.Header.Set("If-Modified-Since", "Fri, 01 May 2020 10:00:00 GMT")
+		res := httptest.NewRecorder()
+		c.Assert(handle(res, req, nil), qt.IsNil)
+		c.Assert(res.Code, qt.Equals, 200)
+		c.Assert(res.Header().Get("Last-Modified"), qt.Equals, "Fri, 01 May 2020 10:01:00 GMT")
+
+		req.Header.Set("If-None-Match", etag)
+		res = httptest.NewRecorder()
+		c.Assert(handle(res, req, nil), qt.IsNil)
+		c.Assert(res.Code, qt.Equals, 200)
+	})
+}
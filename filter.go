@@ -0,0 +1,283 @@
+package tabloid
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A FilterVerdict is what a filter decided about a story or a comment about to be submitted.
+type FilterVerdict int
+
+const (
+	// FilterAllow lets the submission through.
+	FilterAllow FilterVerdict = iota
+	// FilterHold lets the submission through, but holds it until a moderator reviews it.
+	FilterHold
+	// FilterReject refuses the submission.
+	FilterReject
+)
+
+// A FilterResult is the verdict of a filter, along the reason for rejecting or holding the submission and
+// the form field at fault.
+type FilterResult struct {
+	Verdict FilterVerdict
+	Reason  string
+	Field   string
+}
+
+// Allowed returns a result letting the submission through.
+func Allowed() FilterResult {
+	return FilterResult{Verdict: FilterAllow}
+}
+
+// Held returns a result holding the submission for review, for the given reason.
+func Held(field string, reason string) FilterResult {
+	return FilterResult{Verdict: FilterHold, Field: field, Reason: reason}
+}
+
+// Rejected returns a result refusing the submission because of the given field, for the given reason.
+func Rejected(field string, reason string) FilterResult {
+	return FilterResult{Verdict: FilterReject, Field: field, Reason: reason}
+}
+
+// A FilterRejection is the error returned to the user when a filter refused their submission.
+type FilterRejection struct {
+	Reason string
+}
+
+func (e *FilterRejection) Error() string {
+	return e.Reason
+}
+
+// StoryFilterFn represents a function suitable for Story filters.
+type StoryFilterFn func(*Story) (FilterResult, error)
+
+// CommentFilterFn represents a function suitable for Comment filters.
+type CommentFilterFn func(*Story, *Comment) (FilterResult, error)
+
+// A Filter checks both stories and comments, see Server.AddFilter.
+type Filter interface {
+	FilterStory(*Story) (FilterResult, error)
+	FilterComment(*Story, *Comment) (FilterResult, error)
+}
+
+// AddStoryFilter registers a given StoryFilterFn, that will be called every time a story is about to be submitted,
+// before it's stored. Multiple filters will be called in the order they were registered, until one rejects the story.
+func (s *Server) AddStoryFilter(fn StoryFilterFn) {
+	s.storyFilters = append(s.storyFilters, fn)
+}
+
+// AddCommentFilter registers a given CommentFilterFn, that will be called every time a comment is about to be
+// submitted or edited, before it's stored. Multiple filters will be called in the order they were registered,
+// until one rejects the comment.
+func (s *Server) AddCommentFilter(fn CommentFilterFn) {
+	s.commentFilters = append(s.commentFilters, fn)
+}
+
+// AddFilter registers a Filter for both stories and comments.
+func (s *Server) AddFilter(f Filter) {
+	s.AddStoryFilter(f.FilterStory)
+	s.AddCommentFilter(f.FilterComment)
+}
+
+// filterStory runs the story filters, returning an UnprocessableEntityError if one rejects the story, or
+// true if one asks to hold it.
+func (s *Server) filterStory(story *Story) (bool, error) {
+	results := make([]FilterResult, 0, len(s.storyFilters))
+	for _, f := range s.storyFilters {
+		result, err := f(story)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, result)
+		if result.Verdict == FilterReject {
+			break
+		}
+	}
+
+	return verdict(results)
+}
+
+// filterComment runs the comment filters, see filterStory.
+func (s *Server) filterComment(story *Story, comment *Comment) (bool, error) {
+	results := make([]FilterResult, 0, len(s.commentFilters))
+	for _, f := range s.commentFilters {
+		result, err := f(story, comment)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, result)
+		if result.Verdict == FilterReject {
+			break
+		}
+	}
+
+	return verdict(results)
+}
+
+// verdict combines the results of filters: a single rejection refuses the submission and a single hold
+// holds it.
+func verdict(results []FilterResult) (bool, error) {
+	hold := false
+	for _, r := range results {
+		switch r.Verdict {
+		case FilterReject:
+			return false, UnprocessableEntityWithError(&FilterRejection{Reason: r.Reason}, r.Field)
+		case FilterHold:
+			hold = true
+		}
+	}
+
+	return hold, nil
+}
+
+// linkRegexp matches the links written in a body.
+var linkRegexp = regexp.MustCompile(`https?://[^\s<>()"']+`)
+
+// BannedDomains is a Filter rejecting stories and comments linking to the given domains or their subdomains.
+type BannedDomains []string
+
+func (d BannedDomains) FilterStory(story *Story) (FilterResult, error) {
+	if story.URL != "" && d.banned(story.URL) {
+		return Rejected("url", "This domain is banned."), nil
+	}
+
+	return d.filterBody(story.Body), nil
+}
+
+func (d BannedDomains) FilterComment(_ *Story, comment *Comment) (FilterResult, error) {
+	return d.filterBody(comment.Body), nil
+}
+
+func (d BannedDomains) filterBody(body string) FilterResult {
+	for _, link := range linkRegexp.FindAllString(body, -1) {
+		if d.banned(link) {
+			return Rejected("body", "It links to a banned domain.")
+		}
+	}
+
+	return Allowed()
+}
+
+// banned returns true if the given link points to one of the domains.
+func (d BannedDomains) banned(link string) bool {
+	host := Domain(link)
+	if host == "" {
+		return false
+	}
+
+	for _, domain := range d {
+		if onDomain(host, strings.TrimPrefix(strings.ToLower(domain), "www.")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BannedWords is a Filter rejecting stories and comments containing any of the given words, regardless of
+// their case.
+type BannedWords []string
+
+func (w BannedWords) FilterStory(story *Story) (FilterResult, error) {
+	if w.contains(story.Title) {
+		return Rejected("title", "It contains a banned word."), nil
+	}
+
+	if w.contains(story.Body) {
+		return Rejected("body", "It contains a banned word."), nil
+	}
+
+	return Allowed(), nil
+}
+
+func (w BannedWords) FilterComment(_ *Story, comment *Comment) (FilterResult, error) {
+	if w.contains(comment.Body) {
+		return Rejected("body", "It contains a banned word."), nil
+	}
+
+	return Allowed(), nil
+}
+
+// contains returns true if the given text has one of the words.
+func (w BannedWords) contains(text string) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r == '-' || r == '\'' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r > 127)
+	})
+
+	for _, word := range words {
+		for _, banned := range w {
+			if word == strings.ToLower(banned) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// LinkLimit is a Filter holding stories and comments whose body has more links than the limit, as it's
+// a common trait of spam.
+type LinkLimit int
+
+func (l LinkLimit) FilterStory(story *Story) (FilterResult, error) {
+	return l.filterBody(story.Body), nil
+}
+
+func (l LinkLimit) FilterComment(_ *Story, comment *Comment) (FilterResult, error) {
+	return l.filterBody(comment.Body), nil
+}
+
+func (l LinkLimit) filterBody(body string) FilterResult {
+	if len(linkRegexp.FindAllString(body, -1)) > int(l) {
+		return Held("body", "It has too many links.")
+	}
+
+	return Allowed()
+}
+
+// defaultDuplicateMinLength is the length of the shortest bodies DuplicateBodies checks when MinLength
+// isn't set.
+const defaultDuplicateMinLength = 40
+
+// DuplicateBodies is a Filter rejecting stories and comments whose body is identical to one posted
+// within the Window, as spam often comes in waves. Bodies shorter than MinLength characters, like "Thanks!",
+// are left alone as members commonly post the same ones.
+type DuplicateBodies struct {
+	Store     Store
+	Window    time.Duration
+	MinLength int
+}
+
+func (d *DuplicateBodies) FilterStory(story *Story) (FilterResult, error) {
+	return d.filterBody(story.Body)
+}
+
+func (d *DuplicateBodies) FilterComment(_ *Story, comment *Comment) (FilterResult, error) {
+	return d.filterBody(comment.Body)
+}
+
+func (d *DuplicateBodies) filterBody(body string) (FilterResult, error) {
+	minLength := d.MinLength
+	if minLength <= 0 {
+		minLength = defaultDuplicateMinLength
+	}
+
+	body = strings.TrimSpace(body)
+	if utf8.RuneCountInString(body) < minLength {
+		return Allowed(), nil
+	}
+
+	count, err := d.Store.CountDuplicateBodies(body, NowFunc().Add(-d.Window))
+	if err != nil {
+		return FilterResult{}, err
+	}
+
+	if count > 0 {
+		return Rejected("body", "The same text has already been posted."), nil
+	}
+
+	return Allowed(), nil
+}
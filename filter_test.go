@@ -0,0 +1,105 @@
+package tabloid
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// duplicatesStore is a Store only answering CountDuplicateBodies, with the counts of bodies.
+type duplicatesStore struct {
+	Store
+	bodies map[string]int
+	calls  int
+}
+
+func (s *duplicatesStore) CountDuplicateBodies(body string, _ time.Time) (int, error) {
+	s.calls++
+	return s.bodies[body], nil
+}
+
+func TestFilters(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("banned domains", func(c *qt.C) {
+		f := BannedDomains{"spam.com"}
+
+		r, err := f.FilterStory(&Story{URL: "https://www.spam.com/buy"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.Verdict, qt.Equals, FilterReject)
+		c.Assert(r.Field, qt.Equals, "url")
+
+		r, _ = f.FilterStory(&Story{URL: "https://notspam.com"})
+		c.Assert(r.Verdict, qt.Equals, FilterAllow)
+
+		r, _ = f.FilterComment(nil, &Comment{Body: "see http://SPAM.com/deal"})
+		c.Assert(r.Verdict, qt.Equals, FilterReject)
+		c.Assert(r.Field, qt.Equals, "body")
+	})
+
+	c.Run("banned words", func(c *qt.C) {
+		f := BannedWords{"casino"}
+
+		r, _ := f.FilterStory(&Story{Title: "Best Casino in town"})
+		c.Assert(r.Verdict, qt.Equals, FilterReject)
+		c.Assert(r.Field, qt.Equals, "title")
+
+		r, _ = f.FilterComment(nil, &Comment{Body: "casinos are fine, only whole words match"})
+		c.Assert(r.Verdict, qt.Equals, FilterAllow)
+	})
+
+	c.Run("link limit", func(c *qt.C) {
+		f := LinkLimit(1)
+
+		r, _ := f.FilterComment(nil, &Comment{Body: "http://a.com"})
+		c.Assert(r.Verdict, qt.Equals, FilterAllow)
+
+		r, _ = f.FilterComment(nil, &Comment{Body: "http://a.com and https://b.com"})
+		c.Assert(r.Verdict, qt.Equals, FilterHold)
+	})
+
+	c.Run("duplicate bodies", func(c *qt.C) {
+		store := &duplicatesStore{bodies: map[string]int{
+			"Thanks!": 3,
+			"Cheap watches at the best prices, only this week": 1,
+		}}
+		f := &DuplicateBodies{Store: store, Window: time.Hour}
+
+		r, err := f.FilterComment(nil, &Comment{Body: " Cheap watches at the best prices, only this week "})
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.Verdict, qt.Equals, FilterReject)
+
+		r, _ = f.FilterComment(nil, &Comment{Body: "Cheap watches at the best prices, only next week"})
+		c.Assert(r.Verdict, qt.Equals, FilterAllow)
+
+		// short bodies are never checked
+		r, _ = f.FilterComment(nil, &Comment{Body: "Thanks!"})
+		c.Assert(r.Verdict, qt.Equals, FilterAllow)
+		c.Assert(store.calls, qt.Equals, 2)
+	})
+
+	c.Run("chain", func(c *qt.C) {
+		s := &Server{}
+		calls := 0
+		s.AddStoryFilter(func(*Story) (FilterResult, error) { calls++; return Held("body", "suspicious"), nil })
+		s.AddStoryFilter(func(*Story) (FilterResult, error) { calls++; return Allowed(), nil })
+
+		hold, err := s.filterStory(&Story{})
+		c.Assert(err, qt.IsNil)
+		c.Assert(hold, qt.IsTrue)
+		c.Assert(calls, qt.Equals, 2)
+
+		s.AddStoryFilter(func(*Story) (FilterResult, error) { return Rejected("title", "Nope."), nil })
+		s.AddStoryFilter(func(*Story) (FilterResult, error) { c.Fatal("called after a rejection"); return Allowed(), nil })
+		_, err = s.filterStory(&Story{})
+		c.Assert(err, qt.ErrorMatches, `UnprocessableEntityError: error Nope\., \[title\]`)
+
+		w := httptest.NewRecorder()
+		err.(ErrorResponder).RespondError(w, httptest.NewRequest("POST", "/submit", nil))
+		c.Assert(w.Code, qt.Equals, 422)
+		c.Assert(strings.Contains(w.Body.String(), "Nope."), qt.IsTrue)
+	})
+}
@@ -0,0 +1,76 @@
+package tabloid
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A FlagReason tells moderators why a story or a comment has been flagged.
+type FlagReason string
+
+const (
+	FlagSpam     FlagReason = "spam"
+	FlagOffTopic FlagReason = "off-topic"
+	FlagAbusive  FlagReason = "abusive"
+)
+
+// AllFlagReasons lists every reason a member can pick when flagging.
+var AllFlagReasons = []FlagReason{FlagSpam, FlagOffTopic, FlagAbusive}
+
+// ParseFlagReason returns the flag reason with the given name, or an error if there is none.
+func ParseFlagReason(name string) (FlagReason, error) {
+	for _, r := range AllFlagReasons {
+		if FlagReason(name) == r {
+			return r, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown flag reason %q", name)
+}
+
+// A Flag is a report from a member about a story, or a comment when CommentID is set, waiting for
+// a moderator to review it.
+type Flag struct {
+	ID         string         `db:"id"`
+	StoryID    string         `db:"story_id"`
+	CommentID  sql.NullString `db:"comment_id"`
+	UserID     string         `db:"user_id"`
+	Reason     FlagReason     `db:"reason"`
+	CreatedAt  time.Time      `db:"created_at"`
+	ResolvedAt sql.NullTime   `db:"resolved_at"`
+}
+
+// NewFlag returns a flag on the given story, or on one of its comments if commentID is set.
+func NewFlag(storyID string, commentID sql.NullString, userID string, reason FlagReason) *Flag {
+	return &Flag{
+		StoryID:   storyID,
+		CommentID: commentID,
+		UserID:    userID,
+		Reason:    reason,
+		CreatedAt: NowFunc(),
+	}
+}
+
+// FlaggedContent is a story or a comment with unresolved flags, as listed in the moderation queue.
+type FlaggedContent struct {
+	StoryID    string         `db:"story_id"`
+	CommentID  sql.NullString `db:"comment_id"`
+	Title      string         `db:"title"`
+	Body       string         `db:"body"`
+	Author     string         `db:"author"`
+	FlagsCount int64          `db:"flags_count"`
+	Reasons    string         `db:"reasons"`
+	HiddenAt   sql.NullTime   `db:"hidden_at"`
+	FlaggedAt  time.Time      `db:"flagged_at"`
+}
+
+// IsComment returns true if the flagged content is a comment rather than a story.
+func (f *FlaggedContent) IsComment() bool {
+	return f.CommentID.Valid
+}
+
+// IsHidden returns true if the content has been hidden after reaching the flag threshold.
+func (f *FlaggedContent) IsHidden() bool {
+	return f.HiddenAt.Valid
+}
@@ -0,0 +1,33 @@
+package tabloid
+
+import (
+	"database/sql"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFlags(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("ParseFlagReason", func(c *qt.C) {
+		reason, err := ParseFlagReason("off-topic")
+		c.Assert(err, qt.IsNil)
+		c.Assert(reason, qt.Equals, FlagOffTopic)
+
+		_, err = ParseFlagReason("boring")
+		c.Assert(err, qt.ErrorMatches, `unknown flag reason "boring"`)
+	})
+
+	c.Run("hidden comments are blanked", func(c *qt.C) {
+		comment := &Comment{ID: "1", Body: "buy now", Author: "spammer", AuthorID: "2", HiddenAt: sql.NullTime{Time: NowFunc(), Valid: true}}
+		presenter := NewCommentPresenter(&CommentNode{Comment: comment})
+
+		c.Assert(presenter.Hidden, qt.IsTrue)
+		c.Assert(string(presenter.Body), qt.Contains, "hidden pending moderation")
+		c.Assert(presenter.Author, qt.Equals, "spammer")
+
+		presenter.SetCanEdit(&User{ID: "2"}, 0, NowFunc())
+		c.Assert(presenter.CanEdit, qt.IsFalse)
+	})
+}
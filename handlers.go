@@ -19,39 +19,318 @@ import (
 // HandleE is a httprouter.Handle that also returns an error.
 type HandleE func(http.ResponseWriter, *http.Request, httprouter.Params) error
 
-// HandleOAuthStart handles requests starting the OAauth authentication process.
+// HandleOAuthStart handles requests starting the OAauth authentication process with the provider
+// named in the path, or the default one if there is none.
 func (s *Server) HandleOAuthStart() HandleE {
-	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
-		return s.authService.Start(res, req)
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		authService, err := s.findAuthService(params.ByName("provider"))
+		if err != nil {
+			return err
+		}
+
+		return authService.Start(res, req)
 	}
 }
 
 // HandleOAuthCallback handles requests of the OAuth provider redirects the user back
 // to Tabloid, after successfully authenticating him on its side.
+//
+// If the user is already signed in through another provider, the identity is linked to
+// its account instead of creating a new user.
 func (s *Server) HandleOAuthCallback() HandleE {
-	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		authService, err := s.findAuthService(params.ByName("provider"))
+		if err != nil {
+			return err
+		}
+
+		session, err := s.currentSession(req)
+		if err != nil {
+			return err
+		}
+
+		var userRecord *User
+		if session != nil && session.Provider != authService.Name() {
+			userRecord, err = s.findSessionUser(session)
+			if err != nil {
+				return err
+			}
+		}
+
 		// need to think about error handling here
 		// probably a before write callback is good enough?
-		return s.authService.Callback(res, req, func(u *authentication.User) error {
-			_, err := s.store.CreateOrUpdateUser(u.Login, u.Email)
-			SetFlash(res, "success", "Signed in.")
-			return err
+		return authService.Callback(res, req, func(u *authentication.User) error {
+			if userRecord == nil {
+				userID, err := s.store.CreateOrUpdateUserFromIdentity(authService.Name(), u.Login, u.Email)
+				if err != nil {
+					return err
+				}
+
+				// signing in again replaces the current session
+				if session != nil {
+					err := s.sessionStore.DeleteSession(session.UserID, session.ID)
+					if err != nil {
+						return Maybe404(err)
+					}
+				}
+
+				userRecord, err := s.store.FindUserByID(userID)
+				if err != nil {
+					return err
+				}
+
+				if userRecord == nil {
+					return fmt.Errorf("user %s not found", userID)
+				}
+
+				if userRecord.IsBanned() {
+					SetFlash(res, "danger", "This account has been banned.")
+					return nil
+				}
+
+				// a new user signs in for the first time when they're created
+				if userRecord.CreatedAt.Equal(userRecord.LastLoginAt) {
+					s.emitWebhookEvent(WebhookUserJoined, newAPIUser(userRecord))
+				}
+
+				err = s.openSession(res, req, userRecord, authService.Name())
+				if err != nil {
+					return err
+				}
+
+				SetFlash(res, "success", "Signed in.")
+				return nil
+			}
+
+			ownerID, err := s.store.LinkIdentity(userRecord.ID, authService.Name(), u.Login, u.Email)
+			if err != nil {
+				return err
+			}
+
+			if ownerID != userRecord.ID {
+				SetFlash(res, "danger", "This account is already linked to another user.")
+			} else {
+				SetFlash(res, "success", "Account linked.")
+			}
+			return nil
 		})
 	}
 }
 
-// HandleOAuthDestroy handles requests destroying the current session.
+// HandleOAuthDestroy handles requests destroying the current session, signing out from all providers.
+// Other sessions of the user are left untouched.
 func (s *Server) HandleOAuthDestroy() HandleE {
 	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
-		return s.authService.Destroy(res, req)
+		err := s.destroySessions(res, req)
+		if err != nil {
+			return err
+		}
+
+		http.Redirect(res, req, "/", http.StatusFound)
+		return nil
 	}
 }
 
+// HandleLogin handles requests to get the list of providers the user can sign in with. If the user is
+// already authenticated, it lists them as well, so another account can be linked to the current user.
+func (s *Server) HandleLogin() HandleE {
+	tmpl, err := template.New("login.html").Funcs(s.helpers()).ParseFiles(
+		"assets/templates/login.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		res.Header().Set("Content-Type", "text/html")
+
+		var providers []string
+		for _, as := range s.authServices {
+			providers = append(providers, as.Name())
+		}
+
+		vars := map[string]interface{}{
+			"Session":   ctxSession(req.Context()),
+			"Providers": providers,
+		}
+
+		err = tmpl.Execute(res, vars)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// findAuthService returns the AuthService for the given provider name. If the name is empty, which
+// happens on the legacy /oauth routes, it returns the default provider.
+func (s *Server) findAuthService(name string) (authentication.AuthService, error) {
+	if name == "" {
+		return s.authServices[0], nil
+	}
+
+	for _, as := range s.authServices {
+		if as.Name() == name {
+			return as, nil
+		}
+	}
+
+	return nil, NotFound("/auth/" + name)
+}
+
+// currentSession returns the session matching the session cookie, or nil if there is none or if it
+// expired or its user has been banned, in which case it's deleted.
+func (s *Server) currentSession(req *http.Request) (*Session, error) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err == http.ErrNoCookie {
+		return nil, nil
+	}
+
+	session, err := s.sessionStore.FindSessionByHash(HashAPIToken(cookie.Value))
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	now := NowFunc()
+	idleTimeout := time.Duration(s.config.SessionIdleTimeoutInHours) * time.Hour
+	lifetime := time.Duration(s.config.SessionLifetimeInHours) * time.Hour
+	if session.Expired(idleTimeout, lifetime, now) || session.BannedAt.Valid {
+		err := s.sessionStore.DeleteSession(session.UserID, session.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	// no need to write on every single request
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		err := s.sessionStore.TouchSession(session.ID, now)
+		if err != nil {
+			s.Logger.Warn().Err(err).Str("session_id", session.ID).Msg("Failed to touch session")
+		}
+		session.LastSeenAt = now
+	}
+
+	return session, nil
+}
+
+// openSession creates a new session for the given user and hands its secret to the client through a cookie.
+func (s *Server) openSession(res http.ResponseWriter, req *http.Request, userRecord *User, provider string) error {
+	session, secret, err := NewSession(userRecord, provider, req)
+	if err != nil {
+		return err
+	}
+
+	err = s.sessionStore.CreateSession(session)
+	if err != nil {
+		return err
+	}
+
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    secret,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if s.config.SessionLifetimeInHours > 0 {
+		cookie.MaxAge = s.config.SessionLifetimeInHours * 3600
+	}
+
+	http.SetCookie(res, cookie)
+	return nil
+}
+
+// destroySessions signs the user out, by deleting the current session and its cookie.
+func (s *Server) destroySessions(res http.ResponseWriter, req *http.Request) error {
+	session, err := s.currentSession(req)
+	if err != nil {
+		return err
+	}
+
+	if session != nil {
+		err := s.sessionStore.DeleteSession(session.UserID, session.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	return nil
+}
+
+// findSessionUser returns the user record the session belongs to.
+// If no user is found, it returns nil without an error.
+func (s *Server) findSessionUser(session *Session) (*User, error) {
+	return s.store.FindUserByID(session.UserID)
+}
+
+// isShadowedFrom returns true if the given author is shadow-banned and isn't the viewer, in which case their
+// content must be hidden. The viewer is nil for unauthenticated requests.
+func (s *Server) isShadowedFrom(authorID string, viewer *User) (bool, error) {
+	if viewer != nil && viewer.ID == authorID {
+		return false, nil
+	}
+
+	author, err := s.store.FindUserByID(authorID)
+	if err != nil {
+		return false, err
+	}
+
+	return author != nil && author.IsShadowBanned(), nil
+}
+
+// canSeeStory returns true if the viewer can reach the story by its id. Held stories, and the ones hidden
+// after being flagged, are only visible to their author and moderators until reviewed, and the ones of
+// shadow-banned authors only to themselves. The viewer is nil for unauthenticated requests.
+func (s *Server) canSeeStory(story *Story, viewer *User) (bool, error) {
+	if (story.IsHeld() || story.IsHidden()) && !isAuthor(viewer, story) && !CanModerate(viewer) {
+		return false, nil
+	}
+
+	shadowed, err := s.isShadowedFrom(story.AuthorID, viewer)
+	if err != nil {
+		return false, err
+	}
+
+	return !shadowed, nil
+}
+
+// canSeeComment returns true if the viewer can reach the comment, whose story they can see. Removed comments
+// can't be reached, and held and hidden ones, or the ones of shadow-banned authors, are treated like
+// stories are by canSeeStory.
+func (s *Server) canSeeComment(comment *Comment, viewer *User) (bool, error) {
+	if comment.IsRemoved() {
+		return false, nil
+	}
+
+	if (comment.IsHeld() || comment.IsHidden()) && !isAuthor(viewer, comment) && !CanModerate(viewer) {
+		return false, nil
+	}
+
+	shadowed, err := s.isShadowedFrom(comment.AuthorID, viewer)
+	if err != nil {
+		return false, err
+	}
+
+	return !shadowed, nil
+}
+
 // HandleIndex handles requests for the root path, listing sorted paginated stories.
 // If the client isn't authenticated, it serves a template with no upvoting nor commenting
 // capabilities.
 func (s *Server) HandleIndex() HandleE {
-	tmpl, err := template.New("index.html").Funcs(helpers).ParseFiles("assets/templates/index.html",
+	tmpl, err := template.New("index.html").Funcs(s.helpers()).ParseFiles("assets/templates/index.html",
 		"assets/templates/_header.html",
 		"assets/templates/_footer.html",
 		"assets/templates/_story.html")
@@ -62,7 +341,8 @@ func (s *Server) HandleIndex() HandleE {
 	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
 		session := ctxSession(req.Context())
 
-		if session != nil {
+		// personal access tokens are seen as authenticated too
+		if session != nil || ctxUser(req.Context()) != nil {
 			s.Logger.Debug().Msg("Authenticated")
 			return s.handleAuthenticatedIndex(res, req, params, tmpl)
 		} else {
@@ -76,14 +356,14 @@ func (s *Server) HandleIndex() HandleE {
 func (s *Server) handleAuthenticatedIndex(res http.ResponseWriter, req *http.Request, params httprouter.Params, tmpl *template.Template) error {
 	session := ctxSession(req.Context())
 
-	userRecord, err := s.store.FindUserByLogin(session.Login)
+	userRecord, err := s.requestUser(req)
 	if err != nil {
 		return err
 	}
 
 	if userRecord == nil {
 		// there is a session but no user in the database, wiping the session
-		err := s.authService.Destroy(res, req)
+		err := s.destroySessions(res, req)
 		if err != nil {
 			return err
 		}
@@ -105,9 +385,11 @@ func (s *Server) handleAuthenticatedIndex(res http.ResponseWriter, req *http.Req
 		return err
 	}
 
-	// sort story by their rank
+	// sort story by their rank, pinned ones first
+	now := NowFunc()
+	settings := s.currentSiteSettings()
 	sort.Slice(stories, func(i, j int) bool {
-		return rank(stories[i]) > rank(stories[j])
+		return rankPinnedFirst(&stories[i].Story, &stories[j].Story, now, settings)
 	})
 
 	storyPresenters := []*storyPresenter{}
@@ -119,6 +401,7 @@ func (s *Server) handleAuthenticatedIndex(res http.ResponseWriter, req *http.Req
 		} else {
 			pr.Upvoted = false
 		}
+		pr.Downvoted = st.Up.Valid && !st.Up.Bool
 		storyPresenters = append(storyPresenters, pr)
 	}
 
@@ -140,6 +423,13 @@ func (s *Server) handleAuthenticatedIndex(res http.ResponseWriter, req *http.Req
 		vars["NextPage"] = -1
 	}
 
+	if wantsJSON(req) {
+		return writeJSON(res, http.StatusOK, map[string]interface{}{
+			"stories":   storyPresenters,
+			"next_page": nextAPIPage(page, vars["NextPage"] != -1),
+		})
+	}
+
 	err = tmpl.Execute(res, vars)
 	if err != nil {
 		return err
@@ -163,9 +453,11 @@ func (s *Server) handleUnauthenticatedIndex(res http.ResponseWriter, req *http.R
 		return err
 	}
 
-	// sort story by their rank
+	// sort story by their rank, pinned ones first
+	now := NowFunc()
+	settings := s.currentSiteSettings()
 	sort.Slice(stories, func(i, j int) bool {
-		return rank(stories[i]) > rank(stories[j])
+		return rankPinnedFirst(stories[i], stories[j], now, settings)
 	})
 
 	storyPresenters := []*storyPresenter{}
@@ -191,6 +483,13 @@ func (s *Server) handleUnauthenticatedIndex(res http.ResponseWriter, req *http.R
 		vars["NextPage"] = -1
 	}
 
+	if wantsJSON(req) {
+		return writeJSON(res, http.StatusOK, map[string]interface{}{
+			"stories":   storyPresenters,
+			"next_page": nextAPIPage(page, vars["NextPage"] != -1),
+		})
+	}
+
 	err = tmpl.Execute(res, vars)
 	if err != nil {
 		return err
@@ -202,7 +501,7 @@ func (s *Server) handleUnauthenticatedIndex(res http.ResponseWriter, req *http.R
 // HandleSubmit handles requests to get the form for submitting a Story. It redirects to the root path if
 // not authenticated.
 func (s *Server) HandleSubmit() HandleE {
-	tmpl, err := template.New("submit.html").Funcs(helpers).ParseFiles(
+	tmpl, err := template.New("submit.html").Funcs(s.helpers()).ParseFiles(
 		"assets/templates/submit.html",
 		"assets/templates/_header.html",
 		"assets/templates/_footer.html")
@@ -222,7 +521,8 @@ func (s *Server) HandleSubmit() HandleE {
 		}
 
 		vars := map[string]interface{}{
-			"Session": session,
+			"Session":       session,
+			"CanFetchTitle": s.metadataFetcher != nil,
 		}
 
 		err = tmpl.Execute(res, vars)
@@ -237,10 +537,11 @@ func (s *Server) HandleSubmit() HandleE {
 // HandleShow handles requests to access a particular Story, showing all its comments and allowing the user to comment
 // if authenticated.
 func (s *Server) HandleShow() HandleE {
-	tmpl, err := template.New("show.html").Funcs(helpers).ParseFiles(
+	tmpl, err := template.New("show.html").Funcs(s.helpers()).ParseFiles(
 		"assets/templates/show.html",
 		"assets/templates/_story_comments.html",
 		"assets/templates/_comment.html",
+		"assets/templates/_flag_form.html",
 		"assets/templates/_comment_form.html",
 		"assets/templates/_header.html",
 		"assets/templates/_footer.html")
@@ -253,7 +554,8 @@ func (s *Server) HandleShow() HandleE {
 
 		session := ctxSession(req.Context())
 
-		if session != nil {
+		// personal access tokens are seen as authenticated too
+		if session != nil || ctxUser(req.Context()) != nil {
 			s.Logger.Debug().Msg("authenticated")
 			return s.handleShowAuthenticated(res, req, params, tmpl)
 		} else {
@@ -272,6 +574,15 @@ func (s *Server) handleShowUnauthenticated(res http.ResponseWriter, req *http.Re
 		return Maybe404(err)
 	}
 
+	visible, err := s.canSeeStory(story, nil)
+	if err != nil {
+		return err
+	}
+
+	if !visible {
+		return NotFound(req.URL.Path)
+	}
+
 	comments, err := s.store.ListComments(story.ID)
 	if err != nil {
 		return err
@@ -284,9 +595,25 @@ func (s *Server) handleShowUnauthenticated(res http.ResponseWriter, req *http.Re
 
 	commentsTree := NewCommentPresentersTree(cc)
 	commentsTree.Sort(rank)
+	storyPresenter := newStoryPresenterWithBody(story)
+	storyPresenter.Preview, err = s.linkPreview(story)
+	if err != nil {
+		return err
+	}
+	storyPresenter.ArchivePath, err = s.archivePath(story)
+	if err != nil {
+		return err
+	}
+
+	if wantsJSON(req) {
+		return writeJSON(res, http.StatusOK, map[string]interface{}{
+			"story":    storyPresenter,
+			"comments": commentsTree,
+		})
+	}
 
 	err = tmpl.Execute(res, map[string]interface{}{
-		"Story":    story,
+		"Story":    storyPresenter,
 		"Comments": commentsTree,
 		"Session":  session,
 	})
@@ -300,14 +627,14 @@ func (s *Server) handleShowUnauthenticated(res http.ResponseWriter, req *http.Re
 
 func (s *Server) handleShowAuthenticated(res http.ResponseWriter, req *http.Request, params httprouter.Params, tmpl *template.Template) error {
 	session := ctxSession(req.Context())
-	userRecord, err := s.store.FindUserByLogin(session.Login)
+	userRecord, err := s.requestUser(req)
 	if err != nil {
 		return err
 	}
 
 	if userRecord == nil {
 		// there is a session but no user in the database, wiping the session
-		err := s.authService.Destroy(res, req)
+		err := s.destroySessions(res, req)
 		if err != nil {
 			return err
 		}
@@ -322,6 +649,15 @@ func (s *Server) handleShowAuthenticated(res http.ResponseWriter, req *http.Requ
 		return Maybe404(err)
 	}
 
+	visible, err := s.canSeeStory(&story.Story, userRecord)
+	if err != nil {
+		return err
+	}
+
+	if !visible {
+		return NotFound(req.URL.Path)
+	}
+
 	comments, err := s.store.ListCommentsWithVotes(story.ID, userRecord.ID)
 	if err != nil {
 		return err
@@ -332,9 +668,25 @@ func (s *Server) handleShowAuthenticated(res http.ResponseWriter, req *http.Requ
 		cc[i] = c
 	}
 	commentsTree := NewCommentPresentersTree(cc)
-	commentsTree.SetCanEdits(userRecord.Name, time.Duration(s.config.EditWindowInMinutes)*time.Minute, NowFunc())
+	commentsTree.SetCanEdits(userRecord, time.Duration(s.config.EditWindowInMinutes)*time.Minute, NowFunc())
 	storyPresenter := newStoryPresenterWithBody(&story.Story)
 	storyPresenter.Upvoted = story.Up.Bool
+	storyPresenter.Downvoted = story.Up.Valid && !story.Up.Bool
+	storyPresenter.Preview, err = s.linkPreview(&story.Story)
+	if err != nil {
+		return err
+	}
+	storyPresenter.ArchivePath, err = s.archivePath(&story.Story)
+	if err != nil {
+		return err
+	}
+
+	if wantsJSON(req) {
+		return writeJSON(res, http.StatusOK, map[string]interface{}{
+			"story":    storyPresenter,
+			"comments": commentsTree,
+		})
+	}
 
 	err = tmpl.Execute(res, map[string]interface{}{
 		"Story":    storyPresenter,
@@ -352,7 +704,21 @@ func (s *Server) handleShowAuthenticated(res http.ResponseWriter, req *http.Requ
 // HandleSubmitAction handles requests for when a user submit a Story form. It redirects the user to the root path if not
 // authenticated. In case someone bypass the client-side form validations with invalid form data,
 // it returns a HTTP error.
+//
+// Submitting a link already listed redirects to its discussion, unless it was submitted before the repost window,
+// in which case the form is shown again for the user to confirm they want to repost it.
+//
+// Clients asking for JSON get the story with a created status instead, or a conflict error for links already
+// listed unless they repost them.
 func (s *Server) HandleSubmitAction() HandleE {
+	tmpl, err := template.New("submit.html").Funcs(s.helpers()).ParseFiles(
+		"assets/templates/submit.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
 	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
 		res.Header().Set("Content-Type", "text/html")
 
@@ -364,37 +730,58 @@ func (s *Server) HandleSubmitAction() HandleE {
 		title := strings.TrimSpace(req.FormValue("title"))
 		body := strings.TrimSpace(req.FormValue("body"))
 		url_ := strings.TrimSpace(req.FormValue("url"))
-		if url_ != "" {
-			u, err := url.Parse(url_)
-			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-				return UnprocessableEntityWithError(err, "url", url_)
-			}
-		}
-
-		if title == "" || len(title) > 64 {
-			return UnprocessableEntity("title")
-		}
-
-		if url_ == "" && body == "" {
-			return UnprocessableEntity("url", "body")
+		err = validateStory(title, url_, body)
+		if err != nil {
+			return err
 		}
 
 		userRecord := ctxUser(req.Context())
 		story := NewStory(title, body, userRecord.ID, url_)
 
-		err = s.store.InsertStory(story)
+		if story.CanonicalURL != "" {
+			existing, err := s.store.FindStoryByCanonicalURL(story.CanonicalURL)
+			if err != nil {
+				return err
+			}
+
+			if existing != nil {
+				window := time.Duration(s.config.RepostWindowInDays) * 24 * time.Hour
+				tooSoon := window > 0 && NowFunc().Sub(existing.CreatedAt) < window
+				if wantsJSON(req) && (tooSoon || req.FormValue("repost") != "true") {
+					return DuplicateStory(existing)
+				}
+
+				if tooSoon {
+					SetFlash(res, "info", "This link has already been submitted.")
+					http.Redirect(res, req, "/stories/"+existing.ID+"/comments", http.StatusFound)
+					return nil
+				}
+
+				if req.FormValue("repost") != "true" {
+					return tmpl.Execute(res, map[string]interface{}{
+						"Session":       ctxSession(req.Context()),
+						"CanFetchTitle": s.metadataFetcher != nil,
+						"Duplicate":     existing,
+						"Title":         title,
+						"URL":           url_,
+						"Body":          body,
+					})
+				}
+			}
+		}
+
+		err = s.createStory(userRecord, story)
 		if err != nil {
 			return err
 		}
 
-		story.Author = userRecord.Name
+		if wantsJSON(req) {
+			res.Header().Set("Location", "/stories/"+story.ID+"/comments")
+			return writeJSON(res, http.StatusCreated, newStoryPresenterWithBody(story))
+		}
 
-		// HACK
-		for _, h := range s.storyHooks {
-			err := h(story)
-			if err != nil {
-				return err
-			}
+		if story.IsHeld() && !userRecord.IsShadowBanned() {
+			SetFlash(res, "info", "Thanks, your story will be listed once a moderator reviewed it.")
 		}
 
 		http.Redirect(res, req, "/stories/"+story.ID+"/comments", http.StatusFound)
@@ -402,6 +789,56 @@ func (s *Server) HandleSubmitAction() HandleE {
 	}
 }
 
+// validateStory checks the fields of a story being submitted, which needs a title and either a link or a
+// body.
+func validateStory(title string, url_ string, body string) error {
+	if url_ != "" {
+		u, err := url.Parse(url_)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return UnprocessableEntityWithError(err, "url", url_)
+		}
+	}
+
+	if title == "" || len(title) > 64 {
+		return UnprocessableEntity("title")
+	}
+
+	if url_ == "" && body == "" {
+		return UnprocessableEntity("url", "body")
+	}
+
+	return nil
+}
+
+// createStory runs a story submitted by the given user through the filters and stores it, held if needs be.
+// Its link metadata is then fetched in the background, and hooks are run unless nobody else can see it yet.
+func (s *Server) createStory(userRecord *User, story *Story) error {
+	hold, err := s.filterStory(story)
+	if err != nil {
+		return err
+	}
+
+	if hold || s.holdsSubmission(userRecord, story, NowFunc()) {
+		story.HeldAt = sql.NullTime{Time: NowFunc(), Valid: true}
+	}
+
+	err = s.store.InsertStory(story)
+	if err != nil {
+		return err
+	}
+
+	story.Author = userRecord.Name
+	s.queueLinkMetadata(story.URL)
+
+	// nobody else can see it, hooks would give it away, and held ones will run them once a moderator
+	// released it
+	if userRecord.IsShadowBanned() || story.IsHeld() {
+		return nil
+	}
+
+	return s.runStoryHooks(story)
+}
+
 // HandleSubmitCommentAction handles requests for when a user submit a Comment form for a given Story. It redirects
 // the user to the root path if not authenticated. In case someone bypass the client-side form validations
 // with invalid form data, it returns a HTTP error.
@@ -409,9 +846,8 @@ func (s *Server) HandleSubmitCommentAction() HandleE {
 	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
 		res.Header().Set("Content-Type", "text/html")
 
-		session := ctxSession(req.Context())
-		// redirect if unauthenticated
-		if session == nil {
+		// the user may be authenticated with a session or a personal access token
+		if ctxUser(req.Context()) == nil {
 			return Unauthorized(req.URL.Path)
 		}
 
@@ -421,6 +857,19 @@ func (s *Server) HandleSubmitCommentAction() HandleE {
 			return Maybe404(err)
 		}
 
+		visible, err := s.canSeeStory(story, ctxUser(req.Context()))
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		if story.Locked {
+			return StoryLocked(req.URL.Path)
+		}
+
 		err = req.ParseForm()
 		if err != nil {
 			return BadRequest(err)
@@ -439,94 +888,187 @@ func (s *Server) HandleSubmitCommentAction() HandleE {
 			comment = NewComment(story.ID, sql.NullString{String: "", Valid: false}, body, userRecord.ID)
 		}
 
-		err = s.store.InsertComment(comment)
+		err = s.createComment(userRecord, story, comment)
 		if err != nil {
 			return err
 		}
 
-		// HACK
-		comment.Author = userRecord.Name
-		for _, h := range s.commentHooks {
-			err := h(story, comment)
-			if err != nil {
-				s.Logger.Warn().Err(err).Msg("story hook failed")
-				return err
-			}
+		storyPath := fmt.Sprintf("/stories/%v/comments", story.ID)
+		if wantsJSON(req) {
+			res.Header().Set("Location", storyPath+"#"+comment.ID)
+			return writeJSON(res, http.StatusCreated, NewCommentPresenter(&CommentNode{Comment: comment}))
+		}
+
+		if comment.IsHeld() {
+			SetFlash(res, "info", "Thanks, your comment will be shown once a moderator reviewed it.")
 		}
 
-		storyPath := fmt.Sprintf("/stories/%v/comments", story.ID)
 		http.Redirect(res, req, storyPath, http.StatusFound)
 		return nil
 	}
 }
 
-// HandleVoteCommentAction handles requests to vote on a comment. It redirects back to the Story on which
+// createComment runs a comment posted by the given user through the filters and stores it, held if needs
+// be. Hooks are run unless nobody else can see it yet.
+func (s *Server) createComment(userRecord *User, story *Story, comment *Comment) error {
+	hold, err := s.filterComment(story, comment)
+	if err != nil {
+		return err
+	}
+
+	if hold {
+		comment.HeldAt = sql.NullTime{Time: NowFunc(), Valid: true}
+	}
+
+	err = s.store.InsertComment(comment)
+	if err != nil {
+		return err
+	}
+
+	// HACK
+	comment.Author = userRecord.Name
+
+	// nobody else can see it, hooks would give it away, and held ones will run them once a moderator
+	// released it
+	if userRecord.IsShadowBanned() || comment.IsHeld() {
+		return nil
+	}
+
+	return s.runCommentHooks(story, comment)
+}
+
+// HandleVoteCommentAction handles requests to vote on a comment, up unless the "up" form field is false. It redirects back to the Story on which
 // the Comment was posted on. If not authenticated, it redirects to the root path.
 func (s *Server) HandleVoteCommentAction() HandleE {
 	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
-		// We'll redirect to a given route after submitting this, so we use redir to specify it
+		// We'll redirect to a given route after submitting this, so we use redir to specify it, JSON clients
+		// getting no content instead
 		redir, err := normalizeRedir(req.URL.Query()["redir"])
-		if err != nil {
+		if err != nil && !wantsJSON(req) {
 			return UnprocessableEntityWithError(err, "redir")
 		}
 
+		userRecord := ctxUser(req.Context())
 		storyID := params.ByName("story_id")
-		_, err = s.store.FindStory(storyID)
+		story, err := s.store.FindStory(storyID)
 		if err != nil {
 			return Maybe404(err)
 		}
 
+		visible, err := s.canSeeStory(story, userRecord)
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
 		id := params.ByName("id")
 		s.Logger.Debug().Str("id", id).Msg("comment")
-		_, err = s.store.FindComment(id)
+		comment, err := s.store.FindComment(id)
 		if err != nil {
 			return Maybe404(err)
 		}
 
-		userRecord := ctxUser(req.Context())
+		// the story has to be the one of the comment, or its visibility wouldn't tell anything
+		if comment.StoryID != storyID {
+			return NotFound(req.URL.Path)
+		}
 
-		err = s.store.CreateOrUpdateVoteOnComment(id, userRecord.ID, true)
+		err = s.vote(userRecord, comment.StoryID, comment.ID, req.FormValue("up") != "false")
 		if err != nil {
 			return err
 		}
 
+		if wantsJSON(req) {
+			res.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+
 		http.Redirect(res, req, redir, http.StatusFound)
 		return nil
 	}
 }
 
-// HandleVoteStoryAction handles requests to vote on a given Story. If not authenticated, it redirects to the root path.
+// HandleVoteStoryAction handles requests to vote on a given Story, up unless the "up" form field is false. If not authenticated, it redirects to the root path.
+// Clients asking for JSON get no content instead of being redirected.
 func (s *Server) HandleVoteStoryAction() HandleE {
 	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
-		// We'll redirect to a given route after submitting this, so we use redir to specify it
+		// We'll redirect to a given route after submitting this, so we use redir to specify it, JSON clients
+		// getting no content instead
 		redir, err := normalizeRedir(req.URL.Query()["redir"])
-		if err != nil {
+		if err != nil && !wantsJSON(req) {
 			return UnprocessableEntityWithError(err, "redir")
 		}
 
+		userRecord := ctxUser(req.Context())
 		id := params.ByName("id")
-		_, err = s.store.FindStory(id)
+		story, err := s.store.FindStory(id)
 		if err != nil {
 			return Maybe404(err)
 		}
 
-		userRecord := ctxUser(req.Context())
+		visible, err := s.canSeeStory(story, userRecord)
 		if err != nil {
 			return err
 		}
 
-		err = s.store.CreateOrUpdateVoteOnStory(id, userRecord.ID, true)
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		err = s.vote(userRecord, id, "", req.FormValue("up") != "false")
 		if err != nil {
 			return err
 		}
 
+		if wantsJSON(req) {
+			res.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+
 		http.Redirect(res, req, redir, http.StatusFound)
 		return nil
 	}
 }
 
+// vote records the vote of the given user on a story, or on a comment if commentID is set. Downvoting
+// requires some karma. Votes of shadow-banned users are accepted but dropped, so they can't move scores
+// and karma while not learning about the ban.
+func (s *Server) vote(userRecord *User, storyID string, commentID string, up bool) error {
+	if !up {
+		err := requireKarma(userRecord, s.config.MinKarmaToDownvote)
+		if err != nil {
+			return err
+		}
+	}
+
+	if userRecord.IsShadowBanned() {
+		return nil
+	}
+
+	var created bool
+	var err error
+	if commentID != "" {
+		created, err = s.store.CreateOrUpdateVoteOnComment(commentID, userRecord.ID, up)
+	} else {
+		created, err = s.store.CreateOrUpdateVoteOnStory(storyID, userRecord.ID, up)
+	}
+	if err != nil {
+		return err
+	}
+
+	// changing or repeating a vote isn't a new one
+	if created {
+		s.emitWebhookEvent(WebhookVoteCreated, &webhookVote{StoryID: storyID, CommentID: commentID, User: userRecord.Name, Up: up})
+	}
+
+	return nil
+}
+
 func (s *Server) HandleCommentEdit() HandleE {
-	tmpl, err := template.New("edit.html").Funcs(helpers).ParseFiles(
+	tmpl, err := template.New("edit.html").Funcs(s.helpers()).ParseFiles(
 		"assets/templates/edit.html",
 		"assets/templates/_header.html",
 		"assets/templates/_footer.html")
@@ -560,17 +1102,7 @@ func (s *Server) HandleCommentEdit() HandleE {
 			return err
 		}
 
-		// Cannot edit comments that aren't yours.
-		if comment.AuthorID != userRecord.ID {
-			http.Redirect(res, req, "/", http.StatusFound)
-			return nil
-		}
-
-		// If comment is older than edit window, let's redirect
-		editWindow := time.Duration(s.config.EditWindowInMinutes) * time.Minute
-		if comment.CreatedAt.Add(editWindow).Before(NowFunc()) {
-			SetFlash(res, "warning", "Comment is too old to be edited.")
-			http.Redirect(res, req, "/stories/"+story.ID+"/comments", http.StatusFound)
+		if !s.canEditComment(res, req, userRecord, comment) {
 			return nil
 		}
 
@@ -594,7 +1126,7 @@ func (s *Server) HandleCommentUpdateAction() HandleE {
 		userRecord := ctxUser(req.Context())
 
 		storyID := params.ByName("story_id")
-		_, err := s.store.FindStory(storyID)
+		story, err := s.store.FindStory(storyID)
 		if err != nil {
 			return Maybe404(err)
 		}
@@ -610,9 +1142,7 @@ func (s *Server) HandleCommentUpdateAction() HandleE {
 			return err
 		}
 
-		// Cannot edit comments that aren't yours.
-		if comment.AuthorID != userRecord.ID {
-			http.Redirect(res, req, "/", http.StatusFound)
+		if !s.canEditComment(res, req, userRecord, comment) {
 			return nil
 		}
 
@@ -621,18 +1151,73 @@ func (s *Server) HandleCommentUpdateAction() HandleE {
 			return UnprocessableEntityWithError(err)
 		}
 
-		comment.Body = req.Form.Get("body")
-		err = s.store.UpdateComment(comment)
+		err = s.updateComment(story, comment, req.Form.Get("body"))
 		if err != nil {
 			return err
 		}
 
-		SetFlash(res, "success", "Comment has been edited")
+		if comment.IsHeld() {
+			SetFlash(res, "info", "Thanks, your comment will be shown once a moderator reviewed it.")
+		} else {
+			SetFlash(res, "success", "Comment has been edited")
+		}
 		http.Redirect(res, req, "/stories/"+storyID+"/comments", http.StatusFound)
 		return nil
 	}
 }
 
+// updateComment replaces the body of a comment, running it through the filters again if it changed.
+func (s *Server) updateComment(story *Story, comment *Comment, body string) error {
+	// an unchanged body has already been through the filters, the duplicates one would even reject it
+	if body != comment.Body {
+		comment.Body = body
+		hold, err := s.filterComment(story, comment)
+		if err != nil {
+			return err
+		}
+
+		if hold {
+			comment.HeldAt = sql.NullTime{Time: NowFunc(), Valid: true}
+		}
+	}
+
+	return s.store.UpdateComment(comment)
+}
+
+// canEditComment returns true if the user can edit the comment. Otherwise, it redirects the user, explaining
+// why if it's because the comment is too old.
+func (s *Server) canEditComment(res http.ResponseWriter, req *http.Request, userRecord *User, comment *Comment) bool {
+	if comment.IsRemoved() {
+		http.Redirect(res, req, "/stories/"+comment.StoryID+"/comments", http.StatusFound)
+		return false
+	}
+
+	editWindow := time.Duration(s.config.EditWindowInMinutes) * time.Minute
+	if CanEditComment(userRecord, comment, editWindow, NowFunc()) {
+		return true
+	}
+
+	// Cannot edit comments that aren't yours.
+	if !isAuthor(userRecord, comment) {
+		http.Redirect(res, req, "/", http.StatusFound)
+		return false
+	}
+
+	SetFlash(res, "warning", "Comment is too old to be edited.")
+	http.Redirect(res, req, "/stories/"+comment.StoryID+"/comments", http.StatusFound)
+	return false
+}
+
 func rank(s ranking.Rankable) float64 {
 	return ranking.Rank(s, 1.8, 4*24, NowFunc())
 }
+
+// rankPinnedFirst returns true if story a comes before story b on the index, stories pinned at the given
+// time always coming first regardless of their rank, which is penalized according to the site settings.
+func rankPinnedFirst(a *Story, b *Story, at time.Time, settings *SiteSettings) bool {
+	if a.IsPinned(at) != b.IsPinned(at) {
+		return a.IsPinned(at)
+	}
+
+	return penalizedRank(a, settings) > penalizedRank(b, settings)
+}
@@ -38,4 +38,43 @@ var helpers template.FuncMap = template.FuncMap{
 
 		return dict, nil
 	},
+	// csrfField renders the hidden input carrying the CSRF token of the session, to be embedded in
+	// every form changing state. It renders nothing if there is no session.
+	"csrfField": func(session *Session) template.HTML {
+		if session == nil {
+			return ""
+		}
+
+		return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(session.CSRFToken) + `">`)
+	},
+	// canModerate and canAdminister tell templates what the user of the session is allowed to do, see
+	// CanModerate and CanAdminister.
+	"canModerate": func(session *Session) bool {
+		return CanModerate(session.user())
+	},
+	"canAdminister": func(session *Session) bool {
+		return CanAdminister(session.user())
+	},
+	"flagReasons": func() []FlagReason {
+		return AllFlagReasons
+	},
+	"excerpt": excerpt,
+}
+
+// excerptLength is how many characters of a text are kept in an excerpt.
+const excerptLength = 300
+
+// excerpt returns the beginning of a text, cut on a word boundary when it's too long.
+func excerpt(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= excerptLength {
+		return string(runes)
+	}
+
+	cut := string(runes[:excerptLength])
+	if i := strings.LastIndexAny(cut, " \n\t"); i > 0 {
+		cut = cut[:i]
+	}
+
+	return strings.TrimSpace(cut) + "…"
 }
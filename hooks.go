@@ -0,0 +1,267 @@
+package tabloid
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// defaultHookWorkers is how many hooks run at once when HookWorkers isn't set.
+	defaultHookWorkers = 4
+	// defaultHookTimeout bounds each run of a hook when HookTimeoutInSeconds isn't set.
+	defaultHookTimeout = 30 * time.Second
+	// hookQueueSize is how many hook runs can wait for a worker, the ones coming after being dropped.
+	hookQueueSize = 256
+)
+
+// hookRetryDelay is how long to wait before running a failed hook again, doubling after each attempt.
+var hookRetryDelay = time.Second
+
+// errHookTimeout is returned when a hook runs for longer than its timeout.
+var errHookTimeout = errors.New("hook timed out")
+
+// hookPanicError is returned when a hook panics, along the stack it panicked with.
+type hookPanicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *hookPanicError) Error() string {
+	return fmt.Sprintf("hook panicked: %v", e.value)
+}
+
+// hookRun is a hook to run on a story or a comment, queued until a worker picks it.
+type hookRun struct {
+	// kind is either "story" or "comment", and index the position of the hook among the ones of its kind,
+	// in the order they were registered. They tell hooks apart in the logs.
+	kind      string
+	index     int
+	storyID   string
+	commentID string
+	fn        func() error
+	// attempt is how many times the hook ran, and delay how long to wait before running it again if it fails.
+	attempt int
+	delay   time.Duration
+}
+
+// runStoryHooks runs the story hooks on a story which has just become visible to everyone. Unless hooks are
+// synchronous, they're queued and it returns right away.
+func (s *Server) runStoryHooks(story *Story) error {
+	// hooks may run after the request is done with the story
+	st := *story
+	story = &st
+	for i, h := range s.storyHooks {
+		h := h
+		err := s.runHook(&hookRun{kind: "story", index: i, storyID: story.ID, fn: func() error { return h(story) }})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runCommentHooks runs the comment hooks on a comment which has just become visible to everyone. Unless hooks
+// are synchronous, they're queued and it returns right away.
+func (s *Server) runCommentHooks(story *Story, comment *Comment) error {
+	// hooks may run after the request is done with them
+	st, c := *story, *comment
+	story, comment = &st, &c
+	for i, h := range s.commentHooks {
+		h := h
+		err := s.runHook(&hookRun{kind: "comment", index: i, storyID: story.ID, commentID: comment.ID, fn: func() error { return h(story, comment) }})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) runHook(run *hookRun) error {
+	if s.config.SynchronousHooks {
+		err := callHook(run.fn, s.hookTimeout())
+		if err != nil {
+			s.hookLogger(run).Warn().Err(err).Msg("hook failed")
+		}
+		return err
+	}
+
+	if !s.queueHook(run) {
+		// either the server isn't prepared or it's stopped, nothing would pick the run
+		s.hookLogger(run).Warn().Msg("hook workers aren't running, running the hook inline")
+		s.executeHook(run)
+	}
+
+	return nil
+}
+
+// queueHook queues a hook run for the workers, returning false if they aren't running. Runs coming while the
+// queue is full are dropped rather than holding up the request until the workers catch up.
+func (s *Server) queueHook(run *hookRun) bool {
+	// the workers can't stop while a run is being queued, so it can't be left in the queue
+	s.hookWorkersMu.RLock()
+	defer s.hookWorkersMu.RUnlock()
+
+	if !s.hookWorkersRunning {
+		return false
+	}
+
+	select {
+	case s.hookQueue <- run:
+	default:
+		s.hookLogger(run).Error().Int("attempt", run.attempt).Msg("hook queue is full, dropping the hook run")
+	}
+
+	return true
+}
+
+// hookTimeout returns how long a hook can run, HookTimeoutInSeconds or its default.
+func (s *Server) hookTimeout() time.Duration {
+	timeout := time.Duration(s.config.HookTimeoutInSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	return timeout
+}
+
+// hookLogger returns a logger telling which hook runs on what.
+func (s *Server) hookLogger(run *hookRun) *zerolog.Logger {
+	ctx := s.Logger.With().Str("hook", run.kind).Int("hook_index", run.index).Str("story_id", run.storyID)
+	if run.commentID != "" {
+		ctx = ctx.Str("comment_id", run.commentID)
+	}
+
+	logger := ctx.Logger()
+	return &logger
+}
+
+// startHookWorkers starts the workers running the queued hooks, HookWorkers of them.
+func (s *Server) startHookWorkers() {
+	workers := s.config.HookWorkers
+	if workers <= 0 {
+		workers = defaultHookWorkers
+	}
+
+	s.hookWorkersMu.Lock()
+	defer s.hookWorkersMu.Unlock()
+
+	s.hookWorkersRunning = true
+	for i := 0; i < workers; i++ {
+		s.hookWorkers.Add(1)
+		go s.runHookWorker()
+	}
+}
+
+// stopHookWorkers stops the workers once they ran the queued hooks, then waits for the pending retries,
+// which run inline once due. Hooks coming after run inline too.
+func (s *Server) stopHookWorkers() {
+	s.hookWorkersMu.Lock()
+	if s.hookWorkersRunning {
+		s.hookWorkersRunning = false
+		close(s.hooksStopped)
+	}
+	s.hookWorkersMu.Unlock()
+
+	s.hookWorkers.Wait()
+	s.hookRetries.Wait()
+}
+
+// runHookWorker runs the queued hooks until the workers are stopped, running the ones still queued by then
+// before returning.
+func (s *Server) runHookWorker() {
+	defer s.hookWorkers.Done()
+
+	for {
+		select {
+		case run := <-s.hookQueue:
+			s.executeHook(run)
+		case <-s.hooksStopped:
+			for {
+				select {
+				case run := <-s.hookQueue:
+					s.executeHook(run)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// executeHook runs a hook, scheduling it to run again with an increasing delay while it returns an error, up
+// to HookRetries times. Hooks which time out or panic aren't run again, as they'd likely do the same.
+func (s *Server) executeHook(run *hookRun) {
+	logger := s.hookLogger(run)
+	run.attempt++
+	if run.attempt == 1 {
+		run.delay = hookRetryDelay
+	}
+
+	start := time.Now()
+	err := callHook(run.fn, s.hookTimeout())
+	if err == nil {
+		logger.Debug().Int("attempt", run.attempt).Dur("duration", time.Since(start)).Msg("hook ran")
+		return
+	}
+
+	var panicErr *hookPanicError
+	if errors.As(err, &panicErr) {
+		logger.Error().Err(err).Int("attempt", run.attempt).Bytes("stack", panicErr.stack).Msg("hook panicked")
+		return
+	}
+
+	if err == errHookTimeout || run.attempt > s.config.HookRetries {
+		logger.Error().Err(err).Int("attempt", run.attempt).Dur("duration", time.Since(start)).Msg("hook failed")
+		return
+	}
+
+	logger.Warn().Err(err).Int("attempt", run.attempt).Dur("retry_in", run.delay).Msg("hook failed, retrying")
+	s.retryHook(run)
+}
+
+// retryHook queues a failed hook run again once its delay is over, doubling the delay for the next attempt.
+// Workers aren't held while waiting, and the run goes inline if they were stopped in the meantime.
+func (s *Server) retryHook(run *hookRun) {
+	delay := run.delay
+	run.delay *= 2
+
+	s.hookRetries.Add(1)
+	time.AfterFunc(delay, func() {
+		defer s.hookRetries.Done()
+
+		if !s.queueHook(run) {
+			s.executeHook(run)
+		}
+	})
+}
+
+// callHook calls a hook, turning a panic into a hookPanicError and giving up on waiting for it after the
+// given timeout. Hooks can't be interrupted, one which times out keeps running in the background.
+func callHook(fn func() error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- &hookPanicError{value: v, stack: debug.Stack()}
+			}
+		}()
+
+		done <- fn()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errHookTimeout
+	}
+}
@@ -0,0 +1,186 @@
+package tabloid
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/rs/zerolog"
+)
+
+func TestCallHook(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("returns the error of the hook", func(c *qt.C) {
+		err := callHook(func() error { return errors.New("slack is down") }, time.Second)
+		c.Assert(err, qt.ErrorMatches, "slack is down")
+	})
+
+	c.Run("recovers from panics", func(c *qt.C) {
+		err := callHook(func() error { panic("oops") }, time.Second)
+		var panicErr *hookPanicError
+		c.Assert(errors.As(err, &panicErr), qt.IsTrue)
+		c.Assert(err, qt.ErrorMatches, "hook panicked: oops")
+		c.Assert(string(panicErr.stack), qt.Contains, "TestCallHook")
+	})
+
+	c.Run("gives up on slow hooks", func(c *qt.C) {
+		release := make(chan struct{})
+		defer close(release)
+
+		err := callHook(func() error { <-release; return nil }, 10*time.Millisecond)
+		c.Assert(err, qt.Equals, errHookTimeout)
+	})
+}
+
+func TestRunHooks(t *testing.T) {
+	c := qt.New(t)
+	c.Patch(&hookRetryDelay, time.Millisecond)
+
+	story := &Story{ID: "1"}
+	comment := &Comment{ID: "2", StoryID: "1"}
+
+	c.Run("hooks run in the background, failing ones being run again", func(c *qt.C) {
+		s := NewServer(&ServerConfig{HookRetries: 2}, zerolog.Nop(), nil)
+		s.startHookWorkers()
+
+		attempts := make(chan string, 10)
+		s.AddStoryHook(func(story *Story) error {
+			attempts <- story.ID
+			return errors.New("slack is down")
+		})
+		s.AddCommentHook(func(story *Story, comment *Comment) error {
+			attempts <- comment.ID
+			return nil
+		})
+
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		c.Assert(s.runCommentHooks(story, comment), qt.IsNil)
+
+		// queued hooks run before the workers stop
+		s.stopHookWorkers()
+		close(attempts)
+
+		seen := map[string]int{}
+		for id := range attempts {
+			seen[id]++
+		}
+		c.Assert(seen, qt.DeepEquals, map[string]int{"1": 3, "2": 1})
+	})
+
+	c.Run("retries don't hold up the workers", func(c *qt.C) {
+		c.Patch(&hookRetryDelay, 50*time.Millisecond)
+		s := NewServer(&ServerConfig{HookWorkers: 1, HookRetries: 1}, zerolog.Nop(), nil)
+		s.startHookWorkers()
+
+		ran := make(chan string, 10)
+		failed := false
+		s.AddStoryHook(func(story *Story) error {
+			ran <- "first"
+			if !failed {
+				failed = true
+				return errors.New("slack is down")
+			}
+			return nil
+		})
+		s.AddStoryHook(func(story *Story) error {
+			ran <- "second"
+			return nil
+		})
+
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		s.stopHookWorkers()
+		close(ran)
+
+		order := []string{}
+		for name := range ran {
+			order = append(order, name)
+		}
+		c.Assert(order, qt.DeepEquals, []string{"first", "second", "first"})
+	})
+
+	c.Run("hooks are dropped when the queue is full", func(c *qt.C) {
+		s := NewServer(&ServerConfig{}, zerolog.Nop(), nil)
+		// workers which never pick anything
+		s.hookQueue = make(chan *hookRun)
+		s.hookWorkersRunning = true
+
+		ran := false
+		s.AddStoryHook(func(story *Story) error {
+			ran = true
+			return nil
+		})
+
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		c.Assert(ran, qt.IsFalse)
+	})
+
+	c.Run("hooks run inline when the workers aren't running", func(c *qt.C) {
+		s := NewServer(&ServerConfig{}, zerolog.Nop(), nil)
+
+		ran := 0
+		s.AddStoryHook(func(story *Story) error {
+			ran++
+			return nil
+		})
+
+		// not prepared yet
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		c.Assert(ran, qt.Equals, 1)
+
+		s.startHookWorkers()
+		s.stopHookWorkers()
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		c.Assert(ran, qt.Equals, 2)
+	})
+
+	c.Run("hooks which panic aren't run again", func(c *qt.C) {
+		s := NewServer(&ServerConfig{HookRetries: 2}, zerolog.Nop(), nil)
+
+		attempts := 0
+		s.executeHook(&hookRun{kind: "story", storyID: "1", fn: func() error {
+			attempts++
+			panic("oops")
+		}})
+		c.Assert(attempts, qt.Equals, 1)
+	})
+
+	c.Run("synchronous hooks fail the caller", func(c *qt.C) {
+		s := NewServer(&ServerConfig{SynchronousHooks: true}, zerolog.Nop(), nil)
+
+		ran := []string{}
+		s.AddStoryHook(func(story *Story) error {
+			ran = append(ran, "first")
+			return errors.New("slack is down")
+		})
+		s.AddStoryHook(func(story *Story) error {
+			ran = append(ran, "second")
+			return nil
+		})
+
+		c.Assert(s.runStoryHooks(story), qt.ErrorMatches, "slack is down")
+		c.Assert(ran, qt.DeepEquals, []string{"first"})
+	})
+
+	c.Run("synchronous hooks recover from panics", func(c *qt.C) {
+		s := NewServer(&ServerConfig{SynchronousHooks: true}, zerolog.Nop(), nil)
+		s.AddCommentHook(func(story *Story, comment *Comment) error {
+			panic("oops")
+		})
+
+		c.Assert(s.runCommentHooks(story, comment), qt.ErrorMatches, "hook panicked: oops")
+	})
+
+	c.Run("hooks get their own copy", func(c *qt.C) {
+		s := NewServer(&ServerConfig{SynchronousHooks: true}, zerolog.Nop(), nil)
+		s.AddStoryHook(func(story *Story) error {
+			story.Title = "changed"
+			return nil
+		})
+
+		story := &Story{ID: "1", Title: "Foobar"}
+		c.Assert(s.runStoryHooks(story), qt.IsNil)
+		c.Assert(story.Title, qt.Equals, "Foobar")
+	})
+}
@@ -4,11 +4,15 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/PuerkitoBio/goquery"
 	qt "github.com/frankban/quicktest"
 	"github.com/gorilla/sessions"
 	"github.com/jhchabran/tabloid"
+	"github.com/jhchabran/tabloid/authentication"
 	"github.com/jhchabran/tabloid/authentication/fake_auth"
 	"github.com/jhchabran/tabloid/pgstore"
 	"github.com/jmoiron/sqlx"
@@ -25,6 +29,18 @@ func truncateDatabase(db *sqlx.DB) {
 	db.MustExec("TRUNCATE TABLE comments;")
 	db.MustExec("TRUNCATE TABLE users;")
 	db.MustExec("TRUNCATE TABLE votes;")
+	db.MustExec("TRUNCATE TABLE identities;")
+	db.MustExec("TRUNCATE TABLE api_tokens;")
+	db.MustExec("TRUNCATE TABLE sessions;")
+	db.MustExec("TRUNCATE TABLE site_settings;")
+	db.MustExec("TRUNCATE TABLE flags;")
+	db.MustExec("TRUNCATE TABLE moderation_log;")
+	db.MustExec("TRUNCATE TABLE rate_limits;")
+	db.MustExec("TRUNCATE TABLE link_metadata;")
+	db.MustExec("TRUNCATE TABLE archives;")
+	db.MustExec("TRUNCATE TABLE stories_search;")
+	db.MustExec("TRUNCATE TABLE comments_search;")
+	db.MustExec("TRUNCATE TABLE webhook_deliveries, webhooks;")
 }
 
 // testingLogWriter is an output target for zerolog which will print on the testing logger.
@@ -44,12 +60,19 @@ func (l *testingLogWriter) Write(p []byte) (n int, err error) {
 type testContext struct {
 	c          *qt.C
 	server     *tabloid.Server
+	config     *tabloid.ServerConfig
 	testServer *httptest.Server
 	pgStore    *pgstore.PGStore
 }
 
 // newTestContext creates a server instance with its component initialized for integration testing.
 func newTestContext(c *qt.C) *testContext {
+	return newTestContextWithProviders(c, "fake")
+}
+
+// newTestContextWithProviders creates a server instance like newTestContext, with a fake authentication
+// provider for each given name.
+func newTestContextWithProviders(c *qt.C, names ...string) *testContext {
 	tc := testContext{c: c}
 
 	w := testingLogWriter{c}
@@ -58,17 +81,30 @@ func newTestContext(c *qt.C) *testContext {
 
 	tc.pgStore = pgstore.New(dbString)
 	sessionStore := sessions.NewCookieStore([]byte("test"))
-	fakeAuth := fake_auth.New(sessionStore, logger)
 
+	var fakeAuths []*fake_auth.Handler
+	var authServices []authentication.AuthService
+	for _, name := range names {
+		fakeAuth := fake_auth.New(sessionStore, logger)
+		fakeAuth.SetName(name)
+		fakeAuths = append(fakeAuths, fakeAuth)
+		authServices = append(authServices, fakeAuth)
+	}
+
+	// the config can be adjusted by tests until the server is prepared. Hooks run within the requests, so
+	// tests can check what they did as soon as they get the response.
+	tc.config = &tabloid.ServerConfig{Addr: testServerHost, StoriesPerPage: 3, EditWindowInMinutes: 60, SynchronousHooks: true}
 	tc.server = tabloid.NewServer(
-		&tabloid.ServerConfig{Addr: testServerHost, StoriesPerPage: 3, EditWindowInMinutes: 60},
+		tc.config,
 		logger,
 		tc.pgStore,
-		fakeAuth,
+		authServices...,
 	)
 	tc.testServer = httptest.NewServer(tc.server)
 
-	fakeAuth.SetServerURL(tc.testServer.URL)
+	for _, fakeAuth := range fakeAuths {
+		fakeAuth.SetServerURL(tc.testServer.URL)
+	}
 
 	return &tc
 }
@@ -132,3 +168,97 @@ func (tc *testContext) newAuthenticatedClient() *http.Client {
 	tc.c.Assert(resp.StatusCode, qt.Equals, 200)
 	return client
 }
+
+// newSessionClient returns a client signed in as the given user through a session opened directly in
+// the store, like if the user had signed in from another device.
+func (tc *testContext) newSessionClient(userID string) *http.Client {
+	user, err := tc.pgStore.FindUserByID(userID)
+	tc.c.Assert(err, qt.IsNil)
+
+	session, secret, err := tabloid.NewSession(user, "fake", httptest.NewRequest("GET", "/", nil))
+	tc.c.Assert(err, qt.IsNil)
+	tc.c.Assert(tc.pgStore.CreateSession(session), qt.IsNil)
+
+	client := tc.newHTTPClient()
+	u, err := url.Parse(tc.url("/"))
+	tc.c.Assert(err, qt.IsNil)
+	client.Jar.SetCookies(u, []*http.Cookie{{Name: "tabloid-sid", Value: secret, Path: "/"}})
+
+	return client
+}
+
+// csrfToken returns the CSRF token of the session the client is signed in with, or an empty string
+// if it isn't signed in.
+func (tc *testContext) csrfToken(client *http.Client) string {
+	u, err := url.Parse(tc.url("/"))
+	tc.c.Assert(err, qt.IsNil)
+
+	for _, cookie := range client.Jar.Cookies(u) {
+		if cookie.Name != "tabloid-sid" {
+			continue
+		}
+
+		session, err := tc.pgStore.FindSessionByHash(tabloid.HashAPIToken(cookie.Value))
+		tc.c.Assert(err, qt.IsNil)
+		if session != nil {
+			return session.CSRFToken
+		}
+	}
+
+	return ""
+}
+
+// postForm posts the given values like a browser would, attaching the CSRF token of the client session
+// like the forms rendered by the server do.
+func (tc *testContext) postForm(client *http.Client, path string, values url.Values) (*http.Response, error) {
+	form := url.Values{}
+	for k, v := range values {
+		form[k] = v
+	}
+
+	if token := tc.csrfToken(client); token != "" {
+		form.Set("csrf_token", token)
+	}
+
+	return client.PostForm(tc.url(path), form)
+}
+
+// createAPIToken creates a personal access token through the settings page of the given authenticated client,
+// returning its secret.
+func (tc *testContext) createAPIToken(client *http.Client, scopes ...string) string {
+	values := url.Values{
+		"name":   []string{"test token"},
+		"scopes": scopes,
+	}
+
+	resp, err := tc.postForm(client, "/settings/tokens", values)
+	tc.c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	tc.c.Assert(resp.StatusCode, qt.Equals, 200)
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	tc.c.Assert(err, qt.IsNil)
+
+	secret := strings.TrimSpace(doc.Find("#new-token").Text())
+	tc.c.Assert(secret, qt.Not(qt.Equals), "")
+	return secret
+}
+
+// newTokenRequest returns a request authenticated with the given personal access token.
+func (tc *testContext) newTokenRequest(method string, path string, values url.Values, secret string) *http.Request {
+	req, err := http.NewRequest(method, tc.url(path), strings.NewReader(values.Encode()))
+	tc.c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+secret)
+	return req
+}
+
+// newAPIRequest returns a request to the JSON API with the given body, authenticated with the given personal
+// access token.
+func (tc *testContext) newAPIRequest(method string, path string, body string, secret string) *http.Request {
+	req, err := http.NewRequest(method, tc.url(path), strings.NewReader(body))
+	tc.c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+secret)
+	return req
+}
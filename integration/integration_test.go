@@ -1,8 +1,13 @@
 package integration
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"strings"
@@ -12,6 +17,8 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	qt "github.com/frankban/quicktest"
 	"github.com/jhchabran/tabloid"
+	"github.com/jhchabran/tabloid/client"
+	"github.com/rs/zerolog"
 )
 
 func TestIndexPage(t *testing.T) {
@@ -253,7 +260,7 @@ func TestSubmitStory(t *testing.T) {
 			"url":   []string{"http://duckduckgo.com"},
 			"body":  []string{"foobar"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 401)
 	})
@@ -272,7 +279,7 @@ func TestSubmitStory(t *testing.T) {
 			"title": []string{string(b)},
 			"url":   []string{"http://duckduckgo.com"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 422)
@@ -287,7 +294,7 @@ func TestSubmitStory(t *testing.T) {
 			"title": []string{"Captain Nemo"},
 			"url":   []string{"http://duckduckgo.com"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -303,7 +310,7 @@ func TestSubmitStory(t *testing.T) {
 			"url":   []string{"http://duckduckgo.com"},
 			"body":  []string{"Here is a great link"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -318,7 +325,7 @@ func TestSubmitStory(t *testing.T) {
 			"title": []string{"How do I git gud at coding"},
 			"body":  []string{"Someone told me I must learn assembly"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -332,7 +339,7 @@ func TestSubmitStory(t *testing.T) {
 		values := url.Values{
 			"title": []string{"Captain Nemo"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 422)
@@ -346,7 +353,7 @@ func TestSubmitStory(t *testing.T) {
 		values := url.Values{
 			"body": []string{"errrrr"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 422)
@@ -362,7 +369,7 @@ func TestSubmitStory(t *testing.T) {
 			"url":   []string{"http://foobar"},
 		}
 
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -383,7 +390,7 @@ func TestSubmitStory(t *testing.T) {
 			"url":   []string{"http://foobar.com/         "},
 		}
 
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -405,7 +412,7 @@ func TestSubmitStory(t *testing.T) {
 			"body":  []string{"space\nnow      \n\n     "},
 		}
 
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -438,7 +445,7 @@ func TestSubmitStory(t *testing.T) {
 				"url":   []string{test.url},
 			}
 
-			resp, err := client.PostForm(tc.url("/submit"), values)
+			resp, err := tc.postForm(client, "/submit", values)
 			c.Assert(err, qt.IsNil, qt.Commentf("url=%v", test.url))
 			defer resp.Body.Close()
 			c.Assert(resp.StatusCode, qt.Equals, test.status, qt.Commentf("url=%v", test.url))
@@ -477,10 +484,13 @@ func TestAuthentication(t *testing.T) {
 		doc, err := goquery.NewDocumentFromReader(resp.Body)
 		c.Assert(err, qt.IsNil)
 
-		logoutPath, ok := doc.Find("a#session-signout").Attr("href")
+		form := doc.Find("#session-signout").Closest("form")
+		logoutPath, ok := form.Attr("action")
+		c.Assert(ok, qt.IsTrue)
+		csrfToken, ok := form.Find("input[name=csrf_token]").Attr("value")
 		c.Assert(ok, qt.IsTrue)
 
-		resp, err = client.Get(tc.url(logoutPath))
+		resp, err = client.PostForm(tc.url(logoutPath), url.Values{"csrf_token": []string{csrfToken}})
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
@@ -490,6 +500,290 @@ func TestAuthentication(t *testing.T) {
 		_, ok = doc.Find("a#session-signin").Attr("href")
 		c.Assert(ok, qt.IsTrue)
 	})
+
+	c.Run("signing in with a named provider", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newHTTPClient()
+
+		resp, err := client.Get(tc.url("/auth/fake/start"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		login := doc.Find("a#session-login").Text()
+		c.Assert(login, qt.Contains, "fakeLogin")
+	})
+
+	c.Run("signing in with an unknown provider", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newHTTPClient()
+
+		resp, err := client.Get(tc.url("/auth/unknown/start"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("login page lists the providers", func(c *qt.C) {
+		tc := newTestContextWithProviders(c, "fake", "other")
+		tc.prepareServer()
+		client := tc.newHTTPClient()
+
+		resp, err := client.Get(tc.url("/login"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+
+		var hrefs []string
+		doc.Find("a.auth-provider").Each(func(_ int, sel *goquery.Selection) {
+			hrefs = append(hrefs, sel.AttrOr("href", ""))
+		})
+		c.Assert(hrefs, qt.DeepEquals, []string{"/auth/fake/start", "/auth/other/start"})
+	})
+}
+
+func TestMultipleProviders(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("same login on two providers creates two users", func(c *qt.C) {
+		tc := newTestContextWithProviders(c, "fake", "other")
+		tc.prepareServer()
+
+		// both fake providers hand out fakeLogin1 on their first sign in
+		resp, err := tc.newHTTPClient().Get(tc.url("/auth/fake/start"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		resp, err = tc.newHTTPClient().Get(tc.url("/auth/other/start"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		var names []string
+		err = tc.pgStore.DB().Select(&names, "SELECT name FROM users ORDER BY id")
+		c.Assert(err, qt.IsNil)
+		c.Assert(names, qt.DeepEquals, []string{"fakeLogin1", "fakeLogin1-other"})
+	})
+
+	c.Run("signing in with another provider links the account", func(c *qt.C) {
+		tc := newTestContextWithProviders(c, "fake", "other")
+		tc.prepareServer()
+		client := tc.newHTTPClient()
+
+		resp, err := client.Get(tc.url("/auth/fake/start"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		resp, err = client.Get(tc.url("/auth/other/start"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		var usersCount int
+		err = tc.pgStore.DB().Get(&usersCount, "SELECT count(*) FROM users")
+		c.Assert(err, qt.IsNil)
+		c.Assert(usersCount, qt.Equals, 1)
+
+		var providers []string
+		err = tc.pgStore.DB().Select(&providers, "SELECT provider FROM identities ORDER BY provider")
+		c.Assert(err, qt.IsNil)
+		c.Assert(providers, qt.DeepEquals, []string{"fake", "other"})
+	})
+
+	c.Run("signing out signs out from all providers", func(c *qt.C) {
+		tc := newTestContextWithProviders(c, "fake", "other")
+		tc.prepareServer()
+		client := tc.newHTTPClient()
+
+		resp, err := client.Get(tc.url("/auth/fake/start"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		resp, err = client.Get(tc.url("/auth/other/start"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		resp, err = tc.postForm(client, "/oauth/destroy", nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		_, ok := doc.Find("a#session-signin").Attr("href")
+		c.Assert(ok, qt.IsTrue)
+	})
+}
+
+func TestCSRF(t *testing.T) {
+	c := qt.New(t)
+
+	values := url.Values{
+		"title": []string{"Forged"},
+		"url":   []string{"http://duckduckgo.com"},
+	}
+
+	c.Run("rejects forms without the session token", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.PostForm(tc.url("/submit"), values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		var count int
+		err = tc.pgStore.DB().Get(&count, "SELECT count(*) FROM stories")
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 0)
+	})
+
+	c.Run("rejects forms with another session token", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+		otherClient := tc.newAuthenticatedClient()
+
+		forged := url.Values{"csrf_token": []string{tc.csrfToken(otherClient)}}
+		for k, v := range values {
+			forged[k] = v
+		}
+
+		resp, err := client.PostForm(tc.url("/submit"), forged)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+	})
+
+	c.Run("forms embed the session token", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/submit"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		token, ok := doc.Find("#submit-form input[name=csrf_token]").Attr("value")
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(token, qt.Equals, tc.csrfToken(client))
+	})
+
+	c.Run("logging out on GET is not allowed", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/oauth/destroy"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 405)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	c := qt.New(t)
+
+	// isSignedIn returns true if the client is signed in, according to the header of the index page.
+	isSignedIn := func(c *qt.C, tc *testContext, client *http.Client) bool {
+		resp, err := client.Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		return doc.Find("#session-signout").Length() == 1
+	}
+
+	// signInTwice returns two clients signed in as the same user, as if they were different devices.
+	signInTwice := func(c *qt.C, tc *testContext) (*http.Client, *http.Client) {
+		client := tc.newAuthenticatedClient()
+		user, err := tc.pgStore.FindUserByIdentity("fake", "fakeLogin1")
+		c.Assert(err, qt.IsNil)
+
+		return client, tc.newSessionClient(user.ID)
+	}
+
+	c.Run("settings list sessions", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, _ := signInTwice(c, tc)
+
+		resp, err := client.Get(tc.url("/settings"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".session-item").Length(), qt.Equals, 2)
+		c.Assert(doc.Find(".current-session").Length(), qt.Equals, 1)
+		c.Assert(doc.Find("form.revoke-session-form").Length(), qt.Equals, 1)
+	})
+
+	c.Run("revoking a session signs it out", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, otherClient := signInTwice(c, tc)
+		c.Assert(isSignedIn(c, tc, otherClient), qt.IsTrue)
+
+		resp, err := client.Get(tc.url("/settings"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+
+		action, ok := doc.Find("form.revoke-session-form").Attr("action")
+		c.Assert(ok, qt.IsTrue)
+
+		resp, err = tc.postForm(client, action, url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		c.Assert(isSignedIn(c, tc, client), qt.IsTrue)
+		c.Assert(isSignedIn(c, tc, otherClient), qt.IsFalse)
+	})
+
+	c.Run("signing out everywhere", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, otherClient := signInTwice(c, tc)
+
+		resp, err := tc.postForm(client, "/settings/sessions", url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		c.Assert(isSignedIn(c, tc, client), qt.IsFalse)
+		c.Assert(isSignedIn(c, tc, otherClient), qt.IsFalse)
+	})
+
+	c.Run("signing out keeps other sessions", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, otherClient := signInTwice(c, tc)
+
+		resp, err := tc.postForm(client, "/oauth/destroy", nil)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		c.Assert(isSignedIn(c, tc, client), qt.IsFalse)
+		c.Assert(isSignedIn(c, tc, otherClient), qt.IsTrue)
+	})
 }
 
 // TestStoryVoting is brittler than other tests because it doesn't reset the
@@ -525,7 +819,7 @@ func TestStoryVoting(t *testing.T) {
 		c.Assert(ok, qt.IsTrue)
 		c.Assert(action, qt.Not(qt.IsNil))
 
-		resp, err = client.PostForm(tc.url(action), nil)
+		resp, err = tc.postForm(client, action, nil)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 
@@ -574,7 +868,7 @@ func TestStoryVoting(t *testing.T) {
 		c.Assert(ok, qt.IsTrue)
 		c.Assert(action, qt.Not(qt.IsNil))
 
-		resp, err = client.PostForm(tc.url(action), nil)
+		resp, err = tc.postForm(client, action, nil)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 
@@ -597,7 +891,7 @@ func TestStoryVoting(t *testing.T) {
 
 		href, ok := doc.Find("a.voters-inactive").Attr("href")
 		c.Assert(ok, qt.IsTrue, qt.Commentf("cannot find placeholder for unathenticated upvotes"))
-		c.Assert(href, qt.Equals, "/oauth/start")
+		c.Assert(href, qt.Equals, "/login")
 	})
 
 	c.Run("click on the upvote arrow with a different user", func(c *qt.C) {
@@ -616,7 +910,7 @@ func TestStoryVoting(t *testing.T) {
 		c.Assert(ok, qt.IsTrue)
 		c.Assert(action, qt.Not(qt.IsNil))
 
-		resp, err = client.PostForm(tc.url(action), nil)
+		resp, err = tc.postForm(client, action, nil)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 
@@ -697,7 +991,7 @@ func TestCommentsSubmiting(t *testing.T) {
 			"parent-id": []string{""},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 401)
 		defer resp.Body.Close()
@@ -720,7 +1014,7 @@ func TestCommentsSubmiting(t *testing.T) {
 			"parent-id": []string{""},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
@@ -764,7 +1058,7 @@ func TestCommentsSubmiting(t *testing.T) {
 			"parent-id": []string{parentCommentID},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
@@ -792,7 +1086,7 @@ func TestCommentsSubmiting(t *testing.T) {
 			"parent-id": []string{""},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
@@ -853,7 +1147,7 @@ func TestCommentsEditing(t *testing.T) {
 		"parent-id": []string{""},
 	}
 
-	resp, err = client.PostForm(tc.url(action), values)
+	resp, err = tc.postForm(client, action, values)
 	c.Assert(err, qt.IsNil)
 	c.Assert(resp.StatusCode, qt.Equals, 200)
 	defer resp.Body.Close()
@@ -883,7 +1177,7 @@ func TestCommentsEditing(t *testing.T) {
 			"body":    []string{"barbarbar"},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
@@ -1023,7 +1317,7 @@ func TestCommentsVoting(t *testing.T) {
 		c.Assert(ok, qt.IsTrue)
 		c.Assert(action, qt.Not(qt.IsNil))
 
-		resp, err = client.PostForm(tc.url(action), nil)
+		resp, err = tc.postForm(client, action, nil)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 
@@ -1051,7 +1345,7 @@ func TestCommentsVoting(t *testing.T) {
 
 		href, ok := doc.Find("a.voters-inactive").Attr("href")
 		c.Assert(ok, qt.IsTrue, qt.Commentf("cannot find placeholder for unathenticated upvotes"))
-		c.Assert(href, qt.Equals, "/oauth/start")
+		c.Assert(href, qt.Equals, "/login")
 	})
 
 	c.Run("click on the upvote arrow with a different user", func(c *qt.C) {
@@ -1083,7 +1377,7 @@ func TestCommentsVoting(t *testing.T) {
 		c.Assert(ok, qt.IsTrue)
 		c.Assert(action, qt.Not(qt.IsNil))
 
-		resp, err = client.PostForm(tc.url(action), nil)
+		resp, err = tc.postForm(client, action, nil)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 
@@ -1112,7 +1406,7 @@ func TestHooks(t *testing.T) {
 			"title": []string{"Captain Nemo"},
 			"url":   []string{"http://duckduckgo.com"},
 		}
-		resp, err := client.PostForm(tc.url("/submit"), values)
+		resp, err := tc.postForm(client, "/submit", values)
 		c.Assert(err, qt.IsNil)
 		defer resp.Body.Close()
 		c.Assert(resp.StatusCode, qt.Equals, 200)
@@ -1159,11 +1453,1868 @@ func TestHooks(t *testing.T) {
 			"parent-id": []string{""},
 		}
 
-		resp, err = client.PostForm(tc.url(action), values)
+		resp, err = tc.postForm(client, action, values)
 		c.Assert(err, qt.IsNil)
 		c.Assert(resp.StatusCode, qt.Equals, 200)
 		defer resp.Body.Close()
 
 		c.Assert(seen, qt.IsTrue)
 	})
+
+	c.Run("A failing hook doesn't fail the request", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.SynchronousHooks = false
+		// the hook is logged as failed after the test may be done
+		tc.server.Logger = zerolog.Nop()
+		ran := make(chan string, 1)
+		tc.server.AddStoryHook(func(story *tabloid.Story) error {
+			ran <- story.Title
+			return errors.New("slack is down")
+		})
+		tc.prepareServer()
+
+		client := tc.newAuthenticatedClient()
+		values := url.Values{"title": []string{"Captain Nemo"}, "url": []string{"http://duckduckgo.com"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		select {
+		case title := <-ran:
+			c.Assert(title, qt.Equals, "Captain Nemo")
+		case <-time.After(5 * time.Second):
+			c.Fatal("the hook didn't run")
+		}
+	})
+}
+
+func TestAPITokens(t *testing.T) {
+	c := qt.New(t)
+
+	// redirects aren't followed, so we can check what the token request itself returns
+	noRedirectClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	c.Run("submitting a story with a token", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "submit")
+
+		values := url.Values{
+			"title": []string{"Posted from CI"},
+			"url":   []string{"http://duckduckgo.com"},
+		}
+		resp, err := noRedirectClient.Do(tc.newTokenRequest("POST", "/submit", values, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 302)
+
+		var author string
+		err = tc.pgStore.DB().Get(&author, "SELECT users.name FROM stories JOIN users ON users.id = stories.author_id")
+		c.Assert(err, qt.IsNil)
+		c.Assert(author, qt.Equals, "fakeLogin1")
+	})
+
+	c.Run("token without the required scope is forbidden", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read", "vote")
+
+		values := url.Values{
+			"title": []string{"Posted from CI"},
+			"url":   []string{"http://duckduckgo.com"},
+		}
+		resp, err := noRedirectClient.Do(tc.newTokenRequest("POST", "/submit", values, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+	})
+
+	c.Run("unknown token is unauthorized", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		values := url.Values{
+			"title": []string{"Posted from CI"},
+			"url":   []string{"http://duckduckgo.com"},
+		}
+		resp, err := noRedirectClient.Do(tc.newTokenRequest("POST", "/submit", values, "tabloid_nope"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+
+	c.Run("revoked token is unauthorized", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+		secret := tc.createAPIToken(client, "submit")
+
+		resp, err := client.Get(tc.url("/settings"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+
+		action, ok := doc.Find("form.revoke-token-form").First().Attr("action")
+		c.Assert(ok, qt.IsTrue)
+
+		resp, err = tc.postForm(client, action, url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		values := url.Values{
+			"title": []string{"Posted from CI"},
+			"url":   []string{"http://duckduckgo.com"},
+		}
+		resp, err = noRedirectClient.Do(tc.newTokenRequest("POST", "/submit", values, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+
+	c.Run("tokens can't manage tokens", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read", "submit", "comment", "vote")
+
+		resp, err := noRedirectClient.Do(tc.newTokenRequest("GET", "/settings", nil, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	c := qt.New(t)
+
+	// newAdminClient returns a client signed in as a freshly created admin.
+	newAdminClient := func(c *qt.C, tc *testContext) *http.Client {
+		adminID, err := tc.createUser("admin")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(adminID, tabloid.RoleAdmin), qt.IsNil)
+		return tc.newSessionClient(adminID)
+	}
+
+	c.Run("members can't reach the admin area", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/admin/users"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		resp, err = tc.postForm(client, "/admin/settings", url.Values{"_method": []string{"PUT"}, "name": []string{"Pwned"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+	})
+
+	c.Run("admins see the users", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := newAdminClient(c, tc)
+		_, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+
+		resp, err := client.Get(tc.url("/admin/users?q=bob"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("#session-admin").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".user-item").Length(), qt.Equals, 1)
+		c.Assert(strings.TrimSpace(doc.Find(".user-item .user-name").Text()), qt.Equals, "bob")
+	})
+
+	c.Run("banning a user signs them out", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := newAdminClient(c, tc)
+		bobID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		bobClient := tc.newSessionClient(bobID)
+
+		resp, err := tc.postForm(client, "/admin/users/"+bobID+"/ban", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		user, err := tc.pgStore.FindUserByID(bobID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(user.IsBanned(), qt.IsTrue)
+
+		resp, err = bobClient.Get(tc.url("/settings"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+
+	c.Run("removing a story hides it", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := newAdminClient(c, tc)
+		bobID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Foobar", "Foobaring", bobID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		resp, err := tc.postForm(client, "/admin/stories/"+story.ID, url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = client.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("updating the site settings", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client := newAdminClient(c, tc)
+
+		values := url.Values{
+			"_method":      []string{"PUT"},
+			"name":         []string{"Newsroom"},
+			"description":  []string{"All the news"},
+			"announcement": []string{"Maintenance tonight"},
+		}
+		resp, err := tc.postForm(client, "/admin/settings", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = client.Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("title").Text(), qt.Contains, "Newsroom")
+		c.Assert(strings.TrimSpace(doc.Find("#announcement").Text()), qt.Equals, "Maintenance tonight")
+	})
+}
+
+func TestFlags(t *testing.T) {
+	c := qt.New(t)
+
+	// setup creates a story written by someone else than the authenticated client.
+	setup := func(c *qt.C, tc *testContext) (*http.Client, *tabloid.Story) {
+		authorID, err := tc.createUser("author")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		return tc.newAuthenticatedClient(), story
+	}
+
+	c.Run("content members can't see can't be flagged", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, story := setup(c, tc)
+
+		held := tabloid.NewStory("Held", "", story.AuthorID, "http://held.com")
+		held.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+		c.Assert(tc.pgStore.InsertStory(held), qt.IsNil)
+
+		shadowedID, err := tc.createUser("shadowed")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.ShadowBanUser(shadowedID), qt.IsNil)
+		shadowed := tabloid.NewStory("Shadowed", "", shadowedID, "http://shadowed.com")
+		c.Assert(tc.pgStore.InsertStory(shadowed), qt.IsNil)
+
+		removed := tabloid.NewComment(story.ID, sql.NullString{}, "removed", story.AuthorID)
+		c.Assert(tc.pgStore.InsertComment(removed), qt.IsNil)
+		c.Assert(tc.pgStore.RemoveComment(removed.ID), qt.IsNil)
+		heldComment := tabloid.NewComment(story.ID, sql.NullString{}, "held", story.AuthorID)
+		heldComment.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+		c.Assert(tc.pgStore.InsertComment(heldComment), qt.IsNil)
+
+		for _, path := range []string{
+			"/stories/" + held.ID + "/flags",
+			"/stories/" + shadowed.ID + "/flags",
+			"/story/" + story.ID + "/comments/" + removed.ID + "/flags",
+			"/story/" + story.ID + "/comments/" + heldComment.ID + "/flags",
+		} {
+			resp, err := tc.postForm(client, path+"?redir=/", url.Values{"reason": []string{"spam"}})
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, 404, qt.Commentf(path))
+		}
+
+		flagged, err := tc.pgStore.ListFlaggedContent(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(flagged, qt.HasLen, 0)
+	})
+
+	c.Run("members can flag stories, moderators review them", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, story := setup(c, tc)
+
+		resp, err := client.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+
+		action, ok := doc.Find("form.flag-form").First().Attr("action")
+		c.Assert(ok, qt.IsTrue)
+
+		resp, err = tc.postForm(client, action, url.Values{"reason": []string{"spam"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = client.Get(tc.url("/moderation/flags"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+		moderatorClient := tc.newSessionClient(moderatorID)
+
+		resp, err = moderatorClient.Get(tc.url("/moderation/flags"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".flagged-item").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".flagged-item .flags-count").Text(), qt.Equals, "1")
+
+		values := url.Values{"story_id": []string{story.ID}, "comment_id": []string{""}}
+		resp, err = tc.postForm(moderatorClient, "/moderation/flags/approve", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = client.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("hidden stories are only reachable by their author and moderators", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.FlagThreshold = 1
+		tc.prepareServer()
+		client, story := setup(c, tc)
+
+		resp, err := tc.postForm(client, "/stories/"+story.ID+"/flags?redir=/", url.Values{"reason": []string{"spam"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+
+		for _, reader := range []struct {
+			client *http.Client
+			path   string
+			status int
+		}{
+			{client, "/stories/" + story.ID + "/comments", 404},
+			{tc.newHTTPClient(), "/stories/" + story.ID + "/comments", 404},
+			{tc.newHTTPClient(), "/api/v1/stories/" + story.ID, 404},
+			{tc.newHTTPClient(), "/stories/" + story.ID + "/comments/rss", 404},
+			{tc.newSessionClient(story.AuthorID), "/stories/" + story.ID + "/comments", 200},
+			{tc.newSessionClient(moderatorID), "/stories/" + story.ID + "/comments", 200},
+		} {
+			resp, err = reader.client.Get(tc.url(reader.path))
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, reader.status, qt.Commentf(reader.path))
+		}
+	})
+
+	c.Run("invalid reason", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		client, story := setup(c, tc)
+
+		resp, err := tc.postForm(client, "/stories/"+story.ID+"/flags?redir=/", url.Values{"reason": []string{"boring"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+	})
+}
+
+func TestUserRestrictions(t *testing.T) {
+	c := qt.New(t)
+
+	submit := func(c *qt.C, tc *testContext, client *http.Client) *http.Response {
+		values := url.Values{"title": []string{"Foobar"}, "url": []string{"http://foobar.com"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		return resp
+	}
+
+	c.Run("banned users are signed out", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		id, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		client := tc.newSessionClient(id)
+
+		c.Assert(tc.pgStore.BanUser(id), qt.IsNil)
+
+		resp := submit(c, tc, client)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+
+	c.Run("suspended users can read but not post", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		id, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		client := tc.newSessionClient(id)
+
+		c.Assert(tc.pgStore.SuspendUser(id, tabloid.NowFunc().Add(time.Hour), "Too much spam"), qt.IsNil)
+
+		resp, err := client.Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("#suspension").Text(), qt.Contains, "Too much spam")
+
+		resp = submit(c, tc, client)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Contains, "Too much spam")
+	})
+
+	c.Run("shadow-banned users only see their own stories", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		id, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		client := tc.newSessionClient(id)
+		otherClient := tc.newAuthenticatedClient()
+
+		c.Assert(tc.pgStore.ShadowBanUser(id), qt.IsNil)
+
+		resp := submit(c, tc, client)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		storyPath := resp.Request.URL.Path
+
+		countStories := func(client *http.Client) int {
+			resp, err := client.Get(tc.url("/"))
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+
+			doc, err := goquery.NewDocumentFromReader(resp.Body)
+			c.Assert(err, qt.IsNil)
+			return doc.Find(".story-item").Length()
+		}
+
+		c.Assert(countStories(client), qt.Equals, 1)
+		c.Assert(countStories(otherClient), qt.Equals, 0)
+		c.Assert(countStories(tc.newHTTPClient()), qt.Equals, 0)
+
+		resp, err = otherClient.Get(tc.url(storyPath))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("votes of shadow-banned users don't change scores", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		voterID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.ShadowBanUser(voterID), qt.IsNil)
+		before, err := tc.pgStore.FindStory(story.ID)
+		c.Assert(err, qt.IsNil)
+
+		resp, err := tc.postForm(tc.newSessionClient(voterID), "/stories/"+story.ID+"/votes?redir=/", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		found, err := tc.pgStore.FindStory(story.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Score, qt.Equals, before.Score)
+		author, err := tc.pgStore.FindUserByID(authorID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(author.Karma, qt.Equals, 0)
+	})
+
+	c.Run("flags of shadow-banned users don't hide anything", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.FlagThreshold = 1
+		tc.prepareServer()
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		flaggerID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.ShadowBanUser(flaggerID), qt.IsNil)
+
+		resp, err := tc.postForm(tc.newSessionClient(flaggerID), "/stories/"+story.ID+"/flags?redir=/", url.Values{"reason": []string{"spam"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		found, err := tc.pgStore.FindStory(story.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.IsHidden(), qt.IsFalse)
+		flagged, err := tc.pgStore.ListFlaggedContent(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(flagged, qt.HasLen, 0)
+	})
+}
+
+func TestLockingAndPinning(t *testing.T) {
+	c := qt.New(t)
+
+	// setup creates a story and returns a client signed in as a moderator.
+	setup := func(c *qt.C, tc *testContext) (*http.Client, *tabloid.Story) {
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+
+		story := tabloid.NewStory("Foobar", "Foobaring", moderatorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		return tc.newSessionClient(moderatorID), story
+	}
+
+	c.Run("locked stories reject comments", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		moderatorClient, story := setup(c, tc)
+		client := tc.newAuthenticatedClient()
+
+		resp, err := tc.postForm(moderatorClient, "/moderation/stories/"+story.ID+"/lock", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("#locked-notice").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".new-comment-form").Length(), qt.Equals, 0)
+
+		resp, err = tc.postForm(client, "/stories/"+story.ID+"/comments", url.Values{"body": []string{"Flame on"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		resp, err = tc.postForm(client, "/moderation/stories/"+story.ID+"/lock", url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+	})
+
+	c.Run("pinned stories come first", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		moderatorClient, story := setup(c, tc)
+
+		// push the story out of the first page
+		for i := 0; i < 3; i++ {
+			other := tabloid.NewStory("Other "+strconv.Itoa(i), "", story.AuthorID, "http://foobar.com")
+			c.Assert(tc.pgStore.InsertStory(other), qt.IsNil)
+		}
+		tc.pgStore.DB().MustExec("UPDATE stories SET created_at = $1 WHERE id = $2", tabloid.NowFunc().Add(-72*time.Hour), story.ID)
+
+		resp, err := tc.postForm(moderatorClient, "/moderation/stories/"+story.ID+"/pin", url.Values{"_method": []string{"PUT"}, "days": []string{"1"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = tc.newHTTPClient().Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		first := doc.Find(".story-item").First()
+		c.Assert(first.AttrOr("id", ""), qt.Equals, "story-"+story.ID)
+		c.Assert(first.Find(".story-pinned").Length(), qt.Equals, 1)
+	})
+}
+
+func TestModerationLog(t *testing.T) {
+	c := qt.New(t)
+
+	// logEntries returns the text of the entries of the moderation log, as seen by the given client.
+	logEntries := func(c *qt.C, tc *testContext, client *http.Client) []string {
+		resp, err := client.Get(tc.url("/moderation/log"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		entries := []string{}
+		doc.Find(".moderation-log-entry").Each(func(_ int, s *goquery.Selection) {
+			entries = append(entries, strings.Join(strings.Fields(s.Text()), " "))
+		})
+		return entries
+	}
+
+	c.Run("moderation actions are logged publicly", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+		adminID, err := tc.createUser("admin")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(adminID, tabloid.RoleAdmin), qt.IsNil)
+		adminClient := tc.newSessionClient(adminID)
+		bobID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Foobar", "Foobaring", bobID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		resp, err := tc.postForm(adminClient, "/admin/stories/"+story.ID, url.Values{"_method": []string{"DELETE"}, "reason": []string{"Spam"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = tc.postForm(adminClient, "/admin/users/"+bobID+"/shadow_ban", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		entries := logEntries(c, tc, tc.newHTTPClient())
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0], qt.Contains, "admin removed story Foobar")
+		c.Assert(entries[0], qt.Contains, "Spam")
+
+		// shadow-bans are only visible to moderators
+		entries = logEntries(c, tc, adminClient)
+		c.Assert(entries, qt.HasLen, 2)
+		c.Assert(entries[0], qt.Contains, "admin shadow-banned user bob")
+	})
+
+	c.Run("hooks can write entries", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.server.AddStoryHook(func(story *tabloid.Story) error {
+			return tc.server.LogModeration(tabloid.NewModerationLogEntry("", tabloid.ModerationLockStory, "Auto-locked").OnStory(story.ID))
+		})
+		tc.prepareServer()
+
+		client := tc.newAuthenticatedClient()
+		values := url.Values{"title": []string{"Captain Nemo"}, "url": []string{"http://duckduckgo.com"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		entries := logEntries(c, tc, client)
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0], qt.Contains, "automated locked story Captain Nemo")
+	})
+}
+
+func TestRateLimits(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("submitting too many stories", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.RateLimits = map[tabloid.RateLimitAction]tabloid.RateLimit{
+			tabloid.RateLimitSubmit: {Count: 20, Period: 24 * time.Hour, NewAccountCount: 2, NewAccountAge: 24 * time.Hour},
+		}
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		submit := func() *http.Response {
+			values := url.Values{"title": []string{"Foobar"}, "url": []string{"http://foobar.com"}}
+			resp, err := tc.postForm(client, "/submit", values)
+			c.Assert(err, qt.IsNil)
+			return resp
+		}
+
+		for i := 0; i < 2; i++ {
+			resp := submit()
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, 200)
+		}
+
+		resp := submit()
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 429)
+		c.Assert(resp.Header.Get("Retry-After"), qt.Not(qt.Equals), "")
+	})
+}
+
+func TestProbation(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("downvoting requires karma", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.MinKarmaToDownvote = 10
+		tc.prepareServer()
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		voterID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		client := tc.newSessionClient(voterID)
+
+		downvote := func() *http.Response {
+			resp, err := tc.postForm(client, "/stories/"+story.ID+"/votes", url.Values{"up": []string{"false"}})
+			c.Assert(err, qt.IsNil)
+			return resp
+		}
+
+		resp := downvote()
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+
+		tc.pgStore.DB().MustExec("UPDATE users SET karma = 10 WHERE id = $1", voterID)
+
+		resp = downvote()
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		found, err := tc.pgStore.FindStory(story.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Score, qt.Equals, int64(0))
+	})
+
+	c.Run("stories from new accounts are held", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.HoldSubmissionsInDays = 2
+		tc.config.NewUserInDays = 7
+		storiesSeen := 0
+		tc.server.AddStoryHook(func(story *tabloid.Story) error {
+			if story.IsHeld() {
+				return errors.New("hooks ran on a held story")
+			}
+			storiesSeen++
+			return nil
+		})
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		values := url.Values{"title": []string{"Foobar"}, "url": []string{"http://foobar.com"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("#held-notice").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".story-new-user").Length(), qt.Equals, 1)
+		c.Assert(storiesSeen, qt.Equals, 0)
+
+		stories, err := tc.pgStore.ListHeldStories(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stories, qt.HasLen, 1)
+
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+		moderatorClient := tc.newSessionClient(moderatorID)
+		bobID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		bobClient := tc.newSessionClient(bobID)
+
+		// until released, only its author and moderators can reach it
+		storyPath := "/stories/" + stories[0].ID + "/comments"
+		for _, reader := range []struct {
+			client *http.Client
+			status int
+		}{{tc.newHTTPClient(), 404}, {bobClient, 404}, {client, 200}, {moderatorClient, 200}} {
+			resp, err = reader.client.Get(tc.url(storyPath))
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, reader.status)
+		}
+
+		resp, err = http.Get(tc.url("/api/v1/stories/" + stories[0].ID))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+
+		resp, err = tc.postForm(bobClient, storyPath, url.Values{"body": []string{"Sneaky"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+
+		resp, err = tc.postForm(bobClient, "/stories/"+stories[0].ID+"/votes?redir=/", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+
+		resp, err = tc.postForm(moderatorClient, "/moderation/stories/"+stories[0].ID+"/release", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(storiesSeen, qt.Equals, 1)
+
+		resp, err = tc.newHTTPClient().Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".story-item").Length(), qt.Equals, 1)
+	})
+
+	c.Run("releasing the story of a shadow-banned user doesn't run the hooks", func(c *qt.C) {
+		tc := newTestContext(c)
+		storiesSeen := 0
+		tc.server.AddStoryHook(func(story *tabloid.Story) error {
+			storiesSeen++
+			return nil
+		})
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.ShadowBanUser(authorID), qt.IsNil)
+		story := tabloid.NewStory("Foobar", "", authorID, "http://foobar.com")
+		story.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+
+		resp, err := tc.postForm(tc.newSessionClient(moderatorID), "/moderation/stories/"+story.ID+"/release", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(storiesSeen, qt.Equals, 0)
+	})
+
+	c.Run("comments of held stories can't be voted on through another story", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		held := tabloid.NewStory("Foobar", "", authorID, "http://foobar.com")
+		held.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+		c.Assert(tc.pgStore.InsertStory(held), qt.IsNil)
+		comment := tabloid.NewComment(held.ID, sql.NullString{}, "kudos", authorID)
+		c.Assert(tc.pgStore.InsertComment(comment), qt.IsNil)
+		visible := tabloid.NewStory("Barfoo", "", authorID, "http://barfoo.com")
+		c.Assert(tc.pgStore.InsertStory(visible), qt.IsNil)
+
+		resp, err := tc.postForm(tc.newAuthenticatedClient(), "/story/"+visible.ID+"/comments/"+comment.ID+"/votes?redir=/", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+}
+
+func TestFilters(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("rejected stories aren't stored", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.server.AddFilter(tabloid.BannedDomains{"spam.com"})
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		values := url.Values{"title": []string{"Deals"}, "url": []string{"http://www.spam.com"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(body), qt.Contains, "This domain is banned.")
+
+		stories, err := tc.pgStore.ListStories(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stories, qt.HasLen, 0)
+	})
+
+	c.Run("held comments wait for a moderator", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.server.AddFilter(tabloid.LinkLimit(1))
+		commentsSeen := 0
+		tc.server.AddCommentHook(func(story *tabloid.Story, comment *tabloid.Comment) error {
+			commentsSeen++
+			return nil
+		})
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		client := tc.newAuthenticatedClient()
+		values := url.Values{"body": []string{"http://a.com http://b.com"}}
+		resp, err := tc.postForm(client, "/stories/"+story.ID+"/comments", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(commentsSeen, qt.Equals, 0)
+
+		comments, err := tc.pgStore.ListHeldComments(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(comments, qt.HasLen, 1)
+
+		moderatorID, err := tc.createUser("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(moderatorID, tabloid.RoleModerator), qt.IsNil)
+		moderatorClient := tc.newSessionClient(moderatorID)
+
+		resp, err = tc.postForm(moderatorClient, "/moderation/comments/"+comments[0].ID+"/release", url.Values{})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(commentsSeen, qt.Equals, 1)
+
+		comments, err = tc.pgStore.ListHeldComments(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(comments, qt.HasLen, 0)
+	})
+}
+
+func TestDuplicateURLs(t *testing.T) {
+	c := qt.New(t)
+
+	// redirects aren't followed, so we can check where the submission leads
+	noRedirect := func(client *http.Client) *http.Client {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		return client
+	}
+
+	c.Run("recent duplicates redirect to the existing discussion", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.RepostWindowInDays = 30
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		client := noRedirect(tc.newAuthenticatedClient())
+		values := url.Values{"title": []string{"Foobar again"}, "url": []string{"http://www.foobar.com/a/?utm_source=x"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 302)
+		c.Assert(resp.Header.Get("Location"), qt.Equals, "/stories/"+story.ID+"/comments")
+
+		var count int
+		c.Assert(tc.pgStore.DB().Get(&count, "SELECT COUNT(*) FROM stories"), qt.IsNil)
+		c.Assert(count, qt.Equals, 1)
+	})
+
+	c.Run("older duplicates can be reposted once confirmed", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.RepostWindowInDays = 30
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		tc.pgStore.DB().MustExec("UPDATE stories SET created_at = $1", tabloid.NowFunc().Add(-60*24*time.Hour))
+
+		client := tc.newAuthenticatedClient()
+		values := url.Values{"title": []string{"Foobar again"}, "url": []string{"https://foobar.com/a"}}
+		resp, err := tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find("#duplicate-notice").Text(), qt.Contains, "60 days ago")
+		repost, ok := doc.Find("input[name=repost]").Attr("value")
+		c.Assert(ok, qt.IsTrue)
+
+		values.Set("repost", repost)
+		resp, err = tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		var count int
+		c.Assert(tc.pgStore.DB().Get(&count, "SELECT COUNT(*) FROM stories"), qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+	})
+}
+
+func TestDomains(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("stories link to their domain listing", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.InsertStory(tabloid.NewStory("Foo", "", authorID, "https://www.foobar.com/a")), qt.IsNil)
+		c.Assert(tc.pgStore.InsertStory(tabloid.NewStory("Bar", "", authorID, "https://other.com/b")), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		href, ok := doc.Find(".story-domain").First().Attr("href")
+		c.Assert(ok, qt.IsTrue)
+
+		resp, err = http.Get(tc.url(href))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".story-item").Length(), qt.Equals, 1)
+	})
+
+	c.Run("admins can ban domains", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		adminID, err := tc.createUser("admin")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(adminID, tabloid.RoleAdmin), qt.IsNil)
+		client := tc.newSessionClient(adminID)
+
+		values := url.Values{
+			"_method":          []string{"PUT"},
+			"name":             []string{"Newsroom"},
+			"banned_domains":   []string{"spam.com"},
+			"domain_penalties": []string{"foobar.com 0.5"},
+		}
+		resp, err := tc.postForm(client, "/admin/settings", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		settings, err := tc.pgStore.FindSiteSettings()
+		c.Assert(err, qt.IsNil)
+		c.Assert(settings.DomainPenalties, qt.DeepEquals, map[string]float64{"foobar.com": 0.5})
+
+		values = url.Values{"title": []string{"Deals"}, "url": []string{"http://deals.spam.com"}}
+		resp, err = tc.postForm(client, "/submit", values)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+	})
+}
+
+func TestLinkMetadata(t *testing.T) {
+	c := qt.New(t)
+
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Foobar</title><meta property="og:description" content="All about foobar"></head></html>`))
+	}))
+	defer standIn.Close()
+
+	c.Run("fetching the title of a link", func(c *qt.C) {
+		tc := newTestContext(c)
+		// the stand-in server listens on a loopback address
+		fetcher := tabloid.NewMetadataFetcher()
+		fetcher.AllowPrivateAddresses = true
+		tc.server.SetMetadataFetcher(fetcher)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/submit/fetch-title?url=" + url.QueryEscape(standIn.URL+"/a")))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		var metadata map[string]string
+		c.Assert(json.NewDecoder(resp.Body).Decode(&metadata), qt.IsNil)
+		c.Assert(metadata["title"], qt.Equals, "Foobar")
+		c.Assert(metadata["description"], qt.Equals, "All about foobar")
+
+		cached, err := tc.pgStore.FindLinkMetadata(tabloid.CanonicalURL(standIn.URL + "/a"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(cached.Title, qt.Equals, "Foobar")
+	})
+
+	c.Run("private addresses are refused", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.server.SetMetadataFetcher(tabloid.NewMetadataFetcher())
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/submit/fetch-title?url=" + url.QueryEscape(standIn.URL)))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+	})
+
+	c.Run("fetching titles is rate limited", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.RateLimits = map[tabloid.RateLimitAction]tabloid.RateLimit{
+			tabloid.RateLimitFetchTitle: {Count: 1, Period: time.Hour},
+		}
+		fetcher := tabloid.NewMetadataFetcher()
+		fetcher.AllowPrivateAddresses = true
+		tc.server.SetMetadataFetcher(fetcher)
+		tc.prepareServer()
+		client := tc.newAuthenticatedClient()
+
+		resp, err := client.Get(tc.url("/submit/fetch-title?url=" + url.QueryEscape(standIn.URL+"/a")))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = client.Get(tc.url("/submit/fetch-title?url=" + url.QueryEscape(standIn.URL+"/b")))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 429)
+		c.Assert(resp.Header.Get("Retry-After"), qt.Not(qt.Equals), "")
+	})
+
+	c.Run("anonymous users can't fetch titles", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.server.SetMetadataFetcher(tabloid.NewMetadataFetcher())
+		tc.prepareServer()
+
+		resp, err := http.Get(tc.url("/submit/fetch-title?url=" + url.QueryEscape(standIn.URL)))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 401)
+	})
+}
+
+func TestLinkPreviews(t *testing.T) {
+	c := qt.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/thumbnail.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	standIn := httptest.NewServer(mux)
+	defer standIn.Close()
+
+	c.Run("story pages show a preview of the link", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.LinkPreviews = true
+		// the stand-in server listens on a loopback address
+		fetcher := tabloid.NewMetadataFetcher()
+		fetcher.AllowPrivateAddresses = true
+		tc.server.SetMetadataFetcher(fetcher)
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foo", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.UpsertLinkMetadata(&tabloid.LinkMetadata{
+			CanonicalURL: story.CanonicalURL,
+			URL:          story.URL,
+			SiteName:     "Foobar",
+			Description:  "All about foobar",
+			ImageURL:     standIn.URL + "/thumbnail.png",
+			FetchedAt:    tabloid.NowFunc(),
+		}), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".link-preview-site").Text(), qt.Equals, "Foobar")
+		c.Assert(doc.Find(".link-preview-description").Text(), qt.Equals, "All about foobar")
+		src, ok := doc.Find(".link-preview-image").Attr("src")
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(strings.HasPrefix(src, "/"), qt.IsTrue)
+
+		resp, err = http.Get(tc.url(src))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "image/png")
+	})
+
+	c.Run("previews can be disabled", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.LinkPreviews = false
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foo", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.UpsertLinkMetadata(&tabloid.LinkMetadata{
+			CanonicalURL: story.CanonicalURL,
+			URL:          story.URL,
+			Description:  "All about foobar",
+			FetchedAt:    tabloid.NowFunc(),
+		}), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".link-preview").Length(), qt.Equals, 0)
+
+		resp, err = http.Get(tc.url("/stories/" + story.ID + "/preview-image"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+}
+
+func TestArchives(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("archived links can be read", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.ArchiveLinks = true
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foo", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.InsertArchive(&tabloid.Archive{
+			CanonicalURL: story.CanonicalURL,
+			URL:          story.URL,
+			Title:        "Foobar",
+			Text:         "First\n\nSecond",
+			FetchedAt:    tabloid.NowFunc(),
+		}), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/comments"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		href, ok := doc.Find(".story-archive").Attr("href")
+		c.Assert(ok, qt.IsTrue)
+
+		resp, err = http.Get(tc.url(href))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".archive-title").Text(), qt.Equals, "Foobar")
+		c.Assert(doc.Find(".archive-text p").Length(), qt.Equals, 2)
+	})
+
+	c.Run("excluded domains aren't shown", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.ArchiveLinks = true
+		tc.config.ArchiveExcludedDomains = []string{"foobar.com"}
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foo", "", authorID, "https://news.foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.InsertArchive(&tabloid.Archive{
+			CanonicalURL: story.CanonicalURL,
+			URL:          story.URL,
+			Text:         "Opted out",
+			FetchedAt:    tabloid.NowFunc(),
+		}), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/archive"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("stories without an archive", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.config.ArchiveLinks = true
+		tc.prepareServer()
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foo", "", authorID, "https://foobar.com/a")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/archive"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+}
+
+func TestSearch(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("searching stories and comments", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		aliceID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		bobID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Compilers are fun", "", aliceID, "https://compilers.com/fun")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.InsertStory(tabloid.NewStory("Gardening", "Tomatoes", bobID, "")), qt.IsNil)
+		c.Assert(tc.pgStore.InsertComment(tabloid.NewComment(story.ID, sql.NullString{}, "I wrote a compiler once", bobID)), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/search?q=compilers"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".search-result").Length(), qt.Equals, 2)
+		c.Assert(doc.Find(".search-result-story").First().Text(), qt.Equals, "Compilers are fun")
+
+		resp, err = http.Get(tc.url("/search?q=compilers&type=comments&author=bob"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".search-result").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".search-result-comment").Text(), qt.Equals, "I wrote a compiler once")
+	})
+
+	c.Run("invalid filters", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		resp, err := http.Get(tc.url("/search?q=compilers&since=yesterday"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+	})
+}
+
+func TestAPI(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("reading stories and comments", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		aliceID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Compilers are fun", "", aliceID, "https://compilers.com/fun")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "I wrote one", aliceID)
+		c.Assert(tc.pgStore.InsertComment(comment), qt.IsNil)
+		reply := tabloid.NewComment(story.ID, sql.NullString{String: comment.ID, Valid: true}, "Me too", aliceID)
+		c.Assert(tc.pgStore.InsertComment(reply), qt.IsNil)
+
+		resp, err := http.Get(tc.url("/api/v1/stories"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+
+		var list struct {
+			Stories []struct {
+				ID    string `json:"id"`
+				Title string `json:"title"`
+			} `json:"stories"`
+			NextPage *int `json:"next_page"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&list), qt.IsNil)
+		c.Assert(list.Stories, qt.HasLen, 1)
+		c.Assert(list.Stories[0].Title, qt.Equals, "Compilers are fun")
+		c.Assert(list.NextPage, qt.IsNil)
+
+		resp, err = http.Get(tc.url("/api/v1/stories/" + story.ID))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		var show struct {
+			Title    string `json:"title"`
+			Comments []struct {
+				ID      string `json:"id"`
+				Replies []struct {
+					ID string `json:"id"`
+				} `json:"replies"`
+			} `json:"comments"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&show), qt.IsNil)
+		c.Assert(show.Comments, qt.HasLen, 1)
+		c.Assert(show.Comments[0].ID, qt.Equals, comment.ID)
+		c.Assert(show.Comments[0].Replies, qt.HasLen, 1)
+		c.Assert(show.Comments[0].Replies[0].ID, qt.Equals, reply.ID)
+
+		resp, err = http.Get(tc.url("/api/v1/users/alice"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		resp, err = http.Get(tc.url("/api/v1/stories/not-a-story"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	})
+
+	c.Run("submitting, commenting and voting with a token", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read", "submit", "comment", "vote")
+
+		resp, err := http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories", `{"title":"","url":"https://example.com"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+
+		var apiErr struct {
+			Error struct {
+				Status int      `json:"status"`
+				Fields []string `json:"fields"`
+			} `json:"error"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&apiErr), qt.IsNil)
+		c.Assert(apiErr.Error.Status, qt.Equals, 422)
+		c.Assert(apiErr.Error.Fields, qt.DeepEquals, []string{"title"})
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories", `{"title":"Example","url":"https://example.com"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 201)
+
+		var story struct {
+			ID string `json:"id"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&story), qt.IsNil)
+		c.Assert(resp.Header.Get("Location"), qt.Equals, "/api/v1/stories/"+story.ID)
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories", `{"title":"Example again","url":"https://example.com/"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 409)
+		c.Assert(resp.Header.Get("Location"), qt.Equals, "/stories/"+story.ID+"/comments")
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories/"+story.ID+"/comments", `{"body":"First"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 201)
+
+		var comment struct {
+			ID string `json:"id"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&comment), qt.IsNil)
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("PUT", "/api/v1/comments/"+comment.ID, `{"body":"First!"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		updated, err := tc.pgStore.FindComment(comment.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(updated.Body, qt.Equals, "First!")
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/comments/"+comment.ID+"/votes", `{"up":false}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 204)
+
+		resp, err = http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories/"+story.ID+"/votes", `{}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 422)
+	})
+
+	c.Run("through the client", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		ctx := context.Background()
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read", "submit", "comment")
+		api := client.New(tc.testServer.URL, secret)
+
+		story, err := api.SubmitStory(ctx, &client.NewStory{Title: "Example", URL: "https://example.com"})
+		c.Assert(err, qt.IsNil)
+
+		comment, err := api.SubmitComment(ctx, story.ID, "", "First")
+		c.Assert(err, qt.IsNil)
+		_, err = api.SubmitComment(ctx, story.ID, comment.ID, "Second")
+		c.Assert(err, qt.IsNil)
+
+		found, err := api.Story(ctx, story.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Title, qt.Equals, "Example")
+		c.Assert(found.Comments, qt.HasLen, 1)
+		c.Assert(found.Comments[0].Replies, qt.HasLen, 1)
+
+		_, err = api.SubmitStory(ctx, &client.NewStory{Title: "Example again", URL: "https://example.com"})
+		c.Assert(err, qt.Not(qt.IsNil))
+		c.Assert(err.(*client.Error).Status, qt.Equals, 409)
+
+		_, err = api.Story(ctx, "not-a-story")
+		c.Assert(client.IsNotFound(err), qt.IsTrue)
+
+		resp, err := http.Get(tc.url("/api/v1/openapi.json"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	})
+
+	c.Run("writing needs the right scope", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read")
+
+		resp, err := http.DefaultClient.Do(tc.newAPIRequest("POST", "/api/v1/stories", `{"title":"Example","url":"https://example.com"}`, secret))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	})
+}
+
+func TestFeeds(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("stories and comments", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		aliceID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Compilers are fun", "", aliceID, "https://compilers.com/fun")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		c.Assert(tc.pgStore.InsertComment(tabloid.NewComment(story.ID, sql.NullString{}, "I wrote one", aliceID)), qt.IsNil)
+
+		for _, path := range []string{"/rss", "/newest/atom", "/users/alice/feed.json", "/domain/compilers.com/rss", "/stories/" + story.ID + "/comments/rss"} {
+			resp, err := http.Get(tc.url(path))
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, 200, qt.Commentf(path))
+			c.Assert(resp.Header.Get("ETag"), qt.Not(qt.Equals), "")
+		}
+
+		resp, err := http.Get(tc.url("/stories/" + story.ID + "/comments/feed.json"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		var feed struct {
+			Items []struct {
+				ContentHTML string `json:"content_html"`
+			} `json:"items"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&feed), qt.IsNil)
+		c.Assert(feed.Items, qt.HasLen, 1)
+		c.Assert(feed.Items[0].ContentHTML, qt.Contains, "I wrote one")
+
+		resp, err = http.Get(tc.url("/rss"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		req, err := http.NewRequest("GET", tc.url("/rss"), nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		resp, err = http.DefaultClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusNotModified)
+
+		resp, err = http.Get(tc.url("/users/nobody/rss"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 404)
+	})
+
+	c.Run("feeds reordered by votes aren't reported unchanged", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		aliceID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		older := tabloid.NewStory("Compilers are fun", "", aliceID, "https://compilers.com/fun")
+		older.CreatedAt = older.CreatedAt.Add(-time.Hour)
+		c.Assert(tc.pgStore.InsertStory(older), qt.IsNil)
+		newer := tabloid.NewStory("Parsers are fun", "", aliceID, "https://parsers.com/fun")
+		c.Assert(tc.pgStore.InsertStory(newer), qt.IsNil)
+
+		var voterIDs []string
+		for _, login := range []string{"bob", "carol", "dave"} {
+			id, err := tc.createUser(login)
+			c.Assert(err, qt.IsNil)
+			voterIDs = append(voterIDs, id)
+		}
+		_, err = tc.pgStore.CreateOrUpdateVoteOnStory(newer.ID, voterIDs[0], true)
+		c.Assert(err, qt.IsNil)
+
+		resp, err := http.Get(tc.url("/rss"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		lastModified := resp.Header.Get("Last-Modified")
+		c.Assert(lastModified, qt.Not(qt.Equals), "")
+
+		req, err := http.NewRequest("GET", tc.url("/rss"), nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("If-Modified-Since", lastModified)
+		resp, err = http.DefaultClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusNotModified)
+
+		// Last-Modified has a precision of a second
+		time.Sleep(time.Second)
+		for _, id := range voterIDs {
+			_, err = tc.pgStore.CreateOrUpdateVoteOnStory(older.ID, id, true)
+			c.Assert(err, qt.IsNil)
+		}
+
+		resp, err = http.DefaultClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(resp.Header.Get("Last-Modified"), qt.Not(qt.Equals), lastModified)
+	})
+}
+
+func TestContentNegotiation(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("reading and writing through the HTML routes", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		secret := tc.createAPIToken(tc.newAuthenticatedClient(), "read", "submit", "comment", "vote")
+		do := func(method string, path string, values url.Values) *http.Response {
+			req := tc.newTokenRequest(method, path, values, secret)
+			req.Header.Set("Accept", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.IsNil)
+			c.Cleanup(func() { resp.Body.Close() })
+			return resp
+		}
+
+		resp := do("POST", "/submit", url.Values{"title": {"Example"}, "url": {"https://example.com"}})
+		c.Assert(resp.StatusCode, qt.Equals, 201)
+
+		var story struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&story), qt.IsNil)
+		c.Assert(story.Title, qt.Equals, "Example")
+		c.Assert(resp.Header.Get("Location"), qt.Equals, "/stories/"+story.ID+"/comments")
+
+		resp = do("POST", "/submit", url.Values{"title": {"Example again"}, "url": {"https://example.com"}})
+		c.Assert(resp.StatusCode, qt.Equals, 409)
+
+		resp = do("POST", "/stories/"+story.ID+"/comments", url.Values{"body": {"First"}})
+		c.Assert(resp.StatusCode, qt.Equals, 201)
+
+		resp = do("POST", "/stories/"+story.ID+"/votes", url.Values{})
+		c.Assert(resp.StatusCode, qt.Equals, 204)
+
+		resp = do("GET", "/stories/"+story.ID+"/comments", url.Values{})
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+
+		var show struct {
+			Story struct {
+				Upvoted bool `json:"upvoted"`
+			} `json:"story"`
+			Comments []struct {
+				BodyHTML string `json:"body_html"`
+			} `json:"comments"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&show), qt.IsNil)
+		c.Assert(show.Story.Upvoted, qt.IsTrue)
+		c.Assert(show.Comments, qt.HasLen, 1)
+
+		resp, err := http.Get(tc.url("/"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "text/html")
+
+		req, err := http.NewRequest("GET", tc.url("/"), nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("Accept", "application/json")
+		resp, err = http.DefaultClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+
+		var index struct {
+			Stories []struct {
+				ID string `json:"id"`
+			} `json:"stories"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&index), qt.IsNil)
+		c.Assert(index.Stories, qt.HasLen, 1)
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("admins add webhooks which receive signed events", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		type received struct {
+			header http.Header
+			body   []byte
+		}
+		deliveries := make(chan received, 10)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			deliveries <- received{header: r.Header, body: body}
+		}))
+		defer receiver.Close()
+
+		adminID, err := tc.createUser("admin")
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.UpdateUserRole(adminID, tabloid.RoleAdmin), qt.IsNil)
+		client := tc.newSessionClient(adminID)
+
+		resp, err := tc.postForm(client, "/admin/webhooks", url.Values{"url": []string{receiver.URL}, "events": []string{"story.created"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		webhooks, err := tc.pgStore.ListWebhooks()
+		c.Assert(err, qt.IsNil)
+		c.Assert(webhooks, qt.HasLen, 1)
+		c.Assert(webhooks[0].Events, qt.DeepEquals, tabloid.WebhookEvents{tabloid.WebhookStoryCreated})
+
+		resp, err = tc.postForm(client, "/submit", url.Values{"title": []string{"Foobar"}, "body": []string{"Hello"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		select {
+		case d := <-deliveries:
+			c.Assert(d.header.Get("X-Tabloid-Event"), qt.Equals, "story.created")
+			c.Assert(d.header.Get("X-Tabloid-Signature"), qt.Equals, "sha256="+tabloid.SignWebhookPayload(webhooks[0].Secret, d.body))
+
+			var payload struct {
+				Event string                 `json:"event"`
+				Data  map[string]interface{} `json:"data"`
+			}
+			c.Assert(json.Unmarshal(d.body, &payload), qt.IsNil)
+			c.Assert(payload.Data["title"], qt.Equals, "Foobar")
+		case <-time.After(5 * time.Second):
+			c.Fatal("the webhook wasn't delivered")
+		}
+
+		resp, err = client.Get(tc.url("/admin/webhook-deliveries"))
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(doc.Find(".webhook-delivery").Length(), qt.Equals, 1)
+		c.Assert(doc.Find(".webhook-delivery .webhook-event").Text(), qt.Equals, "story.created")
+
+		resp, err = tc.postForm(client, "/admin/webhooks/"+webhooks[0].ID, url.Values{"_method": []string{"DELETE"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 200)
+
+		webhooks, err = tc.pgStore.ListWebhooks()
+		c.Assert(err, qt.IsNil)
+		c.Assert(webhooks, qt.HasLen, 0)
+	})
+
+	c.Run("votes are only sent once, with the story of the comment", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		webhook, err := tabloid.NewWebhook("https://example.com", tabloid.WebhookEvents{tabloid.WebhookVoteCreated})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tc.pgStore.InsertWebhook(webhook), qt.IsNil)
+
+		authorID, err := tc.createUser("alice")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("Foobar", "Foobaring", authorID, "http://foobar.com")
+		c.Assert(tc.pgStore.InsertStory(story), qt.IsNil)
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "kudos", authorID)
+		c.Assert(tc.pgStore.InsertComment(comment), qt.IsNil)
+
+		voterID, err := tc.createUser("bob")
+		c.Assert(err, qt.IsNil)
+		client := tc.newSessionClient(voterID)
+		for _, up := range []string{"true", "true", "false"} {
+			resp, err := tc.postForm(client, "/story/"+story.ID+"/comments/"+comment.ID+"/votes?redir=/", url.Values{"up": []string{up}})
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, 200)
+		}
+
+		deliveries, err := tc.pgStore.ListWebhookDeliveries(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries, qt.HasLen, 1)
+		c.Assert(deliveries[0].Event, qt.Equals, tabloid.WebhookVoteCreated)
+
+		var payload struct {
+			Data struct {
+				StoryID   string `json:"story_id"`
+				CommentID string `json:"comment_id"`
+				Up        bool   `json:"up"`
+			} `json:"data"`
+		}
+		c.Assert(json.Unmarshal([]byte(deliveries[0].Payload), &payload), qt.IsNil)
+		c.Assert(payload.Data.StoryID, qt.Equals, story.ID)
+		c.Assert(payload.Data.CommentID, qt.Equals, comment.ID)
+		c.Assert(payload.Data.Up, qt.IsTrue)
+	})
+
+	c.Run("members can't add webhooks", func(c *qt.C) {
+		tc := newTestContext(c)
+		tc.prepareServer()
+
+		resp, err := tc.postForm(tc.newAuthenticatedClient(), "/admin/webhooks", url.Values{"url": []string{"https://example.com"}, "events": []string{"story.created"}})
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, 403)
+	})
 }
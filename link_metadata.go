@@ -0,0 +1,421 @@
+package tabloid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/html"
+)
+
+// LinkMetadata is what was found on the page a link points to, cached so each page is only fetched once.
+type LinkMetadata struct {
+	// CanonicalURL identifies the page, see CanonicalURL.
+	CanonicalURL string    `db:"canonical_url"`
+	URL          string    `db:"url"`
+	Title        string    `db:"title"`
+	SiteName     string    `db:"site_name"`
+	Description  string    `db:"description"`
+	ImageURL     string    `db:"image_url"`
+	FetchedAt    time.Time `db:"fetched_at"`
+	// Error is why the page couldn't be fetched, if it couldn't. Failures are cached too, so the same
+	// broken link isn't fetched over and over.
+	Error string `db:"error"`
+}
+
+// IsStale returns true if the metadata was fetched longer than the given duration ago.
+func (m *LinkMetadata) IsStale(cacheFor time.Duration, at time.Time) bool {
+	return at.Sub(m.FetchedAt) > cacheFor
+}
+
+// ErrPrivateAddress is returned when fetching a link resolving to a private, loopback or otherwise
+// internal address, so users can't use the fetcher to reach the internal network of the instance.
+var ErrPrivateAddress = fmt.Errorf("refusing to fetch a private address")
+
+// A MetadataFetcher fetches the title, Open Graph description and image of web pages.
+type MetadataFetcher struct {
+	// Timeout bounds the whole fetch, redirects included.
+	Timeout time.Duration
+	// MaxBytes is how much of a page is read at most, metadata being expected in its head.
+	MaxBytes int64
+	// MaxImageBytes is the size of the largest image that can be fetched.
+	MaxImageBytes int64
+	// CacheFor is how long fetched metadata is kept before being fetched again.
+	CacheFor time.Duration
+	// AllowPrivateAddresses disables the guard against fetching internal addresses; only meant for tests.
+	AllowPrivateAddresses bool
+	// UserAgent is sent along the requests.
+	UserAgent string
+}
+
+// NewMetadataFetcher returns a MetadataFetcher with reasonable limits.
+func NewMetadataFetcher() *MetadataFetcher {
+	return &MetadataFetcher{
+		Timeout:       5 * time.Second,
+		MaxBytes:      512 * 1024,
+		MaxImageBytes: 2 * 1024 * 1024,
+		CacheFor:      7 * 24 * time.Hour,
+		UserAgent:     "Tabloid (+https://github.com/jhchabran/tabloid)",
+	}
+}
+
+// Fetch retrieves the metadata of the page the given link points to. Only http and https links are fetched,
+// and unless AllowPrivateAddresses is set, only from public addresses.
+func (f *MetadataFetcher) Fetch(ctx context.Context, link string) (*LinkMetadata, error) {
+	resp, cancel, err := f.get(ctx, link, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	metadata := parseMetadata(io.LimitReader(resp.Body, f.MaxBytes), resp.Request.URL)
+	metadata.URL = link
+	metadata.CanonicalURL = CanonicalURL(link)
+	metadata.FetchedAt = NowFunc()
+
+	return metadata, nil
+}
+
+// imageTypes are the types of images that can be fetched. SVG isn't one of them, as it can embed scripts.
+var imageTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// FetchImage retrieves an image, with the same restrictions as Fetch, returning its content type along
+// its content.
+func (f *MetadataFetcher) FetchImage(ctx context.Context, link string) (string, []byte, error) {
+	resp, cancel, err := f.get(ctx, link, "image/*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !imageTypes[mediaType] {
+		return "", nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	// reading one more byte tells if the image is too large
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.MaxImageBytes+1))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if int64(len(data)) > f.MaxImageBytes {
+		return "", nil, fmt.Errorf("image larger than %d bytes", f.MaxImageBytes)
+	}
+
+	return mediaType, data, nil
+}
+
+// get requests the given link, returning the response if it's successful. The returned function releases
+// the resources associated with the timeout, once done with the response.
+func (f *MetadataFetcher) get(ctx context.Context, link string, accept string) (*http.Response, context.CancelFunc, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp, cancel, nil
+}
+
+// client returns an HTTP client which refuses to connect to private addresses, checked once the host is
+// resolved so DNS records pointing to internal addresses are caught as well. Each fetch gets its own client,
+// following the current settings, so connections aren't kept alive past it.
+func (f *MetadataFetcher) client() *http.Client {
+	dialer := &net.Dialer{Timeout: f.Timeout}
+	if !f.AllowPrivateAddresses {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			DisableKeepAlives:     true,
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// privateNetworks are the ranges not reachable from the internet, on top of what the net package
+// already tells apart.
+var privateNetworks = []*net.IPNet{
+	// "this network", which Linux routes to the local host
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("169.254.0.0/16"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	mustParseCIDR("fc00::/7"),
+	// NAT64, translating to IPv4 addresses which may be internal ones
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+// isPrivateIP returns true if the given address isn't a public one.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+// parseMetadata reads the title and the Open Graph properties from the head of a page, base being the
+// URL the page was fetched from, to resolve relative image URLs.
+func parseMetadata(r io.Reader, base *url.URL) *LinkMetadata {
+	metadata := &LinkMetadata{}
+	var title, ogTitle string
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// end of the page, or of what we read from it
+			return metadata.withTitle(ogTitle, title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "body":
+				return metadata.withTitle(ogTitle, title)
+			case "title":
+				if z.Next() == html.TextToken && title == "" {
+					title = strings.TrimSpace(string(z.Text()))
+				}
+			case "meta":
+				property, content := metaAttributes(t)
+				switch property {
+				case "og:title":
+					ogTitle = content
+				case "og:site_name":
+					metadata.SiteName = content
+				case "og:description", "description":
+					if metadata.Description == "" || property == "og:description" {
+						metadata.Description = content
+					}
+				case "og:image":
+					if image, err := base.Parse(content); err == nil && (image.Scheme == "http" || image.Scheme == "https") {
+						metadata.ImageURL = image.String()
+					}
+				}
+			}
+		case html.EndTagToken:
+			if z.Token().Data == "head" {
+				return metadata.withTitle(ogTitle, title)
+			}
+		}
+	}
+}
+
+// withTitle sets the title of the metadata, preferring the Open Graph one which doesn't usually
+// include the name of the site.
+func (m *LinkMetadata) withTitle(ogTitle string, title string) *LinkMetadata {
+	m.Title = title
+	if ogTitle != "" {
+		m.Title = ogTitle
+	}
+
+	return m
+}
+
+// metaAttributes returns the name, or the property, and the content of a meta tag.
+func metaAttributes(t html.Token) (string, string) {
+	var property, content string
+	for _, attr := range t.Attr {
+		switch attr.Key {
+		case "property", "name":
+			if property == "" {
+				property = strings.ToLower(attr.Val)
+			}
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+
+	return property, content
+}
+
+// linkMetadata returns the metadata of the given link, fetching it unless it's been cached recently. Failures
+// are cached as well, in which case the returned metadata has its Error set.
+func (s *Server) linkMetadata(ctx context.Context, link string) (*LinkMetadata, error) {
+	metadata, err := s.store.FindLinkMetadata(CanonicalURL(link))
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata != nil && !metadata.IsStale(s.metadataFetcher.CacheFor, NowFunc()) {
+		return metadata, nil
+	}
+
+	metadata, err = s.metadataFetcher.Fetch(ctx, link)
+	if err != nil {
+		metadata = &LinkMetadata{CanonicalURL: CanonicalURL(link), URL: link, FetchedAt: NowFunc(), Error: err.Error()}
+	}
+
+	err = s.store.UpsertLinkMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// queueLinkMetadata asks for the metadata of the given link to be fetched in the background. It's dropped
+// if too many links are already waiting, as it can be fetched later on.
+func (s *Server) queueLinkMetadata(link string) {
+	if s.metadataFetcher == nil || link == "" {
+		return
+	}
+
+	select {
+	case s.metadataQueue <- link:
+	default:
+		s.Logger.Warn().Str("url", link).Msg("metadata queue full, dropping link")
+	}
+}
+
+// runMetadataWorker fetches the metadata of the queued links, one at a time, archiving them along the way,
+// until the server is stopped. Stale metadata is periodically refreshed along the way.
+func (s *Server) runMetadataWorker() {
+	ticker := time.NewTicker(metadataRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.refreshStaleLinkMetadata()
+		case link := <-s.metadataQueue:
+			if s.metadataFetcher == nil {
+				continue
+			}
+
+			metadata, err := s.linkMetadata(context.Background(), link)
+			if err != nil {
+				s.Logger.Warn().Err(err).Str("url", link).Msg("couldn't store link metadata")
+			} else if metadata.Error != "" {
+				s.Logger.Debug().Str("url", link).Str("error", metadata.Error).Msg("couldn't fetch link metadata")
+			}
+
+			err = s.archiveLink(context.Background(), link)
+			if err != nil {
+				s.Logger.Warn().Err(err).Str("url", link).Msg("couldn't store link archive")
+			}
+		}
+	}
+}
+
+// HandleFetchTitle handles requests to fetch the title and metadata of the link given in the "url" query
+// parameter, answering in JSON, so the submit form can be filled.
+func (s *Server) HandleFetchTitle() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		if s.metadataFetcher == nil {
+			return NotFound(req.URL.Path)
+		}
+
+		link := strings.TrimSpace(req.URL.Query().Get("url"))
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return UnprocessableEntityWithError(err, "url")
+		}
+
+		metadata, err := s.linkMetadata(req.Context(), link)
+		if err != nil {
+			return err
+		}
+
+		if metadata.Error != "" {
+			return UnprocessableEntityWithError(fmt.Errorf("%s", metadata.Error), "url")
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(res).Encode(map[string]string{
+			"title":       metadata.Title,
+			"description": metadata.Description,
+			"image_url":   metadata.ImageURL,
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package tabloid
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+const testPage = `<!doctype html>
+<html>
+<head>
+	<title> Foobar | The Site </title>
+	<meta name="description" content="A plain description">
+	<meta property="og:title" content="Foobar">
+	<meta property="og:site_name" content="The Site">
+	<meta property="og:description" content="All about foobar">
+	<meta property="og:image" content="/images/foobar.png">
+</head>
+<body><title>Not this one</title></body>
+</html>`
+
+func TestMetadataFetcher(t *testing.T) {
+	c := qt.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/bare", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Bare &amp; simple</title></head></html>"))
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head>" + strings.Repeat("<!-- padding -->", 1000) + "<title>Too far</title></head></html>"))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/image.svg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte("<svg></svg>"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+	})
+	standIn := httptest.NewServer(mux)
+	defer standIn.Close()
+
+	// the stand-in server listens on a loopback address
+	f := NewMetadataFetcher()
+	f.AllowPrivateAddresses = true
+
+	c.Run("reads the title and Open Graph properties", func(c *qt.C) {
+		metadata, err := f.Fetch(context.Background(), standIn.URL+"/redirect")
+		c.Assert(err, qt.IsNil)
+		c.Assert(metadata.Title, qt.Equals, "Foobar")
+		c.Assert(metadata.SiteName, qt.Equals, "The Site")
+		c.Assert(metadata.Description, qt.Equals, "All about foobar")
+		c.Assert(metadata.ImageURL, qt.Equals, standIn.URL+"/images/foobar.png")
+		c.Assert(metadata.URL, qt.Equals, standIn.URL+"/redirect")
+		c.Assert(metadata.CanonicalURL, qt.Equals, CanonicalURL(standIn.URL+"/redirect"))
+	})
+
+	c.Run("falls back to the title", func(c *qt.C) {
+		metadata, err := f.Fetch(context.Background(), standIn.URL+"/bare")
+		c.Assert(err, qt.IsNil)
+		c.Assert(metadata.Title, qt.Equals, "Bare & simple")
+		c.Assert(metadata.ImageURL, qt.Equals, "")
+	})
+
+	c.Run("refuses what isn't a web page", func(c *qt.C) {
+		_, err := f.Fetch(context.Background(), standIn.URL+"/json")
+		c.Assert(err, qt.ErrorMatches, `unexpected content type "application/json"`)
+
+		_, err = f.Fetch(context.Background(), standIn.URL+"/missing")
+		c.Assert(err, qt.ErrorMatches, `unexpected status 404`)
+
+		_, err = f.Fetch(context.Background(), "ftp://foobar.com")
+		c.Assert(err, qt.ErrorMatches, `unsupported scheme "ftp"`)
+	})
+
+	c.Run("reads only the beginning of pages", func(c *qt.C) {
+		limited := *f
+		limited.MaxBytes = 1024
+		metadata, err := limited.Fetch(context.Background(), standIn.URL+"/huge")
+		c.Assert(err, qt.IsNil)
+		c.Assert(metadata.Title, qt.Equals, "")
+	})
+
+	c.Run("times out", func(c *qt.C) {
+		impatient := *f
+		impatient.Timeout = 50 * time.Millisecond
+		_, err := impatient.Fetch(context.Background(), standIn.URL+"/slow")
+		c.Assert(err, qt.Not(qt.IsNil))
+	})
+
+	c.Run("refuses private addresses", func(c *qt.C) {
+		_, err := NewMetadataFetcher().Fetch(context.Background(), standIn.URL+"/page")
+		c.Assert(err, qt.ErrorMatches, `.*refusing to fetch a private address`)
+	})
+
+	c.Run("fetches images", func(c *qt.C) {
+		contentType, data, err := f.FetchImage(context.Background(), standIn.URL+"/image.png")
+		c.Assert(err, qt.IsNil)
+		c.Assert(contentType, qt.Equals, "image/png")
+		c.Assert(string(data), qt.Equals, "\x89PNG")
+
+		_, _, err = f.FetchImage(context.Background(), standIn.URL+"/image.svg")
+		c.Assert(err, qt.ErrorMatches, `unexpected content type "image/svg\+xml"`)
+
+		_, _, err = f.FetchImage(context.Background(), standIn.URL+"/page")
+		c.Assert(err, qt.ErrorMatches, `unexpected content type "text/html"`)
+
+		tiny := *f
+		tiny.MaxImageBytes = 2
+		_, _, err = tiny.FetchImage(context.Background(), standIn.URL+"/image.png")
+		c.Assert(err, qt.ErrorMatches, `image larger than 2 bytes`)
+
+		_, _, err = NewMetadataFetcher().FetchImage(context.Background(), standIn.URL+"/image.png")
+		c.Assert(err, qt.ErrorMatches, `.*refusing to fetch a private address`)
+	})
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	c := qt.New(t)
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "0.1.2.3", "192.0.0.8", "198.18.0.1", "198.19.255.255", "240.0.0.1", "255.255.255.255", "::1", "fd00::1", "::ffff:10.0.0.1", "64:ff9b::a00:1", "64:ff9b::808:808"} {
+		c.Assert(isPrivateIP(net.ParseIP(ip)), qt.IsTrue, qt.Commentf("ip %s", ip))
+	}
+
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		c.Assert(isPrivateIP(net.ParseIP(ip)), qt.IsFalse, qt.Commentf("ip %s", ip))
+	}
+}
@@ -0,0 +1,240 @@
+package tabloid
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// metadataRefreshInterval is how often stale link metadata is looked for, to be fetched again.
+const metadataRefreshInterval = time.Hour
+
+// metadataRefreshBatch is how many stale link metadata are fetched again at most on each refresh.
+const metadataRefreshBatch = 50
+
+// previewImageCacheBytes is how much memory the fetched preview images can take at most, the ones fetched
+// the longest ago being dropped first.
+const previewImageCacheBytes = 64 * 1024 * 1024
+
+// A LinkPreview is the card shown on a story page, describing the page the story links to.
+type LinkPreview struct {
+	SiteName    string `json:"site_name"`
+	Description string `json:"description"`
+	// ImagePath is where the thumbnail is served from, through Tabloid, empty if there is none.
+	ImagePath string `json:"image_path,omitempty"`
+}
+
+// linkPreview returns the preview of the page the given story links to, built from its cached metadata.
+// It returns nil if previews are disabled or if there is nothing to show, queuing the metadata to be
+// fetched if it never was.
+func (s *Server) linkPreview(story *Story) (*LinkPreview, error) {
+	if !s.config.LinkPreviews || story.URL == "" {
+		return nil, nil
+	}
+
+	canonicalURL := story.CanonicalURL
+	if canonicalURL == "" {
+		canonicalURL = CanonicalURL(story.URL)
+	}
+
+	metadata, err := s.store.FindLinkMetadata(canonicalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		s.queueLinkMetadata(story.URL)
+		return nil, nil
+	}
+
+	if metadata.Error != "" || (metadata.Description == "" && metadata.ImageURL == "") {
+		return nil, nil
+	}
+
+	preview := &LinkPreview{
+		SiteName:    metadata.SiteName,
+		Description: metadata.Description,
+	}
+
+	if preview.SiteName == "" {
+		preview.SiteName = story.Domain
+	}
+
+	// images are proxied so visiting a story doesn't disclose readers' addresses to the linked site
+	if metadata.ImageURL != "" && s.metadataFetcher != nil {
+		preview.ImagePath = "/stories/" + story.ID + "/preview-image"
+	}
+
+	return preview, nil
+}
+
+// HandlePreviewImage serves the thumbnail of the page a story links to, fetching it on behalf of the reader.
+func (s *Server) HandlePreviewImage() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		if !s.config.LinkPreviews || s.metadataFetcher == nil {
+			return NotFound(req.URL.Path)
+		}
+
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		viewer, err := s.requestUser(req)
+		if err != nil {
+			return err
+		}
+
+		visible, err := s.canSeeStory(story, viewer)
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		canonicalURL := story.CanonicalURL
+		if canonicalURL == "" {
+			canonicalURL = CanonicalURL(story.URL)
+		}
+
+		metadata, err := s.store.FindLinkMetadata(canonicalURL)
+		if err != nil {
+			return err
+		}
+
+		if metadata == nil || metadata.ImageURL == "" {
+			return NotFound(req.URL.Path)
+		}
+
+		image, err := s.previewImage(req.Context(), canonicalURL, metadata.ImageURL)
+		if err != nil {
+			s.Logger.Debug().Err(err).Str("url", metadata.ImageURL).Msg("couldn't fetch preview image")
+			return NotFound(req.URL.Path)
+		}
+
+		res.Header().Set("Content-Type", image.contentType)
+		res.Header().Set("Content-Length", strconv.Itoa(len(image.data)))
+		res.Header().Set("Cache-Control", "public, max-age=86400")
+		res.Header().Set("X-Content-Type-Options", "nosniff")
+		_, err = res.Write(image.data)
+		return err
+	}
+}
+
+// previewImage is a fetched preview image, along the URL it was fetched from.
+type previewImage struct {
+	imageURL    string
+	contentType string
+	data        []byte
+	fetchedAt   time.Time
+}
+
+// previewImageCache keeps the fetched preview images by the canonical URL of the page they illustrate, so
+// they're fetched once per page rather than once per reader. The images take up to maxBytes.
+type previewImageCache struct {
+	mu       sync.Mutex
+	images   map[string]*previewImage
+	size     int
+	maxBytes int
+}
+
+func newPreviewImageCache(maxBytes int) *previewImageCache {
+	return &previewImageCache{images: map[string]*previewImage{}, maxBytes: maxBytes}
+}
+
+// get returns the image of the page with the given canonical URL, nil if it isn't cached, was fetched from
+// another URL or before the given time.
+func (c *previewImageCache) get(canonicalURL string, imageURL string, notBefore time.Time) *previewImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	image := c.images[canonicalURL]
+	if image == nil || image.imageURL != imageURL || image.fetchedAt.Before(notBefore) {
+		return nil
+	}
+
+	return image
+}
+
+// put caches the image of the page with the given canonical URL, dropping the oldest images until it fits.
+func (c *previewImageCache) put(canonicalURL string, image *previewImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(image.data) > c.maxBytes {
+		return
+	}
+
+	c.remove(canonicalURL)
+	for c.size+len(image.data) > c.maxBytes {
+		var oldest string
+		for url, cached := range c.images {
+			if oldest == "" || cached.fetchedAt.Before(c.images[oldest].fetchedAt) {
+				oldest = url
+			}
+		}
+		c.remove(oldest)
+	}
+
+	c.images[canonicalURL] = image
+	c.size += len(image.data)
+}
+
+func (c *previewImageCache) remove(canonicalURL string) {
+	if image, ok := c.images[canonicalURL]; ok {
+		c.size -= len(image.data)
+		delete(c.images, canonicalURL)
+	}
+}
+
+// previewImage returns the preview image of the page with the given canonical URL, fetching it unless it was
+// fetched within the cache duration of the fetcher. Failures aren't cached, as they're usually transient.
+func (s *Server) previewImage(ctx context.Context, canonicalURL string, imageURL string) (*previewImage, error) {
+	now := NowFunc()
+	image := s.previewImages.get(canonicalURL, imageURL, now.Add(-s.metadataFetcher.CacheFor))
+	if image != nil {
+		return image, nil
+	}
+
+	contentType, data, err := s.metadataFetcher.FetchImage(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	image = &previewImage{imageURL: imageURL, contentType: contentType, data: data, fetchedAt: now}
+	s.previewImages.put(canonicalURL, image)
+	return image, nil
+}
+
+// refreshStaleLinkMetadata fetches again a batch of the link metadata which are older than the cache
+// duration of the fetcher, so previews don't go on describing outdated pages.
+func (s *Server) refreshStaleLinkMetadata() {
+	if s.metadataFetcher == nil {
+		return
+	}
+
+	stale, err := s.store.ListStaleLinkMetadata(NowFunc().Add(-s.metadataFetcher.CacheFor), metadataRefreshBatch)
+	if err != nil {
+		s.Logger.Warn().Err(err).Msg("couldn't list stale link metadata")
+		return
+	}
+
+	for _, metadata := range stale {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		_, err := s.linkMetadata(context.Background(), metadata.URL)
+		if err != nil {
+			s.Logger.Warn().Err(err).Str("url", metadata.URL).Msg("couldn't refresh link metadata")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tabloid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/rs/zerolog"
+)
+
+func TestPreviewImage(t *testing.T) {
+	c := qt.New(t)
+
+	fetches := 0
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	}))
+	defer standIn.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.Patch(&NowFunc, func() time.Time { return now })
+
+	s := NewServer(&ServerConfig{}, zerolog.Nop(), nil)
+	s.metadataFetcher = NewMetadataFetcher()
+	s.metadataFetcher.AllowPrivateAddresses = true
+	s.metadataFetcher.CacheFor = time.Hour
+
+	c.Run("images are fetched once per page", func(c *qt.C) {
+		for i := 0; i < 3; i++ {
+			image, err := s.previewImage(context.Background(), "foobar.com/a", standIn.URL+"/a.png")
+			c.Assert(err, qt.IsNil)
+			c.Assert(image.contentType, qt.Equals, "image/png")
+			c.Assert(string(image.data), qt.Equals, "\x89PNG")
+		}
+		c.Assert(fetches, qt.Equals, 1)
+	})
+
+	c.Run("images are fetched again when they change or expire", func(c *qt.C) {
+		_, err := s.previewImage(context.Background(), "foobar.com/a", standIn.URL+"/b.png")
+		c.Assert(err, qt.IsNil)
+		c.Assert(fetches, qt.Equals, 2)
+
+		now = now.Add(2 * time.Hour)
+		_, err = s.previewImage(context.Background(), "foobar.com/a", standIn.URL+"/b.png")
+		c.Assert(err, qt.IsNil)
+		c.Assert(fetches, qt.Equals, 3)
+	})
+}
+
+func TestPreviewImageCache(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newPreviewImageCache(8)
+	cache.put("a", &previewImage{imageURL: "a.png", data: []byte("1234"), fetchedAt: now})
+	cache.put("b", &previewImage{imageURL: "b.png", data: []byte("1234"), fetchedAt: now.Add(time.Minute)})
+	c.Assert(cache.get("a", "a.png", now), qt.Not(qt.IsNil))
+
+	// the oldest image makes room for the new one
+	cache.put("c", &previewImage{imageURL: "c.png", data: []byte("12"), fetchedAt: now.Add(2 * time.Minute)})
+	c.Assert(cache.get("a", "a.png", now), qt.IsNil)
+	c.Assert(cache.get("b", "b.png", now), qt.Not(qt.IsNil))
+	c.Assert(cache.get("c", "c.png", now), qt.Not(qt.IsNil))
+	c.Assert(cache.size, qt.Equals, 6)
+
+	// images larger than the whole cache aren't kept
+	cache.put("d", &previewImage{imageURL: "d.png", data: []byte("123456789"), fetchedAt: now})
+	c.Assert(cache.get("d", "d.png", now), qt.IsNil)
+	c.Assert(cache.size, qt.Equals, 6)
+}
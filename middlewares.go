@@ -3,12 +3,12 @@ package tabloid
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
-	"github.com/jhchabran/tabloid/authentication"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -33,11 +33,14 @@ var ctxKeySession = contextKey("session")
 // ctxKeyUser is the context key for storing the current user record in a context
 var ctxKeyUser = contextKey("user")
 
+// ctxKeyToken is the context key for storing the personal access token the request was authenticated with
+var ctxKeyToken = contextKey("token")
+
 // ctxSession is a helper func to fetch the user session from the context.
-func ctxSession(ctx context.Context) *authentication.User {
+func ctxSession(ctx context.Context) *Session {
 	v := ctx.Value(ctxKeySession)
 	if v != nil {
-		return ctx.Value(ctxKeySession).(*authentication.User)
+		return ctx.Value(ctxKeySession).(*Session)
 	} else {
 		return nil
 	}
@@ -53,6 +56,16 @@ func ctxUser(ctx context.Context) *User {
 	}
 }
 
+// ctxToken is a helper func to fetch the personal access token from the context.
+func ctxToken(ctx context.Context) *APIToken {
+	v := ctx.Value(ctxKeyToken)
+	if v != nil {
+		return v.(*APIToken)
+	} else {
+		return nil
+	}
+}
+
 // withMiddlewares is a helper function to declare routes with middlewares more easily.
 // The caller declares its routes in the body on the f function, calling f's argument on its
 // httprouter.Handle to wrap them.
@@ -84,18 +97,18 @@ func ensureHTTPMethodMiddleware(method string) middleware {
 	}
 }
 
-// loadSessionMiddleware fetches the user session data through the AuthService
+// loadSessionMiddleware fetches the user session from the session store
 // and stores it in the request context. If there's no session it will assign nil in
 // the context to the session key.
 func (s *Server) loadSessionMiddleware() middleware {
 	return func(next HandleE) HandleE {
 		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
-			userData, err := s.authService.CurrentUser(r)
+			session, err := s.currentSession(r)
 			if err != nil {
 				return err
 			}
 
-			ctx := context.WithValue(r.Context(), ctxKeySession, userData)
+			ctx := context.WithValue(r.Context(), ctxKeySession, session)
 			return next(w, r.WithContext(ctx), p)
 		})
 	}
@@ -104,24 +117,36 @@ func (s *Server) loadSessionMiddleware() middleware {
 // loadUser fetches the user from the database and stores it in the request context. If there's an error
 // it will interrupt the middlware chain, returning an http error.
 //
-// If there is no session, it will return an authorization error.
+// If there is no session, it will return an authorization error, unless the user has already been
+// loaded by loadTokenMiddleware.
 func (s *Server) loadUserMiddleware() middleware {
 	return func(next HandleE) HandleE {
 		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			// already authenticated through a personal access token
+			if ctxUser(r.Context()) != nil {
+				return next(w, r, p)
+			}
+
 			session := ctxSession(r.Context())
 
 			if session == nil {
 				return Unauthorized(r.URL.Path)
 			}
 
-			userRecord, err := s.store.FindUserByLogin(session.Login)
+			userRecord, err := s.findSessionUser(session)
 			if err != nil {
 				return err
 			}
 
 			if userRecord == nil {
 				// there is a session but no user in the database, wipe the session and redirect
-				return s.authService.Destroy(w, r)
+				err := s.destroySessions(w, r)
+				if err != nil {
+					return err
+				}
+
+				http.Redirect(w, r, "/", http.StatusFound)
+				return nil
 			}
 
 			ctx := context.WithValue(r.Context(), ctxKeyUser, userRecord)
@@ -130,6 +155,182 @@ func (s *Server) loadUserMiddleware() middleware {
 	}
 }
 
+// loadTokenMiddleware authenticates requests carrying a personal access token in their Authorization header,
+// storing both the token and its owner in the request context. Requests without that header go through
+// untouched, so it can be combined with the session based middlewares.
+func (s *Server) loadTokenMiddleware() middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				return next(w, r, p)
+			}
+
+			if !strings.HasPrefix(header, "Bearer ") {
+				return Unauthorized(r.URL.Path)
+			}
+
+			secret := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+			token, err := s.store.FindAPITokenByHash(HashAPIToken(secret))
+			if err != nil {
+				return err
+			}
+
+			if token == nil {
+				return Unauthorized(r.URL.Path)
+			}
+
+			userRecord, err := s.store.FindUserByID(token.UserID)
+			if err != nil {
+				return err
+			}
+
+			if userRecord == nil || userRecord.IsBanned() {
+				return Unauthorized(r.URL.Path)
+			}
+
+			// failing to keep track of the last usage isn't worth failing the request
+			err = s.store.TouchAPIToken(token.ID)
+			if err != nil {
+				s.Logger.Warn().Err(err).Str("token_id", token.ID).Msg("can't update token last usage")
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKeyToken, token)
+			ctx = context.WithValue(ctx, ctxKeyUser, userRecord)
+			return next(w, r.WithContext(ctx), p)
+		})
+	}
+}
+
+// requireScopeMiddleware ensures that requests authenticated with a personal access token have been
+// granted the given scope. Requests authenticated through a session are not restricted.
+func requireScopeMiddleware(scope Scope) middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			token := ctxToken(r.Context())
+			if token != nil && !token.Scopes.Has(scope) {
+				return Forbidden(r.URL.Path)
+			}
+
+			return next(w, r, p)
+		})
+	}
+}
+
+// requirePermissionMiddleware restricts the routes to users the given permission allows, like CanModerate,
+// responding with a forbidden error otherwise. It must be placed after loadUserMiddleware.
+func requirePermissionMiddleware(allowed func(u *User) bool) middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			userRecord := ctxUser(r.Context())
+			if userRecord == nil {
+				return Unauthorized(r.URL.Path)
+			}
+
+			if !allowed(userRecord) {
+				return Forbidden(r.URL.Path)
+			}
+
+			return next(w, r, p)
+		})
+	}
+}
+
+// requireActiveUserMiddleware prevents banned and suspended users from posting, voting or flagging,
+// responding with the reason of the suspension. It must be placed after loadUserMiddleware.
+func requireActiveUserMiddleware() middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			userRecord := ctxUser(r.Context())
+			if userRecord == nil || userRecord.IsBanned() {
+				return Unauthorized(r.URL.Path)
+			}
+
+			if r.Method != http.MethodGet && userRecord.IsSuspended(NowFunc()) {
+				return Suspended(userRecord)
+			}
+
+			return next(w, r, p)
+		})
+	}
+}
+
+// rateLimitMiddleware refuses requests from users who exhausted their budget for the given action, as
+// configured in ServerConfig.RateLimits, telling them when to retry. It must be placed after loadUserMiddleware.
+func (s *Server) rateLimitMiddleware(action RateLimitAction) middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			limit, ok := s.config.RateLimits[action]
+			userRecord := ctxUser(r.Context())
+			if !ok || userRecord == nil {
+				return next(w, r, p)
+			}
+
+			count := limit.CountFor(userRecord, NowFunc())
+			if count <= 0 || limit.Period <= 0 {
+				return next(w, r, p)
+			}
+
+			allowed, retryAfter, err := s.rateLimiter.TakeRateLimitToken(string(action)+":"+userRecord.ID, count, limit.Period)
+			if err != nil {
+				return err
+			}
+
+			if !allowed {
+				return TooManyRequests(retryAfter)
+			}
+
+			return next(w, r, p)
+		})
+	}
+}
+
+// csrfFieldName is the name of the form field carrying the CSRF token.
+const csrfFieldName = "csrf_token"
+
+// csrfHeaderName is the header that can carry the CSRF token instead of the form field.
+const csrfHeaderName = "X-CSRF-Token"
+
+// csrfMiddleware rejects state changing requests made with a session that don't carry its CSRF token,
+// either in the csrf_token form field or in the X-CSRF-Token header.
+//
+// Requests authenticated with a personal access token are exempt, as browsers never send the Authorization
+// header on their own. Requests without a session are left to the middlewares down the chain.
+func csrfMiddleware() middleware {
+	return func(next HandleE) HandleE {
+		return HandleE(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(w, r, p)
+			}
+
+			if ctxToken(r.Context()) != nil {
+				return next(w, r, p)
+			}
+
+			session := ctxSession(r.Context())
+			if session == nil {
+				return next(w, r, p)
+			}
+
+			token := r.Header.Get(csrfHeaderName)
+			if token == "" {
+				err := r.ParseForm()
+				if err != nil {
+					return BadRequest(err)
+				}
+				token = r.PostForm.Get(csrfFieldName)
+			}
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				return InvalidCSRFToken(r.URL.Path)
+			}
+
+			return next(w, r, p)
+		})
+	}
+}
+
 // httpVerbFormUnwrapper extract "_method" form parameter and update the request HTTP verb accordingly.
 // It is a top level http middleware, being placed in front of the router.
 func (s *Server) httpVerbFormUnwrapper(next http.Handler) http.Handler {
@@ -172,7 +373,7 @@ func (s *Server) httpVerbFormUnwrapper(next http.Handler) http.Handler {
 			case "PATCH":
 				req.Method = http.MethodPatch
 			case "DELETE":
-				req.Method = http.MethodPatch
+				req.Method = http.MethodDelete
 			case "POST":
 			case "":
 			default:
@@ -1,9 +1,13 @@
 package tabloid
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/julienschmidt/httprouter"
@@ -58,3 +62,131 @@ func TestWithMiddlewares(t *testing.T) {
 // TODO
 // func TestLoadSessionMiddleware(t *testing.T) {
 // }
+
+func TestCSRFMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error { return nil }
+	h := csrfMiddleware()(handler)
+	session := &Session{CSRFToken: "secret"}
+
+	newRequest := func(method string, body string, session *Session, token *APIToken) *http.Request {
+		req := httptest.NewRequest(method, "/submit", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := context.WithValue(req.Context(), ctxKeySession, session)
+		ctx = context.WithValue(ctx, ctxKeyToken, token)
+		return req.WithContext(ctx)
+	}
+
+	c.Run("safe methods go through", func(c *qt.C) {
+		err := h(httptest.NewRecorder(), newRequest("GET", "", session, nil), nil)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("valid token in the form", func(c *qt.C) {
+		err := h(httptest.NewRecorder(), newRequest("POST", "csrf_token=secret", session, nil), nil)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("valid token in the header", func(c *qt.C) {
+		req := newRequest("PUT", "", session, nil)
+		req.Header.Set("X-CSRF-Token", "secret")
+		err := h(httptest.NewRecorder(), req, nil)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("missing token", func(c *qt.C) {
+		err := h(httptest.NewRecorder(), newRequest("POST", "title=foo", session, nil), nil)
+		c.Assert(err, qt.ErrorMatches, "CSRFError: /submit")
+	})
+
+	c.Run("wrong token", func(c *qt.C) {
+		err := h(httptest.NewRecorder(), newRequest("POST", "csrf_token=nope", session, nil), nil)
+		c.Assert(err, qt.ErrorMatches, "CSRFError: /submit")
+	})
+
+	c.Run("personal access tokens are exempt", func(c *qt.C) {
+		err := h(httptest.NewRecorder(), newRequest("POST", "", session, &APIToken{}), nil)
+		c.Assert(err, qt.IsNil)
+	})
+}
+
+func TestRequirePermissionMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error { return nil }
+	h := requirePermissionMiddleware(CanModerate)(handler)
+
+	newRequest := func(user *User) *http.Request {
+		req := httptest.NewRequest("GET", "/admin", nil)
+		if user == nil {
+			return req
+		}
+		return req.WithContext(context.WithValue(req.Context(), ctxKeyUser, user))
+	}
+
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{Role: RoleModerator}), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{Role: RoleAdmin}), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{Role: RoleMember}), nil), qt.ErrorMatches, "ForbiddenError: /admin")
+	c.Assert(h(httptest.NewRecorder(), newRequest(nil), nil), qt.ErrorMatches, "UnauthorizedError: /admin")
+}
+
+func TestRequireActiveUserMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error { return nil }
+	h := requireActiveUserMiddleware()(handler)
+
+	newRequest := func(method string, user *User) *http.Request {
+		req := httptest.NewRequest(method, "/submit", nil)
+		return req.WithContext(context.WithValue(req.Context(), ctxKeyUser, user))
+	}
+
+	now := NowFunc()
+	suspended := &User{SuspendedUntil: sql.NullTime{Time: now.Add(time.Hour), Valid: true}, SuspensionReason: "spam"}
+	expired := &User{SuspendedUntil: sql.NullTime{Time: now.Add(-time.Hour), Valid: true}}
+	banned := &User{BannedAt: sql.NullTime{Time: now, Valid: true}}
+
+	c.Assert(h(httptest.NewRecorder(), newRequest("POST", &User{}), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest("POST", expired), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest("GET", suspended), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest("POST", suspended), nil), qt.ErrorMatches, "SuspendedError: .*")
+	c.Assert(h(httptest.NewRecorder(), newRequest("POST", banned), nil), qt.ErrorMatches, "UnauthorizedError: /submit")
+
+	rec := httptest.NewRecorder()
+	err := h(rec, newRequest("POST", suspended), nil)
+	c.Assert(err.(ErrorResponder).RespondError(rec, nil), qt.IsTrue)
+	c.Assert(rec.Code, qt.Equals, http.StatusForbidden)
+	c.Assert(rec.Body.String(), qt.Contains, "spam")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error { return nil }
+	s := &Server{
+		config:      &ServerConfig{RateLimits: map[RateLimitAction]RateLimit{RateLimitVote: {Count: 1, Period: time.Hour}}},
+		rateLimiter: NewMemoryRateLimiter(),
+	}
+
+	newRequest := func(user *User) *http.Request {
+		req := httptest.NewRequest("POST", "/stories/1/votes", nil)
+		return req.WithContext(context.WithValue(req.Context(), ctxKeyUser, user))
+	}
+
+	h := s.rateLimitMiddleware(RateLimitVote)(handler)
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{ID: "1"}), nil), qt.IsNil)
+	err := h(httptest.NewRecorder(), newRequest(&User{ID: "1"}), nil)
+	c.Assert(err, qt.ErrorMatches, "TooManyRequestsError: .*")
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{ID: "2"}), nil), qt.IsNil)
+
+	rec := httptest.NewRecorder()
+	c.Assert(err.(ErrorResponder).RespondError(rec, nil), qt.IsTrue)
+	c.Assert(rec.Code, qt.Equals, http.StatusTooManyRequests)
+	c.Assert(rec.Header().Get("Retry-After"), qt.Equals, "3600")
+
+	// actions without a budget aren't limited
+	h = s.rateLimitMiddleware(RateLimitSubmit)(handler)
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{ID: "1"}), nil), qt.IsNil)
+	c.Assert(h(httptest.NewRecorder(), newRequest(&User{ID: "1"}), nil), qt.IsNil)
+}
@@ -0,0 +1,505 @@
+package tabloid
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// HandleFlagStoryAction handles requests to flag a story, with a reason given in the "reason" form field.
+func (s *Server) HandleFlagStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		// We'll redirect to a given route after submitting this, so we use redir to specify it
+		redir, err := normalizeRedir(req.URL.Query()["redir"])
+		if err != nil {
+			return UnprocessableEntityWithError(err, "redir")
+		}
+
+		reason, err := ParseFlagReason(req.FormValue("reason"))
+		if err != nil {
+			return UnprocessableEntityWithError(err, "reason")
+		}
+
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		userRecord := ctxUser(req.Context())
+		visible, err := s.canSeeStory(story, userRecord)
+		if err != nil {
+			return err
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		return s.flag(res, req, redir, userRecord, story, NewFlag(story.ID, sql.NullString{}, userRecord.ID, reason))
+	}
+}
+
+// HandleFlagCommentAction handles requests to flag a comment, with a reason given in the "reason" form field.
+func (s *Server) HandleFlagCommentAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		// We'll redirect to a given route after submitting this, so we use redir to specify it
+		redir, err := normalizeRedir(req.URL.Query()["redir"])
+		if err != nil {
+			return UnprocessableEntityWithError(err, "redir")
+		}
+
+		reason, err := ParseFlagReason(req.FormValue("reason"))
+		if err != nil {
+			return UnprocessableEntityWithError(err, "reason")
+		}
+
+		storyID := params.ByName("story_id")
+		story, err := s.store.FindStory(storyID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		comment, err := s.store.FindComment(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		if comment.StoryID != storyID {
+			return NotFound(req.URL.Path)
+		}
+
+		userRecord := ctxUser(req.Context())
+		visible, err := s.canSeeStory(story, userRecord)
+		if err != nil {
+			return err
+		}
+
+		if visible {
+			visible, err = s.canSeeComment(comment, userRecord)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !visible {
+			return NotFound(req.URL.Path)
+		}
+
+		flag := NewFlag(storyID, sql.NullString{String: comment.ID, Valid: true}, userRecord.ID, reason)
+		return s.flag(res, req, redir, userRecord, comment, flag)
+	}
+}
+
+// flag stores the flag on the given content and redirects to redir. Authors can't flag their own content.
+// Flags of shadow-banned users are thanked for like any other but dropped, so they can't hide anything.
+func (s *Server) flag(res http.ResponseWriter, req *http.Request, redir string, userRecord *User, content Authored, flag *Flag) error {
+	if isAuthor(userRecord, content) {
+		SetFlash(res, "warning", "You can't flag your own posts.")
+		http.Redirect(res, req, redir, http.StatusFound)
+		return nil
+	}
+
+	err := requireKarma(userRecord, s.config.MinKarmaToFlag)
+	if err != nil {
+		return err
+	}
+
+	if !userRecord.IsShadowBanned() {
+		err = s.store.InsertFlag(flag, s.config.FlagThreshold)
+		if err != nil {
+			return err
+		}
+	}
+
+	SetFlash(res, "success", "Thanks, a moderator will review it.")
+	http.Redirect(res, req, redir, http.StatusFound)
+	return nil
+}
+
+// HandleModerationFlags handles requests to list the flagged stories and comments waiting for a review, along
+// the held stories and comments.
+func (s *Server) HandleModerationFlags() HandleE {
+	tmpl, err := template.New("moderation_flags.html").Funcs(s.helpers()).ParseFiles(
+		"assets/templates/moderation_flags.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		res.Header().Set("Content-Type", "text/html")
+
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		contents, err := s.store.ListFlaggedContent(page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		held, err := s.store.ListHeldStories(0, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		heldComments, err := s.store.ListHeldComments(0, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		nextPage := -1
+		if len(contents) == adminPerPage {
+			nextPage = page + 1
+		}
+
+		return tmpl.Execute(res, map[string]interface{}{
+			"Session":      ctxSession(req.Context()),
+			"Contents":     contents,
+			"Held":         held,
+			"HeldComments": heldComments,
+			"PrevPage":     page - 1,
+			"NextPage":     nextPage,
+		})
+	}
+}
+
+// HandleModerationApproveFlagsAction handles requests to approve the flags on a story or a comment,
+// which gets removed.
+func (s *Server) HandleModerationApproveFlagsAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		storyID, commentID := flaggedContentParams(req)
+		err := s.store.ApproveFlags(storyID, commentID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(flaggedContentLogEntry(req, ModerationApproveFlags, storyID, commentID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Flags approved, the content has been removed.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleModerationDismissFlagsAction handles requests to dismiss the flags on a story or a comment,
+// which is shown again if it had been hidden.
+func (s *Server) HandleModerationDismissFlagsAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		storyID, commentID := flaggedContentParams(req)
+		err := s.store.DismissFlags(storyID, commentID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(flaggedContentLogEntry(req, ModerationDismissFlags, storyID, commentID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Flags dismissed.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// flaggedContentParams returns the story and the optional comment designated by the "story_id" and
+// "comment_id" form fields.
+func flaggedContentParams(req *http.Request) (string, sql.NullString) {
+	commentID := req.FormValue("comment_id")
+	return req.FormValue("story_id"), sql.NullString{String: commentID, Valid: commentID != ""}
+}
+
+// flaggedContentLogEntry returns a moderation log entry for the given action on the flagged story or comment.
+func flaggedContentLogEntry(req *http.Request, action ModerationAction, storyID string, commentID sql.NullString) *ModerationLogEntry {
+	entry := newModerationLogEntry(req, action)
+	if commentID.Valid {
+		return entry.OnComment(storyID, commentID.String)
+	}
+
+	return entry.OnStory(storyID)
+}
+
+// HandleModerationReleaseStoryAction handles requests to list a held story, running the story hooks that
+// were put off when it was submitted.
+func (s *Server) HandleModerationReleaseStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		author, err := s.store.FindUserByID(story.AuthorID)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.ReleaseStory(story.ID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationReleaseStory).OnStory(story.ID))
+		if err != nil {
+			return err
+		}
+
+		// nobody else can see it, hooks would give it away
+		if author != nil && !author.IsShadowBanned() {
+			story.HeldAt = sql.NullTime{}
+			err = s.runStoryHooks(story)
+			if err != nil {
+				return err
+			}
+		}
+
+		SetFlash(res, "success", "Story released.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleModerationRejectStoryAction handles requests to remove a held story.
+func (s *Server) HandleModerationRejectStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		story, err := s.store.FindStory(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		if !story.IsHeld() {
+			return NotFound(req.URL.Path)
+		}
+
+		err = s.store.RemoveStory(story.ID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationRemoveStory).OnStory(story.ID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Story removed.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleModerationReleaseCommentAction handles requests to show a held comment, running the comment hooks
+// that were put off when it was submitted.
+func (s *Server) HandleModerationReleaseCommentAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		comment, err := s.store.FindComment(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		story, err := s.store.FindStory(comment.StoryID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		author, err := s.store.FindUserByID(comment.AuthorID)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.ReleaseComment(comment.ID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationReleaseComment).OnComment(story.ID, comment.ID))
+		if err != nil {
+			return err
+		}
+
+		// nobody else can see it, hooks would give it away
+		if author != nil && !author.IsShadowBanned() {
+			comment.Author = author.Name
+			comment.HeldAt = sql.NullTime{}
+			err = s.runCommentHooks(story, comment)
+			if err != nil {
+				return err
+			}
+		}
+
+		SetFlash(res, "success", "Comment released.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleModerationRejectCommentAction handles requests to remove a held comment.
+func (s *Server) HandleModerationRejectCommentAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		comment, err := s.store.FindComment(params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		if !comment.IsHeld() {
+			return NotFound(req.URL.Path)
+		}
+
+		err = s.store.RemoveComment(comment.ID)
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		err = s.LogModeration(newModerationLogEntry(req, ModerationRemoveComment).OnComment(comment.StoryID, comment.ID))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Comment removed.")
+		http.Redirect(res, req, "/moderation/flags", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleModerationLockStoryAction handles requests to lock a story, which then can't receive new comments.
+func (s *Server) HandleModerationLockStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		return s.lockStory(res, req, params.ByName("id"), true)
+	}
+}
+
+// HandleModerationUnlockStoryAction handles requests to unlock a story.
+func (s *Server) HandleModerationUnlockStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		return s.lockStory(res, req, params.ByName("id"), false)
+	}
+}
+
+// HandleModerationPinStoryAction handles requests to pin a story for the number of days given in the "days"
+// form field, zero meaning unpinning it.
+func (s *Server) HandleModerationPinStoryAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		id := params.ByName("id")
+		return s.pinStory(res, req, id, "/stories/"+id+"/comments")
+	}
+}
+
+// lockStory locks or unlocks the given story, redirecting to it.
+func (s *Server) lockStory(res http.ResponseWriter, req *http.Request, storyID string, locked bool) error {
+	err := s.store.LockStory(storyID, locked)
+	if err != nil {
+		return Maybe404(err)
+	}
+
+	action := ModerationUnlockStory
+	if locked {
+		action = ModerationLockStory
+	}
+	err = s.LogModeration(newModerationLogEntry(req, action).OnStory(storyID))
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		SetFlash(res, "success", "Story locked.")
+	} else {
+		SetFlash(res, "success", "Story unlocked.")
+	}
+	http.Redirect(res, req, "/stories/"+storyID+"/comments", http.StatusFound)
+	return nil
+}
+
+// pinStory pins the given story for the number of days given in the "days" form field, zero meaning
+// unpinning it, then redirects to redir.
+func (s *Server) pinStory(res http.ResponseWriter, req *http.Request, storyID string, redir string) error {
+	err := req.ParseForm()
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	days, err := strconv.Atoi(req.FormValue("days"))
+	if err != nil || days < 0 {
+		return UnprocessableEntityWithError(err, "days")
+	}
+
+	var until sql.NullTime
+	if days > 0 {
+		until = sql.NullTime{Time: NowFunc().Add(time.Duration(days) * 24 * time.Hour), Valid: true}
+	}
+
+	err = s.store.PinStory(storyID, until)
+	if err != nil {
+		return Maybe404(err)
+	}
+
+	action := ModerationUnpinStory
+	if until.Valid {
+		action = ModerationPinStory
+	}
+	err = s.LogModeration(newModerationLogEntry(req, action).OnStory(storyID))
+	if err != nil {
+		return err
+	}
+
+	if until.Valid {
+		SetFlash(res, "success", "Story pinned.")
+	} else {
+		SetFlash(res, "success", "Story unpinned.")
+	}
+	http.Redirect(res, req, redir, http.StatusFound)
+	return nil
+}
+
+// HandleModerationLog handles requests to list the moderation log, most recent entries first. Private
+// actions, like shadow-bans, are only listed for moderators.
+func (s *Server) HandleModerationLog() HandleE {
+	tmpl, err := template.New("moderation_log.html").Funcs(s.helpers()).ParseFiles(
+		"assets/templates/moderation_log.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		res.Header().Set("Content-Type", "text/html")
+
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		session := ctxSession(req.Context())
+		entries, err := s.store.ListModerationLog(CanModerate(session.user()), page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		nextPage := -1
+		if len(entries) == adminPerPage {
+			nextPage = page + 1
+		}
+
+		return tmpl.Execute(res, map[string]interface{}{
+			"Session":  session,
+			"Entries":  entries,
+			"PrevPage": page - 1,
+			"NextPage": nextPage,
+		})
+	}
+}
+
+// newModerationLogEntry returns a log entry for an action taken by the user making the request, with the
+// reason given in the optional "reason" form field.
+func newModerationLogEntry(req *http.Request, action ModerationAction) *ModerationLogEntry {
+	return NewModerationLogEntry(ctxUser(req.Context()).ID, action, strings.TrimSpace(req.FormValue("reason")))
+}
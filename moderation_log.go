@@ -0,0 +1,99 @@
+package tabloid
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A ModerationAction describes what a moderator did, as shown in the moderation log.
+type ModerationAction string
+
+const (
+	ModerationChangeRole     ModerationAction = "changed role"
+	ModerationBanUser        ModerationAction = "banned user"
+	ModerationUnbanUser      ModerationAction = "unbanned user"
+	ModerationSuspendUser    ModerationAction = "suspended user"
+	ModerationUnsuspendUser  ModerationAction = "lifted suspension"
+	ModerationShadowBanUser  ModerationAction = "shadow-banned user"
+	ModerationUnshadowBan    ModerationAction = "lifted shadow-ban"
+	ModerationRemoveStory    ModerationAction = "removed story"
+	ModerationRemoveComment  ModerationAction = "removed comment"
+	ModerationPinStory       ModerationAction = "pinned story"
+	ModerationUnpinStory     ModerationAction = "unpinned story"
+	ModerationLockStory      ModerationAction = "locked story"
+	ModerationUnlockStory    ModerationAction = "unlocked story"
+	ModerationApproveFlags   ModerationAction = "approved flags"
+	ModerationDismissFlags   ModerationAction = "dismissed flags"
+	ModerationReleaseStory   ModerationAction = "released story"
+	ModerationReleaseComment ModerationAction = "released comment"
+	ModerationUpdateSettings ModerationAction = "updated settings"
+	ModerationAddWebhook     ModerationAction = "added webhook"
+	ModerationRemoveWebhook  ModerationAction = "removed webhook"
+)
+
+// PrivateModerationActions lists the actions only moderators can see in the log, as making them public
+// would defeat their purpose.
+var PrivateModerationActions = []ModerationAction{ModerationShadowBanUser, ModerationUnshadowBan, ModerationAddWebhook, ModerationRemoveWebhook}
+
+// IsPrivate returns true if the action is only visible to moderators.
+func (a ModerationAction) IsPrivate() bool {
+	for _, p := range PrivateModerationActions {
+		if a == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A ModerationLogEntry records who did what to which story, comment or user, and why. Entries without
+// a moderator were written by automated code, such as hooks.
+type ModerationLogEntry struct {
+	ID          string           `db:"id"`
+	ModeratorID sql.NullString   `db:"moderator_id"`
+	Action      ModerationAction `db:"action"`
+	StoryID     sql.NullString   `db:"story_id"`
+	CommentID   sql.NullString   `db:"comment_id"`
+	UserID      sql.NullString   `db:"user_id"`
+	Reason      string           `db:"reason"`
+	CreatedAt   time.Time        `db:"created_at"`
+
+	// Fields below are only filled when listing the log.
+	Moderator  sql.NullString `db:"moderator"`
+	StoryTitle sql.NullString `db:"story_title"`
+	UserName   sql.NullString `db:"user_name"`
+}
+
+// NewModerationLogEntry returns an entry for the given action taken by the given moderator, an empty
+// moderatorID meaning the action was automated. Its target is set with OnStory, OnComment or OnUser.
+func NewModerationLogEntry(moderatorID string, action ModerationAction, reason string) *ModerationLogEntry {
+	return &ModerationLogEntry{
+		ModeratorID: sql.NullString{String: moderatorID, Valid: moderatorID != ""},
+		Action:      action,
+		Reason:      reason,
+		CreatedAt:   NowFunc(),
+	}
+}
+
+// OnStory sets the story the action was taken on.
+func (e *ModerationLogEntry) OnStory(storyID string) *ModerationLogEntry {
+	e.StoryID = sql.NullString{String: storyID, Valid: true}
+	return e
+}
+
+// OnComment sets the comment the action was taken on, and the story it belongs to.
+func (e *ModerationLogEntry) OnComment(storyID string, commentID string) *ModerationLogEntry {
+	e.CommentID = sql.NullString{String: commentID, Valid: true}
+	return e.OnStory(storyID)
+}
+
+// OnUser sets the user the action was taken on.
+func (e *ModerationLogEntry) OnUser(userID string) *ModerationLogEntry {
+	e.UserID = sql.NullString{String: userID, Valid: true}
+	return e
+}
+
+// IsAutomated returns true if no moderator took the action.
+func (e *ModerationLogEntry) IsAutomated() bool {
+	return !e.ModeratorID.Valid
+}
@@ -0,0 +1,30 @@
+package tabloid
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestModerationLogEntry(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("targets", func(c *qt.C) {
+		entry := NewModerationLogEntry("1", ModerationRemoveComment, "rude").OnComment("2", "3")
+		c.Assert(entry.IsAutomated(), qt.IsFalse)
+		c.Assert(entry.StoryID.String, qt.Equals, "2")
+		c.Assert(entry.CommentID.String, qt.Equals, "3")
+		c.Assert(entry.UserID.Valid, qt.IsFalse)
+
+		entry = NewModerationLogEntry("", ModerationBanUser, "").OnUser("4")
+		c.Assert(entry.IsAutomated(), qt.IsTrue)
+		c.Assert(entry.UserID.String, qt.Equals, "4")
+		c.Assert(entry.StoryID.Valid, qt.IsFalse)
+	})
+
+	c.Run("private actions", func(c *qt.C) {
+		c.Assert(ModerationShadowBanUser.IsPrivate(), qt.IsTrue)
+		c.Assert(ModerationUnshadowBan.IsPrivate(), qt.IsTrue)
+		c.Assert(ModerationBanUser.IsPrivate(), qt.IsFalse)
+	})
+}
@@ -0,0 +1,33 @@
+package tabloid
+
+import "time"
+
+// Authored is implemented by content written by a user, like stories and comments.
+type Authored interface {
+	GetAuthorID() string
+	Age() time.Time
+}
+
+// CanModerate returns true if the user is allowed to moderate content written by others.
+func CanModerate(u *User) bool {
+	return u != nil && u.Role.AtLeast(RoleModerator)
+}
+
+// CanAdminister returns true if the user is allowed to administer the instance.
+func CanAdminister(u *User) bool {
+	return u != nil && u.Role.AtLeast(RoleAdmin)
+}
+
+// CanEditComment returns true if the user is a moderator, or the author of the comment while still being
+// within the edit window.
+func CanEditComment(u *User, comment Authored, editWindow time.Duration, at time.Time) bool {
+	if CanModerate(u) {
+		return true
+	}
+
+	return isAuthor(u, comment) && comment.Age().Add(editWindow).After(at)
+}
+
+func isAuthor(u *User, content Authored) bool {
+	return u != nil && u.ID != "" && u.ID == content.GetAuthorID()
+}
@@ -0,0 +1,69 @@
+package tabloid
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRoles(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("AtLeast", func(c *qt.C) {
+		c.Assert(RoleAdmin.AtLeast(RoleModerator), qt.IsTrue)
+		c.Assert(RoleModerator.AtLeast(RoleModerator), qt.IsTrue)
+		c.Assert(RoleMember.AtLeast(RoleModerator), qt.IsFalse)
+		c.Assert(Role("").AtLeast(RoleMember), qt.IsTrue)
+		c.Assert(Role("").AtLeast(RoleModerator), qt.IsFalse)
+	})
+
+	c.Run("ParseRole", func(c *qt.C) {
+		role, err := ParseRole("moderator")
+		c.Assert(err, qt.IsNil)
+		c.Assert(role, qt.Equals, RoleModerator)
+
+		_, err = ParseRole("owner")
+		c.Assert(err, qt.ErrorMatches, `unknown role "owner"`)
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	editWindow := time.Hour
+
+	author := &User{ID: "1", Role: RoleMember}
+	member := &User{ID: "2", Role: RoleMember}
+	moderator := &User{ID: "3", Role: RoleModerator}
+	admin := &User{ID: "4", Role: RoleAdmin}
+
+	recent := &Comment{AuthorID: "1", CreatedAt: now.Add(-time.Minute)}
+	old := &Comment{AuthorID: "1", CreatedAt: now.Add(-2 * time.Hour)}
+
+	c.Run("editing comments", func(c *qt.C) {
+		c.Assert(CanEditComment(author, recent, editWindow, now), qt.IsTrue)
+		c.Assert(CanEditComment(author, old, editWindow, now), qt.IsFalse)
+		c.Assert(CanEditComment(member, recent, editWindow, now), qt.IsFalse)
+		c.Assert(CanEditComment(moderator, old, editWindow, now), qt.IsTrue)
+		c.Assert(CanEditComment(nil, recent, editWindow, now), qt.IsFalse)
+	})
+
+	c.Run("moderation and administration", func(c *qt.C) {
+		c.Assert(CanModerate(member), qt.IsFalse)
+		c.Assert(CanModerate(moderator), qt.IsTrue)
+		c.Assert(CanAdminister(moderator), qt.IsFalse)
+		c.Assert(CanAdminister(admin), qt.IsTrue)
+		c.Assert(CanAdminister(nil), qt.IsFalse)
+	})
+
+	c.Run("templates", func(c *qt.C) {
+		canModerate := helpers["canModerate"].(func(*Session) bool)
+		canAdminister := helpers["canAdminister"].(func(*Session) bool)
+		c.Assert(canModerate(&Session{UserID: "3", Role: RoleModerator}), qt.IsTrue)
+		c.Assert(canAdminister(&Session{UserID: "3", Role: RoleModerator}), qt.IsFalse)
+		c.Assert(canAdminister(&Session{UserID: "4", Role: RoleAdmin}), qt.IsTrue)
+		c.Assert(canModerate(nil), qt.IsFalse)
+	})
+}
@@ -3,11 +3,14 @@ package pgstore
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jhchabran/tabloid"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var recordNotFoundError = errors.New("record not found")
@@ -46,7 +49,7 @@ func (s *PGStore) DB() *sqlx.DB {
 // https://www.citusdata.com/blog/2016/03/30/five-ways-to-paginate/
 func (s *PGStore) ListStories(page int, perPage int) ([]*tabloid.Story, error) {
 	stories := []*tabloid.Story{}
-	err := s.db.Select(&stories, "SELECT stories.*, users.name as author FROM stories JOIN users ON stories.author_id = users.id ORDER BY created_at DESC LIMIT $1 OFFSET $2", perPage, page*perPage)
+	err := s.db.Select(&stories, "SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories JOIN users ON stories.author_id = users.id WHERE stories.deleted_at IS NULL AND stories.hidden_at IS NULL AND stories.held_at IS NULL AND users.shadow_banned_at IS NULL ORDER BY COALESCE(stories.pinned_until > $3, false) DESC, created_at DESC LIMIT $1 OFFSET $2", perPage, page*perPage, tabloid.NowFunc())
 	if err != nil {
 		return nil, err
 	}
@@ -57,12 +60,87 @@ func (s *PGStore) ListStories(page int, perPage int) ([]*tabloid.Story, error) {
 func (s *PGStore) ListStoriesWithVotes(userID string, page int, perPage int) ([]*tabloid.StorySeenByUser, error) {
 	stories := []*tabloid.StorySeenByUser{}
 	err := s.db.Select(&stories,
-		`SELECT stories.*, users.name as author, users.id as user_id, votes.up as up
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at, users.id as user_id, votes.up as up
 		FROM stories
 		JOIN users ON stories.author_id = users.id
 		LEFT JOIN votes ON stories.id = votes.story_id AND votes.user_id = $1
-		ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
-		userID, perPage, page*perPage)
+		WHERE stories.deleted_at IS NULL AND stories.hidden_at IS NULL
+		AND (users.shadow_banned_at IS NULL OR users.id = $1)
+		AND (stories.held_at IS NULL OR users.id = $1)
+		ORDER BY COALESCE(stories.pinned_until > $4, false) DESC, created_at DESC LIMIT $2 OFFSET $3`,
+		userID, perPage, page*perPage, tabloid.NowFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	return stories, nil
+}
+
+// ListStoriesByDomain returns the stories whose URL is on the given domain, most recent first.
+func (s *PGStore) ListStoriesByDomain(domain string, page int, perPage int) ([]*tabloid.Story, error) {
+	stories := []*tabloid.Story{}
+	err := s.db.Select(&stories,
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories
+		JOIN users ON stories.author_id = users.id
+		WHERE stories.domain = $1 AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL
+		AND stories.held_at IS NULL AND users.shadow_banned_at IS NULL
+		ORDER BY stories.created_at DESC LIMIT $2 OFFSET $3`,
+		domain, perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return stories, nil
+}
+
+// ListStoriesByAuthor returns the stories submitted by the given user, most recent first.
+func (s *PGStore) ListStoriesByAuthor(authorID string, page int, perPage int) ([]*tabloid.Story, error) {
+	stories := []*tabloid.Story{}
+	err := s.db.Select(&stories,
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories
+		JOIN users ON stories.author_id = users.id
+		WHERE stories.author_id = $1 AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL
+		AND stories.held_at IS NULL AND users.shadow_banned_at IS NULL
+		ORDER BY stories.created_at DESC LIMIT $2 OFFSET $3`,
+		authorID, perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return stories, nil
+}
+
+// LastStoriesActivity returns when the given stories were last submitted, voted on or commented on, or the
+// zero time if none of them exist.
+func (s *PGStore) LastStoriesActivity(storyIDs []string) (time.Time, error) {
+	var last sql.NullTime
+	err := s.db.Get(&last,
+		`SELECT GREATEST(
+			(SELECT MAX(created_at) FROM stories WHERE id = ANY($1::integer[])),
+			(SELECT MAX(created_at) FROM votes WHERE story_id = ANY($1::integer[])),
+			(SELECT MAX(created_at) FROM comments WHERE story_id = ANY($1::integer[])))`,
+		pq.Array(storyIDs))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return last.Time, nil
+}
+
+// ListStoriesByDomainWithVotes returns the stories whose URL is on the given domain like ListStoriesByDomain,
+// along the votes of the given user.
+func (s *PGStore) ListStoriesByDomainWithVotes(domain string, userID string, page int, perPage int) ([]*tabloid.StorySeenByUser, error) {
+	stories := []*tabloid.StorySeenByUser{}
+	err := s.db.Select(&stories,
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at, users.id as user_id, votes.up as up
+		FROM stories
+		JOIN users ON stories.author_id = users.id
+		LEFT JOIN votes ON stories.id = votes.story_id AND votes.user_id = $2
+		WHERE stories.domain = $1 AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL
+		AND (users.shadow_banned_at IS NULL OR users.id = $2)
+		AND (stories.held_at IS NULL OR users.id = $2)
+		ORDER BY stories.created_at DESC LIMIT $3 OFFSET $4`,
+		domain, userID, perPage, page*perPage)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +150,7 @@ func (s *PGStore) ListStoriesWithVotes(userID string, page int, perPage int) ([]
 
 func (s *PGStore) FindStory(ID string) (*tabloid.Story, error) {
 	story := tabloid.Story{}
-	err := s.db.Get(&story, "SELECT stories.*, users.name as author FROM stories JOIN users ON stories.author_id = users.id WHERE stories.id=$1", ID)
+	err := s.db.Get(&story, "SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories JOIN users ON stories.author_id = users.id WHERE stories.id=$1 AND stories.deleted_at IS NULL", ID)
 	if err != nil {
 		return nil, err
 	}
@@ -83,11 +161,11 @@ func (s *PGStore) FindStory(ID string) (*tabloid.Story, error) {
 func (s *PGStore) FindStoryWithVote(storyID string, userID string) (*tabloid.StorySeenByUser, error) {
 	story := tabloid.StorySeenByUser{}
 	err := s.db.Get(&story,
-		`SELECT stories.*, users.name as author, users.id as user_id, votes.up as up
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at, users.id as user_id, votes.up as up
 		FROM stories
 		JOIN users ON stories.author_id = users.id
 		LEFT JOIN votes ON stories.id = votes.story_id AND votes.user_id = $1
-		WHERE stories.id = $2`,
+		WHERE stories.id = $2 AND stories.deleted_at IS NULL`,
 		userID, storyID)
 	if err != nil {
 		return nil, err
@@ -96,9 +174,57 @@ func (s *PGStore) FindStoryWithVote(storyID string, userID string) (*tabloid.Sto
 	return &story, nil
 }
 
+// FindStoryByCanonicalURL returns the most recent story listed with the given canonical URL.
+// If no story is found, it returns nil without an error.
+func (s *PGStore) FindStoryByCanonicalURL(canonicalURL string) (*tabloid.Story, error) {
+	story := tabloid.Story{}
+	err := s.db.Get(&story,
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories
+		JOIN users ON stories.author_id = users.id
+		WHERE stories.canonical_url = $1 AND stories.canonical_url <> ''
+		AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL AND stories.held_at IS NULL
+		AND users.shadow_banned_at IS NULL
+		ORDER BY stories.created_at DESC LIMIT 1`,
+		canonicalURL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &story, nil
+}
+
+// CanonicalizeURLs fills the canonical URL of the stories submitted before it was stored, returning how
+// many were updated.
+func (s *PGStore) CanonicalizeURLs() (int, error) {
+	stories := []*tabloid.Story{}
+	err := s.db.Select(&stories, "SELECT id, url FROM stories WHERE canonical_url = '' AND url <> ''")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, story := range stories {
+		_, err := s.db.Exec("UPDATE stories SET canonical_url = $1 WHERE id = $2", tabloid.CanonicalURL(story.URL), story.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stories), nil
+}
+
 func (s *PGStore) InsertStory(story *tabloid.Story) error {
 	var id string
 	now := tabloid.NowFunc()
+	if story.CanonicalURL == "" && story.URL != "" {
+		story.CanonicalURL = tabloid.CanonicalURL(story.URL)
+	}
+	if story.Domain == "" && story.URL != "" {
+		story.Domain = tabloid.Domain(story.URL)
+	}
+
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
@@ -108,8 +234,8 @@ func (s *PGStore) InsertStory(story *tabloid.Story) error {
 	err = sqlx.Get(
 		tx,
 		&id,
-		"INSERT INTO stories (title, url, body, author_id, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		story.Title, story.URL, story.Body, story.AuthorID, now,
+		"INSERT INTO stories (title, url, canonical_url, domain, body, author_id, created_at, held_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		story.Title, story.URL, story.CanonicalURL, story.Domain, story.Body, story.AuthorID, now, story.HeldAt,
 	)
 
 	if err != nil {
@@ -141,7 +267,7 @@ func (s *PGStore) InsertStory(story *tabloid.Story) error {
 
 func (s *PGStore) ListComments(storyID string) ([]*tabloid.Comment, error) {
 	comments := []*tabloid.Comment{}
-	err := s.db.Select(&comments, "SELECT comments.*, users.name as author FROM comments JOIN users ON comments.author_id = users.id WHERE story_id=$1 ORDER BY comments.created_at DESC", storyID)
+	err := s.db.Select(&comments, "SELECT comments.*, users.name as author FROM comments JOIN users ON comments.author_id = users.id WHERE story_id=$1 AND users.shadow_banned_at IS NULL ORDER BY comments.created_at DESC", storyID)
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +283,7 @@ func (s *PGStore) ListCommentsWithVotes(storyID string, userID string) ([]*tablo
 		JOIN users ON comments.author_id = users.id
 		LEFT JOIN votes ON comments.id = votes.comment_id AND votes.user_id = $1
 		WHERE comments.story_id = $2
+		AND (users.shadow_banned_at IS NULL OR users.id = $1)
 		ORDER BY created_at`,
 		userID, storyID)
 	if err != nil {
@@ -188,8 +315,8 @@ func (s *PGStore) InsertComment(comment *tabloid.Comment) error {
 	err = sqlx.Get(
 		tx,
 		&id,
-		"INSERT INTO comments (story_id, parent_comment_id, body, author_id, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		comment.StoryID, comment.ParentCommentID, comment.Body, comment.AuthorID, time.Now(),
+		"INSERT INTO comments (story_id, parent_comment_id, body, author_id, created_at, held_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		comment.StoryID, comment.ParentCommentID, comment.Body, comment.AuthorID, time.Now(), comment.HeldAt,
 	)
 
 	if err != nil {
@@ -221,8 +348,8 @@ func (s *PGStore) InsertComment(comment *tabloid.Comment) error {
 
 func (s *PGStore) UpdateComment(comment *tabloid.Comment) error {
 	res, err := s.db.Exec(
-		"UPDATE comments SET story_id = $1, parent_comment_id = $2, body = $3, author_id = $4, created_at = $5 WHERE id=$6",
-		comment.StoryID, comment.ParentCommentID, comment.Body, comment.AuthorID, comment.CreatedAt, comment.ID,
+		"UPDATE comments SET story_id = $1, parent_comment_id = $2, body = $3, author_id = $4, created_at = $5, held_at = $6 WHERE id=$7",
+		comment.StoryID, comment.ParentCommentID, comment.Body, comment.AuthorID, comment.CreatedAt, comment.HeldAt, comment.ID,
 	)
 
 	if err != nil {
@@ -241,6 +368,22 @@ func (s *PGStore) UpdateComment(comment *tabloid.Comment) error {
 	return nil
 }
 
+// FindUserByID returns a User record by its ID.
+// If no user is found, it returns nil without an error.
+func (s *PGStore) FindUserByID(ID string) (*tabloid.User, error) {
+	user := tabloid.User{}
+	err := s.db.Get(&user, "SELECT * FROM users WHERE id=$1", ID)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 // FindUserByLogin returns a User record by its name (so far, it's the Github handle).
 // If no user is found, it returns nil without an error.
 func (s *PGStore) FindUserByLogin(name string) (*tabloid.User, error) {
@@ -270,6 +413,117 @@ func (s *PGStore) CreateOrUpdateUser(login string, email string) (string, error)
 	return id, nil
 }
 
+// FindUserByIdentity returns the User record linked to the given provider account.
+// If no user is found, it returns nil without an error.
+func (s *PGStore) FindUserByIdentity(provider string, login string) (*tabloid.User, error) {
+	user := tabloid.User{}
+	err := s.db.Get(&user,
+		`SELECT users.* FROM users
+		JOIN identities ON identities.user_id = users.id
+		WHERE identities.provider = $1 AND identities.login = $2`,
+		provider, login)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateOrUpdateUserFromIdentity signs in the user linked to the given provider account, creating both of
+// them if it's the first time. The new user gets the provider login as its name, unless it's already taken
+// by someone coming from another provider, in which case the name is suffixed with the provider name.
+func (s *PGStore) CreateOrUpdateUserFromIdentity(provider string, login string, email string) (string, error) {
+	now := tabloid.NowFunc()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = sqlx.Get(tx, &id, "UPDATE identities SET email = $1, last_login_at = $2 WHERE provider = $3 AND login = $4 RETURNING user_id",
+		email, now, provider, login)
+
+	switch {
+	case err == sql.ErrNoRows:
+		name, err := availableUserName(tx, login, provider)
+		if err != nil {
+			return "", err
+		}
+
+		err = sqlx.Get(tx, &id, "INSERT INTO users (name, email, created_at, last_login_at) VALUES ($1, $2, $3, $4) RETURNING id",
+			name, email, now, now)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tx.Exec("INSERT INTO identities (user_id, provider, login, email, created_at, last_login_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			id, provider, login, email, now, now)
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	default:
+		_, err = tx.Exec("UPDATE users SET last_login_at = $1 WHERE id = $2", now, id)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// availableUserName returns a name for a new user signing in for the first time, that doesn't collide
+// with an existing one.
+func availableUserName(q sqlx.Queryer, login string, provider string) (string, error) {
+	name := login
+	for i := 1; ; i++ {
+		var count int
+		err := sqlx.Get(q, &count, "SELECT count(*) FROM users WHERE name = $1", name)
+		if err != nil {
+			return "", err
+		}
+
+		if count == 0 {
+			return name, nil
+		}
+
+		name = login + "-" + provider
+		if i > 1 {
+			name = name + strconv.Itoa(i)
+		}
+	}
+}
+
+// LinkIdentity attaches the given provider account to an existing user, returning the ID of
+// the user the account is linked to. If the account was already linked to someone else, it is left
+// untouched and that other user ID is returned.
+func (s *PGStore) LinkIdentity(userID string, provider string, login string, email string) (string, error) {
+	now := tabloid.NowFunc()
+
+	var id string
+	err := s.db.Get(&id,
+		`INSERT INTO identities (user_id, provider, login, email, created_at, last_login_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider, login) DO UPDATE SET last_login_at = $7 RETURNING user_id`,
+		userID, provider, login, email, now, now, now)
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
 func (s *PGStore) UpdateUser(user *tabloid.User) error {
 	res, err := s.db.Exec(
 		"UPDATE users SET name = $1, email = $2, created_at = $3, last_login_at = $4, settings = $5 WHERE id=$6",
@@ -292,26 +546,839 @@ func (s *PGStore) UpdateUser(user *tabloid.User) error {
 	return nil
 }
 
-func (s *PGStore) CreateOrUpdateVoteOnStory(storyID string, userID string, up bool) error {
-	now := time.Now()
-	_, err := s.db.Exec("INSERT INTO votes (story_id, user_id, up, created_at) VALUES ($1, $2, $3, $4) ON CONFlICT (user_id, story_id) WHERE comment_id IS NULL DO UPDATE SET up = $5",
-		storyID, userID, up, now, up)
+// UpdateUserRole changes the role of a user.
+func (s *PGStore) UpdateUserRole(userID string, role tabloid.Role) error {
+	res, err := s.db.Exec("UPDATE users SET role = $1 WHERE id = $2", role, userID)
+	if err != nil {
+		return err
+	}
 
+	count, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if count != 1 {
+		return recordNotFoundError
+	}
+
 	return nil
 }
 
-func (s *PGStore) CreateOrUpdateVoteOnComment(commentID string, userID string, up bool) error {
-	now := time.Now()
-	_, err := s.db.Exec("INSERT INTO votes (comment_id, user_id, up, created_at) VALUES ($1, $2, $3, $4) ON CONFlICT (user_id, comment_id) WHERE story_id IS NULL DO UPDATE SET up = $5",
-		commentID, userID, up, now, up)
+// ListUsers returns users whose name or email matches the given query, most recently logged in first.
+// An empty query matches all users.
+func (s *PGStore) ListUsers(query string, page int, perPage int) ([]*tabloid.User, error) {
+	users := []*tabloid.User{}
+	err := s.db.Select(&users,
+		`SELECT * FROM users
+		WHERE $1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%'
+		ORDER BY last_login_at DESC LIMIT $2 OFFSET $3`,
+		query, perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// BanUser bans a user.
+func (s *PGStore) BanUser(userID string) error {
+	return s.execOne("UPDATE users SET banned_at = $1 WHERE id = $2", tabloid.NowFunc(), userID)
+}
+
+// UnbanUser lifts the ban of a user.
+func (s *PGStore) UnbanUser(userID string) error {
+	return s.execOne("UPDATE users SET banned_at = NULL WHERE id = $1", userID)
+}
+
+// SuspendUser prevents a user from posting until the given time, the reason being shown to them.
+func (s *PGStore) SuspendUser(userID string, until time.Time, reason string) error {
+	return s.execOne("UPDATE users SET suspended_until = $1, suspension_reason = $2 WHERE id = $3", until, reason, userID)
+}
+
+// UnsuspendUser lifts the suspension of a user.
+func (s *PGStore) UnsuspendUser(userID string) error {
+	return s.execOne("UPDATE users SET suspended_until = NULL, suspension_reason = '' WHERE id = $1", userID)
+}
+
+// ShadowBanUser makes the stories and comments of a user only visible to themselves.
+func (s *PGStore) ShadowBanUser(userID string) error {
+	return s.execOne("UPDATE users SET shadow_banned_at = $1 WHERE id = $2", tabloid.NowFunc(), userID)
+}
+
+// UnshadowBanUser makes the stories and comments of a user visible to everyone again.
+func (s *PGStore) UnshadowBanUser(userID string) error {
+	return s.execOne("UPDATE users SET shadow_banned_at = NULL WHERE id = $1", userID)
+}
 
+// ListRecentStories returns stories whose title or url matches the given query, most recent first,
+// including the removed ones. An empty query matches all stories.
+func (s *PGStore) ListRecentStories(query string, page int, perPage int) ([]*tabloid.Story, error) {
+	stories := []*tabloid.Story{}
+	err := s.db.Select(&stories,
+		`SELECT stories.*, users.name as author FROM stories
+		JOIN users ON stories.author_id = users.id
+		WHERE $1 = '' OR stories.title ILIKE '%' || $1 || '%' OR stories.url ILIKE '%' || $1 || '%'
+		ORDER BY stories.created_at DESC LIMIT $2 OFFSET $3`,
+		query, perPage, page*perPage)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return stories, nil
+}
+
+// ListRecentComments returns comments whose body matches the given query, most recent first, including
+// the removed ones. An empty query matches all comments.
+func (s *PGStore) ListRecentComments(query string, page int, perPage int) ([]*tabloid.Comment, error) {
+	comments := []*tabloid.Comment{}
+	err := s.db.Select(&comments,
+		`SELECT comments.*, users.name as author FROM comments
+		JOIN users ON comments.author_id = users.id
+		WHERE $1 = '' OR comments.body ILIKE '%' || $1 || '%'
+		ORDER BY comments.created_at DESC LIMIT $2 OFFSET $3`,
+		query, perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// RemoveStory removes a story, which is kept in the database but not listed anymore.
+func (s *PGStore) RemoveStory(storyID string) error {
+	return s.execOne("UPDATE stories SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL", tabloid.NowFunc(), storyID)
+}
+
+// RemoveComment removes a comment, which is kept in the database so its replies can still be displayed.
+func (s *PGStore) RemoveComment(commentID string) error {
+	return s.execOne("UPDATE comments SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL", tabloid.NowFunc(), commentID)
+}
+
+// PinStory pins a story until the given time, or unpins it if it's null.
+func (s *PGStore) PinStory(storyID string, until sql.NullTime) error {
+	return s.execOne("UPDATE stories SET pinned_until = $1 WHERE id = $2", until, storyID)
+}
+
+// LockStory locks or unlocks a story, locked stories not accepting new comments.
+func (s *PGStore) LockStory(storyID string, locked bool) error {
+	return s.execOne("UPDATE stories SET locked = $1 WHERE id = $2", locked, storyID)
+}
+
+// ListHeldStories returns the stories waiting for a moderator to review them, oldest first.
+func (s *PGStore) ListHeldStories(page int, perPage int) ([]*tabloid.Story, error) {
+	stories := []*tabloid.Story{}
+	err := s.db.Select(&stories,
+		`SELECT stories.*, users.name as author, users.created_at as author_created_at FROM stories
+		JOIN users ON stories.author_id = users.id
+		WHERE stories.held_at IS NOT NULL AND stories.deleted_at IS NULL
+		ORDER BY stories.held_at LIMIT $1 OFFSET $2`,
+		perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return stories, nil
+}
+
+// ReleaseStory lists a held story. It returns sql.ErrNoRows if the story isn't held.
+func (s *PGStore) ReleaseStory(storyID string) error {
+	return s.execOne("UPDATE stories SET held_at = NULL WHERE id = $1 AND held_at IS NOT NULL", storyID)
+}
+
+// ListHeldComments returns the comments waiting for a moderator to review them, oldest first.
+func (s *PGStore) ListHeldComments(page int, perPage int) ([]*tabloid.Comment, error) {
+	comments := []*tabloid.Comment{}
+	err := s.db.Select(&comments,
+		`SELECT comments.*, users.name as author FROM comments
+		JOIN users ON comments.author_id = users.id
+		WHERE comments.held_at IS NOT NULL AND comments.deleted_at IS NULL
+		ORDER BY comments.held_at LIMIT $1 OFFSET $2`,
+		perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// ReleaseComment shows a held comment. It returns sql.ErrNoRows if the comment isn't held.
+func (s *PGStore) ReleaseComment(commentID string) error {
+	return s.execOne("UPDATE comments SET held_at = NULL WHERE id = $1 AND held_at IS NOT NULL", commentID)
+}
+
+// CountDuplicateBodies returns how many visible stories and comments created since the given time have
+// exactly the given body. Removed, hidden and held ones aren't counted.
+func (s *PGStore) CountDuplicateBodies(body string, since time.Time) (int, error) {
+	var count int
+	err := s.db.Get(&count,
+		`SELECT (SELECT COUNT(*) FROM stories WHERE body = $1 AND created_at >= $2
+			AND deleted_at IS NULL AND hidden_at IS NULL AND held_at IS NULL)
+		+ (SELECT COUNT(*) FROM comments WHERE body = $1 AND created_at >= $2
+			AND deleted_at IS NULL AND hidden_at IS NULL AND held_at IS NULL)`,
+		body, since)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// FindLinkMetadata returns the cached metadata of the page with the given canonical URL.
+// If it was never fetched, it returns nil without an error.
+func (s *PGStore) FindLinkMetadata(canonicalURL string) (*tabloid.LinkMetadata, error) {
+	metadata := tabloid.LinkMetadata{}
+	err := s.db.Get(&metadata, "SELECT * FROM link_metadata WHERE canonical_url = $1", canonicalURL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// UpsertLinkMetadata caches the metadata of a page, replacing what was previously fetched.
+func (s *PGStore) UpsertLinkMetadata(metadata *tabloid.LinkMetadata) error {
+	_, err := s.db.Exec(
+		`INSERT INTO link_metadata (canonical_url, url, title, site_name, description, image_url, error, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (canonical_url) DO UPDATE
+		SET url = $2, title = $3, site_name = $4, description = $5, image_url = $6, error = $7, fetched_at = $8`,
+		metadata.CanonicalURL, metadata.URL, metadata.Title, metadata.SiteName, metadata.Description, metadata.ImageURL, metadata.Error, metadata.FetchedAt)
+	return err
+}
+
+// ListStaleLinkMetadata returns up to limit cached metadata fetched before the given time, oldest first.
+func (s *PGStore) ListStaleLinkMetadata(before time.Time, limit int) ([]*tabloid.LinkMetadata, error) {
+	metadata := []*tabloid.LinkMetadata{}
+	err := s.db.Select(&metadata, "SELECT * FROM link_metadata WHERE fetched_at < $1 ORDER BY fetched_at LIMIT $2", before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// FindArchive returns the archive of the page with the given canonical URL.
+// If it was never archived, it returns nil without an error.
+func (s *PGStore) FindArchive(canonicalURL string) (*tabloid.Archive, error) {
+	archive := tabloid.Archive{}
+	err := s.db.Get(&archive, "SELECT * FROM archives WHERE canonical_url = $1", canonicalURL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+// InsertArchive stores the archive of a page, unless it was already archived, the first snapshot being kept.
+func (s *PGStore) InsertArchive(archive *tabloid.Archive) error {
+	_, err := s.db.Exec(
+		`INSERT INTO archives (canonical_url, url, title, text, truncated, error, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (canonical_url) DO NOTHING`,
+		archive.CanonicalURL, archive.URL, archive.Title, archive.Text, archive.Truncated, archive.Error, archive.FetchedAt)
+	return err
+}
+
+// Search returns the visible stories and comments matching the query, best matches first. Text is parsed
+// like web search engines do, with quoted phrases, "or" and "-" to exclude words. Without text, the most
+// recent stories and comments are returned.
+func (s *PGStore) Search(query *tabloid.SearchQuery, page int, perPage int) ([]*tabloid.SearchResult, error) {
+	// filters apply to both stories and comments, {table} being replaced by the one being searched
+	args := []interface{}{query.Text}
+	filters := ""
+	if query.Author != "" {
+		args = append(args, query.Author)
+		filters += fmt.Sprintf(" AND lower(users.name) = lower($%d)", len(args))
+	}
+	if !query.Since.IsZero() {
+		args = append(args, query.Since)
+		filters += fmt.Sprintf(" AND {table}.created_at >= $%d", len(args))
+	}
+	if !query.Until.IsZero() {
+		args = append(args, query.Until)
+		filters += fmt.Sprintf(" AND {table}.created_at < $%d", len(args))
+	}
+
+	storiesQuery := `SELECT stories.id::text AS story_id, NULL::text AS comment_id, COALESCE(stories.title, '') AS title,
+		COALESCE(stories.url, '') AS url, COALESCE(stories.body, '') AS body, stories.score,
+		stories.author_id::text AS author_id, users.name AS author, stories.created_at,
+		CASE WHEN $1 = '' THEN 0 ELSE ts_rank_cd(stories_search.document, websearch_to_tsquery('english', $1)) END AS rank
+		FROM stories
+		JOIN stories_search ON stories_search.story_id = stories.id
+		JOIN users ON stories.author_id = users.id
+		WHERE ($1 = '' OR stories_search.document @@ websearch_to_tsquery('english', $1))
+		AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL AND stories.held_at IS NULL
+		AND users.shadow_banned_at IS NULL` + strings.ReplaceAll(filters, "{table}", "stories")
+
+	commentsQuery := `SELECT comments.story_id::text AS story_id, comments.id::text AS comment_id,
+		COALESCE(stories.title, '') AS title, '' AS url, comments.body, comments.score,
+		comments.author_id::text AS author_id, users.name AS author, comments.created_at,
+		CASE WHEN $1 = '' THEN 0 ELSE ts_rank_cd(comments_search.document, websearch_to_tsquery('english', $1)) END AS rank
+		FROM comments
+		JOIN comments_search ON comments_search.comment_id = comments.id
+		JOIN stories ON comments.story_id = stories.id
+		JOIN users ON comments.author_id = users.id
+		WHERE ($1 = '' OR comments_search.document @@ websearch_to_tsquery('english', $1))
+		AND comments.deleted_at IS NULL AND comments.hidden_at IS NULL AND comments.held_at IS NULL
+		AND stories.deleted_at IS NULL AND stories.hidden_at IS NULL AND stories.held_at IS NULL
+		AND users.shadow_banned_at IS NULL` + strings.ReplaceAll(filters, "{table}", "comments")
+
+	var q string
+	switch query.Type {
+	case tabloid.SearchStories:
+		q = storiesQuery
+	case tabloid.SearchComments:
+		q = commentsQuery
+	default:
+		q = storiesQuery + " UNION ALL " + commentsQuery
+	}
+
+	args = append(args, perPage, page*perPage)
+	q = fmt.Sprintf("SELECT * FROM (%s) AS results ORDER BY rank DESC, created_at DESC LIMIT $%d OFFSET $%d", q, len(args)-1, len(args))
+
+	results := []*tabloid.SearchResult{}
+	err := s.db.Select(&results, q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// FindSiteSettings returns the settings of the instance, or the default ones if they were never saved.
+func (s *PGStore) FindSiteSettings() (*tabloid.SiteSettings, error) {
+	settings := tabloid.DefaultSiteSettings()
+	err := s.db.Get(settings, "SELECT settings FROM site_settings WHERE id = 1")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return tabloid.DefaultSiteSettings(), nil
+		}
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// UpdateSiteSettings saves the settings of the instance.
+func (s *PGStore) UpdateSiteSettings(settings *tabloid.SiteSettings) error {
+	_, err := s.db.Exec(
+		"INSERT INTO site_settings (id, settings, updated_at) VALUES (1, $1, $2) ON CONFLICT (id) DO UPDATE SET settings = $1, updated_at = $2",
+		settings, tabloid.NowFunc())
+	return err
+}
+
+// InsertFlag records a flag, hiding the flagged content once it has at least threshold unresolved flags.
+// A zero threshold never hides anything. Flagging the same content twice is ignored.
+func (s *PGStore) InsertFlag(flag *tabloid.Flag, threshold int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = sqlx.Get(
+		tx,
+		&id,
+		`INSERT INTO flags (story_id, comment_id, user_id, reason, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING RETURNING id`,
+		flag.StoryID, flag.CommentID, flag.UserID, flag.Reason, flag.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		// already flagged by this user
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	flag.ID = id
+
+	if threshold > 0 {
+		var count int
+		err = sqlx.Get(tx, &count,
+			"SELECT COUNT(*) FROM flags WHERE story_id = $1 AND comment_id IS NOT DISTINCT FROM $2 AND resolved_at IS NULL",
+			flag.StoryID, flag.CommentID)
+		if err != nil {
+			return err
+		}
+
+		if count >= threshold {
+			table, id := flaggedRecord(flag.StoryID, flag.CommentID)
+			_, err = tx.Exec("UPDATE "+table+" SET hidden_at = $1 WHERE id = $2 AND hidden_at IS NULL", tabloid.NowFunc(), id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListFlaggedContent returns the stories and comments having unresolved flags, the most flagged first.
+func (s *PGStore) ListFlaggedContent(page int, perPage int) ([]*tabloid.FlaggedContent, error) {
+	contents := []*tabloid.FlaggedContent{}
+	err := s.db.Select(&contents,
+		`SELECT flags.story_id, flags.comment_id, stories.title, users.name AS author,
+			COALESCE(comments.body, stories.body) AS body,
+			CASE WHEN flags.comment_id IS NULL THEN stories.hidden_at ELSE comments.hidden_at END AS hidden_at,
+			COUNT(*) AS flags_count,
+			string_agg(DISTINCT flags.reason, ', ') AS reasons,
+			MIN(flags.created_at) AS flagged_at
+		FROM flags
+		JOIN stories ON stories.id = flags.story_id
+		LEFT JOIN comments ON comments.id = flags.comment_id
+		JOIN users ON users.id = COALESCE(comments.author_id, stories.author_id)
+		WHERE flags.resolved_at IS NULL
+		GROUP BY flags.story_id, flags.comment_id, stories.title, stories.body, stories.hidden_at,
+			comments.body, comments.hidden_at, users.name
+		ORDER BY flags_count DESC, flagged_at LIMIT $1 OFFSET $2`,
+		perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// ApproveFlags resolves the flags on a story, or one of its comments if commentID is set, and removes it.
+// It returns sql.ErrNoRows if there are no unresolved flags on it.
+func (s *PGStore) ApproveFlags(storyID string, commentID sql.NullString) error {
+	return s.resolveFlags(storyID, commentID, "deleted_at = $2", tabloid.NowFunc())
+}
+
+// DismissFlags resolves the flags on a story, or one of its comments if commentID is set, showing it again
+// if it had been hidden. It returns sql.ErrNoRows if there are no unresolved flags on it.
+func (s *PGStore) DismissFlags(storyID string, commentID sql.NullString) error {
+	return s.resolveFlags(storyID, commentID, "hidden_at = NULL")
+}
+
+// resolveFlags resolves the unresolved flags on the given content, then applies the given assignments on it.
+// The assignments placeholders start at $2, $1 being the id of the content.
+func (s *PGStore) resolveFlags(storyID string, commentID sql.NullString, assignments string, args ...interface{}) error {
+	now := tabloid.NowFunc()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE flags SET resolved_at = $1 WHERE story_id = $2 AND comment_id IS NOT DISTINCT FROM $3 AND resolved_at IS NULL",
+		now, storyID, commentID)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	table, id := flaggedRecord(storyID, commentID)
+	_, err = tx.Exec("UPDATE "+table+" SET "+assignments+" WHERE id = $1", append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// flaggedRecord returns the table and the id of the flagged story or comment.
+func flaggedRecord(storyID string, commentID sql.NullString) (string, string) {
+	if commentID.Valid {
+		return "comments", commentID.String
+	}
+
+	return "stories", storyID
+}
+
+// execOne executes a statement that must affect exactly one row, returning sql.ErrNoRows otherwise.
+func (s *PGStore) execOne(query string, args ...interface{}) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *PGStore) InsertAPIToken(token *tabloid.APIToken) error {
+	var id string
+	err := s.db.Get(&id,
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		token.UserID, token.Name, token.TokenHash, token.Scopes, token.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	token.ID = id
+	return nil
+}
+
+// FindAPITokenByHash returns the token whose secret matches the given hash, unless it has been revoked.
+// If no token is found, it returns nil without an error.
+func (s *PGStore) FindAPITokenByHash(hash string) (*tabloid.APIToken, error) {
+	token := tabloid.APIToken{}
+	err := s.db.Get(&token, "SELECT * FROM api_tokens WHERE token_hash = $1 AND revoked_at IS NULL", hash)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ListAPITokens returns the tokens of a given user that haven't been revoked, most recent first.
+func (s *PGStore) ListAPITokens(userID string) ([]*tabloid.APIToken, error) {
+	tokens := []*tabloid.APIToken{}
+	err := s.db.Select(&tokens, "SELECT * FROM api_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes a token, provided it belongs to the given user. If there is no such token,
+// it returns sql.ErrNoRows.
+func (s *PGStore) RevokeAPIToken(userID string, tokenID string) error {
+	res, err := s.db.Exec("UPDATE api_tokens SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		tabloid.NowFunc(), tokenID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchAPIToken records that a token has just been used.
+func (s *PGStore) TouchAPIToken(tokenID string) error {
+	_, err := s.db.Exec("UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", tabloid.NowFunc(), tokenID)
+	return err
+}
+
+func (s *PGStore) CreateSession(session *tabloid.Session) error {
+	var id string
+	err := s.db.Get(&id,
+		"INSERT INTO sessions (user_id, token_hash, provider, user_agent, ip, created_at, last_seen_at, csrf_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		session.UserID, session.TokenHash, session.Provider, session.UserAgent, session.IP, session.CreatedAt, session.LastSeenAt, session.CSRFToken)
+
+	if err != nil {
+		return err
+	}
+
+	session.ID = id
+	return nil
+}
+
+// FindSessionByHash returns the session whose secret matches the given hash, along the name of its user.
+// If no session is found, it returns nil without an error.
+func (s *PGStore) FindSessionByHash(hash string) (*tabloid.Session, error) {
+	session := tabloid.Session{}
+	err := s.db.Get(&session,
+		"SELECT sessions.*, users.name AS login, users.role AS role, users.banned_at, users.suspended_until, users.suspension_reason, users.karma FROM sessions JOIN users ON sessions.user_id = users.id WHERE sessions.token_hash = $1",
+		hash)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ListSessions returns the sessions of the given user, most recently seen first.
+func (s *PGStore) ListSessions(userID string) ([]*tabloid.Session, error) {
+	sessions := []*tabloid.Session{}
+	err := s.db.Select(&sessions,
+		"SELECT sessions.*, users.name AS login, users.role AS role, users.banned_at, users.suspended_until, users.suspension_reason, users.karma FROM sessions JOIN users ON sessions.user_id = users.id WHERE sessions.user_id = $1 ORDER BY sessions.last_seen_at DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that a session has been used at the given time.
+func (s *PGStore) TouchSession(sessionID string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = $1 WHERE id = $2", at, sessionID)
+	return err
+}
+
+// DeleteSession deletes a session, provided it belongs to the given user. If there is no such session,
+// it returns sql.ErrNoRows.
+func (s *PGStore) DeleteSession(userID string, sessionID string) error {
+	res, err := s.db.Exec("DELETE FROM sessions WHERE id = $1 AND user_id = $2", sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteUserSessions deletes all sessions of the given user, signing them out everywhere.
+func (s *PGStore) DeleteUserSessions(userID string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	return err
+}
+
+// CreateOrUpdateVoteOnStory records the vote of a user on a story, returning true if it's a new one. Rows
+// which were just inserted are told apart by their xmax being zero.
+func (s *PGStore) CreateOrUpdateVoteOnStory(storyID string, userID string, up bool) (bool, error) {
+	now := time.Now()
+	var created bool
+	err := s.db.Get(&created, "INSERT INTO votes (story_id, user_id, up, created_at) VALUES ($1, $2, $3, $4) ON CONFlICT (user_id, story_id) WHERE comment_id IS NULL DO UPDATE SET up = $5 RETURNING (xmax = 0)",
+		storyID, userID, up, now, up)
+
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// CreateOrUpdateVoteOnComment records the vote of a user on a comment, returning true if it's a new one.
+func (s *PGStore) CreateOrUpdateVoteOnComment(commentID string, userID string, up bool) (bool, error) {
+	now := time.Now()
+	var created bool
+	err := s.db.Get(&created, "INSERT INTO votes (comment_id, user_id, up, created_at) VALUES ($1, $2, $3, $4) ON CONFlICT (user_id, comment_id) WHERE story_id IS NULL DO UPDATE SET up = $5 RETURNING (xmax = 0)",
+		commentID, userID, up, now, up)
+
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// InsertModerationLogEntry appends an entry to the moderation log.
+func (s *PGStore) InsertModerationLogEntry(entry *tabloid.ModerationLogEntry) error {
+	var id string
+	err := s.db.Get(
+		&id,
+		`INSERT INTO moderation_log (moderator_id, action, story_id, comment_id, user_id, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		entry.ModeratorID, entry.Action, entry.StoryID, entry.CommentID, entry.UserID, entry.Reason, entry.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	entry.ID = id
+	return nil
+}
+
+// ListModerationLog returns the moderation log, most recent entries first. Private actions are left out
+// unless includePrivate is true.
+func (s *PGStore) ListModerationLog(includePrivate bool, page int, perPage int) ([]*tabloid.ModerationLogEntry, error) {
+	private := make([]string, len(tabloid.PrivateModerationActions))
+	for i, a := range tabloid.PrivateModerationActions {
+		private[i] = string(a)
+	}
+
+	entries := []*tabloid.ModerationLogEntry{}
+	err := s.db.Select(&entries,
+		`SELECT moderation_log.*, moderators.name AS moderator, stories.title AS story_title, users.name AS user_name
+		FROM moderation_log
+		LEFT JOIN users moderators ON moderators.id = moderation_log.moderator_id
+		LEFT JOIN stories ON stories.id = moderation_log.story_id
+		LEFT JOIN users ON users.id = moderation_log.user_id
+		WHERE $1 OR NOT moderation_log.action = ANY($2)
+		ORDER BY moderation_log.created_at DESC, moderation_log.id DESC LIMIT $3 OFFSET $4`,
+		includePrivate, pq.Array(private), perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// TakeRateLimitToken consumes a token from the bucket identified by key, see tabloid.RateLimiter. The bucket
+// is locked while being updated, so concurrent requests from other instances don't overdraw it.
+func (s *PGStore) TakeRateLimitToken(key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	now := tabloid.NowFunc()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	bucket := tabloid.NewTokenBucket(limit, now)
+	_, err = tx.Exec("INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING",
+		key, bucket.Tokens, bucket.UpdatedAt)
+	if err != nil {
+		return false, 0, err
+	}
+
+	err = sqlx.Get(tx, bucket, "SELECT tokens, updated_at FROM rate_limits WHERE key = $1 FOR UPDATE", key)
+	if err != nil {
+		return false, 0, err
+	}
+
+	allowed, retryAfter := bucket.Take(now, limit, period)
+	_, err = tx.Exec("UPDATE rate_limits SET tokens = $1, updated_at = $2 WHERE key = $3", bucket.Tokens, bucket.UpdatedAt, key)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return allowed, retryAfter, tx.Commit()
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func (s *PGStore) ListWebhooks() ([]*tabloid.Webhook, error) {
+	webhooks := []*tabloid.Webhook{}
+	err := s.db.Select(&webhooks, "SELECT * FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// FindWebhook returns the webhook with the given id. If no webhook is found, it returns nil without an error.
+func (s *PGStore) FindWebhook(id string) (*tabloid.Webhook, error) {
+	webhook := tabloid.Webhook{}
+	err := s.db.Get(&webhook, "SELECT * FROM webhooks WHERE id = $1", id)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (s *PGStore) InsertWebhook(webhook *tabloid.Webhook) error {
+	var id string
+	err := s.db.Get(&id,
+		"INSERT INTO webhooks (url, secret, events, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		webhook.URL, webhook.Secret, webhook.Events, webhook.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	webhook.ID = id
+	return nil
+}
+
+// DeleteWebhook removes a webhook, its deliveries going along.
+func (s *PGStore) DeleteWebhook(id string) error {
+	_, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1", id)
+	return err
+}
+
+func (s *PGStore) InsertWebhookDelivery(delivery *tabloid.WebhookDelivery) error {
+	var id string
+	err := s.db.Get(&id,
+		"INSERT INTO webhook_deliveries (webhook_id, event, payload, attempts, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		delivery.WebhookID, delivery.Event, delivery.Payload, delivery.Attempts, delivery.NextAttemptAt, delivery.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	delivery.ID = id
+	return nil
+}
+
+// UpdateWebhookDelivery records how the last attempt of a delivery went.
+func (s *PGStore) UpdateWebhookDelivery(delivery *tabloid.WebhookDelivery) error {
+	return s.execOne(
+		`UPDATE webhook_deliveries SET attempts = $1, next_attempt_at = $2, delivered_at = $3, failed_at = $4,
+		last_status = $5, last_error = $6 WHERE id = $7`,
+		delivery.Attempts, delivery.NextAttemptAt, delivery.DeliveredAt, delivery.FailedAt,
+		delivery.LastStatus, delivery.LastError, delivery.ID)
+}
+
+// ClaimDueWebhookDeliveries returns the pending deliveries due at the given time, oldest first, pushing their
+// next attempt to the given lease. Rows being claimed by another transaction are skipped rather than waited
+// for, so that concurrent servers never claim the same delivery.
+func (s *PGStore) ClaimDueWebhookDeliveries(at time.Time, lease time.Time, limit int) ([]*tabloid.WebhookDelivery, error) {
+	deliveries := []*tabloid.WebhookDelivery{}
+	err := s.db.Select(&deliveries,
+		`WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = $2
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE next_attempt_at <= $1
+				ORDER BY next_attempt_at, id LIMIT $3
+				FOR UPDATE SKIP LOCKED)
+			RETURNING *)
+		SELECT claimed.*, webhooks.url
+		FROM claimed
+		JOIN webhooks ON webhooks.id = claimed.webhook_id
+		ORDER BY claimed.created_at, claimed.id`,
+		at, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ListWebhookDeliveries returns the deliveries of every webhook, most recent first.
+func (s *PGStore) ListWebhookDeliveries(page int, perPage int) ([]*tabloid.WebhookDelivery, error) {
+	deliveries := []*tabloid.WebhookDelivery{}
+	err := s.db.Select(&deliveries,
+		`SELECT webhook_deliveries.*, webhooks.url
+		FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+		ORDER BY webhook_deliveries.created_at DESC, webhook_deliveries.id DESC LIMIT $1 OFFSET $2`,
+		perPage, page*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
 }
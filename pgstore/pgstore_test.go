@@ -2,7 +2,9 @@ package pgstore
 
 import (
 	"database/sql"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/jhchabran/tabloid"
 
@@ -80,7 +82,7 @@ func TestPGStore(t *testing.T) {
 		comment := tabloid.NewComment(story.ID, sql.NullString{}, "foobar", userB)
 		err = store.InsertComment(comment)
 		c.Assert(err, qt.IsNil)
-		err = store.CreateOrUpdateVoteOnComment(comment.ID, userA, true)
+		_, err = store.CreateOrUpdateVoteOnComment(comment.ID, userA, true)
 		c.Assert(err, qt.IsNil)
 		c.Assert(0, qt.Not(qt.Equals), comment.ID)
 
@@ -93,7 +95,7 @@ func TestPGStore(t *testing.T) {
 		otherComment := tabloid.NewComment(otherStory.ID, sql.NullString{}, "other foobar", userB)
 		err = store.InsertComment(otherComment)
 		c.Assert(err, qt.IsNil)
-		err = store.CreateOrUpdateVoteOnComment(otherComment.ID, userA, true)
+		_, err = store.CreateOrUpdateVoteOnComment(otherComment.ID, userA, true)
 		c.Assert(err, qt.IsNil)
 		c.Assert(0, qt.Not(qt.Equals), otherComment.ID)
 
@@ -164,6 +166,188 @@ func TestPGStore(t *testing.T) {
 		})
 	})
 
+	c.Run("Signing in from an identity", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE identities;")
+		})
+
+		c.Run("OK first sign in creates the user", func(c *qt.C) {
+			id, err := store.CreateOrUpdateUserFromIdentity("github", "foobar", "foobar@foobar.com")
+			c.Assert(err, qt.IsNil)
+
+			user, err := store.FindUserByIdentity("github", "foobar")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.ID, qt.Equals, id)
+			c.Assert(user.Name, qt.Equals, "foobar")
+		})
+
+		c.Run("OK subsequent sign ins return the same user", func(c *qt.C) {
+			first, err := store.CreateOrUpdateUserFromIdentity("github", "barfoo", "barfoo@foobar.com")
+			c.Assert(err, qt.IsNil)
+			second, err := store.CreateOrUpdateUserFromIdentity("github", "barfoo", "barfoo@foobar.com")
+			c.Assert(err, qt.IsNil)
+			c.Assert(second, qt.Equals, first)
+		})
+
+		c.Run("OK same login on another provider doesn't collide", func(c *qt.C) {
+			github, err := store.CreateOrUpdateUserFromIdentity("github", "alpha", "alpha@foobar.com")
+			c.Assert(err, qt.IsNil)
+			gitlab, err := store.CreateOrUpdateUserFromIdentity("gitlab", "alpha", "alpha@foobar.com")
+			c.Assert(err, qt.IsNil)
+			c.Assert(gitlab, qt.Not(qt.Equals), github)
+
+			user, err := store.FindUserByIdentity("gitlab", "alpha")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.Name, qt.Equals, "alpha-gitlab")
+		})
+
+		c.Run("unknown identity", func(c *qt.C) {
+			user, err := store.FindUserByIdentity("github", "non-existing")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user, qt.IsNil)
+		})
+	})
+
+	c.Run("Linking an identity", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE identities;")
+		})
+
+		userA, err := store.CreateOrUpdateUserFromIdentity("github", "a", "a@a.com")
+		c.Assert(err, qt.IsNil)
+		userB, err := store.CreateOrUpdateUserFromIdentity("github", "b", "b@b.com")
+		c.Assert(err, qt.IsNil)
+
+		c.Run("OK", func(c *qt.C) {
+			ownerID, err := store.LinkIdentity(userA, "gitlab", "a-on-gitlab", "a@a.com")
+			c.Assert(err, qt.IsNil)
+			c.Assert(ownerID, qt.Equals, userA)
+
+			user, err := store.FindUserByIdentity("gitlab", "a-on-gitlab")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.ID, qt.Equals, userA)
+		})
+
+		c.Run("identity already linked to someone else", func(c *qt.C) {
+			ownerID, err := store.LinkIdentity(userB, "gitlab", "a-on-gitlab", "a@a.com")
+			c.Assert(err, qt.IsNil)
+			c.Assert(ownerID, qt.Equals, userA)
+		})
+	})
+
+	c.Run("Personal access tokens", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE api_tokens;")
+		})
+
+		token, secret, err := tabloid.NewAPIToken("1", "ci", tabloid.Scopes{tabloid.ScopeSubmit, tabloid.ScopeVote})
+		c.Assert(err, qt.IsNil)
+		err = store.InsertAPIToken(token)
+		c.Assert(err, qt.IsNil)
+		c.Assert(token.ID, qt.Not(qt.Equals), "")
+
+		c.Run("OK find by hash", func(c *qt.C) {
+			found, err := store.FindAPITokenByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.ID, qt.Equals, token.ID)
+			c.Assert(found.Scopes, qt.DeepEquals, tabloid.Scopes{tabloid.ScopeSubmit, tabloid.ScopeVote})
+			c.Assert(found.LastUsedAt.Valid, qt.IsFalse)
+		})
+
+		c.Run("OK touch", func(c *qt.C) {
+			err := store.TouchAPIToken(token.ID)
+			c.Assert(err, qt.IsNil)
+
+			found, err := store.FindAPITokenByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.LastUsedAt.Valid, qt.IsTrue)
+		})
+
+		c.Run("OK list", func(c *qt.C) {
+			tokens, err := store.ListAPITokens("1")
+			c.Assert(err, qt.IsNil)
+			c.Assert(tokens, qt.HasLen, 1)
+
+			tokens, err = store.ListAPITokens("2")
+			c.Assert(err, qt.IsNil)
+			c.Assert(tokens, qt.HasLen, 0)
+		})
+
+		c.Run("cannot revoke someone else's token", func(c *qt.C) {
+			err := store.RevokeAPIToken("2", token.ID)
+			c.Assert(err, qt.Equals, sql.ErrNoRows)
+		})
+
+		c.Run("OK revoke", func(c *qt.C) {
+			err := store.RevokeAPIToken("1", token.ID)
+			c.Assert(err, qt.IsNil)
+
+			found, err := store.FindAPITokenByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.IsNil)
+		})
+	})
+
+	c.Run("Sessions", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE identities;")
+			store.DB().MustExec("TRUNCATE TABLE sessions;")
+		})
+
+		userID, err := store.CreateOrUpdateUserFromIdentity("github", "alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		user, err := store.FindUserByID(userID)
+		c.Assert(err, qt.IsNil)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		session, secret, err := tabloid.NewSession(user, "github", req)
+		c.Assert(err, qt.IsNil)
+		err = store.CreateSession(session)
+		c.Assert(err, qt.IsNil)
+		c.Assert(session.ID, qt.Not(qt.Equals), "")
+
+		c.Run("OK find by hash", func(c *qt.C) {
+			found, err := store.FindSessionByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.ID, qt.Equals, session.ID)
+			c.Assert(found.Login, qt.Equals, "alice")
+			c.Assert(found.Provider, qt.Equals, "github")
+		})
+
+		c.Run("OK touch", func(c *qt.C) {
+			at := tabloid.NowFunc().Add(time.Hour)
+			err := store.TouchSession(session.ID, at)
+			c.Assert(err, qt.IsNil)
+
+			found, err := store.FindSessionByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.LastSeenAt.Unix(), qt.Equals, at.Unix())
+		})
+
+		c.Run("OK list", func(c *qt.C) {
+			sessions, err := store.ListSessions(userID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(sessions, qt.HasLen, 1)
+		})
+
+		c.Run("cannot delete someone else's session", func(c *qt.C) {
+			err := store.DeleteSession(userID+"0", session.ID)
+			c.Assert(err, qt.Equals, sql.ErrNoRows)
+		})
+
+		c.Run("OK delete all", func(c *qt.C) {
+			err := store.DeleteUserSessions(userID)
+			c.Assert(err, qt.IsNil)
+
+			found, err := store.FindSessionByHash(tabloid.HashAPIToken(secret))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.IsNil)
+		})
+	})
+
 	c.Run("Updating a user", func(c *qt.C) {
 		c.Cleanup(func() {
 			store.DB().MustExec("TRUNCATE TABLE users;")
@@ -196,5 +380,752 @@ func TestPGStore(t *testing.T) {
 			c.Assert(user.Settings.SendDailyDigest, qt.IsFalse)
 		})
 
+		c.Run("OK role", func(c *qt.C) {
+			user, err := store.FindUserByLogin("foobar")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.Role, qt.Equals, tabloid.RoleMember)
+
+			err = store.UpdateUserRole(user.ID, tabloid.RoleAdmin)
+			c.Assert(err, qt.IsNil)
+
+			user, err = store.FindUserByLogin("foobar")
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.Role, qt.Equals, tabloid.RoleAdmin)
+		})
+
+	})
+	c.Run("Administration", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+			store.DB().MustExec("TRUNCATE TABLE site_settings;")
+		})
+
+		userID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		_, err = store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("foo", "body", userID, "http://foobar.com")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "some comment", userID)
+		c.Assert(store.InsertComment(comment), qt.IsNil)
+
+		c.Run("OK list users", func(c *qt.C) {
+			users, err := store.ListUsers("", 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(users, qt.HasLen, 2)
+
+			users, err = store.ListUsers("ali", 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(users, qt.HasLen, 1)
+			c.Assert(users[0].Name, qt.Equals, "alice")
+		})
+
+		c.Run("OK ban and unban", func(c *qt.C) {
+			c.Assert(store.BanUser(userID), qt.IsNil)
+			user, err := store.FindUserByID(userID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.IsBanned(), qt.IsTrue)
+
+			c.Assert(store.UnbanUser(userID), qt.IsNil)
+			user, err = store.FindUserByID(userID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.IsBanned(), qt.IsFalse)
+		})
+
+		c.Run("ban non existing user", func(c *qt.C) {
+			c.Assert(store.BanUser(userID+"0"), qt.Equals, sql.ErrNoRows)
+		})
+
+		c.Run("OK pin story", func(c *qt.C) {
+			until := sql.NullTime{Time: tabloid.NowFunc().Add(time.Hour), Valid: true}
+			c.Assert(store.PinStory(story.ID, until), qt.IsNil)
+
+			found, err := store.FindStory(story.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.IsPinned(tabloid.NowFunc()), qt.IsTrue)
+		})
+
+		c.Run("OK remove comment", func(c *qt.C) {
+			c.Assert(store.RemoveComment(comment.ID), qt.IsNil)
+
+			comments, err := store.ListRecentComments("", 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(comments, qt.HasLen, 1)
+			c.Assert(comments[0].IsRemoved(), qt.IsTrue)
+		})
+
+		c.Run("OK remove story", func(c *qt.C) {
+			c.Assert(store.RemoveStory(story.ID), qt.IsNil)
+
+			_, err := store.FindStory(story.ID)
+			c.Assert(err, qt.Equals, sql.ErrNoRows)
+
+			stories, err := store.ListRecentStories("foo", 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 1)
+			c.Assert(stories[0].IsRemoved(), qt.IsTrue)
+		})
+
+		c.Run("OK site settings", func(c *qt.C) {
+			settings, err := store.FindSiteSettings()
+			c.Assert(err, qt.IsNil)
+			c.Assert(settings, qt.DeepEquals, tabloid.DefaultSiteSettings())
+
+			settings.Announcement = "Hello"
+			c.Assert(store.UpdateSiteSettings(settings), qt.IsNil)
+
+			settings, err = store.FindSiteSettings()
+			c.Assert(err, qt.IsNil)
+			c.Assert(settings.Announcement, qt.Equals, "Hello")
+		})
+	})
+	c.Run("Flags", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+			store.DB().MustExec("TRUNCATE TABLE flags;")
+		})
+
+		authorID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		bobID, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+		carolID, err := store.CreateOrUpdateUser("carol", "carol@carol.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("foo", "body", authorID, "http://foobar.com")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "some comment", authorID)
+		c.Assert(store.InsertComment(comment), qt.IsNil)
+		commentID := sql.NullString{String: comment.ID, Valid: true}
+
+		c.Run("OK flagging twice is ignored", func(c *qt.C) {
+			c.Assert(store.InsertFlag(tabloid.NewFlag(story.ID, sql.NullString{}, bobID, tabloid.FlagSpam), 2), qt.IsNil)
+			c.Assert(store.InsertFlag(tabloid.NewFlag(story.ID, sql.NullString{}, bobID, tabloid.FlagAbusive), 2), qt.IsNil)
+
+			contents, err := store.ListFlaggedContent(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(contents, qt.HasLen, 1)
+			c.Assert(contents[0].FlagsCount, qt.Equals, int64(1))
+			c.Assert(contents[0].IsHidden(), qt.IsFalse)
+		})
+
+		c.Run("OK threshold hides the story", func(c *qt.C) {
+			c.Assert(store.InsertFlag(tabloid.NewFlag(story.ID, sql.NullString{}, carolID, tabloid.FlagOffTopic), 2), qt.IsNil)
+
+			stories, err := store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 0)
+
+			contents, err := store.ListFlaggedContent(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(contents[0].IsHidden(), qt.IsTrue)
+			c.Assert(contents[0].Reasons, qt.Equals, "off-topic, spam")
+		})
+
+		c.Run("OK dismiss shows the story again", func(c *qt.C) {
+			c.Assert(store.DismissFlags(story.ID, sql.NullString{}), qt.IsNil)
+
+			stories, err := store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 1)
+
+			c.Assert(store.DismissFlags(story.ID, sql.NullString{}), qt.Equals, sql.ErrNoRows)
+		})
+
+		c.Run("OK approve removes the comment", func(c *qt.C) {
+			c.Assert(store.InsertFlag(tabloid.NewFlag(story.ID, commentID, bobID, tabloid.FlagAbusive), 2), qt.IsNil)
+
+			contents, err := store.ListFlaggedContent(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(contents, qt.HasLen, 1)
+			c.Assert(contents[0].IsComment(), qt.IsTrue)
+			c.Assert(contents[0].Body, qt.Equals, "some comment")
+
+			c.Assert(store.ApproveFlags(story.ID, commentID), qt.IsNil)
+
+			found, err := store.FindComment(comment.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.IsRemoved(), qt.IsTrue)
+
+			contents, err = store.ListFlaggedContent(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(contents, qt.HasLen, 0)
+		})
+	})
+	c.Run("User restrictions", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		aliceID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		bobID, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+
+		c.Run("OK suspend and unsuspend", func(c *qt.C) {
+			until := tabloid.NowFunc().Add(time.Hour)
+			c.Assert(store.SuspendUser(aliceID, until, "spam"), qt.IsNil)
+
+			user, err := store.FindUserByID(aliceID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.IsSuspended(tabloid.NowFunc()), qt.IsTrue)
+			c.Assert(user.SuspensionReason, qt.Equals, "spam")
+
+			c.Assert(store.UnsuspendUser(aliceID), qt.IsNil)
+			user, err = store.FindUserByID(aliceID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(user.IsSuspended(tabloid.NowFunc()), qt.IsFalse)
+		})
+
+		c.Run("OK shadow-banned content is only listed for its author", func(c *qt.C) {
+			story := tabloid.NewStory("foo", "body", aliceID, "http://foobar.com")
+			c.Assert(store.InsertStory(story), qt.IsNil)
+			comment := tabloid.NewComment(story.ID, sql.NullString{}, "some comment", aliceID)
+			c.Assert(store.InsertComment(comment), qt.IsNil)
+
+			c.Assert(store.ShadowBanUser(aliceID), qt.IsNil)
+
+			stories, err := store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 0)
+
+			seen, err := store.ListStoriesWithVotes(bobID, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(seen, qt.HasLen, 0)
+
+			seen, err = store.ListStoriesWithVotes(aliceID, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(seen, qt.HasLen, 1)
+
+			comments, err := store.ListComments(story.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(comments, qt.HasLen, 0)
+
+			commentsSeen, err := store.ListCommentsWithVotes(story.ID, aliceID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(commentsSeen, qt.HasLen, 1)
+
+			c.Assert(store.UnshadowBanUser(aliceID), qt.IsNil)
+
+			stories, err = store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 1)
+		})
+	})
+	c.Run("Locking and pinning stories", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		userID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+
+		old := tabloid.NewStory("old", "body", userID, "http://foobar.com")
+		c.Assert(store.InsertStory(old), qt.IsNil)
+		recent := tabloid.NewStory("recent", "body", userID, "http://foobar.com")
+		c.Assert(store.InsertStory(recent), qt.IsNil)
+		store.DB().MustExec("UPDATE stories SET created_at = $1 WHERE id = $2", tabloid.NowFunc().Add(-72*time.Hour), old.ID)
+
+		c.Run("OK lock", func(c *qt.C) {
+			c.Assert(store.LockStory(old.ID, true), qt.IsNil)
+			found, err := store.FindStory(old.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.Locked, qt.IsTrue)
+
+			c.Assert(store.LockStory(old.ID, false), qt.IsNil)
+			found, err = store.FindStory(old.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.Locked, qt.IsFalse)
+		})
+
+		c.Run("OK pinned stories are listed first", func(c *qt.C) {
+			stories, err := store.ListStories(0, 1)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories[0].ID, qt.Equals, recent.ID)
+
+			until := sql.NullTime{Time: tabloid.NowFunc().Add(time.Hour), Valid: true}
+			c.Assert(store.PinStory(old.ID, until), qt.IsNil)
+
+			stories, err = store.ListStories(0, 1)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories[0].ID, qt.Equals, old.ID)
+
+			seen, err := store.ListStoriesWithVotes(userID, 0, 1)
+			c.Assert(err, qt.IsNil)
+			c.Assert(seen[0].ID, qt.Equals, old.ID)
+		})
+	})
+
+	c.Run("Moderation log", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE moderation_log;")
+		})
+
+		modID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		userID, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+		story := tabloid.NewStory("title", "body", userID, "http://foobar.com")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+
+		removal := tabloid.NewModerationLogEntry(modID, tabloid.ModerationRemoveStory, "spam").OnStory(story.ID)
+		c.Assert(store.InsertModerationLogEntry(removal), qt.IsNil)
+		c.Assert(removal.ID, qt.Not(qt.Equals), "")
+		shadowBan := tabloid.NewModerationLogEntry(modID, tabloid.ModerationShadowBanUser, "").OnUser(userID)
+		shadowBan.CreatedAt = removal.CreatedAt.Add(time.Second)
+		c.Assert(store.InsertModerationLogEntry(shadowBan), qt.IsNil)
+		automated := tabloid.NewModerationLogEntry("", tabloid.ModerationLockStory, "").OnStory(story.ID)
+		automated.CreatedAt = removal.CreatedAt.Add(2 * time.Second)
+		c.Assert(store.InsertModerationLogEntry(automated), qt.IsNil)
+
+		c.Run("OK public entries", func(c *qt.C) {
+			entries, err := store.ListModerationLog(false, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(entries, qt.HasLen, 2)
+			c.Assert(entries[0].IsAutomated(), qt.IsTrue)
+			c.Assert(entries[1].Moderator.String, qt.Equals, "alice")
+			c.Assert(entries[1].StoryTitle.String, qt.Equals, "title")
+			c.Assert(entries[1].Reason, qt.Equals, "spam")
+		})
+
+		c.Run("OK private entries", func(c *qt.C) {
+			entries, err := store.ListModerationLog(true, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(entries, qt.HasLen, 3)
+			c.Assert(entries[1].Action, qt.Equals, tabloid.ModerationShadowBanUser)
+			c.Assert(entries[1].UserName.String, qt.Equals, "bob")
+		})
+
+		c.Run("OK append-only", func(c *qt.C) {
+			store.DB().MustExec("DELETE FROM moderation_log")
+			store.DB().MustExec("UPDATE moderation_log SET reason = 'nothing to see'")
+
+			entries, err := store.ListModerationLog(true, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(entries, qt.HasLen, 3)
+			c.Assert(entries[2].Reason, qt.Equals, "spam")
+		})
+	})
+
+	c.Run("Rate limits", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE rate_limits;")
+		})
+
+		for i := 0; i < 2; i++ {
+			ok, _, err := store.TakeRateLimitToken("submit:1", 2, time.Hour)
+			c.Assert(err, qt.IsNil)
+			c.Assert(ok, qt.IsTrue)
+		}
+
+		ok, retryAfter, err := store.TakeRateLimitToken("submit:1", 2, time.Hour)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsFalse)
+		c.Assert(retryAfter > 0, qt.IsTrue)
+
+		ok, _, err = store.TakeRateLimitToken("submit:2", 2, time.Hour)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+	})
+
+	c.Run("Karma and held stories", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		authorID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		voterID, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("title", "body", authorID, "http://foobar.com")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+
+		c.Run("OK votes from others change the karma", func(c *qt.C) {
+			author, err := store.FindUserByID(authorID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(author.Karma, qt.Equals, 0)
+
+			created, err := store.CreateOrUpdateVoteOnStory(story.ID, voterID, true)
+			c.Assert(err, qt.IsNil)
+			c.Assert(created, qt.IsTrue)
+			author, err = store.FindUserByID(authorID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(author.Karma, qt.Equals, 1)
+
+			// changing the vote
+			created, err = store.CreateOrUpdateVoteOnStory(story.ID, voterID, false)
+			c.Assert(err, qt.IsNil)
+			c.Assert(created, qt.IsFalse)
+			author, err = store.FindUserByID(authorID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(author.Karma, qt.Equals, -1)
+
+			found, err := store.FindStory(story.ID)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found.Score, qt.Equals, int64(0))
+			c.Assert(found.AuthorCreatedAt.Valid, qt.IsTrue)
+		})
+
+		c.Run("OK held stories aren't listed until released", func(c *qt.C) {
+			held := tabloid.NewStory("held", "body", authorID, "http://foobar.com")
+			held.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+			c.Assert(store.InsertStory(held), qt.IsNil)
+
+			stories, err := store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 1)
+
+			// its author can see it
+			seen, err := store.ListStoriesWithVotes(authorID, 0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(seen, qt.HasLen, 2)
+
+			heldStories, err := store.ListHeldStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(heldStories, qt.HasLen, 1)
+			c.Assert(heldStories[0].ID, qt.Equals, held.ID)
+
+			c.Assert(store.ReleaseStory(held.ID), qt.IsNil)
+			c.Assert(store.ReleaseStory(held.ID), qt.Equals, sql.ErrNoRows)
+
+			stories, err = store.ListStories(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(stories, qt.HasLen, 2)
+		})
+	})
+
+	c.Run("Held comments and duplicate bodies", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		authorID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("title", "buy now", authorID, "")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "buy now", authorID)
+		comment.HeldAt = sql.NullTime{Time: tabloid.NowFunc(), Valid: true}
+		c.Assert(store.InsertComment(comment), qt.IsNil)
+
+		c.Run("OK held comments are listed until released", func(c *qt.C) {
+			held, err := store.ListHeldComments(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(held, qt.HasLen, 1)
+			c.Assert(held[0].ID, qt.Equals, comment.ID)
+			c.Assert(held[0].Author, qt.Equals, "alice")
+
+			c.Assert(store.ReleaseComment(comment.ID), qt.IsNil)
+			c.Assert(store.ReleaseComment(comment.ID), qt.Equals, sql.ErrNoRows)
+
+			held, err = store.ListHeldComments(0, 10)
+			c.Assert(err, qt.IsNil)
+			c.Assert(held, qt.HasLen, 0)
+		})
+
+		c.Run("OK duplicates are counted within the window", func(c *qt.C) {
+			count, err := store.CountDuplicateBodies("buy now", tabloid.NowFunc().Add(-time.Hour))
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 2)
+
+			count, err = store.CountDuplicateBodies("buy now", tabloid.NowFunc().Add(time.Hour))
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 0)
+
+			count, err = store.CountDuplicateBodies("something else", tabloid.NowFunc().Add(-time.Hour))
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 0)
+
+			// removed ones aren't
+			c.Assert(store.RemoveStory(story.ID), qt.IsNil)
+			count, err = store.CountDuplicateBodies("buy now", tabloid.NowFunc().Add(-time.Hour))
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 1)
+		})
+	})
+
+	c.Run("Canonical URLs", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		authorID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("title", "", authorID, "https://www.foobar.com/a/?utm_source=x")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+
+		c.Run("OK finds the story by its canonical URL", func(c *qt.C) {
+			found, err := store.FindStoryByCanonicalURL(tabloid.CanonicalURL("http://foobar.com/a"))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.Not(qt.IsNil))
+			c.Assert(found.ID, qt.Equals, story.ID)
+
+			found, err = store.FindStoryByCanonicalURL(tabloid.CanonicalURL("http://foobar.com/b"))
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.IsNil)
+		})
+
+		c.Run("OK removed stories are ignored", func(c *qt.C) {
+			c.Assert(store.RemoveStory(story.ID), qt.IsNil)
+			found, err := store.FindStoryByCanonicalURL(story.CanonicalURL)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.IsNil)
+		})
+
+		c.Run("OK older stories are canonicalized", func(c *qt.C) {
+			store.DB().MustExec("UPDATE stories SET canonical_url = '', deleted_at = NULL")
+			count, err := store.CanonicalizeURLs()
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 1)
+
+			found, err := store.FindStoryByCanonicalURL("foobar.com/a")
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.Not(qt.IsNil))
+		})
+	})
+
+	c.Run("Domains", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+		})
+
+		authorID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(store.InsertStory(tabloid.NewStory("a", "", authorID, "https://www.foobar.com/a")), qt.IsNil)
+		c.Assert(store.InsertStory(tabloid.NewStory("b", "", authorID, "https://foobar.com/b")), qt.IsNil)
+		c.Assert(store.InsertStory(tabloid.NewStory("c", "", authorID, "https://other.com/c")), qt.IsNil)
+		c.Assert(store.InsertStory(tabloid.NewStory("d", "Ask", authorID, "")), qt.IsNil)
+
+		stories, err := store.ListStoriesByDomain("foobar.com", 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stories, qt.HasLen, 2)
+		c.Assert(stories[0].Domain, qt.Equals, "foobar.com")
+
+		seen, err := store.ListStoriesByDomainWithVotes("foobar.com", authorID, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(seen, qt.HasLen, 2)
+		c.Assert(seen[0].Up.Bool, qt.IsTrue)
+	})
+
+	c.Run("Stories by author", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+		})
+
+		aliceID, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		bobID, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(store.InsertStory(tabloid.NewStory("a", "", aliceID, "https://foobar.com/a")), qt.IsNil)
+		c.Assert(store.InsertStory(tabloid.NewStory("b", "", bobID, "https://foobar.com/b")), qt.IsNil)
+
+		stories, err := store.ListStoriesByAuthor(aliceID, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stories, qt.HasLen, 1)
+		c.Assert(stories[0].Title, qt.Equals, "a")
+		c.Assert(stories[0].Author, qt.Equals, "alice")
+	})
+
+	c.Run("Link metadata", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE link_metadata;")
+		})
+
+		found, err := store.FindLinkMetadata("foobar.com")
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.IsNil)
+
+		metadata := &tabloid.LinkMetadata{CanonicalURL: "foobar.com", URL: "https://foobar.com", Error: "timeout", FetchedAt: tabloid.NowFunc()}
+		c.Assert(store.UpsertLinkMetadata(metadata), qt.IsNil)
+
+		metadata.Title = "Foobar"
+		metadata.SiteName = "Foo"
+		metadata.Error = ""
+		c.Assert(store.UpsertLinkMetadata(metadata), qt.IsNil)
+
+		found, err = store.FindLinkMetadata("foobar.com")
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Title, qt.Equals, "Foobar")
+		c.Assert(found.SiteName, qt.Equals, "Foo")
+		c.Assert(found.Error, qt.Equals, "")
+
+		old := &tabloid.LinkMetadata{CanonicalURL: "old.com", URL: "https://old.com", FetchedAt: tabloid.NowFunc().Add(-48 * time.Hour)}
+		c.Assert(store.UpsertLinkMetadata(old), qt.IsNil)
+
+		stale, err := store.ListStaleLinkMetadata(tabloid.NowFunc().Add(-24*time.Hour), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stale, qt.HasLen, 1)
+		c.Assert(stale[0].CanonicalURL, qt.Equals, "old.com")
+	})
+
+	c.Run("Archives", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE archives;")
+		})
+
+		found, err := store.FindArchive("foobar.com/a")
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.IsNil)
+
+		archive := &tabloid.Archive{CanonicalURL: "foobar.com/a", URL: "https://foobar.com/a", Title: "Foobar", Text: "First\n\nSecond", FetchedAt: tabloid.NowFunc()}
+		c.Assert(store.InsertArchive(archive), qt.IsNil)
+
+		// the first snapshot is kept
+		c.Assert(store.InsertArchive(&tabloid.Archive{CanonicalURL: "foobar.com/a", URL: "https://foobar.com/a", Error: "timeout", FetchedAt: tabloid.NowFunc()}), qt.IsNil)
+
+		found, err = store.FindArchive("foobar.com/a")
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Title, qt.Equals, "Foobar")
+		c.Assert(found.Paragraphs(), qt.DeepEquals, []string{"First", "Second"})
+		c.Assert(found.Error, qt.Equals, "")
+	})
+
+	c.Run("Search", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE stories;")
+			store.DB().MustExec("TRUNCATE TABLE comments;")
+			store.DB().MustExec("TRUNCATE TABLE users;")
+			store.DB().MustExec("TRUNCATE TABLE votes;")
+			store.DB().MustExec("TRUNCATE TABLE stories_search;")
+			store.DB().MustExec("TRUNCATE TABLE comments_search;")
+		})
+
+		alice, err := store.CreateOrUpdateUser("alice", "alice@alice.com")
+		c.Assert(err, qt.IsNil)
+		bob, err := store.CreateOrUpdateUser("bob", "bob@bob.com")
+		c.Assert(err, qt.IsNil)
+
+		story := tabloid.NewStory("Compilers are fun", "", alice, "https://compilers.com/fun")
+		c.Assert(store.InsertStory(story), qt.IsNil)
+		c.Assert(store.InsertStory(tabloid.NewStory("Gardening", "Growing tomatoes and compilers", bob, "")), qt.IsNil)
+		comment := tabloid.NewComment(story.ID, sql.NullString{}, "I wrote a compiler once", bob)
+		c.Assert(store.InsertComment(comment), qt.IsNil)
+
+		results, err := store.Search(&tabloid.SearchQuery{Text: "compilers"}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 3)
+		// titles weigh more than bodies
+		c.Assert(results[0].StoryID, qt.Equals, story.ID)
+		c.Assert(results[0].IsComment(), qt.IsFalse)
+
+		results, err = store.Search(&tabloid.SearchQuery{Text: "compilers", Type: tabloid.SearchComments}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].CommentID.String, qt.Equals, comment.ID)
+		c.Assert(results[0].Title, qt.Equals, "Compilers are fun")
+
+		results, err = store.Search(&tabloid.SearchQuery{Text: "compilers", Author: "Bob", Type: tabloid.SearchStories}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].Title, qt.Equals, "Gardening")
+
+		results, err = store.Search(&tabloid.SearchQuery{Text: "compilers", Since: tabloid.NowFunc().Add(time.Hour)}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 0)
+
+		// edits are searchable right away
+		comment.Body = "Nothing to see"
+		c.Assert(store.UpdateComment(comment), qt.IsNil)
+		results, err = store.Search(&tabloid.SearchQuery{Text: "compiler", Type: tabloid.SearchComments}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 0)
+
+		c.Assert(store.RemoveStory(story.ID), qt.IsNil)
+		results, err = store.Search(&tabloid.SearchQuery{Text: "compilers"}, 0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(results, qt.HasLen, 1)
+	})
+
+	c.Run("Webhooks", func(c *qt.C) {
+		c.Cleanup(func() {
+			store.DB().MustExec("TRUNCATE TABLE webhook_deliveries, webhooks;")
+		})
+
+		webhook, err := tabloid.NewWebhook("https://example.com/hook", tabloid.WebhookEvents{tabloid.WebhookStoryCreated})
+		c.Assert(err, qt.IsNil)
+		c.Assert(store.InsertWebhook(webhook), qt.IsNil)
+
+		found, err := store.FindWebhook(webhook.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.Events, qt.DeepEquals, tabloid.WebhookEvents{tabloid.WebhookStoryCreated})
+		c.Assert(found.Secret, qt.Equals, webhook.Secret)
+
+		now := tabloid.NowFunc()
+		delivery := &tabloid.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         tabloid.WebhookStoryCreated,
+			Payload:       `{"event":"story.created"}`,
+			NextAttemptAt: sql.NullTime{Time: now, Valid: true},
+			CreatedAt:     now,
+		}
+		c.Assert(store.InsertWebhookDelivery(delivery), qt.IsNil)
+
+		due, err := store.ClaimDueWebhookDeliveries(now, now.Add(time.Minute), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 1)
+		c.Assert(due[0].URL, qt.Equals, "https://example.com/hook")
+
+		// claimed until the lease expires
+		due, err = store.ClaimDueWebhookDeliveries(now, now.Add(time.Minute), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 0)
+		due, err = store.ClaimDueWebhookDeliveries(now.Add(time.Minute), now.Add(2*time.Minute), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 1)
+
+		delivery.Attempts = 1
+		delivery.NextAttemptAt = sql.NullTime{}
+		delivery.DeliveredAt = sql.NullTime{Time: now, Valid: true}
+		delivery.LastStatus = 200
+		c.Assert(store.UpdateWebhookDelivery(delivery), qt.IsNil)
+
+		due, err = store.ClaimDueWebhookDeliveries(now.Add(time.Hour), now.Add(2*time.Hour), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 0)
+
+		deliveries, err := store.ListWebhookDeliveries(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries, qt.HasLen, 1)
+		c.Assert(deliveries[0].DeliveredAt.Valid, qt.IsTrue)
+
+		// deliveries go along their webhook
+		c.Assert(store.DeleteWebhook(webhook.ID), qt.IsNil)
+		deliveries, err = store.ListWebhookDeliveries(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries, qt.HasLen, 0)
+		found, err = store.FindWebhook(webhook.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.IsNil)
 	})
 }
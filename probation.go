@@ -0,0 +1,41 @@
+package tabloid
+
+import (
+	"database/sql"
+	"time"
+)
+
+// isNewUser returns true if an account created at the given time was still new at the time given by at, as
+// configured by ServerConfig.NewUserInDays.
+func (s *Server) isNewUser(createdAt sql.NullTime, at time.Time) bool {
+	if s.config.NewUserInDays <= 0 || !createdAt.Valid {
+		return false
+	}
+
+	return createdAt.Time.Add(time.Duration(s.config.NewUserInDays) * 24 * time.Hour).After(at)
+}
+
+// holdsSubmission returns true if the given story, submitted by the given user, must be held until a moderator
+// reviews it. Only stories with a URL from accounts younger than ServerConfig.HoldSubmissionsInDays are held.
+func (s *Server) holdsSubmission(user *User, story *Story, at time.Time) bool {
+	if s.config.HoldSubmissionsInDays <= 0 || story.URL == "" || CanModerate(user) {
+		return false
+	}
+
+	return user.CreatedAt.Add(time.Duration(s.config.HoldSubmissionsInDays) * 24 * time.Hour).After(at)
+}
+
+// requireKarma returns a NotEnoughKarmaError if the given user has less than the required karma. Moderators
+// are exempt.
+func requireKarma(user *User, required int) error {
+	if user.Karma < required && !CanModerate(user) {
+		return NotEnoughKarma(required)
+	}
+
+	return nil
+}
+
+// hasKarma tells templates if the user of the given session has the required karma, see requireKarma.
+func hasKarma(session *Session, required int) bool {
+	return session != nil && (session.Karma >= required || CanModerate(session.user()))
+}
@@ -0,0 +1,42 @@
+package tabloid
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestProbation(t *testing.T) {
+	c := qt.New(t)
+
+	now, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	s := &Server{config: &ServerConfig{NewUserInDays: 7, HoldSubmissionsInDays: 2}}
+	newcomer := &User{CreatedAt: now.Add(-24 * time.Hour)}
+	regular := &User{CreatedAt: now.Add(-30 * 24 * time.Hour)}
+
+	c.Run("new users", func(c *qt.C) {
+		c.Assert(s.isNewUser(sql.NullTime{Time: newcomer.CreatedAt, Valid: true}, now), qt.IsTrue)
+		c.Assert(s.isNewUser(sql.NullTime{Time: regular.CreatedAt, Valid: true}, now), qt.IsFalse)
+		c.Assert(s.isNewUser(sql.NullTime{}, now), qt.IsFalse)
+	})
+
+	c.Run("held submissions", func(c *qt.C) {
+		link := &Story{URL: "http://foobar.com"}
+		c.Assert(s.holdsSubmission(newcomer, link, now), qt.IsTrue)
+		c.Assert(s.holdsSubmission(newcomer, &Story{Body: "Ask Tabloid"}, now), qt.IsFalse)
+		c.Assert(s.holdsSubmission(regular, link, now), qt.IsFalse)
+		c.Assert(s.holdsSubmission(&User{CreatedAt: newcomer.CreatedAt, Role: RoleModerator}, link, now), qt.IsFalse)
+	})
+
+	c.Run("karma", func(c *qt.C) {
+		c.Assert(requireKarma(&User{Karma: 10}, 10), qt.IsNil)
+		c.Assert(requireKarma(&User{Karma: 9}, 10), qt.ErrorMatches, "NotEnoughKarmaError: 10 required")
+		c.Assert(requireKarma(&User{Role: RoleModerator}, 10), qt.IsNil)
+
+		c.Assert(hasKarma(&Session{Karma: 10}, 10), qt.IsTrue)
+		c.Assert(hasKarma(&Session{Karma: 9}, 10), qt.IsFalse)
+		c.Assert(hasKarma(nil, 0), qt.IsFalse)
+	})
+}
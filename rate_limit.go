@@ -0,0 +1,102 @@
+package tabloid
+
+import (
+	"sync"
+	"time"
+)
+
+// A RateLimitAction names an action whose pace is limited for each user.
+type RateLimitAction string
+
+const (
+	RateLimitSubmit     RateLimitAction = "submit"
+	RateLimitComment    RateLimitAction = "comment"
+	RateLimitVote       RateLimitAction = "vote"
+	RateLimitFetchTitle RateLimitAction = "fetch-title"
+)
+
+// A RateLimit is a budget of Count actions per Period. Accounts younger than NewAccountAge get
+// NewAccountCount instead, when it's set. A zero Count means no limit.
+type RateLimit struct {
+	Count           int
+	Period          time.Duration
+	NewAccountCount int
+	NewAccountAge   time.Duration
+}
+
+// CountFor returns the number of actions the given user can take per period, at the given time.
+func (l RateLimit) CountFor(user *User, at time.Time) int {
+	if l.NewAccountCount > 0 && user.CreatedAt.Add(l.NewAccountAge).After(at) {
+		return l.NewAccountCount
+	}
+
+	return l.Count
+}
+
+// A RateLimiter keeps track of the budgets of users, as token buckets.
+type RateLimiter interface {
+	// TakeRateLimitToken consumes a token from the bucket identified by key, which holds up to limit tokens
+	// and is refilled over period. If the bucket is empty, it returns false along how long to wait for
+	// the next token.
+	TakeRateLimitToken(key string, limit int, period time.Duration) (bool, time.Duration, error)
+}
+
+// A TokenBucket holds the tokens left for a given budget, as of UpdatedAt.
+type TokenBucket struct {
+	Tokens    float64   `db:"tokens"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// NewTokenBucket returns a full bucket of limit tokens.
+func NewTokenBucket(limit int, at time.Time) *TokenBucket {
+	return &TokenBucket{Tokens: float64(limit), UpdatedAt: at}
+}
+
+// Take refills the bucket with the tokens earned since it was last updated, limit tokens being earned per
+// period, then consumes one. If there are none left, it returns false along how long to wait for the next one.
+func (b *TokenBucket) Take(at time.Time, limit int, period time.Duration) (bool, time.Duration) {
+	interval := float64(period) / float64(limit)
+	if elapsed := at.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens += float64(elapsed) / interval
+	}
+	if b.Tokens > float64(limit) {
+		b.Tokens = float64(limit)
+	}
+	b.UpdatedAt = at
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.Tokens) * interval)
+}
+
+// MemoryRateLimiter is a RateLimiter keeping buckets in a map, by key. Buckets are only created once a token
+// is taken from them, so untouched budgets cost nothing.
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*TokenBucket
+}
+
+// NewMemoryRateLimiter returns a MemoryRateLimiter with all buckets full.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		buckets: map[string]*TokenBucket{},
+	}
+}
+
+func (m *MemoryRateLimiter) TakeRateLimitToken(key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := NowFunc()
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = NewTokenBucket(limit, now)
+		m.buckets[key] = bucket
+	}
+
+	allowed, retryAfter := bucket.Take(now, limit, period)
+	return allowed, retryAfter, nil
+}
@@ -0,0 +1,67 @@
+package tabloid
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTokenBucket(t *testing.T) {
+	c := qt.New(t)
+
+	now, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	bucket := NewTokenBucket(2, now)
+
+	ok, _ := bucket.Take(now, 2, time.Hour)
+	c.Assert(ok, qt.IsTrue)
+	ok, _ = bucket.Take(now, 2, time.Hour)
+	c.Assert(ok, qt.IsTrue)
+
+	ok, retryAfter := bucket.Take(now, 2, time.Hour)
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(retryAfter, qt.Equals, 30*time.Minute)
+
+	ok, retryAfter = bucket.Take(now.Add(15*time.Minute), 2, time.Hour)
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(retryAfter, qt.Equals, 15*time.Minute)
+
+	ok, _ = bucket.Take(now.Add(30*time.Minute), 2, time.Hour)
+	c.Assert(ok, qt.IsTrue)
+
+	// it never holds more than the limit
+	bucket.Take(now.Add(48*time.Hour), 2, time.Hour)
+	c.Assert(bucket.Tokens, qt.Equals, 1.0)
+}
+
+func TestRateLimit(t *testing.T) {
+	c := qt.New(t)
+
+	now, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	limit := RateLimit{Count: 20, Period: 24 * time.Hour, NewAccountCount: 5, NewAccountAge: 7 * 24 * time.Hour}
+
+	c.Assert(limit.CountFor(&User{CreatedAt: now.Add(-24 * time.Hour)}, now), qt.Equals, 5)
+	c.Assert(limit.CountFor(&User{CreatedAt: now.Add(-30 * 24 * time.Hour)}, now), qt.Equals, 20)
+	c.Assert(RateLimit{Count: 20}.CountFor(&User{CreatedAt: now}, now), qt.Equals, 20)
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	c := qt.New(t)
+
+	rl := NewMemoryRateLimiter()
+	for i := 0; i < 3; i++ {
+		ok, _, err := rl.TakeRateLimitToken("vote:1", 3, time.Hour)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+	}
+
+	ok, retryAfter, err := rl.TakeRateLimitToken("vote:1", 3, time.Hour)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(retryAfter > 0, qt.IsTrue)
+
+	// other keys have their own budget
+	ok, _, err = rl.TakeRateLimitToken("vote:2", 3, time.Hour)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+}
@@ -0,0 +1,281 @@
+package tabloid
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// SearchType restricts a search to either stories or comments.
+type SearchType string
+
+const (
+	SearchAll      SearchType = ""
+	SearchStories  SearchType = "stories"
+	SearchComments SearchType = "comments"
+)
+
+// A SearchQuery describes what to search for. Text is matched against the titles, URLs and bodies of
+// stories and the bodies of comments, while the other fields narrow the results down. Zero values don't
+// restrict anything.
+type SearchQuery struct {
+	Text   string
+	Author string
+	Type   SearchType
+	Since  time.Time
+	Until  time.Time
+}
+
+// IsEmpty returns true if the query has nothing to search for.
+func (q *SearchQuery) IsEmpty() bool {
+	return strings.TrimSpace(q.Text) == "" && q.Author == ""
+}
+
+// A SearchResult is a story or a comment matching a search. Comments come along the title of their story.
+type SearchResult struct {
+	StoryID   string         `db:"story_id"`
+	CommentID sql.NullString `db:"comment_id"`
+	Title     string         `db:"title"`
+	URL       string         `db:"url"`
+	Body      string         `db:"body"`
+	Score     int64          `db:"score"`
+	AuthorID  string         `db:"author_id"`
+	Author    string         `db:"author"`
+	CreatedAt time.Time      `db:"created_at"`
+	Rank      float64        `db:"rank"`
+}
+
+// IsComment returns true if the result is a comment, false if it's a story.
+func (r *SearchResult) IsComment() bool {
+	return r.CommentID.Valid
+}
+
+// searchTerms splits a text in lower cased words, dropping punctuation.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// termsScore returns how many times the terms appear in the given text, or zero if one of them doesn't.
+func termsScore(terms []string, text string) int {
+	words := map[string]int{}
+	for _, w := range searchTerms(text) {
+		words[w]++
+	}
+
+	score := 0
+	for _, t := range terms {
+		if words[t] == 0 {
+			return 0
+		}
+		score += words[t]
+	}
+
+	return score
+}
+
+// SearchInMemory is a simple search over the given stories and comments, for stores without full-text
+// search. It's up to the caller to only pass what can be seen. Each term must appear in the story or the
+// comment, results being ranked by how often terms appear, titles weighing more than URLs and bodies.
+func SearchInMemory(query *SearchQuery, stories []*Story, comments []*Comment, page int, perPage int) []*SearchResult {
+	terms := searchTerms(query.Text)
+	results := []*SearchResult{}
+
+	matches := func(author string, createdAt time.Time) bool {
+		if query.Author != "" && !strings.EqualFold(author, query.Author) {
+			return false
+		}
+		if !query.Since.IsZero() && createdAt.Before(query.Since) {
+			return false
+		}
+		if !query.Until.IsZero() && !createdAt.Before(query.Until) {
+			return false
+		}
+		return true
+	}
+
+	titles := map[string]string{}
+	for _, story := range stories {
+		titles[story.ID] = story.Title
+		if query.Type == SearchComments || !matches(story.Author, story.CreatedAt) {
+			continue
+		}
+
+		rank := 1
+		if len(terms) > 0 {
+			// all terms must be found in the story, wherever they are
+			if termsScore(terms, story.Title+" "+story.URL+" "+story.Body) == 0 {
+				continue
+			}
+			rank = 0
+			for _, t := range terms {
+				rank += 3*termsScore([]string{t}, story.Title) + 2*termsScore([]string{t}, story.URL) + termsScore([]string{t}, story.Body)
+			}
+		}
+
+		results = append(results, &SearchResult{
+			StoryID:   story.ID,
+			Title:     story.Title,
+			URL:       story.URL,
+			Body:      story.Body,
+			Score:     story.Score,
+			AuthorID:  story.AuthorID,
+			Author:    story.Author,
+			CreatedAt: story.CreatedAt,
+			Rank:      float64(rank),
+		})
+	}
+
+	for _, comment := range comments {
+		if query.Type == SearchStories || !matches(comment.Author, comment.CreatedAt) {
+			continue
+		}
+
+		rank := 1
+		if len(terms) > 0 {
+			rank = termsScore(terms, comment.Body)
+			if rank == 0 {
+				continue
+			}
+		}
+
+		results = append(results, &SearchResult{
+			StoryID:   comment.StoryID,
+			CommentID: sql.NullString{String: comment.ID, Valid: true},
+			Title:     titles[comment.StoryID],
+			Body:      comment.Body,
+			Score:     comment.Score,
+			AuthorID:  comment.AuthorID,
+			Author:    comment.Author,
+			CreatedAt: comment.CreatedAt,
+			Rank:      float64(rank),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+
+	start := page * perPage
+	if start >= len(results) {
+		return []*SearchResult{}
+	}
+	end := start + perPage
+	if end > len(results) {
+		end = len(results)
+	}
+
+	return results[start:end]
+}
+
+// parseSearchQuery reads a search query from the parameters of a request. Dates are days, until being
+// inclusive.
+func parseSearchQuery(values url.Values) (*SearchQuery, error) {
+	get := func(key string) string {
+		return strings.TrimSpace(values.Get(key))
+	}
+
+	query := &SearchQuery{
+		Text:   get("q"),
+		Author: get("author"),
+		Type:   SearchType(get("type")),
+	}
+
+	switch query.Type {
+	case SearchAll, SearchStories, SearchComments:
+	default:
+		return nil, UnprocessableEntity("type")
+	}
+
+	if v := get("since"); v != "" {
+		since, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, UnprocessableEntityWithError(err, "since")
+		}
+		query.Since = since
+	}
+
+	if v := get("until"); v != "" {
+		until, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, UnprocessableEntityWithError(err, "until")
+		}
+		query.Until = until.Add(24 * time.Hour)
+	}
+
+	return query, nil
+}
+
+// HandleSearch handles searching stories and comments, with the "q", "author", "type", "since" and "until"
+// query parameters.
+func (s *Server) HandleSearch() HandleE {
+	tmpl, err := template.New("search.html").Funcs(s.helpers()).ParseFiles("assets/templates/search.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to load templates")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		res.Header().Set("Content-Type", "text/html")
+
+		values := req.URL.Query()
+		query, err := parseSearchQuery(values)
+		if err != nil {
+			return err
+		}
+
+		page, _ := strconv.Atoi(values.Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		// one more result tells if there is a next page
+		perPage := s.config.StoriesPerPage
+		results := []*SearchResult{}
+		if !query.IsEmpty() {
+			results, err = s.store.Search(query, page, perPage+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		nextPage := -1
+		if len(results) > perPage {
+			results = results[:perPage]
+			nextPage = page + 1
+		}
+
+		prevPage := -1
+		if page > 0 {
+			prevPage = page - 1
+		}
+
+		// pagination links keep the other parameters
+		values.Del("page")
+
+		return tmpl.Execute(res, map[string]interface{}{
+			"Query":    query,
+			"Path":     template.URL("/search?" + values.Encode()),
+			"Since":    values.Get("since"),
+			"Until":    values.Get("until"),
+			"Results":  results,
+			"Searched": !query.IsEmpty(),
+			"Session":  ctxSession(req.Context()),
+			"PrevPage": prevPage,
+			"NextPage": nextPage,
+		})
+	}
+}
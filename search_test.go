@@ -0,0 +1,93 @@
+package tabloid
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSearchInMemory(t *testing.T) {
+	c := qt.New(t)
+	now := time.Now()
+
+	stories := []*Story{
+		{ID: "1", Title: "Compilers are fun", URL: "https://compilers.com", Author: "alice", CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: "2", Title: "Gardening", Body: "Growing tomatoes, and compilers.", Author: "bob", CreatedAt: now},
+	}
+	comments := []*Comment{
+		{ID: "3", StoryID: "1", Body: "I wrote compilers once", Author: "bob", CreatedAt: now},
+	}
+
+	c.Run("ranks titles first", func(c *qt.C) {
+		results := SearchInMemory(&SearchQuery{Text: "Compilers"}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 3)
+		c.Assert(results[0].StoryID, qt.Equals, "1")
+		c.Assert(results[0].IsComment(), qt.IsFalse)
+	})
+
+	c.Run("requires every term", func(c *qt.C) {
+		results := SearchInMemory(&SearchQuery{Text: "compilers tomatoes"}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].StoryID, qt.Equals, "2")
+	})
+
+	c.Run("filters", func(c *qt.C) {
+		results := SearchInMemory(&SearchQuery{Text: "compilers", Type: SearchComments}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].CommentID.String, qt.Equals, "3")
+		c.Assert(results[0].Title, qt.Equals, "Compilers are fun")
+
+		results = SearchInMemory(&SearchQuery{Text: "compilers", Author: "Bob", Type: SearchStories}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].StoryID, qt.Equals, "2")
+
+		results = SearchInMemory(&SearchQuery{Text: "compilers", Since: now.Add(-time.Hour)}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 2)
+
+		results = SearchInMemory(&SearchQuery{Author: "alice"}, stories, comments, 0, 10)
+		c.Assert(results, qt.HasLen, 1)
+	})
+
+	c.Run("pages", func(c *qt.C) {
+		results := SearchInMemory(&SearchQuery{Text: "compilers"}, stories, comments, 1, 2)
+		c.Assert(results, qt.HasLen, 1)
+
+		results = SearchInMemory(&SearchQuery{Text: "compilers"}, stories, comments, 2, 2)
+		c.Assert(results, qt.HasLen, 0)
+	})
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	c := qt.New(t)
+
+	query, err := parseSearchQuery(url.Values{"q": {" compilers "}, "type": {"stories"}, "since": {"2020-01-01"}, "until": {"2020-01-31"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(query.Text, qt.Equals, "compilers")
+	c.Assert(query.Type, qt.Equals, SearchStories)
+	c.Assert(query.Since, qt.Equals, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	// until is inclusive
+	c.Assert(query.Until, qt.Equals, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err = parseSearchQuery(url.Values{"type": {"users"}})
+	c.Assert(err, qt.ErrorMatches, `UnprocessableEntityError: \[type\]`)
+
+	_, err = parseSearchQuery(url.Values{"since": {"yesterday"}})
+	c.Assert(err, qt.ErrorMatches, `UnprocessableEntityError: .*\[since\]`)
+
+	query, err = parseSearchQuery(url.Values{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(query.IsEmpty(), qt.IsTrue)
+}
+
+func TestExcerpt(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(excerpt(" short "), qt.Equals, "short")
+
+	long := excerpt(strings.Repeat("word ", 100))
+	c.Assert(len([]rune(long)) <= excerptLength+1, qt.IsTrue)
+	c.Assert(strings.HasSuffix(long, "word…"), qt.IsTrue)
+}
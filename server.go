@@ -4,6 +4,7 @@ package tabloid
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jhchabran/tabloid/authentication"
@@ -28,12 +30,40 @@ type Server struct {
 	config          *ServerConfig
 	store           Store
 	router          *httprouter.Router
-	authService     authentication.AuthService
+	authServices    []authentication.AuthService
+	sessionStore    SessionStore
+	rateLimiter     RateLimiter
+	archiveStore    ArchiveStore
+	webhookStore    WebhookStore
+	webhookWake     chan struct{}
 	rootHandler     http.Handler
+	routes          []route
 	done            chan struct{}
 	idleConnsClosed chan struct{}
 	storyHooks      []StoryHookFn
 	commentHooks    []CommentHookFn
+	hookQueue       chan *hookRun
+	hooksStopped    chan struct{}
+	hookWorkers     sync.WaitGroup
+	hookRetries     sync.WaitGroup
+	storyFilters    []StoryFilterFn
+	commentFilters  []CommentFilterFn
+	metadataFetcher *MetadataFetcher
+	metadataQueue   chan string
+	previewImages   *previewImageCache
+
+	hookWorkersMu      sync.RWMutex
+	hookWorkersRunning bool
+
+	siteSettingsMu       sync.Mutex
+	siteSettings         *SiteSettings
+	siteSettingsLoadedAt time.Time
+}
+
+// route is a route declared on the router, kept to list them.
+type route struct {
+	Method string
+	Path   string
 }
 
 // ServerConfig represents the settings required for the server to operate.
@@ -43,6 +73,46 @@ type ServerConfig struct {
 	EditWindowInMinutes      int
 	FrontPageTimeBaseInHours int
 	FrontPageGravity         float64
+	// SessionIdleTimeoutInHours is how long a session can go unused before expiring, zero disables it.
+	SessionIdleTimeoutInHours int
+	// SessionLifetimeInHours is how long a session lasts at most, zero disables it.
+	SessionLifetimeInHours int
+	// FlagThreshold is the number of flags after which a story or a comment is hidden until a moderator
+	// reviews it, zero disables it.
+	FlagThreshold int
+	// MinKarmaToDownvote and MinKarmaToFlag are the karma a user needs before downvoting and flagging.
+	MinKarmaToDownvote int
+	MinKarmaToFlag     int
+	// NewUserInDays is how long an account is considered new, their stories being marked with a badge, zero
+	// disables it.
+	NewUserInDays int
+	// HoldSubmissionsInDays is how old an account must be for the stories with a URL it submits to be listed
+	// right away, younger accounts' ones being held until a moderator reviews them. Zero disables it.
+	HoldSubmissionsInDays int
+	// RepostWindowInDays is for how long submitting a link again redirects to the existing discussion, after
+	// which the user is asked if they want to repost it anyway. Zero always asks.
+	RepostWindowInDays int
+	// FetchLinkMetadata enables fetching the title and metadata of the submitted links.
+	FetchLinkMetadata bool
+	// LinkPreviews enables showing a preview of the linked page on story pages, built from its metadata.
+	LinkPreviews bool
+	// ArchiveLinks enables taking a snapshot of the text of the submitted links, once, when their metadata
+	// is fetched. ArchiveMaxSizeInKB is how much of a page is read at most, and links to one of the
+	// ArchiveExcludedDomains, or their subdomains, are never archived. A zero size reads up to 1MB.
+	ArchiveLinks           bool
+	ArchiveMaxSizeInKB     int
+	ArchiveExcludedDomains []string
+	// RateLimits are the budgets of each user for the limited actions. Actions without one aren't limited.
+	RateLimits map[RateLimitAction]RateLimit
+	// HookWorkers is how many hooks run at once in the background, zero defaulting to 4. Each run is given
+	// HookTimeoutInSeconds, zero defaulting to 30, and a hook returning an error is run again up to
+	// HookRetries times. Runs coming while too many wait for a worker are logged and dropped.
+	HookWorkers          int
+	HookTimeoutInSeconds int
+	HookRetries          int
+	// SynchronousHooks runs the hooks within the request instead, a failing hook failing it, for callers
+	// which need them done before answering.
+	SynchronousHooks bool
 }
 
 func init() {
@@ -51,17 +121,76 @@ func init() {
 }
 
 // NewServer returns a server instance, configured with given components and with middlewares installed.
-func NewServer(config *ServerConfig, logger zerolog.Logger, store Store, authService authentication.AuthService) *Server {
+//
+// Multiple authentication providers can be given, each of them being reachable under /auth/:provider. The first
+// one is the default provider, used by the legacy /oauth routes.
+//
+// Sessions, rate limits budgets, archives of links and webhooks are kept in the store if it implements
+// SessionStore, RateLimiter, ArchiveStore and WebhookStore respectively, like PGStore does. Otherwise they're
+// kept in memory, where they don't survive restarts and aren't shared between instances, which is mostly
+// suitable for development and tests.
+func NewServer(config *ServerConfig, logger zerolog.Logger, store Store, authServices ...authentication.AuthService) *Server {
 	s := &Server{
 		config:          config,
 		store:           store,
-		authService:     authService,
+		authServices:    authServices,
 		router:          httprouter.New(),
 		Logger:          logger,
 		done:            make(chan struct{}),
 		idleConnsClosed: make(chan struct{}),
+		metadataQueue:   make(chan string, 64),
+		webhookWake:     make(chan struct{}, 1),
+		hookQueue:       make(chan *hookRun, hookQueueSize),
+		hooksStopped:    make(chan struct{}),
+		previewImages:   newPreviewImageCache(previewImageCacheBytes),
+	}
+
+	if config.FetchLinkMetadata {
+		s.metadataFetcher = NewMetadataFetcher()
+	}
+
+	if ss, ok := store.(SessionStore); ok {
+		s.sessionStore = ss
+	} else {
+		s.sessionStore = NewMemorySessionStore(func(id string) (*User, error) { return s.store.FindUserByID(id) })
+	}
+
+	if rl, ok := store.(RateLimiter); ok {
+		s.rateLimiter = rl
+	} else {
+		s.rateLimiter = NewMemoryRateLimiter()
+	}
+
+	if as, ok := store.(ArchiveStore); ok {
+		s.archiveStore = as
+	} else {
+		s.archiveStore = NewMemoryArchiveStore()
+	}
+
+	if ws, ok := store.(WebhookStore); ok {
+		s.webhookStore = ws
+	} else {
+		s.webhookStore = NewMemoryWebhookStore()
 	}
 
+	// webhooks are notified like hooks, once stories and comments can be seen by everyone
+	s.AddStoryHook(func(story *Story) error {
+		s.emitWebhookEvent(WebhookStoryCreated, newAPIStory(story, true))
+		return nil
+	})
+	s.AddCommentHook(func(story *Story, comment *Comment) error {
+		s.emitWebhookEvent(WebhookCommentCreated, newAPIComments([]*CommentPresenter{NewCommentPresenter(&CommentNode{Comment: comment})})[0])
+		return nil
+	})
+
+	// the banned domains are configured by admins, in the site settings
+	s.AddStoryFilter(func(story *Story) (FilterResult, error) {
+		return BannedDomains(s.currentSiteSettings().BannedDomains).FilterStory(story)
+	})
+	s.AddCommentFilter(func(story *Story, comment *Comment) (FilterResult, error) {
+		return BannedDomains(s.currentSiteSettings().BannedDomains).FilterComment(story, comment)
+	})
+
 	// Those are top level middewares, set before the router; every requests will go through them.
 	middlewares := []httpMiddleware{
 		s.httpVerbFormUnwrapper,
@@ -89,19 +218,28 @@ func NewServer(config *ServerConfig, logger zerolog.Logger, store Store, authSer
 
 // get declares a GET route with the given handle, inserting the error handling along the way.
 func (s *Server) get(path string, handle HandleE) {
+	s.routes = append(s.routes, route{Method: "GET", Path: path})
 	s.router.GET(path, withError(ensureHTTPMethodMiddleware("GET")(handle)))
 }
 
 // post declares a POST route with the given handle, inserting the error handling along the way.
 func (s *Server) post(path string, handle HandleE) {
+	s.routes = append(s.routes, route{Method: "POST", Path: path})
 	s.router.POST(path, withError(ensureHTTPMethodMiddleware("POST")(handle)))
 }
 
 // put declares a PUT route with the given handle, inserting the error handling along the way.
 func (s *Server) put(path string, handle HandleE) {
+	s.routes = append(s.routes, route{Method: "PUT", Path: path})
 	s.router.PUT(path, withError(ensureHTTPMethodMiddleware("PUT")(handle)))
 }
 
+// delete declares a DELETE route with the given handle, inserting the error handling along the way.
+func (s *Server) delete(path string, handle HandleE) {
+	s.routes = append(s.routes, route{Method: "DELETE", Path: path})
+	s.router.DELETE(path, withError(ensureHTTPMethodMiddleware("DELETE")(handle)))
+}
+
 // withError takes turns a http handler returning error into a normal http router.
 // If an error is returned by the given handler, it will respond appropriately, either
 // through delegating that to the error or by responding with an internal server error.
@@ -115,7 +253,7 @@ func withError(h HandleE) httprouter.Handle {
 				}
 			}
 
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+			writeError(w, r, "internal server error", http.StatusInternalServerError)
 		}
 	}
 }
@@ -129,29 +267,164 @@ func (s *Server) Prepare() error {
 		return err
 	}
 
-	// routes
+	if len(s.authServices) == 0 {
+		return fmt.Errorf("at least one authentication provider is required")
+	}
+
+	s.registerRoutes()
+
+	go s.runMetadataWorker()
+	go s.runWebhookWorker()
+	s.startHookWorkers()
+
+	return nil
+}
+
+// registerRoutes declares every route of the server on its router.
+func (s *Server) registerRoutes() {
+	s.get("/auth/:provider/start", s.HandleOAuthStart())
+	s.get("/auth/:provider/callback", s.HandleOAuthCallback())
+
+	// legacy routes, bound to the default provider
 	s.get("/oauth/start", s.HandleOAuthStart())
 	s.get("/oauth/authorize", s.HandleOAuthCallback())
-	s.get("/oauth/destroy", s.HandleOAuthDestroy())
+
+	withMiddlewares(func(m middleware) {
+		s.post("/oauth/destroy", m(s.HandleOAuthDestroy()))
+	}, s.loadSessionMiddleware(), csrfMiddleware())
 
 	withMiddlewares(func(m middleware) {
 		s.get("/", m(s.HandleIndex()))
+		s.get("/login", m(s.HandleLogin()))
 		s.get("/stories/:id/comments", m(s.HandleShow()))
+		s.get("/stories/:id/preview-image", m(s.HandlePreviewImage()))
+		s.get("/stories/:id/archive", m(s.HandleArchive()))
 		s.get("/submit", m(s.HandleSubmit()))
-	}, s.loadSessionMiddleware())
+		s.get("/domain/:host", m(s.HandleDomain()))
+		s.get("/search", m(s.HandleSearch()))
+		s.get("/moderation/log", m(s.HandleModerationLog()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), requireScopeMiddleware(ScopeRead))
 
+	// Routes below can be reached with a personal access token, as long as it has the required scope. Otherwise,
+	// they require the CSRF token of the session.
 	withMiddlewares(func(m middleware) {
 		s.post("/submit", m(s.HandleSubmitAction()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeSubmit), s.rateLimitMiddleware(RateLimitSubmit))
+
+	withMiddlewares(func(m middleware) {
+		s.get("/submit/fetch-title", m(s.HandleFetchTitle()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeSubmit), s.rateLimitMiddleware(RateLimitFetchTitle))
+
+	withMiddlewares(func(m middleware) {
 		s.post("/stories/:id/comments", m(s.HandleSubmitCommentAction()))
-		s.post("/stories/:id/votes", m(s.HandleVoteStoryAction()))
-		s.post("/story/:story_id/comments/:id/votes", m(s.HandleVoteCommentAction()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeComment), s.rateLimitMiddleware(RateLimitComment))
+
+	withMiddlewares(func(m middleware) {
 		s.get("/story/:story_id/comments/:id/edit", m(s.HandleCommentEdit()))
 		s.put("/story/:story_id/comments/:id", m(s.HandleCommentUpdateAction()))
-	}, s.loadSessionMiddleware(), s.loadUserMiddleware())
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeComment))
+
+	withMiddlewares(func(m middleware) {
+		s.post("/stories/:id/votes", m(s.HandleVoteStoryAction()))
+		s.post("/story/:story_id/comments/:id/votes", m(s.HandleVoteCommentAction()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeVote), s.rateLimitMiddleware(RateLimitVote))
+
+	// Feeds are public, readers polling them without a session.
+	for _, format := range feedFormats {
+		s.get(format.path("/"), s.HandleFeed(format, s.frontPageFeed))
+		s.get(format.path("/newest"), s.HandleFeed(format, s.newestFeed))
+		s.get(format.path("/users/:name"), s.HandleFeed(format, s.userFeed))
+		s.get(format.path("/domain/:host"), s.HandleFeed(format, s.domainFeed))
+		s.get(format.path("/stories/:id/comments"), s.HandleFeed(format, s.storyCommentsFeed))
+	}
+
+	// The JSON API, reading it works anonymously while writing needs a personal access token, or a session
+	// and its CSRF token in the X-CSRF-Token header.
+	s.get(apiPrefix+"/openapi.json", s.HandleAPISpec())
+
+	withMiddlewares(func(m middleware) {
+		s.get(apiPrefix+"/stories", m(s.HandleAPIStories()))
+		s.get(apiPrefix+"/stories/:id", m(s.HandleAPIStory()))
+		s.get(apiPrefix+"/users/:name", m(s.HandleAPIUser()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), requireScopeMiddleware(ScopeRead))
+
+	withMiddlewares(func(m middleware) {
+		s.post(apiPrefix+"/stories", m(s.HandleAPISubmitStory()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeSubmit), s.rateLimitMiddleware(RateLimitSubmit))
+
+	withMiddlewares(func(m middleware) {
+		s.post(apiPrefix+"/stories/:id/comments", m(s.HandleAPISubmitComment()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeComment), s.rateLimitMiddleware(RateLimitComment))
+
+	withMiddlewares(func(m middleware) {
+		s.put(apiPrefix+"/comments/:id", m(s.HandleAPIUpdateComment()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeComment))
+
+	withMiddlewares(func(m middleware) {
+		s.post(apiPrefix+"/stories/:id/votes", m(s.HandleAPIVote()))
+		s.post(apiPrefix+"/comments/:comment_id/votes", m(s.HandleAPIVote()))
+	}, s.loadSessionMiddleware(), s.loadTokenMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware(), requireScopeMiddleware(ScopeVote), s.rateLimitMiddleware(RateLimitVote))
+
+	withMiddlewares(func(m middleware) {
+		s.post("/stories/:id/flags", m(s.HandleFlagStoryAction()))
+		s.post("/story/:story_id/comments/:id/flags", m(s.HandleFlagCommentAction()))
+	}, s.loadSessionMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requireActiveUserMiddleware())
+
+	withMiddlewares(func(m middleware) {
+		s.get("/moderation/flags", m(s.HandleModerationFlags()))
+		s.post("/moderation/flags/approve", m(s.HandleModerationApproveFlagsAction()))
+		s.post("/moderation/flags/dismiss", m(s.HandleModerationDismissFlagsAction()))
+		s.post("/moderation/stories/:id/lock", m(s.HandleModerationLockStoryAction()))
+		s.delete("/moderation/stories/:id/lock", m(s.HandleModerationUnlockStoryAction()))
+		s.put("/moderation/stories/:id/pin", m(s.HandleModerationPinStoryAction()))
+		s.post("/moderation/stories/:id/release", m(s.HandleModerationReleaseStoryAction()))
+		s.post("/moderation/stories/:id/reject", m(s.HandleModerationRejectStoryAction()))
+		s.post("/moderation/comments/:id/release", m(s.HandleModerationReleaseCommentAction()))
+		s.post("/moderation/comments/:id/reject", m(s.HandleModerationRejectCommentAction()))
+	}, s.loadSessionMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requirePermissionMiddleware(CanModerate))
+
+	// Personal access tokens can't be used to manage themselves.
+	withMiddlewares(func(m middleware) {
+		s.get("/settings", m(s.HandleSettings()))
+		s.post("/settings/tokens", m(s.HandleCreateAPITokenAction()))
+		s.delete("/settings/tokens/:id", m(s.HandleRevokeAPITokenAction()))
+		s.delete("/settings/sessions", m(s.HandleDestroyAllSessionsAction()))
+		s.delete("/settings/sessions/:id", m(s.HandleRevokeSessionAction()))
+	}, s.loadSessionMiddleware(), csrfMiddleware(), s.loadUserMiddleware())
+
+	// The admin area is only reachable through a session.
+	withMiddlewares(func(m middleware) {
+		s.get("/admin", m(s.HandleAdmin()))
+		s.get("/admin/users", m(s.HandleAdminUsers()))
+		s.put("/admin/users/:id/role", m(s.HandleAdminUpdateUserRoleAction()))
+		s.post("/admin/users/:id/ban", m(s.HandleAdminBanUserAction()))
+		s.delete("/admin/users/:id/ban", m(s.HandleAdminUnbanUserAction()))
+		s.post("/admin/users/:id/suspension", m(s.HandleAdminSuspendUserAction()))
+		s.delete("/admin/users/:id/suspension", m(s.HandleAdminUnsuspendUserAction()))
+		s.post("/admin/users/:id/shadow_ban", m(s.HandleAdminShadowBanUserAction()))
+		s.delete("/admin/users/:id/shadow_ban", m(s.HandleAdminUnshadowBanUserAction()))
+		s.get("/admin/stories", m(s.HandleAdminStories()))
+		s.delete("/admin/stories/:id", m(s.HandleAdminRemoveStoryAction()))
+		s.put("/admin/stories/:id/pin", m(s.HandleAdminPinStoryAction()))
+		s.get("/admin/comments", m(s.HandleAdminComments()))
+		s.delete("/admin/comments/:id", m(s.HandleAdminRemoveCommentAction()))
+		s.get("/admin/settings", m(s.HandleAdminSettings()))
+		s.put("/admin/settings", m(s.HandleAdminUpdateSettingsAction()))
+		s.get("/admin/webhooks", m(s.HandleAdminWebhooks()))
+		s.post("/admin/webhooks", m(s.HandleAdminCreateWebhookAction()))
+		s.delete("/admin/webhooks/:id", m(s.HandleAdminRemoveWebhookAction()))
+		s.get("/admin/webhook-deliveries", m(s.HandleAdminWebhookDeliveries()))
+	}, s.loadSessionMiddleware(), csrfMiddleware(), s.loadUserMiddleware(), requirePermissionMiddleware(CanAdminister))
 
 	s.router.ServeFiles("/static/*filepath", http.Dir("assets/static"))
 
-	return nil
+	// unknown routes answer in JSON too under the API
+	s.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, "404 page not found", http.StatusNotFound)
+	})
+	s.router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
 }
 
 // Start runs the server and will block until stopped.
@@ -179,10 +452,12 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop gracefully stops a running server.
+// Stop gracefully stops a running server. Hook workers are stopped once the requests in flight are done, after
+// running the hooks they queued.
 func (s *Server) Stop() {
 	close(s.done)
 	<-s.idleConnsClosed
+	s.stopHookWorkers()
 }
 
 // ServeHTTP implements a http.Handler that answers incoming requests.
@@ -197,61 +472,164 @@ type StoryHookFn func(*Story) error
 type CommentHookFn func(*Story, *Comment) error
 
 // AddStoryHook registers a given StoryHookFn, that will be called every time a story is submitted.
-// Multiple hooks will be called in the order they were registered.
-// If a hook fails and returns an error, it will interrupt the request but won't prevent the Story to be created.
+// Hooks run in the background, concurrently, and a failing one is logged and run again, without affecting
+// the request (see ServerConfig.HookRetries). With ServerConfig.SynchronousHooks, they're called within the
+// request in the order they were registered instead, and a failing hook interrupts the request, but won't
+// prevent the Story to be created.
 func (s *Server) AddStoryHook(fn StoryHookFn) {
 	s.storyHooks = append(s.storyHooks, fn)
 }
 
-// AddCommentHook registers a given CommentHookFn, that will be called every time a story is submitted.
-// Multiple hooks will be called in the order they were registered.
-// If a hook fails and returns an error, it will interrupt the request but won't prevent the Comment to be created.
+// AddCommentHook registers a given CommentHookFn, that will be called every time a comment is submitted.
+// It runs like story hooks, see AddStoryHook.
 func (s *Server) AddCommentHook(fn CommentHookFn) {
 	s.commentHooks = append(s.commentHooks, fn)
 }
 
+// LogModeration appends an entry to the moderation log, so actions taken by custom code, like hooks, are
+// accounted for along the ones taken by moderators.
+func (s *Server) LogModeration(entry *ModerationLogEntry) error {
+	return s.store.InsertModerationLogEntry(entry)
+}
+
+// helpers returns the template helpers, along the ones depending on the server state.
+func (s *Server) helpers() template.FuncMap {
+	funcs := template.FuncMap{
+		"site":        s.currentSiteSettings,
+		"canDownvote": func(session *Session) bool { return hasKarma(session, s.config.MinKarmaToDownvote) },
+		"canFlag":     func(session *Session) bool { return hasKarma(session, s.config.MinKarmaToFlag) },
+		"newUser":     s.isNewUser,
+	}
+
+	for name, fn := range helpers {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// currentSiteSettings returns the site settings, reloading them from the store at most once a minute, so
+// changes made through another instance are eventually picked up.
+func (s *Server) currentSiteSettings() *SiteSettings {
+	s.siteSettingsMu.Lock()
+	defer s.siteSettingsMu.Unlock()
+
+	if s.siteSettings != nil && time.Since(s.siteSettingsLoadedAt) < time.Minute {
+		return s.siteSettings
+	}
+
+	settings, err := s.store.FindSiteSettings()
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to load site settings")
+		if s.siteSettings == nil {
+			return DefaultSiteSettings()
+		}
+		return s.siteSettings
+	}
+
+	s.siteSettings = settings
+	s.siteSettingsLoadedAt = time.Now()
+	return settings
+}
+
+// SetSessionStore replaces the store sessions are kept in, which defaults to the main store if it implements
+// SessionStore.
+func (s *Server) SetSessionStore(ss SessionStore) {
+	s.sessionStore = ss
+}
+
+// SetRateLimiter replaces where the rate limits budgets are kept, which defaults to the main store if it
+// implements RateLimiter.
+func (s *Server) SetRateLimiter(rl RateLimiter) {
+	s.rateLimiter = rl
+}
+
+// SetArchiveStore replaces where the archives of links are kept, which defaults to the main store if it
+// implements ArchiveStore.
+func (s *Server) SetArchiveStore(as ArchiveStore) {
+	s.archiveStore = as
+}
+
+// SetWebhookStore replaces where webhooks and their deliveries are kept, which defaults to the main store if
+// it implements WebhookStore.
+func (s *Server) SetWebhookStore(ws WebhookStore) {
+	s.webhookStore = ws
+}
+
+// SetMetadataFetcher replaces the fetcher of the submitted links metadata, which defaults to one created by
+// NewMetadataFetcher if ServerConfig.FetchLinkMetadata is set. A nil fetcher disables fetching.
+func (s *Server) SetMetadataFetcher(f *MetadataFetcher) {
+	s.metadataFetcher = f
+}
+
 type storyPresenter struct {
-	Pos           int
-	ID            string
-	Title         string
-	URL           string
-	Body          template.HTML
-	Score         int64
-	Author        string
-	AuthorID      string
-	CommentsCount int64
-	CreatedAt     time.Time
-	Upvoted       bool
+	Pos           int           `json:"pos,omitempty"`
+	ID            string        `json:"id"`
+	Title         string        `json:"title"`
+	URL           string        `json:"url,omitempty"`
+	Body          template.HTML `json:"body_html,omitempty"`
+	Score         int64         `json:"score"`
+	Author        string        `json:"author"`
+	AuthorID      string        `json:"-"`
+	CommentsCount int64         `json:"comments_count"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Upvoted       bool          `json:"upvoted"`
+	Downvoted     bool          `json:"downvoted"`
+	Pinned        bool          `json:"pinned"`
+	Locked        bool          `json:"locked"`
+	HeldAt        sql.NullTime  `json:"-"`
+	Domain        string        `json:"domain,omitempty"`
+	// AuthorCreatedAt tells if the story was submitted by a new user.
+	AuthorCreatedAt sql.NullTime `json:"-"`
+	// Preview is the card describing the linked page, nil if there is none to show.
+	Preview *LinkPreview `json:"preview,omitempty"`
+	// ArchivePath is where the archive of the linked page can be read, empty if there is none.
+	ArchivePath string `json:"archive_path,omitempty"`
 }
 
 func newStoryPresenterWithPos(story *Story, pos int) *storyPresenter {
 	return &storyPresenter{
-		Pos:           pos,
-		ID:            story.ID,
-		Title:         story.Title,
-		URL:           story.URL,
-		Score:         story.Score,
-		Author:        story.Author,
-		AuthorID:      story.AuthorID,
-		CommentsCount: story.CommentsCount,
-		CreatedAt:     story.CreatedAt,
+		Pos:             pos,
+		ID:              story.ID,
+		Title:           story.Title,
+		URL:             story.URL,
+		Score:           story.Score,
+		Author:          story.Author,
+		AuthorID:        story.AuthorID,
+		CommentsCount:   story.CommentsCount,
+		CreatedAt:       story.CreatedAt,
+		Pinned:          story.IsPinned(NowFunc()),
+		Locked:          story.Locked,
+		HeldAt:          story.HeldAt,
+		Domain:          story.Domain,
+		AuthorCreatedAt: story.AuthorCreatedAt,
 	}
 }
 
 func newStoryPresenterWithBody(story *Story) *storyPresenter {
 	return &storyPresenter{
-		ID:            story.ID,
-		Title:         story.Title,
-		URL:           story.URL,
-		Body:          renderBody(story.Body),
-		Score:         story.Score,
-		Author:        story.Author,
-		AuthorID:      story.AuthorID,
-		CommentsCount: story.CommentsCount,
-		CreatedAt:     story.CreatedAt,
+		ID:              story.ID,
+		Title:           story.Title,
+		URL:             story.URL,
+		Body:            renderBody(story.Body),
+		Score:           story.Score,
+		Author:          story.Author,
+		AuthorID:        story.AuthorID,
+		CommentsCount:   story.CommentsCount,
+		CreatedAt:       story.CreatedAt,
+		Pinned:          story.IsPinned(NowFunc()),
+		Locked:          story.Locked,
+		HeldAt:          story.HeldAt,
+		Domain:          story.Domain,
+		AuthorCreatedAt: story.AuthorCreatedAt,
 	}
 }
 
+// IsHeld returns true if the story is waiting for a moderator to review it.
+func (sp *storyPresenter) IsHeld() bool {
+	return sp.HeldAt.Valid
+}
+
 func (sp *storyPresenter) IsSelfPost() bool {
 	return sp.URL == ""
 }
@@ -0,0 +1,260 @@
+package tabloid
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sessionCookieName is the name of the cookie holding the opaque session identifier.
+const sessionCookieName = "tabloid-sid"
+
+// A Session represents a signed in user on a given device. It's stored server side, the
+// client only holding an opaque secret in a cookie, whose hash is the TokenHash.
+type Session struct {
+	ID         string    `db:"id"`
+	UserID     string    `db:"user_id"`
+	TokenHash  string    `db:"token_hash"`
+	Provider   string    `db:"provider"`
+	UserAgent  string    `db:"user_agent"`
+	IP         string    `db:"ip"`
+	CreatedAt  time.Time `db:"created_at"`
+	LastSeenAt time.Time `db:"last_seen_at"`
+	// CSRFToken must be sent along every state changing request made with the session.
+	CSRFToken string `db:"csrf_token"`
+	// Login, Role, the ban, the suspension and the karma of the user aren't stored with the session, they're
+	// read from the user whenever the session is found, so they're never outdated.
+	Login            string       `db:"login"`
+	Role             Role         `db:"role"`
+	BannedAt         sql.NullTime `db:"banned_at"`
+	SuspendedUntil   sql.NullTime `db:"suspended_until"`
+	SuspensionReason string       `db:"suspension_reason"`
+	Karma            int          `db:"karma"`
+}
+
+// NewSession returns a session for the given user, opened through the given provider, along its secret value
+// which is meant to be stored in a cookie.
+func NewSession(user *User, provider string, req *http.Request) (*Session, string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := NowFunc()
+
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	session := &Session{
+		UserID:     user.ID,
+		TokenHash:  HashAPIToken(secret),
+		Provider:   provider,
+		UserAgent:  req.UserAgent(),
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		CSRFToken:  csrfToken,
+	}
+	session.setUser(user)
+
+	return session, secret, nil
+}
+
+// setUser copies the fields of the user which come along the session.
+func (s *Session) setUser(user *User) {
+	s.Login = user.Name
+	s.Role = user.Role
+	s.BannedAt = user.BannedAt
+	s.SuspendedUntil = user.SuspendedUntil
+	s.SuspensionReason = user.SuspensionReason
+	s.Karma = user.Karma
+}
+
+// randomToken returns a random string suitable for secrets.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IsSuspended returns true if the user of the session is currently suspended, for templates to tell them why.
+func (s *Session) IsSuspended() bool {
+	return s.SuspendedUntil.Valid && s.SuspendedUntil.Time.After(NowFunc())
+}
+
+// user returns the user of the session, built from the fields coming along it, to check permissions against.
+// It returns nil for a nil session.
+func (s *Session) user() *User {
+	if s == nil {
+		return nil
+	}
+
+	return &User{
+		ID:               s.UserID,
+		Name:             s.Login,
+		Role:             s.Role,
+		BannedAt:         s.BannedAt,
+		SuspendedUntil:   s.SuspendedUntil,
+		SuspensionReason: s.SuspensionReason,
+		Karma:            s.Karma,
+	}
+}
+
+// Expired returns true if the session has been idle for longer than idleTimeout or has been opened for
+// longer than lifetime. A zero duration disables the corresponding check.
+func (s *Session) Expired(idleTimeout time.Duration, lifetime time.Duration, at time.Time) bool {
+	if idleTimeout > 0 && s.LastSeenAt.Add(idleTimeout).Before(at) {
+		return true
+	}
+
+	if lifetime > 0 && s.CreatedAt.Add(lifetime).Before(at) {
+		return true
+	}
+
+	return false
+}
+
+// A SessionStore is responsible of persisting sessions, found back by the hash of their secret.
+//
+// Lookups return nil without an error when no session is found.
+type SessionStore interface {
+	CreateSession(session *Session) error
+	FindSessionByHash(hash string) (*Session, error)
+	ListSessions(userID string) ([]*Session, error)
+	TouchSession(sessionID string, at time.Time) error
+	DeleteSession(userID string, sessionID string) error
+	DeleteUserSessions(userID string) error
+}
+
+// MemorySessionStore is a SessionStore keeping sessions in a map, by id, and looking them up by hash by going
+// through all of them.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	lastID   int
+	sessions map[string]*Session
+	findUser func(id string) (*User, error)
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore, looking up the users of the sessions it finds
+// with findUser. A nil findUser leaves their fields as they were when the sessions were created.
+func NewMemorySessionStore(findUser func(id string) (*User, error)) *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: map[string]*Session{},
+		findUser: findUser,
+	}
+}
+
+func (m *MemorySessionStore) CreateSession(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	session.ID = strconv.Itoa(m.lastID)
+	copied := *session
+	m.sessions[session.ID] = &copied
+
+	return nil
+}
+
+// FindSessionByHash returns the session whose secret matches the given hash, along the current fields of its
+// user. Sessions whose user can't be found anymore aren't returned.
+func (m *MemorySessionStore) FindSessionByHash(hash string) (*Session, error) {
+	m.mu.Lock()
+	var found *Session
+	for _, session := range m.sessions {
+		if session.TokenHash == hash {
+			copied := *session
+			found = &copied
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if found == nil || m.findUser == nil {
+		return found, nil
+	}
+
+	user, err := m.findUser(found.UserID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	found.setUser(user)
+	return found, nil
+}
+
+// ListSessions returns the sessions of the given user, most recently seen first.
+func (m *MemorySessionStore) ListSessions(userID string) ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*Session{}
+	for _, session := range m.sessions {
+		if session.UserID == userID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *MemorySessionStore) TouchSession(sessionID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[sessionID]; ok {
+		session.LastSeenAt = at
+	}
+
+	return nil
+}
+
+// DeleteSession deletes a session, provided it belongs to the given user. If there is no such session, it
+// returns sql.ErrNoRows, like stores backed by a database.
+func (m *MemorySessionStore) DeleteSession(userID string, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.UserID != userID {
+		return sql.ErrNoRows
+	}
+
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *MemorySessionStore) DeleteUserSessions(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package tabloid
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSessionExpired(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	session := &Session{
+		CreatedAt:  now.Add(-48 * time.Hour),
+		LastSeenAt: now.Add(-2 * time.Hour),
+	}
+
+	c.Assert(session.Expired(0, 0, now), qt.IsFalse)
+	c.Assert(session.Expired(3*time.Hour, 72*time.Hour, now), qt.IsFalse)
+	c.Assert(session.Expired(time.Hour, 0, now), qt.IsTrue)
+	c.Assert(session.Expired(0, 24*time.Hour, now), qt.IsTrue)
+}
+
+func TestNewSession(t *testing.T) {
+	c := qt.New(t)
+
+	req := httptest.NewRequest("GET", "/auth/github/callback", nil)
+	req.RemoteAddr = "10.0.0.1:4242"
+	req.Header.Set("User-Agent", "curl")
+
+	session, secret, err := NewSession(&User{ID: "1", Name: "alice"}, "github", req)
+	c.Assert(err, qt.IsNil)
+	c.Assert(session.TokenHash, qt.Equals, HashAPIToken(secret))
+	c.Assert(session.IP, qt.Equals, "10.0.0.1")
+	c.Assert(session.UserAgent, qt.Equals, "curl")
+	c.Assert(session.Login, qt.Equals, "alice")
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	c := qt.New(t)
+
+	users := map[string]*User{
+		"1": {ID: "1", Name: "alice"},
+		"2": {ID: "2", Name: "bob"},
+	}
+	store := NewMemorySessionStore(func(id string) (*User, error) { return users[id], nil })
+	req := httptest.NewRequest("GET", "/", nil)
+
+	first, firstSecret, err := NewSession(users["1"], "github", req)
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.CreateSession(first), qt.IsNil)
+
+	second, _, err := NewSession(users["1"], "github", req)
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.CreateSession(second), qt.IsNil)
+
+	other, otherSecret, err := NewSession(users["2"], "github", req)
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.CreateSession(other), qt.IsNil)
+
+	c.Run("find by hash", func(c *qt.C) {
+		found, err := store.FindSessionByHash(HashAPIToken(firstSecret))
+		c.Assert(err, qt.IsNil)
+		c.Assert(found.ID, qt.Equals, first.ID)
+
+		found, err = store.FindSessionByHash(HashAPIToken("unknown"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.IsNil)
+	})
+
+	c.Run("found sessions come along the current fields of their user", func(c *qt.C) {
+		users["2"] = &User{ID: "2", Name: "bob", Role: RoleModerator, Karma: 42}
+		found, err := store.FindSessionByHash(HashAPIToken(otherSecret))
+		c.Assert(err, qt.IsNil)
+		c.Assert(CanModerate(found.user()), qt.IsTrue)
+		c.Assert(found.Karma, qt.Equals, 42)
+	})
+
+	c.Run("sessions of removed users aren't found", func(c *qt.C) {
+		removed, secret, err := NewSession(&User{ID: "3", Name: "carol"}, "github", req)
+		c.Assert(err, qt.IsNil)
+		c.Assert(store.CreateSession(removed), qt.IsNil)
+
+		found, err := store.FindSessionByHash(HashAPIToken(secret))
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.IsNil)
+	})
+
+	c.Run("list", func(c *qt.C) {
+		sessions, err := store.ListSessions("1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(sessions, qt.HasLen, 2)
+	})
+
+	c.Run("cannot delete someone else's session", func(c *qt.C) {
+		err := store.DeleteSession("2", first.ID)
+		c.Assert(err, qt.Equals, sql.ErrNoRows)
+	})
+
+	c.Run("delete all sessions of a user", func(c *qt.C) {
+		err := store.DeleteUserSessions("1")
+		c.Assert(err, qt.IsNil)
+
+		sessions, err := store.ListSessions("1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(sessions, qt.HasLen, 0)
+
+		found, err := store.FindSessionByHash(HashAPIToken(otherSecret))
+		c.Assert(err, qt.IsNil)
+		c.Assert(found, qt.Not(qt.IsNil))
+	})
+}
@@ -0,0 +1,153 @@
+package tabloid
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// HandleSettings handles requests to get the settings page of the current user, where personal access tokens
+// and sessions are managed.
+func (s *Server) HandleSettings() HandleE {
+	tmpl := s.parseSettingsTemplate()
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		return s.renderSettings(res, req, tmpl, "")
+	}
+}
+
+// HandleCreateAPITokenAction handles requests to create a personal access token. Its secret is only shown
+// once, in the response, as only its hash is stored.
+func (s *Server) HandleCreateAPITokenAction() HandleE {
+	tmpl := s.parseSettingsTemplate()
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		err := req.ParseForm()
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		name := strings.TrimSpace(req.FormValue("name"))
+		if name == "" || len(name) > 64 {
+			return UnprocessableEntity("name")
+		}
+
+		scopes, err := ParseScopes(req.Form["scopes"])
+		if err != nil || len(scopes) == 0 {
+			return UnprocessableEntityWithError(err, "scopes")
+		}
+
+		userRecord := ctxUser(req.Context())
+		token, secret, err := NewAPIToken(userRecord.ID, name, scopes)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.InsertAPIToken(token)
+		if err != nil {
+			return err
+		}
+
+		return s.renderSettings(res, req, tmpl, secret)
+	}
+}
+
+// HandleRevokeAPITokenAction handles requests to revoke one of the personal access tokens of the current user.
+func (s *Server) HandleRevokeAPITokenAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		userRecord := ctxUser(req.Context())
+
+		err := s.store.RevokeAPIToken(userRecord.ID, params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		SetFlash(res, "success", "Token revoked.")
+		http.Redirect(res, req, "/settings", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleRevokeSessionAction handles requests to sign out one of the sessions of the current user.
+func (s *Server) HandleRevokeSessionAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		userRecord := ctxUser(req.Context())
+
+		err := s.sessionStore.DeleteSession(userRecord.ID, params.ByName("id"))
+		if err != nil {
+			return Maybe404(err)
+		}
+
+		SetFlash(res, "success", "Session revoked.")
+		http.Redirect(res, req, "/settings", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleDestroyAllSessionsAction handles requests to sign out everywhere, deleting every session of the current
+// user, including the current one.
+func (s *Server) HandleDestroyAllSessionsAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		userRecord := ctxUser(req.Context())
+
+		err := s.sessionStore.DeleteUserSessions(userRecord.ID)
+		if err != nil {
+			return err
+		}
+
+		err = s.destroySessions(res, req)
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Signed out everywhere.")
+		http.Redirect(res, req, "/", http.StatusFound)
+		return nil
+	}
+}
+
+func (s *Server) parseSettingsTemplate() *template.Template {
+	tmpl, err := template.New("settings.html").Funcs(s.helpers()).ParseFiles(
+		"assets/templates/settings.html",
+		"assets/templates/_header.html",
+		"assets/templates/_footer.html")
+	if err != nil {
+		s.Logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+
+	return tmpl
+}
+
+// renderSettings renders the settings page. If newToken isn't empty, it's displayed so the user can copy it.
+func (s *Server) renderSettings(res http.ResponseWriter, req *http.Request, tmpl *template.Template, newToken string) error {
+	res.Header().Set("Content-Type", "text/html")
+
+	userRecord := ctxUser(req.Context())
+	tokens, err := s.store.ListAPITokens(userRecord.ID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.sessionStore.ListSessions(userRecord.ID)
+	if err != nil {
+		return err
+	}
+
+	vars := map[string]interface{}{
+		"Session":  ctxSession(req.Context()),
+		"User":     userRecord,
+		"Tokens":   tokens,
+		"Scopes":   AllScopes,
+		"NewToken": newToken,
+		"Sessions": sessions,
+	}
+
+	err = tmpl.Execute(res, vars)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
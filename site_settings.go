@@ -0,0 +1,40 @@
+package tabloid
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SiteSettings holds the settings of an instance that admins can edit from the admin area.
+type SiteSettings struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Announcement string `json:"announcement,omitempty"`
+	// BannedDomains are the domains, subdomains included, that stories and comments can't link to.
+	BannedDomains []string `json:"banned_domains,omitempty"`
+	// DomainPenalties are the factors applied to the rank of the stories from a domain, subdomains included,
+	// 0.5 halving it.
+	DomainPenalties map[string]float64 `json:"domain_penalties,omitempty"`
+}
+
+// DefaultSiteSettings returns the settings of an instance whose admins haven't edited them yet.
+func DefaultSiteSettings() *SiteSettings {
+	return &SiteSettings{
+		Name:        "Tabloid",
+		Description: "Tabloid description",
+	}
+}
+
+func (ss SiteSettings) Value() (driver.Value, error) {
+	return json.Marshal(ss)
+}
+
+func (ss *SiteSettings) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("can't decode site settings")
+	}
+
+	return json.Unmarshal(b, &ss)
+}
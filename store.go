@@ -1,20 +1,76 @@
 package tabloid
 
+import (
+	"database/sql"
+	"time"
+)
+
 type Store interface {
 	Connect() error
 	FindStory(ID string) (*Story, error)
 	FindStoryWithVote(ID string, userID string) (*StorySeenByUser, error)
 	ListStories(page int, perPage int) ([]*Story, error)
 	ListStoriesWithVotes(userID string, page int, perPage int) ([]*StorySeenByUser, error)
+	ListStoriesByDomain(domain string, page int, perPage int) ([]*Story, error)
+	ListStoriesByDomainWithVotes(domain string, userID string, page int, perPage int) ([]*StorySeenByUser, error)
+	ListStoriesByAuthor(authorID string, page int, perPage int) ([]*Story, error)
+	// LastStoriesActivity returns when the given stories were last submitted, voted on or commented on, or
+	// the zero time if none of them exist.
+	LastStoriesActivity(storyIDs []string) (time.Time, error)
 	InsertStory(item *Story) error
+	FindStoryByCanonicalURL(canonicalURL string) (*Story, error)
 	FindComment(commentID string) (*Comment, error)
 	ListComments(storyID string) ([]*Comment, error)
 	ListCommentsWithVotes(storyID string, userID string) ([]*CommentSeenByUser, error)
 	InsertComment(comment *Comment) error
 	UpdateComment(comment *Comment) error
+	FindUserByID(ID string) (*User, error)
 	FindUserByLogin(login string) (*User, error)
+	FindUserByIdentity(provider string, login string) (*User, error)
 	CreateOrUpdateUser(login string, email string) (string, error)
-	CreateOrUpdateVoteOnStory(storyID string, userID string, up bool) error
-	CreateOrUpdateVoteOnComment(storyID string, userID string, up bool) error
+	CreateOrUpdateUserFromIdentity(provider string, login string, email string) (string, error)
+	LinkIdentity(userID string, provider string, login string, email string) (string, error)
+	// CreateOrUpdateVoteOnStory and CreateOrUpdateVoteOnComment record the vote of a user, returning true if
+	// they hadn't voted yet, false if an existing vote was changed or repeated.
+	CreateOrUpdateVoteOnStory(storyID string, userID string, up bool) (bool, error)
+	CreateOrUpdateVoteOnComment(commentID string, userID string, up bool) (bool, error)
 	UpdateUser(user *User) error
+	UpdateUserRole(userID string, role Role) error
+	ListUsers(query string, page int, perPage int) ([]*User, error)
+	BanUser(userID string) error
+	UnbanUser(userID string) error
+	SuspendUser(userID string, until time.Time, reason string) error
+	UnsuspendUser(userID string) error
+	ShadowBanUser(userID string) error
+	UnshadowBanUser(userID string) error
+	ListRecentStories(query string, page int, perPage int) ([]*Story, error)
+	ListRecentComments(query string, page int, perPage int) ([]*Comment, error)
+	RemoveStory(storyID string) error
+	RemoveComment(commentID string) error
+	PinStory(storyID string, until sql.NullTime) error
+	LockStory(storyID string, locked bool) error
+	ListHeldStories(page int, perPage int) ([]*Story, error)
+	ReleaseStory(storyID string) error
+	ListHeldComments(page int, perPage int) ([]*Comment, error)
+	ReleaseComment(commentID string) error
+	// CountDuplicateBodies returns how many visible stories and comments created since the given time have
+	// exactly the given body.
+	CountDuplicateBodies(body string, since time.Time) (int, error)
+	FindLinkMetadata(canonicalURL string) (*LinkMetadata, error)
+	UpsertLinkMetadata(metadata *LinkMetadata) error
+	ListStaleLinkMetadata(before time.Time, limit int) ([]*LinkMetadata, error)
+	Search(query *SearchQuery, page int, perPage int) ([]*SearchResult, error)
+	FindSiteSettings() (*SiteSettings, error)
+	UpdateSiteSettings(settings *SiteSettings) error
+	InsertFlag(flag *Flag, threshold int) error
+	ListFlaggedContent(page int, perPage int) ([]*FlaggedContent, error)
+	ApproveFlags(storyID string, commentID sql.NullString) error
+	DismissFlags(storyID string, commentID sql.NullString) error
+	InsertModerationLogEntry(entry *ModerationLogEntry) error
+	ListModerationLog(includePrivate bool, page int, perPage int) ([]*ModerationLogEntry, error)
+	InsertAPIToken(token *APIToken) error
+	FindAPITokenByHash(hash string) (*APIToken, error)
+	ListAPITokens(userID string) ([]*APIToken, error)
+	RevokeAPIToken(userID string, tokenID string) error
+	TouchAPIToken(tokenID string) error
 }
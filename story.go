@@ -6,15 +6,50 @@ import (
 )
 
 type Story struct {
-	ID            string    `db:"id"`
-	Title         string    `db:"title"`
-	URL           string    `db:"url"`
-	Body          string    `db:"body"`
-	Score         int64     `db:"score"`
-	Author        string    `db:"author"`
-	AuthorID      string    `db:"author_id"`
-	CommentsCount int64     `db:"comments_count"`
-	CreatedAt     time.Time `db:"created_at"`
+	ID            string       `db:"id"`
+	Title         string       `db:"title"`
+	URL           string       `db:"url"`
+	Body          string       `db:"body"`
+	Score         int64        `db:"score"`
+	Author        string       `db:"author"`
+	AuthorID      string       `db:"author_id"`
+	CommentsCount int64        `db:"comments_count"`
+	CreatedAt     time.Time    `db:"created_at"`
+	DeletedAt     sql.NullTime `db:"deleted_at"`
+	PinnedUntil   sql.NullTime `db:"pinned_until"`
+	HiddenAt      sql.NullTime `db:"hidden_at"`
+	// Locked stories can't receive new comments.
+	Locked bool `db:"locked"`
+	// HeldAt is set when the story has been submitted by a new account, until a moderator reviews it.
+	HeldAt sql.NullTime `db:"held_at"`
+	// CanonicalURL is the normalized URL, used to find out if a link has already been submitted.
+	CanonicalURL string `db:"canonical_url"`
+	// Domain is the host of the URL, without its "www." prefix, empty for self posts.
+	Domain string `db:"domain"`
+	// AuthorCreatedAt is when the account of the author was created, it's only filled when listing or
+	// finding stories.
+	AuthorCreatedAt sql.NullTime `db:"author_created_at"`
+}
+
+// IsRemoved returns true if the story has been removed by a moderator.
+func (s *Story) IsRemoved() bool {
+	return s.DeletedAt.Valid
+}
+
+// IsHidden returns true if the story has been hidden after being flagged too many times, until
+// a moderator reviews it.
+func (s *Story) IsHidden() bool {
+	return s.HiddenAt.Valid
+}
+
+// IsHeld returns true if the story is waiting for a moderator to review it.
+func (s *Story) IsHeld() bool {
+	return s.HeldAt.Valid
+}
+
+// IsPinned returns true if the story is pinned at the given time.
+func (s *Story) IsPinned(at time.Time) bool {
+	return s.PinnedUntil.Valid && s.PinnedUntil.Time.After(at)
 }
 
 type StorySeenByUser struct {
@@ -25,12 +60,14 @@ type StorySeenByUser struct {
 
 func NewStory(title string, body string, authorID string, url string) *Story {
 	return &Story{
-		Title:     title,
-		Body:      body,
-		Score:     0,
-		AuthorID:  authorID,
-		URL:       url,
-		CreatedAt: NowFunc(),
+		Title:        title,
+		Body:         body,
+		Score:        0,
+		AuthorID:     authorID,
+		URL:          url,
+		CanonicalURL: CanonicalURL(url),
+		Domain:       Domain(url),
+		CreatedAt:    NowFunc(),
 	}
 }
 
@@ -41,3 +78,7 @@ func (s *Story) GetScore() int64 {
 func (s *Story) Age() time.Time {
 	return s.CreatedAt
 }
+
+func (s *Story) GetAuthorID() string {
+	return s.AuthorID
+}
@@ -1,6 +1,7 @@
 package tabloid
 
 import (
+	"database/sql"
 	"testing"
 	"time"
 
@@ -21,6 +22,30 @@ func TestOK(t *testing.T) {
 	})
 }
 
+func TestRankPinnedFirst(t *testing.T) {
+	c := qt.New(t)
+
+	now, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	withFakeNow(func() time.Time { return now }, func() {
+		popular := &Story{Score: 100, CreatedAt: now.Add(-time.Hour)}
+		old := &Story{Score: 1, CreatedAt: now.Add(-72 * time.Hour)}
+		pinned := &Story{Score: 1, CreatedAt: now.Add(-72 * time.Hour), PinnedUntil: sql.NullTime{Time: now.Add(time.Hour), Valid: true}}
+		expired := &Story{Score: 1, CreatedAt: now.Add(-72 * time.Hour), PinnedUntil: sql.NullTime{Time: now.Add(-time.Hour), Valid: true}}
+
+		c.Assert(rankPinnedFirst(popular, old, now, nil), qt.IsTrue)
+		c.Assert(rankPinnedFirst(pinned, popular, now, nil), qt.IsTrue)
+		c.Assert(rankPinnedFirst(popular, pinned, now, nil), qt.IsFalse)
+		c.Assert(rankPinnedFirst(expired, popular, now, nil), qt.IsFalse)
+
+		// penalties apply to subdomains too
+		recent := &Story{Score: 10, CreatedAt: now.Add(-time.Hour), Domain: "blog.spam.com"}
+		settings := &SiteSettings{DomainPenalties: map[string]float64{"spam.com": 0.01}}
+		c.Assert(rankPinnedFirst(recent, old, now, nil), qt.IsTrue)
+		c.Assert(rankPinnedFirst(recent, popular, now, settings), qt.IsFalse)
+		c.Assert(rankPinnedFirst(old, recent, now, settings), qt.IsFalse)
+	})
+}
+
 func withFakeNow(nowFunc func() time.Time, f func()) {
 	old := NowFunc
 	NowFunc = nowFunc
@@ -0,0 +1,118 @@
+package tabloid
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// apiTokenPrefix makes personal access tokens easy to recognize, for example by secret scanners.
+const apiTokenPrefix = "tabloid_"
+
+// A Scope restricts what a personal access token can be used for.
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"
+	ScopeSubmit  Scope = "submit"
+	ScopeComment Scope = "comment"
+	ScopeVote    Scope = "vote"
+)
+
+// AllScopes lists every scope a token can be granted, in the order they're displayed.
+var AllScopes = []Scope{ScopeRead, ScopeSubmit, ScopeComment, ScopeVote}
+
+// Scopes is a set of scopes, stored as a JSON array.
+type Scopes []Scope
+
+// ParseScopes turns raw scope names, typically coming from a form, into Scopes.
+// It returns an error if one of them is unknown.
+func ParseScopes(names []string) (Scopes, error) {
+	var scopes Scopes
+outer:
+	for _, n := range names {
+		for _, scope := range AllScopes {
+			if Scope(n) == scope {
+				scopes = append(scopes, scope)
+				continue outer
+			}
+		}
+
+		return nil, fmt.Errorf("unknown scope %q", n)
+	}
+
+	return scopes, nil
+}
+
+// Has returns true if the given scope is part of the set.
+func (ss Scopes) Has(scope Scope) bool {
+	for _, s := range ss {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ss Scopes) Value() (driver.Value, error) {
+	if ss == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(ss)
+}
+
+func (ss *Scopes) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("can't decode scopes")
+	}
+
+	return json.Unmarshal(b, ss)
+}
+
+// An APIToken is a personal access token, allowing scripts and bots to act on behalf of a user
+// through the Authorization header. Only a hash of the token is stored.
+type APIToken struct {
+	ID         string       `db:"id"`
+	UserID     string       `db:"user_id"`
+	Name       string       `db:"name"`
+	TokenHash  string       `db:"token_hash"`
+	Scopes     Scopes       `db:"scopes"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+}
+
+// NewAPIToken returns a token for the given user along its secret value, which must be handed to the user
+// as it can't be recovered later on.
+func NewAPIToken(userID string, name string, scopes Scopes) (*APIToken, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return &APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashAPIToken(secret),
+		Scopes:    scopes,
+		CreatedAt: NowFunc(),
+	}, secret, nil
+}
+
+// HashAPIToken returns the hash under which a token secret is stored.
+func HashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+package tabloid
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseScopes(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("OK", func(c *qt.C) {
+		scopes, err := ParseScopes([]string{"read", "vote"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(scopes, qt.DeepEquals, Scopes{ScopeRead, ScopeVote})
+	})
+
+	c.Run("unknown scope", func(c *qt.C) {
+		_, err := ParseScopes([]string{"read", "admin"})
+		c.Assert(err, qt.ErrorMatches, `unknown scope "admin"`)
+	})
+}
+
+func TestScopesHas(t *testing.T) {
+	c := qt.New(t)
+
+	scopes := Scopes{ScopeRead, ScopeSubmit}
+	c.Assert(scopes.Has(ScopeSubmit), qt.IsTrue)
+	c.Assert(scopes.Has(ScopeVote), qt.IsFalse)
+	c.Assert(Scopes(nil).Has(ScopeRead), qt.IsFalse)
+}
+
+func TestNewAPIToken(t *testing.T) {
+	c := qt.New(t)
+
+	token, secret, err := NewAPIToken("1", "ci", Scopes{ScopeSubmit})
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.HasPrefix(secret, apiTokenPrefix), qt.IsTrue)
+	c.Assert(token.TokenHash, qt.Equals, HashAPIToken(secret))
+	c.Assert(token.TokenHash, qt.Not(qt.Contains), secret)
+
+	_, other, err := NewAPIToken("1", "ci", Scopes{ScopeSubmit})
+	c.Assert(err, qt.IsNil)
+	c.Assert(other, qt.Not(qt.Equals), secret)
+}
@@ -1,6 +1,7 @@
 package tabloid
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
@@ -24,6 +25,45 @@ func (us *UserSettings) Scan(value interface{}) error {
 	return json.Unmarshal(b, &us)
 }
 
+// A Role defines what a user is allowed to do besides submitting, commenting and voting.
+type Role string
+
+const (
+	RoleMember    Role = "member"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// AllRoles lists every role, from the least to the most privileged.
+var AllRoles = []Role{RoleMember, RoleModerator, RoleAdmin}
+
+// ParseRole returns the role with the given name, or an error if there is none.
+func ParseRole(name string) (Role, error) {
+	for _, r := range AllRoles {
+		if Role(name) == r {
+			return r, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown role %q", name)
+}
+
+// AtLeast returns true if the role is as privileged as the given one, admins being moderators as well.
+// An empty role is considered to be a member.
+func (r Role) AtLeast(other Role) bool {
+	return r.level() >= other.level()
+}
+
+func (r Role) level() int {
+	for i, role := range AllRoles {
+		if r == role {
+			return i
+		}
+	}
+
+	return 0
+}
+
 type User struct {
 	ID          string       `db:"id"`
 	Name        string       `db:"name"`
@@ -31,4 +71,40 @@ type User struct {
 	CreatedAt   time.Time    `db:"created_at"`
 	Settings    UserSettings `db:"settings"`
 	LastLoginAt time.Time    `db:"last_login_at"`
+	Role        Role         `db:"role"`
+	BannedAt    sql.NullTime `db:"banned_at"`
+	// SuspendedUntil is when the suspension of the user ends, SuspensionReason telling them why.
+	SuspendedUntil   sql.NullTime `db:"suspended_until"`
+	SuspensionReason string       `db:"suspension_reason"`
+	// ShadowBannedAt is set when the content of the user is only visible to themselves.
+	ShadowBannedAt sql.NullTime `db:"shadow_banned_at"`
+	// Karma is the score the user received from others on their stories and comments.
+	Karma int `db:"karma"`
+}
+
+// IsBanned returns true if the user has been banned by an admin.
+func (u *User) IsBanned() bool {
+	return u.BannedAt.Valid
+}
+
+// IsSuspended returns true if the user is suspended at the given time.
+func (u *User) IsSuspended(at time.Time) bool {
+	return u.SuspendedUntil.Valid && u.SuspendedUntil.Time.After(at)
+}
+
+// IsShadowBanned returns true if the content of the user is only visible to themselves.
+func (u *User) IsShadowBanned() bool {
+	return u.ShadowBannedAt.Valid
+}
+
+// An Identity links an account from an authentication provider to a User. A User may have
+// several of them, one per provider.
+type Identity struct {
+	ID          string    `db:"id"`
+	UserID      string    `db:"user_id"`
+	Provider    string    `db:"provider"`
+	Login       string    `db:"login"`
+	Email       string    `db:"email"`
+	CreatedAt   time.Time `db:"created_at"`
+	LastLoginAt time.Time `db:"last_login_at"`
 }
@@ -0,0 +1,638 @@
+package tabloid
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// webhookSecretPrefix makes webhook secrets easy to recognize, for example by secret scanners.
+	webhookSecretPrefix = "whsec_"
+	// webhookPollInterval is how often the pending deliveries are looked for, on top of new events waking
+	// the worker up.
+	webhookPollInterval = 30 * time.Second
+	// webhookBatch is how many deliveries are attempted at most each time the worker wakes up.
+	webhookBatch = 20
+	// webhookTimeout bounds how long a receiver has to answer.
+	webhookTimeout = 10 * time.Second
+	// webhookLease is how long claimed deliveries are left alone by other workers, enough to attempt a whole
+	// batch. Deliveries claimed by a worker which stopped halfway are attempted again after it.
+	webhookLease = webhookBatch * webhookTimeout
+	// maxWebhookAttempts is how many times a delivery is attempted before giving up, around four hours
+	// after the first attempt with webhookBackoff.
+	maxWebhookAttempts = 10
+)
+
+// A WebhookEvent is something happening on the site that webhooks can subscribe to.
+type WebhookEvent string
+
+const (
+	WebhookStoryCreated   WebhookEvent = "story.created"
+	WebhookCommentCreated WebhookEvent = "comment.created"
+	WebhookVoteCreated    WebhookEvent = "vote.created"
+	WebhookUserJoined     WebhookEvent = "user.joined"
+)
+
+// AllWebhookEvents lists every event webhooks can subscribe to, in the order they're displayed.
+var AllWebhookEvents = []WebhookEvent{WebhookStoryCreated, WebhookCommentCreated, WebhookVoteCreated, WebhookUserJoined}
+
+// WebhookEvents is a set of events, stored as a JSON array.
+type WebhookEvents []WebhookEvent
+
+// ParseWebhookEvents turns raw event names, typically coming from a form, into WebhookEvents.
+// It returns an error if one of them is unknown.
+func ParseWebhookEvents(names []string) (WebhookEvents, error) {
+	var events WebhookEvents
+outer:
+	for _, n := range names {
+		for _, event := range AllWebhookEvents {
+			if WebhookEvent(n) == event {
+				events = append(events, event)
+				continue outer
+			}
+		}
+
+		return nil, fmt.Errorf("unknown event %q", n)
+	}
+
+	return events, nil
+}
+
+// Has returns true if the given event is part of the set.
+func (es WebhookEvents) Has(event WebhookEvent) bool {
+	for _, e := range es {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (es WebhookEvents) Value() (driver.Value, error) {
+	if es == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(es)
+}
+
+func (es *WebhookEvents) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("can't decode webhook events")
+	}
+
+	return json.Unmarshal(b, es)
+}
+
+// A Webhook is an URL configured by admins, receiving the events it subscribed to. Payloads are signed with
+// its secret, so the receiver can tell they come from Tabloid.
+type Webhook struct {
+	ID        string        `db:"id"`
+	URL       string        `db:"url"`
+	Secret    string        `db:"secret"`
+	Events    WebhookEvents `db:"events"`
+	CreatedAt time.Time     `db:"created_at"`
+}
+
+// NewWebhook returns a webhook posting the given events to the given URL, with a random secret.
+func NewWebhook(url string, events WebhookEvents) (*Webhook, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{
+		URL:       url,
+		Secret:    webhookSecretPrefix + hex.EncodeToString(raw),
+		Events:    events,
+		CreatedAt: NowFunc(),
+	}, nil
+}
+
+// A WebhookDelivery is an event to post to a webhook. It's attempted again with an increasing delay until
+// the receiver accepts it, or until giving up after maxWebhookAttempts.
+type WebhookDelivery struct {
+	ID        string       `db:"id"`
+	WebhookID string       `db:"webhook_id"`
+	Event     WebhookEvent `db:"event"`
+	Payload   string       `db:"payload"`
+	Attempts  int          `db:"attempts"`
+	// NextAttemptAt is when the delivery is due, null once delivered or failed.
+	NextAttemptAt sql.NullTime `db:"next_attempt_at"`
+	DeliveredAt   sql.NullTime `db:"delivered_at"`
+	FailedAt      sql.NullTime `db:"failed_at"`
+	// LastStatus is the status code the receiver last answered with, zero if it couldn't be reached.
+	LastStatus int       `db:"last_status"`
+	LastError  string    `db:"last_error"`
+	CreatedAt  time.Time `db:"created_at"`
+	// URL is where the webhook posts to, it's only filled when listing deliveries.
+	URL string `db:"url"`
+}
+
+// IsPending returns true if the delivery is still to be attempted.
+func (d *WebhookDelivery) IsPending() bool {
+	return !d.DeliveredAt.Valid && !d.FailedAt.Valid
+}
+
+// A WebhookStore is responsible of persisting webhooks and their deliveries, which go along their webhook
+// when it's deleted.
+//
+// Lookups return nil without an error when no webhook is found.
+type WebhookStore interface {
+	ListWebhooks() ([]*Webhook, error)
+	FindWebhook(id string) (*Webhook, error)
+	InsertWebhook(webhook *Webhook) error
+	DeleteWebhook(id string) error
+	InsertWebhookDelivery(delivery *WebhookDelivery) error
+	UpdateWebhookDelivery(delivery *WebhookDelivery) error
+	// ClaimDueWebhookDeliveries returns the pending deliveries due at the given time, oldest first, pushing
+	// their next attempt to the given lease so that other servers sharing the store don't attempt them too.
+	ClaimDueWebhookDeliveries(at time.Time, lease time.Time, limit int) ([]*WebhookDelivery, error)
+	// ListWebhookDeliveries returns the deliveries of every webhook, most recent first.
+	ListWebhookDeliveries(page int, perPage int) ([]*WebhookDelivery, error)
+}
+
+// MemoryWebhookStore is a WebhookStore keeping webhooks and deliveries in slices, in the order they were
+// added.
+type MemoryWebhookStore struct {
+	mu         sync.Mutex
+	lastID     int
+	webhooks   []*Webhook
+	deliveries []*WebhookDelivery
+}
+
+// NewMemoryWebhookStore returns an empty MemoryWebhookStore.
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{}
+}
+
+func (m *MemoryWebhookStore) nextID() string {
+	m.lastID++
+	return strconv.Itoa(m.lastID)
+}
+
+func (m *MemoryWebhookStore) ListWebhooks() ([]*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := []*Webhook{}
+	for _, w := range m.webhooks {
+		kept := *w
+		webhooks = append(webhooks, &kept)
+	}
+
+	return webhooks, nil
+}
+
+func (m *MemoryWebhookStore) FindWebhook(id string) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, w := range m.webhooks {
+		if w.ID == id {
+			found := *w
+			return &found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryWebhookStore) InsertWebhook(webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook.ID = m.nextID()
+	kept := *webhook
+	m.webhooks = append(m.webhooks, &kept)
+	return nil
+}
+
+func (m *MemoryWebhookStore) DeleteWebhook(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// deliveries go along their webhook
+	webhooks := []*Webhook{}
+	for _, w := range m.webhooks {
+		if w.ID != id {
+			webhooks = append(webhooks, w)
+		}
+	}
+	deliveries := []*WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.WebhookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	m.webhooks = webhooks
+	m.deliveries = deliveries
+	return nil
+}
+
+func (m *MemoryWebhookStore) InsertWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery.ID = m.nextID()
+	kept := *delivery
+	m.deliveries = append(m.deliveries, &kept)
+	return nil
+}
+
+func (m *MemoryWebhookStore) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, d := range m.deliveries {
+		if d.ID == delivery.ID {
+			kept := *delivery
+			m.deliveries[i] = &kept
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// withURL returns a copy of the delivery, along the URL of its webhook.
+func (m *MemoryWebhookStore) withURL(d *WebhookDelivery) *WebhookDelivery {
+	found := *d
+	for _, w := range m.webhooks {
+		if w.ID == d.WebhookID {
+			found.URL = w.URL
+		}
+	}
+
+	return &found
+}
+
+func (m *MemoryWebhookStore) ClaimDueWebhookDeliveries(at time.Time, lease time.Time, limit int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []*WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.NextAttemptAt.Valid && !d.NextAttemptAt.Time.After(at) {
+			due = append(due, d)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Time.Before(due[j].NextAttemptAt.Time)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := []*WebhookDelivery{}
+	for _, d := range due {
+		d.NextAttemptAt = sql.NullTime{Time: lease, Valid: true}
+		claimed = append(claimed, m.withURL(d))
+	}
+
+	return claimed, nil
+}
+
+func (m *MemoryWebhookStore) ListWebhookDeliveries(page int, perPage int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := []*WebhookDelivery{}
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, m.withURL(m.deliveries[i]))
+	}
+
+	start := page * perPage
+	if start >= len(deliveries) {
+		return []*WebhookDelivery{}, nil
+	}
+	end := start + perPage
+	if end > len(deliveries) {
+		end = len(deliveries)
+	}
+
+	return deliveries[start:end], nil
+}
+
+// SignWebhookPayload returns the signature of a payload, sent in the X-Tabloid-Signature header as
+// "sha256=<signature>". It's the hex encoded HMAC-SHA256 of the payload, keyed with the webhook secret.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns how long to wait before attempting a delivery again, after the given number of
+// failed attempts: 30 seconds, then doubling each time up to 6 hours.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < 6*time.Hour; i++ {
+		backoff *= 2
+	}
+
+	if backoff > 6*time.Hour {
+		backoff = 6 * time.Hour
+	}
+
+	return backoff
+}
+
+// webhookPayload is the body posted to webhooks.
+type webhookPayload struct {
+	Event     WebhookEvent `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	Data      interface{}  `json:"data"`
+}
+
+// webhookVote is the data of a vote.created event, comment_id being only set for votes on comments.
+type webhookVote struct {
+	StoryID   string `json:"story_id"`
+	CommentID string `json:"comment_id,omitempty"`
+	User      string `json:"user"`
+	Up        bool   `json:"up"`
+}
+
+// emitWebhookEvent queues a delivery of the event for every webhook subscribed to it, waking the worker up.
+// Failing to queue them is logged rather than failing the request which triggered the event.
+func (s *Server) emitWebhookEvent(event WebhookEvent, data interface{}) {
+	err := s.queueWebhookEvent(event, data)
+	if err != nil {
+		s.Logger.Warn().Err(err).Str("event", string(event)).Msg("can't queue webhook deliveries")
+	}
+}
+
+func (s *Server) queueWebhookEvent(event WebhookEvent, data interface{}) error {
+	webhooks, err := s.webhookStore.ListWebhooks()
+	if err != nil {
+		return err
+	}
+
+	now := NowFunc()
+	var payload []byte
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.Events.Has(event) {
+			continue
+		}
+
+		if payload == nil {
+			payload, err = json.Marshal(&webhookPayload{Event: event, CreatedAt: now, Data: data})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.webhookStore.InsertWebhookDelivery(&WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			NextAttemptAt: sql.NullTime{Time: now, Valid: true},
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case s.webhookWake <- struct{}{}:
+		default:
+			// the worker is already about to run
+		}
+	}
+
+	return nil
+}
+
+// runWebhookWorker delivers the queued webhook events, as they come and periodically for the retries, until
+// the server stops.
+func (s *Server) runWebhookWorker() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+
+		err := s.deliverWebhooks(context.Background())
+		if err != nil {
+			s.Logger.Warn().Err(err).Msg("can't deliver webhooks")
+		}
+	}
+}
+
+// deliverWebhooks attempts the deliveries which are due, recording how it went.
+func (s *Server) deliverWebhooks(ctx context.Context) error {
+	now := NowFunc()
+	deliveries, err := s.webhookStore.ClaimDueWebhookDeliveries(now, now.Add(webhookLease), webhookBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		err := s.deliverWebhook(ctx, delivery)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverWebhook posts a delivery to its webhook, scheduling the next attempt if it fails.
+func (s *Server) deliverWebhook(ctx context.Context, delivery *WebhookDelivery) error {
+	webhook, err := s.webhookStore.FindWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	now := NowFunc()
+	delivery.Attempts++
+	delivery.NextAttemptAt = sql.NullTime{}
+
+	if webhook == nil {
+		delivery.LastError = "the webhook has been removed"
+		delivery.FailedAt = sql.NullTime{Time: now, Valid: true}
+		return s.webhookStore.UpdateWebhookDelivery(delivery)
+	}
+
+	delivery.LastStatus, err = postWebhook(ctx, webhook, delivery)
+	delivery.LastError = ""
+	if err != nil {
+		delivery.LastError = err.Error()
+	} else if delivery.LastStatus < 200 || delivery.LastStatus >= 300 {
+		delivery.LastError = http.StatusText(delivery.LastStatus)
+	}
+
+	switch {
+	case delivery.LastError == "":
+		delivery.DeliveredAt = sql.NullTime{Time: now, Valid: true}
+	case delivery.Attempts >= maxWebhookAttempts:
+		delivery.FailedAt = sql.NullTime{Time: now, Valid: true}
+	default:
+		delivery.NextAttemptAt = sql.NullTime{Time: now.Add(webhookBackoff(delivery.Attempts)), Valid: true}
+	}
+
+	return s.webhookStore.UpdateWebhookDelivery(delivery)
+}
+
+// postWebhook posts the payload of a delivery to its webhook, returning the status code it answered with.
+func postWebhook(ctx context.Context, webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tabloid-Webhooks")
+	req.Header.Set("X-Tabloid-Event", string(delivery.Event))
+	req.Header.Set("X-Tabloid-Delivery", delivery.ID)
+	req.Header.Set("X-Tabloid-Signature", "sha256="+SignWebhookPayload(webhook.Secret, payload))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// reading a bit of the body lets the connection be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, nil
+}
+
+// HandleAdminWebhooks handles requests to list the webhooks, along the form to add one.
+func (s *Server) HandleAdminWebhooks() HandleE {
+	tmpl := s.parseAdminTemplate("admin_webhooks.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		webhooks, err := s.webhookStore.ListWebhooks()
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "webhooks", 0, map[string]interface{}{
+			"Webhooks": webhooks,
+			"Events":   AllWebhookEvents,
+		})
+	}
+}
+
+// HandleAdminCreateWebhookAction handles requests to add a webhook, posting the events given in the
+// "events" form field to the "url" one.
+func (s *Server) HandleAdminCreateWebhookAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		err := req.ParseForm()
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		link := strings.TrimSpace(req.FormValue("url"))
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return UnprocessableEntityWithError(err, "url")
+		}
+
+		events, err := ParseWebhookEvents(req.Form["events"])
+		if err != nil || len(events) == 0 {
+			return UnprocessableEntityWithError(err, "events")
+		}
+
+		webhook, err := NewWebhook(link, events)
+		if err != nil {
+			return err
+		}
+
+		err = s.webhookStore.InsertWebhook(webhook)
+		if err != nil {
+			return err
+		}
+
+		err = s.LogModeration(NewModerationLogEntry(ctxUser(req.Context()).ID, ModerationAddWebhook, ""))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Webhook added.")
+		http.Redirect(res, req, "/admin/webhooks", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminRemoveWebhookAction handles requests to remove a webhook, along its deliveries.
+func (s *Server) HandleAdminRemoveWebhookAction() HandleE {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		webhook, err := s.webhookStore.FindWebhook(params.ByName("id"))
+		if err != nil {
+			return err
+		}
+
+		if webhook == nil {
+			return NotFound(req.URL.Path)
+		}
+
+		err = s.webhookStore.DeleteWebhook(webhook.ID)
+		if err != nil {
+			return err
+		}
+
+		err = s.LogModeration(NewModerationLogEntry(ctxUser(req.Context()).ID, ModerationRemoveWebhook, ""))
+		if err != nil {
+			return err
+		}
+
+		SetFlash(res, "success", "Webhook removed.")
+		http.Redirect(res, req, "/admin/webhooks", http.StatusFound)
+		return nil
+	}
+}
+
+// HandleAdminWebhookDeliveries handles requests to list the deliveries of every webhook, most recent first.
+func (s *Server) HandleAdminWebhookDeliveries() HandleE {
+	tmpl := s.parseAdminTemplate("admin_webhook_deliveries.html")
+
+	return func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) error {
+		_, page := adminListParams(req)
+		deliveries, err := s.webhookStore.ListWebhookDeliveries(page, adminPerPage)
+		if err != nil {
+			return err
+		}
+
+		return s.renderAdmin(res, req, tmpl, "webhooks", len(deliveries), map[string]interface{}{
+			"Deliveries": deliveries,
+		})
+	}
+}
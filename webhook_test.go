@@ -0,0 +1,159 @@
+package tabloid
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/rs/zerolog"
+)
+
+func TestParseWebhookEvents(t *testing.T) {
+	c := qt.New(t)
+
+	events, err := ParseWebhookEvents([]string{"story.created", "user.joined"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, WebhookEvents{WebhookStoryCreated, WebhookUserJoined})
+	c.Assert(events.Has(WebhookUserJoined), qt.IsTrue)
+	c.Assert(events.Has(WebhookVoteCreated), qt.IsFalse)
+
+	_, err = ParseWebhookEvents([]string{"story.created", "story.deleted"})
+	c.Assert(err, qt.ErrorMatches, `unknown event "story.deleted"`)
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	c := qt.New(t)
+
+	// computed with: echo -n '{"event":"story.created"}' | openssl dgst -sha256 -hmac whsec_foobar
+	c.Assert(SignWebhookPayload("whsec_foobar", []byte(`{"event":"story.created"}`)), qt.Equals, "64be3f860af266debfadd57f3377f8c65cf9dcc73cb8136d8074c688252ac368")
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(webhookBackoff(1), qt.Equals, 30*time.Second)
+	c.Assert(webhookBackoff(2), qt.Equals, time.Minute)
+	c.Assert(webhookBackoff(5), qt.Equals, 8*time.Minute)
+	c.Assert(webhookBackoff(10), qt.Equals, 4*time.Hour+16*time.Minute)
+	c.Assert(webhookBackoff(20), qt.Equals, 6*time.Hour)
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	c := qt.New(t)
+
+	status := http.StatusOK
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.Patch(&NowFunc, func() time.Time { return now })
+
+	s := NewServer(&ServerConfig{}, zerolog.Nop(), nil)
+	webhook, err := NewWebhook(receiver.URL, WebhookEvents{WebhookStoryCreated})
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.webhookStore.InsertWebhook(webhook), qt.IsNil)
+
+	c.Run("only the subscribed events are delivered", func(c *qt.C) {
+		c.Assert(s.queueWebhookEvent(WebhookUserJoined, newAPIUser(&User{Name: "alice"})), qt.IsNil)
+		deliveries, err := s.webhookStore.ListWebhookDeliveries(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries, qt.HasLen, 0)
+	})
+
+	c.Run("deliveries are signed", func(c *qt.C) {
+		c.Assert(s.queueWebhookEvent(WebhookStoryCreated, map[string]string{"id": "1"}), qt.IsNil)
+		c.Assert(s.deliverWebhooks(context.Background()), qt.IsNil)
+
+		c.Assert(received.Header.Get("X-Tabloid-Event"), qt.Equals, "story.created")
+		c.Assert(received.Header.Get("X-Tabloid-Signature"), qt.Equals, "sha256="+SignWebhookPayload(webhook.Secret, body))
+
+		var payload struct {
+			Event string            `json:"event"`
+			Data  map[string]string `json:"data"`
+		}
+		c.Assert(json.Unmarshal(body, &payload), qt.IsNil)
+		c.Assert(payload.Event, qt.Equals, "story.created")
+		c.Assert(payload.Data["id"], qt.Equals, "1")
+
+		deliveries, err := s.webhookStore.ListWebhookDeliveries(0, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries, qt.HasLen, 1)
+		c.Assert(deliveries[0].DeliveredAt.Valid, qt.IsTrue)
+		c.Assert(deliveries[0].IsPending(), qt.IsFalse)
+		c.Assert(received.Header.Get("X-Tabloid-Delivery"), qt.Equals, deliveries[0].ID)
+	})
+
+	c.Run("failed deliveries are attempted again later, until giving up", func(c *qt.C) {
+		status = http.StatusInternalServerError
+		c.Assert(s.queueWebhookEvent(WebhookStoryCreated, map[string]string{"id": "2"}), qt.IsNil)
+		c.Assert(s.deliverWebhooks(context.Background()), qt.IsNil)
+
+		deliveries, err := s.webhookStore.ListWebhookDeliveries(0, 1)
+		c.Assert(err, qt.IsNil)
+		delivery := deliveries[0]
+		c.Assert(delivery.IsPending(), qt.IsTrue)
+		c.Assert(delivery.Attempts, qt.Equals, 1)
+		c.Assert(delivery.LastStatus, qt.Equals, http.StatusInternalServerError)
+		c.Assert(delivery.NextAttemptAt.Time, qt.Equals, now.Add(30*time.Second))
+
+		// not due yet
+		due, err := s.webhookStore.ClaimDueWebhookDeliveries(now, now, 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 0)
+
+		for i := 1; i < maxWebhookAttempts; i++ {
+			now = now.Add(6 * time.Hour)
+			c.Assert(s.deliverWebhooks(context.Background()), qt.IsNil)
+		}
+
+		deliveries, err = s.webhookStore.ListWebhookDeliveries(0, 1)
+		c.Assert(err, qt.IsNil)
+		delivery = deliveries[0]
+		c.Assert(delivery.Attempts, qt.Equals, maxWebhookAttempts)
+		c.Assert(delivery.FailedAt.Valid, qt.IsTrue)
+		c.Assert(delivery.NextAttemptAt.Valid, qt.IsFalse)
+	})
+
+	c.Run("claimed deliveries aren't claimed again until their lease expires", func(c *qt.C) {
+		store := NewMemoryWebhookStore()
+		delivery := &WebhookDelivery{WebhookID: webhook.ID, Event: WebhookStoryCreated, NextAttemptAt: sql.NullTime{Time: now, Valid: true}}
+		c.Assert(store.InsertWebhookDelivery(delivery), qt.IsNil)
+
+		due, err := store.ClaimDueWebhookDeliveries(now, now.Add(webhookLease), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 1)
+		c.Assert(due[0].ID, qt.Equals, delivery.ID)
+
+		due, err = store.ClaimDueWebhookDeliveries(now, now.Add(webhookLease), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 0)
+
+		due, err = store.ClaimDueWebhookDeliveries(now.Add(webhookLease), now.Add(2*webhookLease), 10)
+		c.Assert(err, qt.IsNil)
+		c.Assert(due, qt.HasLen, 1)
+	})
+
+	c.Run("deliveries of removed webhooks fail", func(c *qt.C) {
+		delivery := &WebhookDelivery{WebhookID: "42", Event: WebhookStoryCreated, NextAttemptAt: sql.NullTime{Time: now, Valid: true}}
+		c.Assert(s.webhookStore.InsertWebhookDelivery(delivery), qt.IsNil)
+		c.Assert(s.deliverWebhooks(context.Background()), qt.IsNil)
+
+		deliveries, err := s.webhookStore.ListWebhookDeliveries(0, 1)
+		c.Assert(err, qt.IsNil)
+		c.Assert(deliveries[0].ID, qt.Equals, delivery.ID)
+		c.Assert(deliveries[0].FailedAt.Valid, qt.IsTrue)
+		c.Assert(deliveries[0].Attempts, qt.Equals, 1)
+	})
+}